@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// histogram counts latencies in microseconds. The values below 2^subBits
+// have a bucket each, above that the buckets of a power of two split it
+// into 2^(subBits-1) parts, so a bucket is within 1/64 of its values.
+const subBits = 7
+
+type histogram struct {
+	counts   []int64
+	total    int64
+	sum      time.Duration
+	min, max time.Duration
+}
+
+func bucketOf(us uint64) int {
+	if us < 1<<subBits {
+		return int(us)
+	}
+	shift := bits.Len64(us) - subBits
+	top := us >> uint(shift)
+	return 1<<subBits + (shift-1)<<(subBits-1) + int(top-1<<(subBits-1))
+}
+
+// bucketRange returns the lowest and highest value of a bucket
+func bucketRange(i int) (low, high uint64) {
+	if i < 1<<subBits {
+		return uint64(i), uint64(i)
+	}
+	j := i - 1<<subBits
+	shift := uint(j>>(subBits-1) + 1)
+	top := uint64(j%(1<<(subBits-1)) + 1<<(subBits-1))
+	return top << shift, (top+1)<<shift - 1
+}
+
+func (h *histogram) record(d time.Duration) {
+	i := bucketOf(uint64(d / time.Microsecond))
+	if i >= len(h.counts) {
+		counts := make([]int64, i+1)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[i]++
+	if h.total == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.total++
+	h.sum += d
+}
+
+func (h *histogram) merge(o *histogram) {
+	if o.total == 0 {
+		return
+	}
+	if len(o.counts) > len(h.counts) {
+		counts := make([]int64, len(o.counts))
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	for i, n := range o.counts {
+		h.counts[i] += n
+	}
+	if h.total == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	if o.max > h.max {
+		h.max = o.max
+	}
+	h.total += o.total
+	h.sum += o.sum
+}
+
+func (h *histogram) mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.total)
+}
+
+// percentile returns the latency p percent of the requests are within, the
+// highest value of its bucket
+func (h *histogram) percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p / 100 * float64(h.total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			_, high := bucketRange(i)
+			d := time.Duration(high+1)*time.Microsecond - 1
+			if d > h.max {
+				d = h.max
+			}
+			if d < h.min {
+				d = h.min
+			}
+			return d
+		}
+	}
+	return h.max
+}
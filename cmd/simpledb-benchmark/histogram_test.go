@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketOf(t *testing.T) {
+
+	for _, us := range []uint64{0, 1, 127, 128, 129, 255, 256, 1000, 1 << 20, 123456789} {
+		low, high := bucketRange(bucketOf(us))
+		if us < low || us > high {
+			t.Errorf("%d: in bucket [%d, %d]", us, low, high)
+		}
+		if float64(high-low) > float64(us)/64 {
+			t.Errorf("%d: bucket [%d, %d] too wide", us, low, high)
+		}
+	}
+	// the buckets follow each other
+	for i := 1; i < 1000; i++ {
+		_, high := bucketRange(i - 1)
+		if low, _ := bucketRange(i); low != high+1 {
+			t.Fatalf("bucket %d starts at %d, bucket %d ends at %d", i, low, i-1, high)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+
+	var a, b histogram
+	for us := 1; us <= 1000; us++ {
+		d := time.Duration(us) * time.Microsecond
+		if us%2 == 0 {
+			a.record(d)
+		} else {
+			b.record(d)
+		}
+	}
+	a.merge(&b)
+
+	if a.total != 1000 || a.min != time.Microsecond || a.max != time.Millisecond {
+		t.Errorf("got total %d, min %v, max %v", a.total, a.min, a.max)
+	}
+	if mean := a.mean(); mean != 500500*time.Nanosecond {
+		t.Errorf("mean: got %v", mean)
+	}
+	var tests = []struct {
+		p    float64
+		want time.Duration // within 1/64
+	}{
+		{0, time.Microsecond},
+		{50, 500 * time.Microsecond},
+		{99, 990 * time.Microsecond},
+		{99.9, 999 * time.Microsecond},
+		{100, time.Millisecond},
+	}
+	for _, test := range tests {
+		got := a.percentile(test.p)
+		if got < test.want || got > test.want+test.want/64+time.Microsecond {
+			t.Errorf("p%v: got %v, want %v", test.p, got, test.want)
+		}
+	}
+	if p := (&histogram{}).percentile(50); p != 0 {
+		t.Errorf("empty p50: got %v", p)
+	}
+}
@@ -0,0 +1,289 @@
+// Command simpledb-benchmark measures the throughput and the latency of a
+// simpledb server.
+//
+//	simpledb-benchmark [-c clients] [-n requests] [-P pipeline] [-d size]
+//	                   [-r keyspace] [-t tests | --mix mix] [-q] [--csv]
+//
+// Each test sends n requests over c connections, P requests at a time.
+// -t runs the tests one after the other, --mix runs one test of weighted
+// commands such as "get=8,set=2".
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"simpledb/simpledb"
+	"simpledb/simpledb/config"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// benchmark runs the workloads
+type benchmark struct {
+	newClient func() *simpledb.Client
+	clients   int
+	requests  int
+	pipeline  int
+	keyspace  int
+	value     string
+}
+
+// result is the outcome of a workload
+type result struct {
+	name     string
+	requests int64
+	errors   int64
+	elapsed  time.Duration
+	latency  *histogram
+}
+
+func (r *result) throughput() float64 {
+	return float64(r.requests) / r.elapsed.Seconds()
+}
+
+// run sends the requests of w. The connections are made before the clock
+// starts, a request lasts from the write of its pipeline to its reply.
+func (b *benchmark) run(w *workload) (*result, error) {
+	clients := make([]*simpledb.Client, b.clients)
+	defer func() {
+		for _, c := range clients {
+			if c != nil {
+				c.Close()
+			}
+		}
+	}()
+	for i := range clients {
+		clients[i] = b.newClient()
+		if _, err := clients[i].Do("DBSIZE"); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		issued int64
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		errs   []error
+	)
+	res := &result{name: w.name, latency: &histogram{}}
+	start := time.Now()
+	for i, c := range clients {
+		wg.Add(1)
+		go func(c *simpledb.Client, seed int64) {
+			defer wg.Done()
+			g := &generator{rnd: rand.New(rand.NewSource(seed)), keyspace: b.keyspace, value: b.value}
+			latency := &histogram{}
+			var failed int64
+			err := b.work(c, w, g, &issued, latency, &failed)
+			mu.Lock()
+			defer mu.Unlock()
+			res.latency.merge(latency)
+			res.errors += failed
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}(c, start.UnixNano()+int64(i))
+	}
+	wg.Wait()
+	res.elapsed = time.Since(start)
+	res.requests = res.latency.total
+	if len(errs) > 0 {
+		return res, errs[0]
+	}
+	return res, nil
+}
+
+// work sends pipelines of requests on c until the requests are all issued
+func (b *benchmark) work(c *simpledb.Client, w *workload, g *generator, issued *int64, latency *histogram, failed *int64) error {
+	for {
+		last := atomic.AddInt64(issued, int64(b.pipeline))
+		n := int64(b.pipeline)
+		if over := last - int64(b.requests); over > 0 {
+			n -= over
+		}
+		if n <= 0 {
+			return nil
+		}
+		sent := time.Now()
+		for i := int64(0); i < n; i++ {
+			if err := c.Send(w.next(g)...); err != nil {
+				return err
+			}
+		}
+		if err := c.Flush(); err != nil {
+			return err
+		}
+		for i := int64(0); i < n; i++ {
+			resp, err := c.Receive()
+			if err != nil {
+				return err
+			}
+			latency.record(time.Since(sent))
+			if resp.IsError() {
+				*failed++
+			}
+		}
+	}
+}
+
+func ms(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+var reportPercentiles = []float64{0, 50, 75, 90, 95, 99, 99.9, 100}
+
+func (b *benchmark) report(r *result) {
+	fmt.Printf("====== %s ======\n", r.name)
+	fmt.Printf("  %d requests completed in %.2f seconds\n", r.requests, r.elapsed.Seconds())
+	fmt.Printf("  %d parallel clients\n", b.clients)
+	fmt.Printf("  %d bytes payload\n", len(b.value))
+	fmt.Printf("  pipeline %d, keyspace %d\n", b.pipeline, b.keyspace)
+	if r.errors > 0 {
+		fmt.Printf("  %d error replies\n", r.errors)
+	}
+	fmt.Println()
+	fmt.Println("Latency by percentile distribution:")
+	for _, p := range reportPercentiles {
+		fmt.Printf("%8.3f%% <= %s milliseconds\n", p, ms(r.latency.percentile(p)))
+	}
+	fmt.Println()
+	fmt.Println("Summary:")
+	fmt.Printf("  throughput summary: %.2f requests per second\n", r.throughput())
+	fmt.Println("  latency summary (msec):")
+	fmt.Printf("  %9s %9s %9s %9s %9s %9s\n", "avg", "min", "p50", "p99", "p999", "max")
+	fmt.Printf("  %9s %9s %9s %9s %9s %9s\n\n", ms(r.latency.mean()), ms(r.latency.min),
+		ms(r.latency.percentile(50)), ms(r.latency.percentile(99)), ms(r.latency.percentile(99.9)), ms(r.latency.max))
+}
+
+func (b *benchmark) reportQuiet(r *result) {
+	fmt.Printf("%s: %.2f requests per second, p50=%s msec\n", r.name, r.throughput(), ms(r.latency.percentile(50)))
+}
+
+var csvHeader = `"test","rps","avg_latency_ms","min_latency_ms","p50_latency_ms","p99_latency_ms","p999_latency_ms","max_latency_ms","errors"`
+
+func csvLine(r *result) string {
+	fields := []string{
+		strconv.Quote(r.name),
+		strconv.FormatFloat(r.throughput(), 'f', 2, 64),
+		ms(r.latency.mean()),
+		ms(r.latency.min),
+		ms(r.latency.percentile(50)),
+		ms(r.latency.percentile(99)),
+		ms(r.latency.percentile(99.9)),
+		ms(r.latency.max),
+		strconv.FormatInt(r.errors, 10),
+	}
+	for i, f := range fields[1:] {
+		fields[i+1] = strconv.Quote(f)
+	}
+	return strings.Join(fields, ",")
+}
+
+func main() {
+	configPath := config.Flag(flag.CommandLine)
+	host := flag.String("h", "", "server host")
+	port := flag.Int("p", 0, "server port")
+	socket := flag.String("s", "", "server unix socket, instead of host and port")
+	password := flag.String("a", "", "password of AUTH")
+	user := flag.String("user", "", "ACL user of AUTH, with -a")
+	useTLS := flag.Bool("tls", false, "connect over TLS")
+	caCert := flag.String("cacert", "", "CA certificate verifying the server")
+	cert := flag.String("cert", "", "client certificate")
+	key := flag.String("key", "", "client private key")
+	clients := flag.Int("c", 50, "parallel connections")
+	requests := flag.Int("n", 100000, "requests of each test")
+	pipeline := flag.Int("P", 1, "requests sent at a time on a connection")
+	size := flag.Int("d", 3, "bytes of the values")
+	keyspace := flag.Int("r", 0, "random keys among r keys, a single key when 0")
+	tests := flag.String("t", defaultTests, "tests to run, one of "+strings.Join(testNames(), ", "))
+	mix := flag.String("mix", "", `weighted commands of a single test, such as "get=8,set=2"`)
+	quiet := flag.Bool("q", false, "print the throughput and p50 only")
+	csv := flag.Bool("csv", false, "print a CSV line per test")
+	flag.Parse()
+
+	// the client logs every command
+	log.SetOutput(ioutil.Discard)
+
+	if *clients <= 0 || *requests <= 0 || *pipeline <= 0 || *size < 0 || *keyspace < 0 {
+		fatal(errors.New("-c, -n and -P must be positive, -d and -r not negative"))
+	}
+	var workloads []*workload
+	var err error
+	if *mix != "" {
+		var w *workload
+		w, err = parseMix(*mix)
+		workloads = []*workload{w}
+	} else {
+		workloads, err = parseTests(*tests)
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	conf, err := config.Load(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "h":
+			conf.Client.Host = *host
+		case "p":
+			conf.Client.Port = *port
+		case "tls":
+			conf.Client.TLS = *useTLS
+		case "cacert":
+			conf.Client.TLSCACertFile = *caCert
+		case "cert":
+			conf.Client.TLSCertFile = *cert
+		case "key":
+			conf.Client.TLSKeyFile = *key
+		}
+	})
+	if *socket != "" {
+		conf.Client.Host = "unix://" + *socket
+	}
+
+	b := &benchmark{
+		newClient: func() *simpledb.Client {
+			c := simpledb.NewClient(conf)
+			c.Username, c.Password = *user, *password
+			return c
+		},
+		clients:  *clients,
+		requests: *requests,
+		pipeline: *pipeline,
+		keyspace: *keyspace,
+		value:    strings.Repeat("x", *size),
+	}
+	if *csv {
+		fmt.Println(csvHeader)
+	}
+	for _, w := range workloads {
+		r, err := b.run(w)
+		if err != nil {
+			fatal(fmt.Errorf("%s: %v", w.name, err))
+		}
+		switch {
+		case *csv:
+			fmt.Println(csvLine(r))
+		case *quiet:
+			b.reportQuiet(r)
+		default:
+			b.report(r)
+		}
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
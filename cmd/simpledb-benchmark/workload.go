@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// generator makes the arguments of the requests of a connection: the keys
+// are picked at random among keyspace keys, always the same key when
+// keyspace is 0
+type generator struct {
+	rnd      *rand.Rand
+	keyspace int
+	value    string
+}
+
+func (g *generator) key(prefix string) string {
+	if g.keyspace <= 0 {
+		return prefix
+	}
+	return fmt.Sprintf("%s:%012d", prefix, g.rnd.Intn(g.keyspace))
+}
+
+func (g *generator) element() string {
+	return g.key("element")
+}
+
+// commands are the requests of a test, by test name
+var commands = map[string]func(g *generator) []string{
+	"set":  func(g *generator) []string { return []string{"SET", g.key("key"), g.value} },
+	"get":  func(g *generator) []string { return []string{"GET", g.key("key")} },
+	"incr": func(g *generator) []string { return []string{"INCR", g.key("counter")} },
+	"mset": func(g *generator) []string {
+		args := []string{"MSET"}
+		for i := 0; i < 10; i++ {
+			args = append(args, g.key("key"), g.value)
+		}
+		return args
+	},
+	"lpush":  func(g *generator) []string { return []string{"LPUSH", "mylist", g.value} },
+	"rpush":  func(g *generator) []string { return []string{"RPUSH", "mylist", g.value} },
+	"lpop":   func(g *generator) []string { return []string{"LPOP", "mylist"} },
+	"rpop":   func(g *generator) []string { return []string{"RPOP", "mylist"} },
+	"lrange": func(g *generator) []string { return []string{"LRANGE", "mylist", "0", "99"} },
+	"sadd":   func(g *generator) []string { return []string{"SADD", "myset", g.element()} },
+	"hset":   func(g *generator) []string { return []string{"HSET", "myhash", g.element(), g.value} },
+	"zadd": func(g *generator) []string {
+		return []string{"ZADD", "myzset", strconv.Itoa(g.rnd.Intn(1 << 20)), g.element()}
+	},
+}
+
+const defaultTests = "set,get,incr,lpush,rpush,lpop,rpop,sadd,hset,zadd,lrange,mset"
+
+// testNames returns the names of commands, sorted
+func testNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// workload picks the command of each request of a test by weight
+type workload struct {
+	name    string
+	tests   []func(g *generator) []string
+	weights []int // cumulative
+}
+
+func (w *workload) next(g *generator) []string {
+	if len(w.tests) == 1 {
+		return w.tests[0](g)
+	}
+	n := g.rnd.Intn(w.weights[len(w.weights)-1])
+	i := sort.SearchInts(w.weights, n+1)
+	return w.tests[i](g)
+}
+
+// parseMix parses a mix such as "get=8,set=2", the weight of a test
+// without one is 1
+func parseMix(spec string) (*workload, error) {
+	w := &workload{name: spec}
+	total := 0
+	for _, part := range strings.Split(spec, ",") {
+		name, weight := strings.TrimSpace(part), 1
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			var err error
+			if weight, err = strconv.Atoi(name[i+1:]); err != nil || weight <= 0 {
+				return nil, fmt.Errorf("mix %q: the weight of %s must be a positive integer", spec, name[:i])
+			}
+			name = name[:i]
+		}
+		test, ok := commands[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown test %q, want one of %s", name, strings.Join(testNames(), ", "))
+		}
+		total += weight
+		w.tests = append(w.tests, test)
+		w.weights = append(w.weights, total)
+	}
+	return w, nil
+}
+
+// parseTests parses a list of tests such as "set,get", each runs alone
+func parseTests(list string) ([]*workload, error) {
+	var workloads []*workload
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		w, err := parseMix(name)
+		if err != nil {
+			return nil, err
+		}
+		w.name = strings.ToUpper(name)
+		workloads = append(workloads, w)
+	}
+	return workloads, nil
+}
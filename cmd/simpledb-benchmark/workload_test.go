@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestParseMix(t *testing.T) {
+
+	var tests = []struct {
+		spec    string
+		weights []int
+		err     bool
+	}{
+		{"get", []int{1}, false},
+		{"get=8,set=2", []int{8, 10}, false},
+		{"GET=3, incr", []int{3, 4}, false},
+		{"get=0", nil, true},
+		{"get=x", nil, true},
+		{"flush", nil, true},
+	}
+	for _, test := range tests {
+		w, err := parseMix(test.spec)
+		if (err != nil) != test.err {
+			t.Errorf("%q: got %v", test.spec, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(w.weights, test.weights) {
+			t.Errorf("%q: got weights %v, want %v", test.spec, w.weights, test.weights)
+		}
+	}
+
+	workloads, err := parseTests("set, get,")
+	if err != nil || len(workloads) != 2 || workloads[0].name != "SET" || workloads[1].name != "GET" {
+		t.Errorf("parseTests: got %v, %v", workloads, err)
+	}
+}
+
+func TestWorkload_Next(t *testing.T) {
+
+	w, err := parseMix("get=3,set=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &generator{rnd: rand.New(rand.NewSource(1)), keyspace: 10, value: "xyz"}
+	counts := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		args := w.next(g)
+		counts[args[0]]++
+		if key := args[1]; len(key) != len("key:000000000000") || key < "key:000000000000" || key > "key:000000000009" {
+			t.Fatalf("key %q out of the keyspace", key)
+		}
+	}
+	// 3000 GET and 1000 SET, give or take
+	if counts["GET"] < 2800 || counts["SET"] < 800 || counts["GET"]+counts["SET"] != 4000 {
+		t.Errorf("got %v", counts)
+	}
+
+	g.keyspace = 0
+	if args := commands["set"](g); !reflect.DeepEqual(args, []string{"SET", "key", "xyz"}) {
+		t.Errorf("no keyspace: got %v", args)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// editor reads the lines of a terminal in raw mode: the arrows move in the
+// line and through the history, tab completes the command name, ctrl-a
+// and ctrl-e go to the start and the end, ctrl-u and ctrl-k cut before and
+// after the cursor and ctrl-l clears the screen. Ctrl-c ends the input, as
+// ctrl-d does on an empty line.
+type editor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	history  []string
+	complete func(word string) []string
+}
+
+func (e *editor) readLine(prompt string) (string, error) {
+	var line []rune
+	pos := 0
+	// the line being typed is kept while browsing the history
+	index, typed := len(e.history), ""
+
+	refresh := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(line))
+		if back := len(line) - pos; back > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", back)
+		}
+	}
+	browse := func(to int) {
+		if to < 0 || to > len(e.history) {
+			return
+		}
+		if index == len(e.history) {
+			typed = string(line)
+		}
+		index = to
+		if index == len(e.history) {
+			line = []rune(typed)
+		} else {
+			line = []rune(e.history[index])
+		}
+		pos = len(line)
+	}
+
+	refresh()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(line), nil
+		case 3, 4: // ctrl-c, ctrl-d
+			if r == 4 && len(line) > 0 {
+				if pos < len(line) {
+					line = append(line[:pos], line[pos+1:]...)
+				}
+				break
+			}
+			fmt.Fprint(e.out, "\r\n")
+			return "", io.EOF
+		case 127, 8: // backspace
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case 1: // ctrl-a
+			pos = 0
+		case 5: // ctrl-e
+			pos = len(line)
+		case 11: // ctrl-k
+			line = line[:pos]
+		case 21: // ctrl-u
+			line = line[pos:]
+			pos = 0
+		case 12: // ctrl-l
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case '\t':
+			line, pos = e.completeLine(prompt, line, pos)
+		case 27:
+			e.escape(&line, &pos, browse, index)
+		default:
+			if unicode.IsPrint(r) {
+				line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+				pos++
+			}
+		}
+		refresh()
+	}
+}
+
+// escape reads the rest of an escape sequence, "[A" is the up arrow
+func (e *editor) escape(line *[]rune, pos *int, browse func(int), index int) {
+	if r, _, _ := e.in.ReadRune(); r != '[' && r != 'O' {
+		return
+	}
+	r, _, _ := e.in.ReadRune()
+	switch r {
+	case 'A':
+		browse(index - 1)
+	case 'B':
+		browse(index + 1)
+	case 'C':
+		if *pos < len(*line) {
+			*pos++
+		}
+	case 'D':
+		if *pos > 0 {
+			*pos--
+		}
+	case 'H':
+		*pos = 0
+	case 'F':
+		*pos = len(*line)
+	case '3': // delete, "[3~"
+		e.in.ReadRune()
+		if *pos < len(*line) {
+			*line = append((*line)[:*pos], (*line)[*pos+1:]...)
+		}
+	}
+}
+
+// completeLine completes the command name before the cursor to the longest
+// common prefix of the candidates, and lists them when it can't go further
+func (e *editor) completeLine(prompt string, line []rune, pos int) ([]rune, int) {
+	word := string(line[:pos])
+	if e.complete == nil || strings.ContainsAny(word, " \t") {
+		return line, pos
+	}
+	candidates := e.complete(word)
+	if len(candidates) == 0 {
+		return line, pos
+	}
+	prefix := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(candidates) > 1 && len(prefix) <= len(word) {
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+		return line, pos
+	}
+	if len(candidates) == 1 {
+		prefix += " "
+	}
+	completed := append([]rune(prefix), line[pos:]...)
+	return completed, len([]rune(prefix))
+}
+
+// commandCompleter completes a prefix with the names of names, in the case
+// of the prefix
+func commandCompleter(names []string) func(string) []string {
+	return func(word string) []string {
+		lower := word != "" && strings.ToLower(word) == word
+		upper := strings.ToUpper(word)
+		var matches []string
+		for _, name := range names {
+			if !strings.HasPrefix(name, upper) {
+				continue
+			}
+			if lower {
+				name = strings.ToLower(name)
+			}
+			matches = append(matches, name)
+		}
+		sort.Strings(matches)
+		return matches
+	}
+}
@@ -0,0 +1,203 @@
+// Command simpledb-cli is the command line client of simpledb.
+//
+//	simpledb-cli [flags]                  commands typed on a terminal
+//	simpledb-cli [flags] command [arg...] a single command
+//	simpledb-cli [flags] < commands       a command per line of stdin
+//	simpledb-cli [flags] --pipe < proto   mass insertion of RESP commands
+//	simpledb-cli [flags] --scan [--pattern p]
+//	simpledb-cli [flags] --bigkeys
+//
+// The replies are human readable on a terminal and raw otherwise, --raw,
+// --csv and --json choose the format. -r repeats a command, -i waits
+// between the runs.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"simpledb/simpledb"
+	"simpledb/simpledb/config"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cli prints the replies of a connection
+type cli struct {
+	client *simpledb.Client
+	addr   string // shown in the prompt and the errors
+	mode   outputMode
+}
+
+func (c *cli) print(resp *simpledb.Resp) {
+	fmt.Println(format(resp, c.mode))
+}
+
+// run sends a command and prints its reply, after SUBSCRIBE, PSUBSCRIBE and
+// MONITOR the messages are printed until the connection ends
+func (c *cli) run(args []string) error {
+	// the server replies the same errors
+	if _, err := simpledb.CheckCommand(args[0], len(args)); err != nil {
+		c.print(simpledb.NewError([]byte(err.Error())))
+		return nil
+	}
+	var resp *simpledb.Resp
+	var err error
+	if strings.ToUpper(args[0]) == "SHUTDOWN" {
+		resp, err = c.client.Shutdown(args[1:]...)
+	} else {
+		resp, err = c.client.Do(args...)
+	}
+	if err != nil {
+		return fmt.Errorf("Error: %s: %v", c.addr, err)
+	}
+	// a server shutting down closes the connection without a reply
+	if resp == nil {
+		return nil
+	}
+	c.print(resp)
+	if resp.IsError() {
+		return nil
+	}
+	switch strings.ToUpper(args[0]) {
+	case "SUBSCRIBE", "PSUBSCRIBE", "MONITOR":
+		if c.mode == modeHuman {
+			fmt.Println("Reading messages... (press Ctrl-C to quit)")
+		}
+		for {
+			resp, err := c.client.ReceiveMessage()
+			if err != nil {
+				return fmt.Errorf("Error: %s: %v", c.addr, err)
+			}
+			c.print(resp)
+		}
+	}
+	return nil
+}
+
+// repeat runs a command repeat times, forever when negative, waiting
+// interval between the runs
+func (c *cli) repeat(args []string, repeat int, interval time.Duration) error {
+	for i := 0; repeat < 0 || i < repeat; i++ {
+		if i > 0 && interval > 0 {
+			time.Sleep(interval)
+		}
+		if err := c.run(args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runLines runs a command per line of stdin
+func (c *cli) runLines() error {
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 512*1024*1024)
+	for scanner.Scan() {
+		args, err := splitArgs(scanner.Text())
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if err := c.run(args); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func main() {
+	configPath := config.Flag(flag.CommandLine)
+	host := flag.String("h", "", "server host")
+	port := flag.Int("p", 0, "server port")
+	socket := flag.String("s", "", "server unix socket, instead of host and port")
+	password := flag.String("a", "", "password of AUTH")
+	user := flag.String("user", "", "ACL user of AUTH, with -a")
+	useTLS := flag.Bool("tls", false, "connect over TLS")
+	caCert := flag.String("cacert", "", "CA certificate verifying the server")
+	cert := flag.String("cert", "", "client certificate")
+	key := flag.String("key", "", "client private key")
+	raw := flag.Bool("raw", false, "print the replies as they are")
+	csv := flag.Bool("csv", false, "print the replies as CSV")
+	jsonOut := flag.Bool("json", false, "print the replies as JSON")
+	repeat := flag.Int("r", 1, "run the command r times, forever when negative")
+	interval := flag.Float64("i", 0, "seconds between the runs of -r")
+	pipe := flag.Bool("pipe", false, "send the RESP commands of stdin, and count the replies")
+	scan := flag.Bool("scan", false, "list the keys with SCAN")
+	pattern := flag.String("pattern", "*", "pattern of the keys of --scan")
+	bigKeys := flag.Bool("bigkeys", false, "find the biggest key of each type")
+	flag.Parse()
+
+	// the client logs every command
+	log.SetOutput(ioutil.Discard)
+
+	conf, err := config.Load(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "h":
+			conf.Client.Host = *host
+		case "p":
+			conf.Client.Port = *port
+		case "tls":
+			conf.Client.TLS = *useTLS
+		case "cacert":
+			conf.Client.TLSCACertFile = *caCert
+		case "cert":
+			conf.Client.TLSCertFile = *cert
+		case "key":
+			conf.Client.TLSKeyFile = *key
+		}
+	})
+	addr := conf.Client.Host + ":" + strconv.Itoa(conf.Client.Port)
+	if *socket != "" {
+		conf.Client.Host, addr = "unix://"+*socket, *socket
+	}
+	client := simpledb.NewClient(conf)
+	client.Username, client.Password = *user, *password
+	defer client.Close()
+
+	c := &cli{client: client, addr: addr}
+	if !isTerminal(int(os.Stdout.Fd())) {
+		c.mode = modeRaw
+	}
+	switch {
+	case *jsonOut:
+		c.mode = modeJSON
+	case *csv:
+		c.mode = modeCSV
+	case *raw:
+		c.mode = modeRaw
+	}
+
+	switch {
+	case *pipe:
+		err = c.pipe(os.Stdin)
+	case *scan:
+		err = c.scan(*pattern)
+	case *bigKeys:
+		err = c.bigKeys()
+	case flag.NArg() > 0:
+		err = c.repeat(flag.Args(), *repeat, time.Duration(*interval*float64(time.Second)))
+	case !isTerminal(int(os.Stdin.Fd())):
+		err = c.runLines()
+	default:
+		c.repl()
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
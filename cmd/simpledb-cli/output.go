@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"simpledb/simpledb"
+	"strconv"
+	"strings"
+)
+
+// the replies are printed as the output mode says, human readable by
+// default on a terminal and raw otherwise
+
+type outputMode int
+
+const (
+	modeHuman outputMode = iota
+	modeRaw
+	modeCSV
+	modeJSON
+)
+
+// isNil reports whether r is the nil reply, the server replies "+nil"
+func isNil(r *simpledb.Resp) bool {
+	return r.Type == simpledb.TypeString && string(r.Value) == "nil"
+}
+
+func format(r *simpledb.Resp, mode outputMode) string {
+	switch mode {
+	case modeRaw:
+		return formatRaw(r)
+	case modeCSV:
+		return formatCSV(r)
+	case modeJSON:
+		b, err := json.Marshal(jsonValue(r))
+		if err != nil {
+			return fmt.Sprintf(`{"error":%q}`, err.Error())
+		}
+		return string(b)
+	}
+	return formatHuman(r)
+}
+
+// formatHuman numbers the elements of an array, a nested array is
+// indented under the number of its element
+func formatHuman(r *simpledb.Resp) string {
+	switch r.Type {
+	case simpledb.TypeError:
+		return "(error) " + string(r.Value)
+	case simpledb.TypeInt:
+		return "(integer) " + string(r.Value)
+	case simpledb.TypeBulkBytes:
+		return strconv.Quote(string(r.Value))
+	case simpledb.TypeArray:
+		if len(r.Array) == 0 {
+			return "(empty array)"
+		}
+		width := len(strconv.Itoa(len(r.Array)))
+		lines := make([]string, 0, len(r.Array))
+		for i, e := range r.Array {
+			prefix := fmt.Sprintf("%*d) ", width, i+1)
+			for j, line := range strings.Split(formatHuman(e), "\n") {
+				if j > 0 {
+					prefix = strings.Repeat(" ", len(prefix))
+				}
+				lines = append(lines, prefix+line)
+			}
+		}
+		return strings.Join(lines, "\n")
+	}
+	if isNil(r) {
+		return "(nil)"
+	}
+	return string(r.Value)
+}
+
+// formatRaw prints the values as they are, one element of an array per
+// line
+func formatRaw(r *simpledb.Resp) string {
+	if r.Type == simpledb.TypeArray {
+		lines := make([]string, 0, len(r.Array))
+		for _, e := range r.Array {
+			lines = append(lines, formatRaw(e))
+		}
+		return strings.Join(lines, "\n")
+	}
+	if isNil(r) {
+		return ""
+	}
+	return string(r.Value)
+}
+
+// formatCSV prints a reply on one line, the elements of the arrays
+// separated by commas
+func formatCSV(r *simpledb.Resp) string {
+	switch r.Type {
+	case simpledb.TypeError:
+		return "ERROR," + strconv.Quote(string(r.Value))
+	case simpledb.TypeInt:
+		return string(r.Value)
+	case simpledb.TypeArray:
+		fields := make([]string, 0, len(r.Array))
+		for _, e := range r.Array {
+			fields = append(fields, formatCSV(e))
+		}
+		return strings.Join(fields, ",")
+	}
+	if isNil(r) {
+		return "NULL"
+	}
+	return strconv.Quote(string(r.Value))
+}
+
+// jsonValue converts a reply for encoding/json, an error is an object
+// with an "error" member
+func jsonValue(r *simpledb.Resp) interface{} {
+	switch r.Type {
+	case simpledb.TypeError:
+		return map[string]string{"error": string(r.Value)}
+	case simpledb.TypeInt:
+		return json.Number(r.Value)
+	case simpledb.TypeArray:
+		values := make([]interface{}, 0, len(r.Array))
+		for _, e := range r.Array {
+			values = append(values, jsonValue(e))
+		}
+		return values
+	}
+	if isNil(r) {
+		return nil
+	}
+	return string(r.Value)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"simpledb/simpledb"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+
+	bulk := func(s string) *simpledb.Resp { return simpledb.NewBulkBytes([]byte(s)) }
+	nested := simpledb.NewArray([]*simpledb.Resp{
+		bulk("a"),
+		simpledb.NewInt([]byte("1")),
+		simpledb.NewArray([]*simpledb.Resp{bulk("b\n"), simpledb.NewString([]byte("nil"))}),
+		simpledb.NewArray(nil),
+	})
+	long := make([]*simpledb.Resp, 10)
+	for i := range long {
+		long[i] = bulk("x")
+	}
+
+	var tests = []struct {
+		resp *simpledb.Resp
+		mode outputMode
+		want string
+	}{
+		{simpledb.NewString([]byte("OK")), modeHuman, "OK"},
+		{simpledb.NewString([]byte("nil")), modeHuman, "(nil)"},
+		{simpledb.NewError([]byte("ERR no")), modeHuman, "(error) ERR no"},
+		{nested, modeHuman, "1) \"a\"\n2) (integer) 1\n3) 1) \"b\\n\"\n   2) (nil)\n4) (empty array)"},
+		{simpledb.NewArray(long[:]), modeHuman, " 1) \"x\"\n 2) \"x\"\n 3) \"x\"\n 4) \"x\"\n 5) \"x\"\n" +
+			" 6) \"x\"\n 7) \"x\"\n 8) \"x\"\n 9) \"x\"\n10) \"x\""},
+		{nested, modeRaw, "a\n1\nb\n\n\n"},
+		{simpledb.NewError([]byte("ERR no")), modeRaw, "ERR no"},
+		{nested, modeCSV, "\"a\",1,\"b\\n\",NULL,"},
+		{simpledb.NewError([]byte("ERR no")), modeCSV, "ERROR,\"ERR no\""},
+		{nested, modeJSON, "[\"a\",1,[\"b\\n\",null],[]]"},
+		{simpledb.NewError([]byte("ERR no")), modeJSON, "{\"error\":\"ERR no\"}"},
+	}
+	for _, test := range tests {
+		if got := format(test.resp, test.mode); got != test.want {
+			t.Errorf("mode %d: got %q, want %q", test.mode, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"simpledb/simpledb"
+	"strconv"
+	"strings"
+)
+
+const (
+	historyFile = ".simpledb_cli_history"
+	historyMax  = 1000
+)
+
+var errUnbalancedQuotes = errors.New("Invalid argument(s)")
+
+// splitArgs splits a line into arguments. "double quotes" take the escapes
+// \n, \r, \t, \", \\ and \xHH, 'single quotes' only \'.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	for i := 0; ; {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		var arg strings.Builder
+		for ; i < len(line) && line[i] != ' ' && line[i] != '\t'; i++ {
+			quote := line[i]
+			if quote != '"' && quote != '\'' {
+				arg.WriteByte(quote)
+				continue
+			}
+			for i++; ; i++ {
+				if i == len(line) {
+					return nil, errUnbalancedQuotes
+				}
+				if line[i] == quote {
+					break
+				}
+				if line[i] != '\\' || i+1 == len(line) {
+					arg.WriteByte(line[i])
+					continue
+				}
+				i++
+				if quote == '\'' {
+					if line[i] != '\'' {
+						arg.WriteByte('\\')
+					}
+					arg.WriteByte(line[i])
+					continue
+				}
+				switch line[i] {
+				case 'n':
+					arg.WriteByte('\n')
+				case 'r':
+					arg.WriteByte('\r')
+				case 't':
+					arg.WriteByte('\t')
+				case 'x':
+					if i+2 < len(line) {
+						if b, err := strconv.ParseUint(line[i+1:i+3], 16, 8); err == nil {
+							arg.WriteByte(byte(b))
+							i += 2
+							break
+						}
+					}
+					arg.WriteByte('x')
+				default:
+					arg.WriteByte(line[i])
+				}
+			}
+			// a closing quote ends the argument
+			if i+1 < len(line) && line[i+1] != ' ' && line[i+1] != '\t' {
+				return nil, errUnbalancedQuotes
+			}
+		}
+		args = append(args, arg.String())
+	}
+}
+
+// sensitive reports whether a line holds a password, it is not written to
+// the history
+func sensitive(args []string) bool {
+	name := strings.ToUpper(args[0])
+	return name == "AUTH" || name == "MIGRATE" ||
+		name == "ACL" && len(args) > 1 && strings.ToUpper(args[1]) == "SETUSER" ||
+		name == "CONFIG" && len(args) > 1 && strings.ToUpper(args[1]) == "SET"
+}
+
+// history keeps the lines of the previous sessions in historyFile of the
+// home directory
+type history struct {
+	path  string
+	lines []string
+}
+
+func loadHistory() *history {
+	h := &history{}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return h
+	}
+	h.path = filepath.Join(home, historyFile)
+	data, err := ioutil.ReadFile(h.path)
+	if err != nil {
+		return h
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			h.lines = append(h.lines, line)
+		}
+	}
+	if len(h.lines) > historyMax {
+		h.lines = h.lines[len(h.lines)-historyMax:]
+		h.save()
+	}
+	return h
+}
+
+func (h *history) add(line string) {
+	if n := len(h.lines); n > 0 && h.lines[n-1] == line {
+		return
+	}
+	h.lines = append(h.lines, line)
+	if h.path == "" {
+		return
+	}
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+func (h *history) save() {
+	ioutil.WriteFile(h.path, []byte(strings.Join(h.lines, "\n")+"\n"), 0600)
+}
+
+// repl reads commands from the terminal until quit, exit or the end of the
+// input
+func (c *cli) repl() {
+	h := loadHistory()
+	names := make([]string, 0, len(simpledb.CommandTable))
+	for _, command := range simpledb.CommandTable {
+		names = append(names, command.Name)
+	}
+	e := &editor{
+		in:       bufio.NewReader(os.Stdin),
+		out:      os.Stdout,
+		complete: commandCompleter(names),
+	}
+	for {
+		e.history = h.lines
+		line, err := c.readLine(e)
+		if err != nil {
+			return
+		}
+		args, err := splitArgs(line)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if !sensitive(args) {
+			h.add(strings.TrimSpace(line))
+		}
+		switch strings.ToLower(args[0]) {
+		case "quit", "exit":
+			return
+		}
+		if err := c.run(args); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// readLine reads a line in raw mode, the terminal is back to normal while
+// a command runs so that ctrl-c interrupts it
+func (c *cli) readLine(e *editor) (string, error) {
+	prompt := c.addr + "> "
+	t, err := makeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		fmt.Print(prompt)
+		line, err := e.in.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	defer t.restore()
+	return e.readLine(prompt)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+
+	var tests = []struct {
+		line string
+		want []string
+		err  bool
+	}{
+		{"  set  k v ", []string{"set", "k", "v"}, false},
+		{`set k "a b\n\x41\""`, []string{"set", "k", "a b\nA\""}, false},
+		{`set k 'it\'s \n'`, []string{"set", "k", `it's \n`}, false},
+		{`set k ""`, []string{"set", "k", ""}, false},
+		{`set k "open`, nil, true},
+		{`set k "a"b`, nil, true},
+		{"", nil, false},
+	}
+	for _, test := range tests {
+		args, err := splitArgs(test.line)
+		if (err != nil) != test.err || !reflect.DeepEqual(args, test.want) {
+			t.Errorf("%q: got %q, %v, want %q", test.line, args, err, test.want)
+		}
+	}
+}
+
+func TestCommandCompleter(t *testing.T) {
+
+	complete := commandCompleter([]string{"GET", "GETSET", "SET", "DEL"})
+	var tests = []struct {
+		word string
+		want []string
+	}{
+		{"GE", []string{"GET", "GETSET"}},
+		{"ge", []string{"get", "getset"}},
+		{"Se", []string{"SET"}},
+		{"x", nil},
+	}
+	for _, test := range tests {
+		if got := complete(test.word); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.word, got, test.want)
+		}
+	}
+}
+
+func TestReadCommand(t *testing.T) {
+
+	r := bufio.NewReader(strings.NewReader("*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$4\r\na\r\nb\r\nGET k\nDEL k"))
+	for _, want := range [][]string{{"SET", "k", "a\r\nb"}, {"GET", "k"}, {"DEL", "k"}} {
+		args, err := readCommand(r)
+		if err != nil || !reflect.DeepEqual(args, want) {
+			t.Errorf("got %q, %v, want %q", args, err, want)
+		}
+	}
+	if _, err := readCommand(r); err != io.EOF {
+		t.Errorf("end: got %v, want EOF", err)
+	}
+	r = bufio.NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$5\r\nk\r\n"))
+	if _, err := readCommand(r); err != io.ErrUnexpectedEOF {
+		t.Errorf("short bulk: got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
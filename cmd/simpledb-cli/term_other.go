@@ -0,0 +1,22 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// without termios the lines are read as the terminal gives them, with
+// no history keys nor completion
+
+type terminal struct{}
+
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (*terminal, error) {
+	return nil, errors.New("raw mode is not supported")
+}
+
+func (t *terminal) restore() error {
+	return nil
+}
@@ -0,0 +1,54 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// terminal is the state of a terminal put in raw mode, restore puts it
+// back
+type terminal struct {
+	fd    int
+	state syscall.Termios
+}
+
+func ioctl(fd int, request uintptr, termios *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func isTerminal(fd int) bool {
+	var termios syscall.Termios
+	return ioctl(fd, ioctlGetTermios, &termios) == nil
+}
+
+// makeRaw turns off the echo, the line editing and the signal keys of the
+// terminal, the keys are then read one by one
+func makeRaw(fd int) (*terminal, error) {
+	t := &terminal{fd: fd}
+	if err := ioctl(fd, ioctlGetTermios, &t.state); err != nil {
+		return nil, err
+	}
+	raw := t.state
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, ioctlSetTermios, &raw); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *terminal) restore() error {
+	return ioctl(t.fd, ioctlSetTermios, &t.state)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readCommand reads a command of the pipe, a RESP array of bulk strings or
+// an inline command line
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, "*") {
+		return splitArgs(line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("protocol error: bad array header %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		header = strings.TrimRight(header, "\r\n")
+		size, err := strconv.Atoi(strings.TrimPrefix(header, "$"))
+		if !strings.HasPrefix(header, "$") || err != nil || size < 0 {
+			return nil, fmt.Errorf("protocol error: bad bulk header %q", header)
+		}
+		bulk := make([]byte, size+2)
+		if _, err := io.ReadFull(r, bulk); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		args[i] = string(bulk[:size])
+	}
+	return args, nil
+}
+
+// pipe sends the commands of in without waiting for their replies, which
+// are read at the same time and counted, the errors are printed
+func (c *cli) pipe(in io.Reader) error {
+	r := bufio.NewReaderSize(in, 64*1024)
+	type result struct {
+		replies, errors int
+		err             error
+	}
+	pending := make(chan struct{}, 64*1024)
+	done := make(chan result, 1)
+	go func() {
+		var res result
+		for range pending {
+			resp, err := c.client.Receive()
+			if err != nil {
+				res.err = err
+				break
+			}
+			res.replies++
+			if resp.IsError() {
+				res.errors++
+				fmt.Fprintln(os.Stderr, string(resp.Value))
+			}
+		}
+		done <- res
+		for range pending {
+		}
+	}()
+
+	var err error
+	sent := 0
+	for {
+		var args []string
+		if args, err = readCommand(r); err != nil {
+			break
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if err = c.client.Send(args...); err != nil {
+			break
+		}
+		sent++
+		pending <- struct{}{}
+		// the commands read so far are sent before waiting on in
+		if r.Buffered() == 0 {
+			if err = c.client.Flush(); err != nil {
+				break
+			}
+		}
+	}
+	if err == io.EOF {
+		err = nil
+		if sent > 0 {
+			err = c.client.Flush()
+		}
+	}
+	close(pending)
+	if err == nil {
+		fmt.Println("All data transferred. Waiting for the last reply...")
+	}
+	res := <-done
+	fmt.Printf("errors: %d, replies: %d\n", res.errors, res.replies)
+	if err == nil {
+		err = res.err
+	}
+	if err == nil && res.errors > 0 {
+		err = fmt.Errorf("%d commands failed", res.errors)
+	}
+	return err
+}
+
+// scanKeys calls fn with the keys matching pattern, a key may come twice
+// when the keyspace changes during the scan
+func (c *cli) scanKeys(pattern string, fn func(key string) error) error {
+	var cursor uint64
+	for {
+		resp, err := c.client.Scan(cursor, "MATCH", pattern, "COUNT", "100")
+		if err != nil {
+			return err
+		}
+		if resp.IsError() {
+			return errors.New(string(resp.Value))
+		}
+		if len(resp.Array) != 2 {
+			return fmt.Errorf("unexpected SCAN reply: %s", format(resp, modeCSV))
+		}
+		if cursor, err = strconv.ParseUint(string(resp.Array[0].Value), 10, 64); err != nil {
+			return fmt.Errorf("unexpected SCAN cursor: %s", resp.Array[0].Value)
+		}
+		for _, key := range resp.Array[1].Array {
+			if err := fn(string(key.Value)); err != nil {
+				return err
+			}
+		}
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+func (c *cli) scan(pattern string) error {
+	return c.scanKeys(pattern, func(key string) error {
+		fmt.Println(key)
+		return nil
+	})
+}
+
+// the size of a key is the bytes of a string and the elements of the
+// other types
+var bigKeyTypes = []struct {
+	name, plural, command, unit string
+}{
+	{"string", "strings", "GET", "bytes"},
+	{"list", "lists", "LLEN", "items"},
+	{"hash", "hashes", "HLEN", "fields"},
+	{"set", "sets", "SCARD", "members"},
+	{"zset", "zsets", "ZCARD", "members"},
+}
+
+// bigKeys scans the keyspace for the biggest key of each type
+func (c *cli) bigKeys() error {
+	type stats struct {
+		keys, size int64
+		biggest    string
+		max        int64
+	}
+	byType := make(map[string]*stats)
+	for _, t := range bigKeyTypes {
+		byType[t.name] = &stats{}
+	}
+	var keys, keyBytes int64
+
+	fmt.Println("# Scanning the entire keyspace to find biggest keys per type")
+	fmt.Println()
+	err := c.scanKeys("*", func(key string) error {
+		resp, err := c.client.Type(key)
+		if err != nil {
+			return err
+		}
+		typ := string(resp.Value)
+		for _, t := range bigKeyTypes {
+			if t.name != typ {
+				continue
+			}
+			resp, err := c.client.Do(t.command, key)
+			if err != nil {
+				return err
+			}
+			size := int64(len(resp.Value))
+			if t.name != "string" {
+				size, _ = strconv.ParseInt(string(resp.Value), 10, 64)
+			}
+			s := byType[typ]
+			keys++
+			keyBytes += int64(len(key))
+			s.keys++
+			s.size += size
+			if s.biggest == "" || size > s.max {
+				s.biggest, s.max = key, size
+				fmt.Printf("Biggest %6s found so far %q with %d %s\n", typ, key, size, t.unit)
+			}
+		}
+		// a key deleted during the scan is of type none
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Println("-------- summary -------")
+	fmt.Println()
+	fmt.Printf("Sampled %d keys in the keyspace!\n", keys)
+	fmt.Printf("Total key length in bytes is %d (avg len %.2f)\n", keyBytes, average(keyBytes, keys))
+	fmt.Println()
+	for _, t := range bigKeyTypes {
+		if s := byType[t.name]; s.keys > 0 {
+			fmt.Printf("Biggest %6s found %q has %d %s\n", t.name, s.biggest, s.max, t.unit)
+		}
+	}
+	fmt.Println()
+	for _, t := range bigKeyTypes {
+		s := byType[t.name]
+		fmt.Printf("%d %s with %d %s (%05.2f%% of keys, avg size %.2f)\n",
+			s.keys, t.plural, s.size, t.unit, 100*average(s.keys, keys), average(s.size, s.keys))
+	}
+	return nil
+}
+
+func average(total, n int64) float64 {
+	if n == 0 {
+		return 0
+	}
+	return float64(total) / float64(n)
+}
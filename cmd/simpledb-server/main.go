@@ -0,0 +1,132 @@
+// Command simpledb-server runs a simpledb server.
+//
+//	simpledb-server [--config file] [--port port] [--bind host] [--dir dir]
+//	                [--loglevel level] [--pidfile file]
+//
+// The flags override the config file, see config.Load. SIGTERM and SIGINT
+// shut the server down gracefully, SIGHUP reloads the config file and
+// applies the parameters CONFIG SET can change.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"simpledb/simpledb"
+	"simpledb/simpledb/config"
+	"strconv"
+	"syscall"
+)
+
+var logLevels = map[string]bool{"debug": true, "verbose": true, "notice": true, "warning": true}
+
+func main() {
+	configPath := config.Flag(flag.CommandLine)
+	port := flag.Int("port", 0, "TCP port, 0 with --unixsocket listens on the socket only")
+	bind := flag.String("bind", "", "address to listen on")
+	unixSocket := flag.String("unixsocket", "", "unix socket path")
+	dir := flag.String("dir", "", "working directory, where relative paths such as aclfile are found")
+	logLevel := flag.String("loglevel", "notice", "debug, verbose, notice or warning")
+	pidFile := flag.String("pidfile", "", "file the process id is written to, removed on exit")
+	flag.Parse()
+
+	// the server logs every command, it is only shown at debug and verbose
+	notice := log.New(os.Stderr, "", log.LstdFlags)
+	if !logLevels[*logLevel] {
+		notice.Fatalf("--loglevel: unknown level %q, want debug, verbose, notice or warning", *logLevel)
+	}
+	if *logLevel != "debug" && *logLevel != "verbose" {
+		log.SetOutput(ioutil.Discard)
+	}
+	if *logLevel == "warning" {
+		notice.SetOutput(ioutil.Discard)
+	}
+
+	conf, err := config.Load(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	// the flags given on the command line override the file
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			conf.Server.Port = *port
+		case "bind":
+			conf.Server.Host = *bind
+		case "unixsocket":
+			conf.Server.UnixSocket = *unixSocket
+		}
+	})
+	if err := conf.Validate(); err != nil {
+		fatal(err)
+	}
+	// SIGHUP and CONFIG REWRITE find the file again after --dir
+	if conf.Path != "" {
+		if conf.Path, err = filepath.Abs(conf.Path); err != nil {
+			fatal(err)
+		}
+	}
+	if *dir != "" {
+		if err := os.Chdir(*dir); err != nil {
+			fatal(err)
+		}
+	}
+	if *pidFile != "" {
+		if err := ioutil.WriteFile(*pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+			fatal(err)
+		}
+		defer os.Remove(*pidFile)
+	}
+
+	server := simpledb.NewServer(conf)
+	fmt.Print(server.Banner())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	go func() {
+		for sig := range signals {
+			if sig != syscall.SIGHUP {
+				notice.Printf("received %v, shutting down", sig)
+				// a failed save keeps the server running, as SHUTDOWN does
+				if err := server.Shutdown(); err != nil {
+					notice.Printf("shutdown: %v", err)
+					continue
+				}
+				return
+			}
+			reload(server, conf.Path, notice)
+		}
+	}()
+
+	if err := server.Run(); err != nil {
+		if *pidFile != "" {
+			os.Remove(*pidFile)
+		}
+		fatal(err)
+	}
+	<-server.Done()
+}
+
+// reload reads the config file again and applies it to the server
+func reload(server *simpledb.Server, path string, notice *log.Logger) {
+	conf, err := config.Load(path)
+	if err != nil {
+		notice.Printf("reload the config: %v, keeping the running config", err)
+		return
+	}
+	changed, err := server.Reload(conf)
+	if err != nil {
+		notice.Printf("reload the config: %v, keeping the running config", err)
+		return
+	}
+	notice.Printf("config reloaded, changed: %v", changed)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "simpledb-server:", err)
+	os.Exit(1)
+}
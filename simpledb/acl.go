@@ -0,0 +1,885 @@
+package simpledb
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// acl commands:
+// auth, acl setuser|getuser|deluser|list|users|whoami|cat|log|load|save
+//
+// a connection runs its commands as a user. While the default user has
+// nopass every connection starts as default, else AUTH is required first.
+//
+// a user is built by rules applied in order, as in redis:
+//
+//	on, off                     enable or disable the user
+//	>password, <password        add or remove a password, kept as sha256
+//	#hash, !hash                add or remove a sha256 hash
+//	nopass, resetpass           any password, or none
+//	~pattern, allkeys, resetkeys        keys the commands may access
+//	&pattern, allchannels, resetchannels  pub/sub channels
+//	+command, -command, +command|sub    commands, or one subcommand
+//	+@category, -@category      categories, allcommands and nocommands
+//	reset                       off resetpass resetkeys resetchannels -@all
+//
+// the categories come from Command.SFlag: @read, @write and @admin, and
+// @all for every command. The users are loaded from and saved to aclfile,
+// one "user name rules..." line each, the format of ACL LIST.
+
+const aclLogMaxLen = 128
+
+var aclCategories = map[string]byte{"read": 'r', "write": 'w', "admin": 'a'}
+
+var (
+	errNoAuth        = errors.New("NOAUTH Authentication required.")
+	errWrongPass     = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	errAuthNoPass    = errors.New("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	errNoPermKey     = errors.New("NOPERM No permissions to access a key")
+	errNoPermChannel = errors.New("NOPERM No permissions to access a channel")
+	errACLSubcmd     = errors.New("ERR unknown subcommand, try ACL SETUSER|GETUSER|DELUSER|LIST|USERS|WHOAMI|CAT|LOG|LOAD|SAVE")
+	errDeleteDefault = errors.New("ERR The 'default' user cannot be removed")
+	errNoACLFile     = errors.New("ERR This instance is not configured to use an ACL file")
+)
+
+// User is an ACL user
+type User struct {
+	name        string
+	enabled     bool
+	nopass      bool
+	passwords   []string        // sha256 hex
+	commands    map[string]bool // allowed commands
+	subcommands map[string]bool // "COMMAND|SUB" overriding commands
+	rules       []string        // command rules, for ACL LIST
+	allKeys     bool
+	keys        []string
+	allChannels bool
+	channels    []string
+}
+
+func newUser(name string) *User {
+	u := &User{name: name}
+	u.reset()
+	return u
+}
+
+func (u *User) reset() {
+	u.enabled, u.nopass, u.passwords = false, false, nil
+	u.commands = make(map[string]bool)
+	u.subcommands = make(map[string]bool)
+	u.rules = []string{"-@all"}
+	u.allKeys, u.keys = false, nil
+	u.allChannels, u.channels = false, nil
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func isPasswordHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil && strings.ToLower(hash) == hash
+}
+
+func (u *User) addPassword(hash string) {
+	u.nopass = false
+	for _, p := range u.passwords {
+		if p == hash {
+			return
+		}
+	}
+	u.passwords = append(u.passwords, hash)
+}
+
+func (u *User) removePassword(hash string) bool {
+	for i, p := range u.passwords {
+		if p == hash {
+			u.passwords = append(u.passwords[:i], u.passwords[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// setCommands allows or denies the commands match selects
+func (u *User) setCommands(allow bool, match func(*Command) bool) {
+	for _, c := range CommandTable {
+		if !match(c) {
+			continue
+		}
+		if allow {
+			u.commands[c.Name] = true
+		} else {
+			delete(u.commands, c.Name)
+		}
+		for sub := range u.subcommands {
+			if strings.HasPrefix(sub, c.Name+"|") {
+				delete(u.subcommands, sub)
+			}
+		}
+	}
+}
+
+func (u *User) commandRule(rule string) error {
+	allow := rule[0] == '+'
+	name := rule[1:]
+	switch {
+	case strings.HasPrefix(name, "@"):
+		category := strings.ToLower(name[1:])
+		flag, ok := aclCategories[category]
+		if category != "all" && !ok {
+			return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': Unknown command or category name in ACL", rule)
+		}
+		u.setCommands(allow, func(c *Command) bool {
+			return category == "all" || c.SFlag == flag
+		})
+		if category == "all" {
+			// +@all and -@all override every rule before them
+			u.rules = nil
+		}
+	case strings.Contains(name, "|"):
+		parts := strings.SplitN(name, "|", 2)
+		command := LookupCommand(parts[0])
+		if command == nil || parts[1] == "" {
+			return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': Unknown command or category name in ACL", rule)
+		}
+		u.subcommands[command.Name+"|"+strings.ToUpper(parts[1])] = allow
+	default:
+		command := LookupCommand(name)
+		if command == nil {
+			return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': Unknown command or category name in ACL", rule)
+		}
+		u.setCommands(allow, func(c *Command) bool { return c == command })
+	}
+	u.rules = append(u.rules, strings.ToLower(rule))
+	return nil
+}
+
+// setRule applies one rule to the user
+func (u *User) setRule(rule string) error {
+	if rule == "" {
+		return errSyntax
+	}
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+	case "off":
+		u.enabled = false
+	case "nopass":
+		u.nopass, u.passwords = true, nil
+	case "resetpass":
+		u.nopass, u.passwords = false, nil
+	case "reset":
+		u.reset()
+	case "allkeys", "~*":
+		u.allKeys, u.keys = true, nil
+	case "resetkeys":
+		u.allKeys, u.keys = false, nil
+	case "allchannels", "&*":
+		u.allChannels, u.channels = true, nil
+	case "resetchannels":
+		u.allChannels, u.channels = false, nil
+	case "allcommands":
+		return u.commandRule("+@all")
+	case "nocommands":
+		return u.commandRule("-@all")
+	default:
+		switch rule[0] {
+		case '>':
+			u.addPassword(hashPassword(rule[1:]))
+		case '<':
+			if !u.removePassword(hashPassword(rule[1:])) {
+				return fmt.Errorf("ERR Error in ACL SETUSER modifier '<...>': no such password")
+			}
+		case '#':
+			if !isPasswordHash(rule[1:]) {
+				return fmt.Errorf("ERR Error in ACL SETUSER modifier '#...': The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+			}
+			u.addPassword(rule[1:])
+		case '!':
+			if !u.removePassword(rule[1:]) {
+				return fmt.Errorf("ERR Error in ACL SETUSER modifier '!...': no such password")
+			}
+		case '~':
+			if !u.allKeys {
+				u.keys = append(u.keys, rule[1:])
+			}
+		case '&':
+			if !u.allChannels {
+				u.channels = append(u.channels, rule[1:])
+			}
+		case '+', '-':
+			return u.commandRule(rule)
+		default:
+			return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': Syntax error", rule)
+		}
+	}
+	return nil
+}
+
+// describe returns the rules rebuilding the user
+func (u *User) describe() []string {
+	rules := []string{"off"}
+	if u.enabled {
+		rules[0] = "on"
+	}
+	if u.nopass {
+		rules = append(rules, "nopass")
+	}
+	for _, p := range u.passwords {
+		rules = append(rules, "#"+p)
+	}
+	if u.allKeys {
+		rules = append(rules, "~*")
+	}
+	for _, k := range u.keys {
+		rules = append(rules, "~"+k)
+	}
+	switch {
+	case u.allChannels:
+		rules = append(rules, "&*")
+	case len(u.channels) == 0:
+		rules = append(rules, "resetchannels")
+	}
+	for _, c := range u.channels {
+		rules = append(rules, "&"+c)
+	}
+	return append(rules, u.rules...)
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if matchPattern(p, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// key positions of the commands whose keys are not only their first
+// argument, last -1 is the last argument, a zero spec is no key
+type keySpec struct {
+	first, last, step int
+}
+
+var keySpecs = map[string]keySpec{
+	"DEL": {1, -1, 1}, "EXISTS": {1, -1, 1}, "MGET": {1, -1, 1}, "TOUCH": {1, -1, 1},
+	"UNLINK": {1, -1, 1}, "WATCH": {1, -1, 1}, "MSET": {1, -1, 2},
+	"RENAME": {1, 2, 1}, "RENAMENX": {1, 2, 1}, "COPY": {1, 2, 1},
+	"SDIFF": {1, -1, 1}, "SDIFFSCORE": {1, -1, 1}, "SINTER": {1, -1, 1},
+	"SINTERSCORE": {1, -1, 1}, "SUNION": {1, -1, 1}, "SUNIONSCORE": {1, -1, 1},
+	"OBJECT": {2, 2, 1},
+
+	"KEYS": {}, "SCAN": {}, "RANDOMKEY": {}, "DBSIZE": {},
+	"SUBSCRIBE": {}, "UNSUBSCRIBE": {}, "PSUBSCRIBE": {}, "PUNSUBSCRIBE": {}, "PUBLISH": {},
+	"MULTI": {}, "EXEC": {}, "DISCARD": {}, "UNWATCH": {},
+	"SCRIPT": {}, "FUNCTION": {}, "INFO": {}, "CONFIG": {}, "SLOWLOG": {}, "LATENCY": {},
+	"CLIENT": {}, "MONITOR": {}, "AUTH": {}, "ACL": {}, "SHUTDOWN": {},
+}
+
+// commandKeys returns the keys a command accesses
+func commandKeys(command *Command, args []string) []string {
+	switch command.Name {
+	case "EVAL", "EVALSHA", "FCALL", "FCALL_RO":
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 || 3+n > len(args) {
+			return nil
+		}
+		return args[3 : 3+n]
+	case "MEMORY":
+		if len(args) > 2 && strings.ToUpper(args[1]) == "USAGE" {
+			return args[2:3]
+		}
+		return nil
+	case "SORT":
+		keys := args[1:2]
+		for i := 2; i+1 < len(args); i++ {
+			if strings.ToUpper(args[i]) == "STORE" {
+				keys = append(keys, args[i+1])
+			}
+		}
+		return keys
+	case "MIGRATE":
+		if len(args) > 3 && args[3] != "" {
+			return args[3:4]
+		}
+		for i := 6; i < len(args); i++ {
+			if strings.ToUpper(args[i]) == "KEYS" {
+				return args[i+1:]
+			}
+		}
+		return nil
+	}
+	spec, ok := keySpecs[command.Name]
+	if !ok {
+		spec = keySpec{1, 1, 1}
+	}
+	if spec.step == 0 || spec.first >= len(args) {
+		return nil
+	}
+	last := spec.last
+	if last < 0 || last >= len(args) {
+		last = len(args) - 1
+	}
+	var keys []string
+	for i := spec.first; i <= last; i += spec.step {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+// aclDenial is why a command was refused, for ACL LOG
+type aclDenial struct {
+	reason string // command, key, channel or auth
+	object string
+	err    error
+}
+
+// check returns why the user may not run the command, nil when it may
+func (u *User) check(command *Command, args []string) *aclDenial {
+	allowed := u.commands[command.Name]
+	name := strings.ToLower(command.Name)
+	if len(args) > 1 {
+		sub := command.Name + "|" + strings.ToUpper(args[1])
+		if v, ok := u.subcommands[sub]; ok {
+			allowed, name = v, strings.ToLower(sub)
+		}
+	}
+	if !allowed {
+		return &aclDenial{"command", name,
+			fmt.Errorf("NOPERM User %s has no permissions to run the '%s' command", u.name, name)}
+	}
+	if !u.allKeys {
+		for _, key := range commandKeys(command, args) {
+			if !matchAny(u.keys, key) {
+				return &aclDenial{"key", key, errNoPermKey}
+			}
+		}
+	}
+	if u.allChannels {
+		return nil
+	}
+	switch command.Name {
+	case "SUBSCRIBE", "PUBLISH":
+		channels := args[1:]
+		if command.Name == "PUBLISH" {
+			channels = args[1:2]
+		}
+		for _, channel := range channels {
+			if !matchAny(u.channels, channel) {
+				return &aclDenial{"channel", channel, errNoPermChannel}
+			}
+		}
+	case "PSUBSCRIBE":
+		// a pattern is allowed only when it is one of the user patterns
+		for _, pattern := range args[1:] {
+			if !contains(u.channels, pattern) {
+				return &aclDenial{"channel", pattern, errNoPermChannel}
+			}
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+type aclLogEntry struct {
+	id       int64
+	count    int
+	reason   string
+	context  string // toplevel, multi or lua
+	object   string
+	username string
+	client   string
+	created  time.Time
+	updated  time.Time
+}
+
+// ACL holds the users and the log of the refused commands and logins
+type ACL struct {
+	mu        sync.RWMutex
+	users     map[string]*User
+	file      string
+	log       []*aclLogEntry // newest first
+	nextLogID int64
+}
+
+func defaultACLUser() *User {
+	u := newUser(defaultUser)
+	for _, rule := range []string{"on", "nopass", "~*", "&*", "+@all"} {
+		u.setRule(rule)
+	}
+	return u
+}
+
+// newACL returns the default user alone, protected by requirePass when
+// set, the users of file are read by load
+func newACL(requirePass, file string) *ACL {
+	a := &ACL{
+		mu:    sync.RWMutex{},
+		users: map[string]*User{defaultUser: defaultACLUser()},
+		file:  file,
+	}
+	a.setRequirePass(requirePass)
+	return a
+}
+
+// setRequirePass sets the only password of the default user, none when
+// empty
+func (a *ACL) setRequirePass(password string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	u := a.users[defaultUser]
+	if password == "" {
+		u.setRule("nopass")
+		return
+	}
+	u.setRule("resetpass")
+	u.setRule(">" + password)
+}
+
+// defaultNoPass reports whether new connections are authenticated as the
+// default user
+func (a *ACL) defaultNoPass() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	u := a.users[defaultUser]
+	return u.enabled && u.nopass
+}
+
+// userEnabled reports whether the user exists and is on
+func (a *ACL) userEnabled(name string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	u, ok := a.users[name]
+	return ok && u.enabled
+}
+
+func (a *ACL) authenticate(name, password string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	u, ok := a.users[name]
+	if !ok || !u.enabled {
+		return false
+	}
+	return u.nopass || contains(u.passwords, hashPassword(password))
+}
+
+func (a *ACL) check(name string, command *Command, args []string) *aclDenial {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	u, ok := a.users[name]
+	if !ok {
+		return &aclDenial{"auth", command.Name, errNoAuth}
+	}
+	return u.check(command, args)
+}
+
+// addLog records a denial, a denial like a recent one only counts it
+func (a *ACL) addLog(d *aclDenial, context, username, client string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	for _, e := range a.log {
+		if e.reason == d.reason && e.context == context && e.object == d.object && e.username == username {
+			e.count++
+			e.updated, e.client = now, client
+			return
+		}
+	}
+	a.log = append([]*aclLogEntry{{
+		id: a.nextLogID, count: 1, reason: d.reason, context: context, object: d.object,
+		username: username, client: client, created: now, updated: now,
+	}}, a.log...)
+	a.nextLogID++
+	if len(a.log) > aclLogMaxLen {
+		a.log = a.log[:aclLogMaxLen]
+	}
+}
+
+func (a *ACL) list() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	names := make([]string, 0, len(a.users))
+	for name := range a.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = "user " + name + " " + strings.Join(a.users[name].describe(), " ")
+	}
+	return lines
+}
+
+// parseACLLines builds the users of ACL file lines
+func parseACLLines(lines []string, source string) (map[string]*User, error) {
+	users := make(map[string]*User)
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return nil, fmt.Errorf("ERR %s:%d: line should start with user keyword", source, i+1)
+		}
+		u := newUser(fields[1])
+		for _, rule := range fields[2:] {
+			if err := u.setRule(rule); err != nil {
+				return nil, fmt.Errorf("ERR %s:%d: %s", source, i+1, strings.TrimPrefix(err.Error(), "ERR "))
+			}
+		}
+		users[u.name] = u
+	}
+	return users, nil
+}
+
+func loadACLFile(file string) (map[string]*User, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		// a missing file is an empty one, ACL SAVE creates it
+		return parseACLLines(nil, file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseACLLines(strings.Split(string(data), "\n"), file)
+}
+
+// load replaces the users with those of the file, the default user is
+// kept when the file has none. It returns the names of the users removed
+func (a *ACL) load() ([]string, error) {
+	if a.file == "" {
+		return nil, errNoACLFile
+	}
+	users, err := loadACLFile(a.file)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := users[defaultUser]; !ok {
+		users[defaultUser] = a.users[defaultUser]
+	}
+	var removed []string
+	for name := range a.users {
+		if _, ok := users[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	a.users = users
+	return removed, nil
+}
+
+// save writes the users to the file, through a temporary file so a
+// failure keeps the old one
+func (a *ACL) save() error {
+	if a.file == "" {
+		return errNoACLFile
+	}
+	tmp := a.file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, line := range a.list() {
+		w.WriteString(line + "\n")
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.file)
+}
+
+// checkACL refuses a command the user of the connection may not run
+func (s *Server) checkACL(command *Command, resp *Resp, context string) error {
+	if command.Name == "AUTH" {
+		return nil
+	}
+	args := make([]string, len(resp.Array))
+	for i, arg := range resp.Array {
+		args[i] = string(arg.Value)
+	}
+	d := s.acl.check(s.user, command, args)
+	if d == nil {
+		return nil
+	}
+	s.acl.addLog(d, context, s.user, s.clientLine())
+	return d.err
+}
+
+// clientLine is the CLIENT INFO line of the connection
+func (s *Server) clientLine() string {
+	if s.client == nil {
+		return ""
+	}
+	return s.client.line(time.Now())
+}
+
+// killUsers closes the connections of the users, the connection of s once
+// its reply is written
+func (s *Server) killUsers(names []string) (self bool) {
+	for _, name := range names {
+		if _, me := s.killClients(&killFilter{user: name}); me {
+			self = true
+		}
+	}
+	return self
+}
+
+func (s *Server) setUser(name string) {
+	s.user = name
+	if s.client != nil {
+		s.client.mu.Lock()
+		s.client.user = name
+		s.client.mu.Unlock()
+	}
+}
+
+func auth(s *Server, resp *Resp) error {
+	if len(resp.Array) > 3 {
+		return s.replyErr(errSyntax)
+	}
+	name, password := defaultUser, string(resp.Array[1].Value)
+	if len(resp.Array) == 3 {
+		name, password = string(resp.Array[1].Value), string(resp.Array[2].Value)
+	} else if s.acl.defaultNoPass() {
+		return s.replyErr(errAuthNoPass)
+	}
+	if !s.acl.authenticate(name, password) {
+		s.acl.addLog(&aclDenial{reason: "auth", object: "AUTH"}, "toplevel", name, s.clientLine())
+		return s.replyErr(errWrongPass)
+	}
+	s.setUser(name)
+	return s.replyOk()
+}
+
+func aclGetUser(u *User) *Resp {
+	flags := []*Resp{NewBulkBytes([]byte("off"))}
+	if u.enabled {
+		flags[0] = NewBulkBytes([]byte("on"))
+	}
+	if u.nopass {
+		flags = append(flags, NewBulkBytes([]byte("nopass")))
+	}
+	var passwords []*Resp
+	for _, p := range u.passwords {
+		passwords = append(passwords, NewBulkBytes([]byte(p)))
+	}
+	keys, channels := "", ""
+	if u.allKeys {
+		keys = "~*"
+	} else if len(u.keys) > 0 {
+		keys = "~" + strings.Join(u.keys, " ~")
+	}
+	if u.allChannels {
+		channels = "&*"
+	} else if len(u.channels) > 0 {
+		channels = "&" + strings.Join(u.channels, " &")
+	}
+	return NewArray([]*Resp{
+		NewBulkBytes([]byte("flags")), NewArray(flags),
+		NewBulkBytes([]byte("passwords")), NewArray(passwords),
+		NewBulkBytes([]byte("commands")), NewBulkBytes([]byte(strings.Join(u.rules, " "))),
+		NewBulkBytes([]byte("keys")), NewBulkBytes([]byte(keys)),
+		NewBulkBytes([]byte("channels")), NewBulkBytes([]byte(channels)),
+	})
+}
+
+func (e *aclLogEntry) resp(now time.Time) *Resp {
+	bulk := func(s string) *Resp { return NewBulkBytes([]byte(s)) }
+	integer := func(n int64) *Resp { return NewInt([]byte(strconv.FormatInt(n, 10))) }
+	age := strconv.FormatFloat(now.Sub(e.created).Seconds(), 'f', 3, 64)
+	return NewArray([]*Resp{
+		bulk("count"), integer(int64(e.count)),
+		bulk("reason"), bulk(e.reason),
+		bulk("context"), bulk(e.context),
+		bulk("object"), bulk(e.object),
+		bulk("username"), bulk(e.username),
+		bulk("age-seconds"), bulk(age),
+		bulk("client-info"), bulk(e.client),
+		bulk("entry-id"), integer(e.id),
+		bulk("timestamp-created"), integer(e.created.UnixNano() / int64(time.Millisecond)),
+		bulk("timestamp-last-updated"), integer(e.updated.UnixNano() / int64(time.Millisecond)),
+	})
+}
+
+func aclCommand(s *Server, resp *Resp) error {
+	args := make([]string, len(resp.Array)-2)
+	for i, arg := range resp.Array[2:] {
+		args[i] = string(arg.Value)
+	}
+	a := s.acl
+	switch strings.ToUpper(string(resp.Array[1].Value)) {
+	case "SETUSER":
+		if len(args) == 0 {
+			return s.replyErr(errACLSubcmd)
+		}
+		a.mu.Lock()
+		u, ok := a.users[args[0]]
+		// the rules apply to a copy, a bad rule changes nothing
+		updated := newUser(args[0])
+		if ok {
+			*updated = *u
+			updated.commands = copyBoolMap(u.commands)
+			updated.subcommands = copyBoolMap(u.subcommands)
+			updated.passwords = append([]string(nil), u.passwords...)
+			updated.rules = append([]string(nil), u.rules...)
+			updated.keys = append([]string(nil), u.keys...)
+			updated.channels = append([]string(nil), u.channels...)
+		}
+		for _, rule := range args[1:] {
+			if err := updated.setRule(rule); err != nil {
+				a.mu.Unlock()
+				return s.replyErr(err)
+			}
+		}
+		a.users[args[0]] = updated
+		a.mu.Unlock()
+		return s.replyOk()
+	case "GETUSER":
+		if len(args) != 1 {
+			return s.replyErr(errACLSubcmd)
+		}
+		a.mu.RLock()
+		u, ok := a.users[args[0]]
+		var reply *Resp
+		if ok {
+			reply = aclGetUser(u)
+		}
+		a.mu.RUnlock()
+		if !ok {
+			return s.replyNil()
+		}
+		return s.writeResp(reply)
+	case "DELUSER":
+		var deleted []string
+		a.mu.Lock()
+		for _, name := range args {
+			if name == defaultUser {
+				a.mu.Unlock()
+				return s.replyErr(errDeleteDefault)
+			}
+		}
+		for _, name := range args {
+			if _, ok := a.users[name]; ok {
+				delete(a.users, name)
+				deleted = append(deleted, name)
+			}
+		}
+		a.mu.Unlock()
+		self := s.killUsers(deleted)
+		err := s.writeResp(NewInt([]byte(strconv.Itoa(len(deleted)))))
+		if self {
+			s.conn.Close()
+		}
+		return err
+	case "LIST":
+		return s.writeArgs(a.list())
+	case "USERS":
+		var names []string
+		for _, line := range a.list() {
+			names = append(names, strings.Fields(line)[1])
+		}
+		return s.writeArgs(names)
+	case "WHOAMI":
+		return s.writeResp(NewBulkBytes([]byte(s.user)))
+	case "CAT":
+		if len(args) == 0 {
+			return s.writeArgs([]string{"all", "read", "write", "admin"})
+		}
+		category := strings.ToLower(args[0])
+		flag, ok := aclCategories[category]
+		if !ok && category != "all" {
+			return s.replyErr(fmt.Errorf("ERR Unknown category '%s'", args[0]))
+		}
+		var names []string
+		for _, c := range CommandTable {
+			if category == "all" || c.SFlag == flag {
+				names = append(names, strings.ToLower(c.Name))
+			}
+		}
+		return s.writeArgs(names)
+	case "LOG":
+		count := 10
+		if len(args) == 1 && strings.ToUpper(args[0]) == "RESET" {
+			a.mu.Lock()
+			a.log = nil
+			a.mu.Unlock()
+			return s.replyOk()
+		}
+		if len(args) == 1 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 0 {
+				return s.replyErr(errInteger)
+			}
+			count = n
+		}
+		now := time.Now()
+		var entries []*Resp
+		a.mu.RLock()
+		for i, e := range a.log {
+			if i == count {
+				break
+			}
+			entries = append(entries, e.resp(now))
+		}
+		a.mu.RUnlock()
+		return s.writeResp(NewArray(entries))
+	case "LOAD":
+		removed, err := a.load()
+		if err != nil {
+			return s.replyErr(err)
+		}
+		err = s.replyOk()
+		if s.killUsers(removed) {
+			s.conn.Close()
+		}
+		return err
+	case "SAVE":
+		if err := a.save(); err != nil {
+			return s.replyErr(err)
+		}
+		return s.replyOk()
+	}
+	return s.replyErr(errACLSubcmd)
+}
+
+func copyBoolMap(m map[string]bool) map[string]bool {
+	c := make(map[string]bool, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,270 @@
+package simpledb
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUser_SetRule(t *testing.T) {
+
+	var tests = []struct {
+		rules []string
+		want  string
+	}{
+		{nil, "off resetchannels -@all"},
+		{[]string{"on", "nopass", "~*", "&*", "+@all"}, "on nopass ~* &* +@all"},
+		{[]string{"on", "allkeys", "allchannels", "allcommands"}, "on ~* &* +@all"},
+		{[]string{"on", "~app:*", "&news", "+@read", "-keys"}, "on ~app:* &news -@all +@read -keys"},
+		{[]string{"+@all", "-@admin", "+client|id"}, "off resetchannels +@all -@admin +client|id"},
+		{[]string{"+get", "-@all"}, "off resetchannels -@all"},
+		{[]string{"on", "~a", "resetkeys", "&b", "resetchannels", "reset"}, "off resetchannels -@all"},
+		{[]string{"nopass", ">pw", "<pw"}, "off resetchannels -@all"},
+	}
+	for _, test := range tests {
+		u := newUser("alice")
+		for _, rule := range test.rules {
+			if err := u.setRule(rule); err != nil {
+				t.Fatalf("%v: %v", test.rules, err)
+			}
+		}
+		if got := strings.Join(u.describe(), " "); got != test.want {
+			t.Errorf("%v: got %q, want %q", test.rules, got, test.want)
+		}
+	}
+
+	u := newUser("alice")
+	u.setRule(">secret")
+	if got := u.describe()[1]; got != "#"+hashPassword("secret") {
+		t.Errorf("password: got %s, want its sha256", got)
+	}
+	for _, rule := range []string{"+nosuchcommand", "+@nosuchcategory", "#abc", "<unknown", "bogus", ""} {
+		if err := newUser("alice").setRule(rule); err == nil {
+			t.Errorf("%q: no error", rule)
+		}
+	}
+}
+
+func TestUser_Check(t *testing.T) {
+
+	u := newUser("alice")
+	for _, rule := range []string{"on", "~app:*", "&news.*", "&sports", "+@read", "+set", "+client|id"} {
+		if err := u.setRule(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tests = []struct {
+		args   []string
+		reason string
+	}{
+		{[]string{"GET", "app:1"}, ""},
+		{[]string{"GET", "other"}, "key"},
+		{[]string{"SET", "app:1", "v"}, ""},
+		{[]string{"DEL", "app:1"}, "command"},
+		{[]string{"MGET", "app:1", "app:2"}, ""},
+		{[]string{"LRANGE", "app:l", "0", "-1"}, ""},
+		{[]string{"LPUSH", "app:l", "a"}, "command"},
+		{[]string{"APPEND", "app:1", "v"}, "command"},
+		{[]string{"EXISTS", "app:1", "app:2"}, ""},
+		{[]string{"EXISTS", "app:1", "other"}, "key"},
+		{[]string{"EVAL", "return 1", "1", "other"}, "command"},
+		{[]string{"CLIENT", "ID"}, ""},
+		{[]string{"CLIENT", "KILL", "ID", "1"}, "command"},
+		{[]string{"SUBSCRIBE", "news.today", "sports"}, ""},
+		{[]string{"SUBSCRIBE", "weather"}, "channel"},
+		{[]string{"PSUBSCRIBE", "news.*"}, ""},
+		{[]string{"PSUBSCRIBE", "*"}, "channel"},
+		{[]string{"PUBLISH", "sports", "goal"}, ""},
+		{[]string{"PUBLISH", "weather", "rain"}, "channel"},
+	}
+	for _, test := range tests {
+		command := LookupCommand(test.args[0])
+		d := u.check(command, test.args)
+		reason := ""
+		if d != nil {
+			reason = d.reason
+		}
+		if reason != test.reason {
+			t.Errorf("%v: got %q, want %q", test.args, reason, test.reason)
+		}
+	}
+}
+
+func TestServer_Auth(t *testing.T) {
+
+	server := newTestServer()
+	server.acl = newACL("secret", "")
+	wb, rb := pipeConn(server)
+
+	var tests = []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"GET", "app:1"}, errNoAuth.Error()},
+		{[]interface{}{"AUTH", "wrong"}, errWrongPass.Error()},
+		{[]interface{}{"AUTH", "secret"}, "OK"},
+		{[]interface{}{"ACL", "WHOAMI"}, "default"},
+		{[]interface{}{"ACL", "SETUSER", "alice", "on", ">pw", "~app:*", "+get", "+set", "+acl|whoami"}, "OK"},
+		{[]interface{}{"ACL", "SETUSER", "alice", "+nosuchcommand"}, "ERR Error in ACL SETUSER modifier '+nosuchcommand': Unknown command or category name in ACL"},
+		{[]interface{}{"AUTH", "alice", "wrong"}, errWrongPass.Error()},
+		{[]interface{}{"AUTH", "alice", "pw"}, "OK"},
+		{[]interface{}{"ACL", "WHOAMI"}, "alice"},
+		{[]interface{}{"SET", "app:1", "v"}, "OK"},
+		{[]interface{}{"GET", "other"}, errNoPermKey.Error()},
+		{[]interface{}{"DEL", "app:1"}, "NOPERM User alice has no permissions to run the 'del' command"},
+		{[]interface{}{"ACL", "LIST"}, "NOPERM User alice has no permissions to run the 'acl' command"},
+		{[]interface{}{"AUTH", "secret"}, "OK"},
+		{[]interface{}{"DEL", "app:1"}, "OK"},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Value) != test.want {
+			t.Errorf("%v: got %s, want %s", test.args, resp.Value, test.want)
+		}
+	}
+
+	resp, err := call(wb, rb, "ACL", "LOG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the refused ACL LIST, DEL and GET, then the two failed logins
+	if len(resp.Array) != 5 {
+		t.Fatalf("ACL LOG: got %d entries, want 5", len(resp.Array))
+	}
+	newest := resp.Array[0].Array
+	if string(newest[3].Value) != "command" || string(newest[7].Value) != "acl" || string(newest[9].Value) != "alice" {
+		t.Errorf("ACL LOG: got reason %s object %s username %s", newest[3].Value, newest[7].Value, newest[9].Value)
+	}
+
+	// a deleted user is disconnected
+	owb, orb := pipeConn(server)
+	if resp, _ := call(owb, orb, "AUTH", "alice", "pw"); string(resp.Value) != "OK" {
+		t.Fatalf("AUTH alice: got %s", resp.Value)
+	}
+	if resp, _ := call(wb, rb, "ACL", "DELUSER", "alice", "bob"); string(resp.Value) != "1" {
+		t.Errorf("ACL DELUSER: got %s, want 1", resp.Value)
+	}
+	if _, err := orb.HandleStream(); err == nil {
+		t.Errorf("the connection of the deleted user is still open")
+	}
+	if resp, _ := call(wb, rb, "ACL", "DELUSER", "default"); string(resp.Value) != errDeleteDefault.Error() {
+		t.Errorf("ACL DELUSER default: got %s", resp.Value)
+	}
+}
+
+func TestServer_ReadOnlyUser(t *testing.T) {
+
+	server := newTestServer()
+	wb, rb := pipeConn(server)
+
+	var tests = []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"RPUSH", "l", "a"}, "1"},
+		{[]interface{}{"ACL", "SETUSER", "reader", "on", ">pw", "~*", "+@read", "-@write"}, "OK"},
+		{[]interface{}{"AUTH", "reader", "pw"}, "OK"},
+		{[]interface{}{"LLEN", "l"}, "1"},
+		{[]interface{}{"MGET", "l"}, ""},
+		{[]interface{}{"LPUSH", "l", "b"}, "NOPERM User reader has no permissions to run the 'lpush' command"},
+		{[]interface{}{"RPOP", "l"}, "NOPERM User reader has no permissions to run the 'rpop' command"},
+		{[]interface{}{"LSET", "l", "0", "b"}, "NOPERM User reader has no permissions to run the 'lset' command"},
+		{[]interface{}{"APPEND", "s", "b"}, "NOPERM User reader has no permissions to run the 'append' command"},
+		// FUNCTION is an admin command, LIST runs without @write
+		{[]interface{}{"AUTH", "default", "x"}, "OK"},
+		{[]interface{}{"ACL", "SETUSER", "ops", "on", ">pw", "~*", "+@all", "-@write"}, "OK"},
+		{[]interface{}{"AUTH", "ops", "pw"}, "OK"},
+		{[]interface{}{"FUNCTION", "LIST"}, ""},
+		{[]interface{}{"LLEN", "l"}, "1"},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := string(resp.Value)
+		if resp.Type == TypeArray {
+			got = ""
+		}
+		if got != test.want {
+			t.Errorf("%v: got %s, want %s", test.args, resp.Value, test.want)
+		}
+	}
+}
+
+func TestServer_KeyRestrictedAdmin(t *testing.T) {
+
+	server := newTestServer()
+	wb, rb := pipeConn(server)
+
+	// the subcommands of the admin commands are not keys
+	var tests = []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"ACL", "SETUSER", "admin", "on", ">pw", "~app:*", "+@all"}, "OK"},
+		{[]interface{}{"AUTH", "admin", "pw"}, "OK"},
+		{[]interface{}{"CONFIG", "GET", "maxmemory"}, ""},
+		{[]interface{}{"CONFIG", "SET", "maxmemory", "0"}, "OK"},
+		{[]interface{}{"SHUTDOWN", "ABORT"}, errNoShutdown.Error()},
+		{[]interface{}{"GET", "other"}, errNoPermKey.Error()},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := string(resp.Value)
+		if resp.Type == TypeArray {
+			got = ""
+		}
+		if got != test.want {
+			t.Errorf("%v: got %s, want %s", test.args, resp.Value, test.want)
+		}
+	}
+}
+
+func TestACL_SaveLoad(t *testing.T) {
+
+	file := filepath.Join(t.TempDir(), "users.acl")
+	a := newACL("secret", file)
+	if _, err := a.load(); err != nil {
+		t.Fatal(err)
+	}
+	bob := newUser("bob")
+	for _, rule := range []string{"on", ">pw", "~cache:*", "+@read"} {
+		bob.setRule(rule)
+	}
+	a.users["bob"] = bob
+	if err := a.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := newACL("", file)
+	if _, err := b.load(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(b.list(), "\n"), strings.Join(a.list(), "\n"); got != want {
+		t.Errorf("loaded users:\n%s\nwant:\n%s", got, want)
+	}
+	if !b.authenticate("bob", "pw") || !b.authenticate(defaultUser, "secret") || b.authenticate("bob", "secret") {
+		t.Errorf("the loaded passwords do not match the saved ones")
+	}
+
+	delete(a.users, "bob")
+	a.save()
+	removed, err := b.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != "bob" {
+		t.Errorf("removed: got %v, want [bob]", removed)
+	}
+	if _, err := newACL("", "").load(); err != errNoACLFile {
+		t.Errorf("load without a file: got %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package simpledb
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"strconv"
 	"time"
 )
@@ -49,6 +50,34 @@ func (w *WriteBuffer) WriteArray(i int) (int, error) {
 	return w.buf.WriteString(fmt.Sprintf("*%d\r\n", i))
 }
 
+// WriteResp writes r and its elements as they are typed
+func (w *WriteBuffer) WriteResp(r *Resp) (int, error) {
+	switch r.Type {
+	case TypeString:
+		return w.WriteString(string(r.Value))
+	case TypeError:
+		return w.buf.WriteString(fmt.Sprintf("-%s\r\n", r.Value))
+	case TypeInt:
+		return w.buf.WriteString(fmt.Sprintf(":%s\r\n", r.Value))
+	case TypeBulkBytes:
+		return w.WriteBulkString(string(r.Value))
+	case TypeArray:
+		total, err := w.WriteArray(len(r.Array))
+		if err != nil {
+			return 0, err
+		}
+		for _, e := range r.Array {
+			n, err := w.WriteResp(e)
+			if err != nil {
+				return 0, err
+			}
+			total += n
+		}
+		return total, nil
+	}
+	return 0, fmt.Errorf("unknown resp type %v", r.Type)
+}
+
 func (r *ReadBuffer) ReadLine() (RespType, []byte, error) {
 	buf, err := r.buf.ReadBytes('\n')
 	if err != nil {
@@ -75,11 +104,13 @@ func (r *ReadBuffer) HandleStream() (*Resp, error) {
 		// $6\r\nfoobar\r\n
 	case TypeBulkBytes:
 		length, _ := strconv.Atoi(string(buf))
-		if length < 1 {
+		// $-1 is a nil bulk, $0 still ends with \r\n
+		if length < 0 {
 			return NewBulkBytes([]byte("")), nil
 		}
 		p := make([]byte, length+2)
-		n, err := r.buf.Read(p)
+		// a single Read may return less than a whole bulk on a stream
+		n, err := io.ReadFull(r.buf, p)
 		if err != nil {
 			return nil, err
 		}
@@ -3,7 +3,9 @@ package simpledb
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"simpledb/simpledb/config"
@@ -15,12 +17,17 @@ const (
 	defaultTimeout = 3
 )
 
-var clientConfig *config.Config
-
 type Client struct {
+	// a host name or address, or unix:///path of a unix socket
 	Host string
 	Port int
 	conn net.Conn
+	// sent with AUTH on connect when Password is set, the default user
+	// without Username
+	Username string
+	Password string
+	// connect over TLS when set, see NewTLSConfig
+	TLSConfig *tls.Config
 
 	rb             *ReadBuffer
 	wb             *WriteBuffer
@@ -32,13 +39,6 @@ type Client struct {
 	reply   *Resp
 }
 
-func init() {
-	var err error
-	clientConfig, err = config.NewConfig()
-	if err != nil {
-		log.Fatal(err)
-	}
-}
 func DefaultClient() *Client {
 
 	cli := &Client{}
@@ -50,11 +50,32 @@ func DefaultClient() *Client {
 	return cli
 }
 
-func NewClient() *Client {
+// NewClient returns a client of conf, without one the config is loaded by
+// config.Load from SIMPLEDB_CONFIG or the defaults
+func NewClient(conf ...*config.Config) *Client {
 
+	var clientConfig *config.Config
+	if len(conf) > 0 {
+		clientConfig = conf[0]
+	} else {
+		var err error
+		if clientConfig, err = config.Load(""); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var tlsConfig *tls.Config
+	if clientConfig.Client.TLS {
+		var err error
+		tlsConfig, err = NewTLSConfig(clientConfig.Client.TLSCertFile, clientConfig.Client.TLSKeyFile,
+			clientConfig.Client.TLSCACertFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 	return &Client{
 		Host:           clientConfig.Client.Host,
 		Port:           clientConfig.Client.Port,
+		TLSConfig:      tlsConfig,
 		ConnectTimeout: clientConfig.Client.ConnectTimeout,
 		readTimeout:    clientConfig.Client.ReadTimeout,
 		writeTimeout:   clientConfig.Client.WriteTimeout,
@@ -62,7 +83,12 @@ func NewClient() *Client {
 }
 
 func (c *Client) Close() error {
-	return c.Close()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
 }
 
 func (c *Client) execute(args ...interface{}) (*Resp, error) {
@@ -93,16 +119,24 @@ func (c *Client) execute(args ...interface{}) (*Resp, error) {
 	if _, err := CheckCommand(cmd, arity); err != nil {
 		return nil, err
 	}
-	if err := c.connect(); err != nil {
-		return nil, err
+	// the connection is kept between commands, so that connection state
+	// such as subscriptions lives on the server
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
 	}
+	c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout * time.Second))
+	c.conn.SetReadDeadline(time.Now().Add(c.readTimeout * time.Second))
 
 	if err := c.writeArgsWithFlush(args...); err != nil {
+		c.Close()
 		return nil, fmt.Errorf("conn write buffer fail %s", err.Error())
 	}
 
 	reply, err := c.readRely()
 	if err != nil {
+		c.Close()
 		return nil, fmt.Errorf("reply read buffer fail %s", err.Error())
 	}
 	c.reply = reply
@@ -111,8 +145,17 @@ func (c *Client) execute(args ...interface{}) (*Resp, error) {
 
 func (c *Client) connect() error {
 
-	addr := c.Host + ":" + strconv.Itoa(c.Port)
-	conn, err := net.DialTimeout("tcp", addr, c.ConnectTimeout*time.Second)
+	network, addr := c.address()
+	var (
+		conn net.Conn
+		err  error
+	)
+	if c.TLSConfig != nil {
+		dialer := &net.Dialer{Timeout: c.ConnectTimeout * time.Second}
+		conn, err = tls.DialWithDialer(dialer, network, addr, c.TLSConfig)
+	} else {
+		conn, err = net.DialTimeout(network, addr, c.ConnectTimeout*time.Second)
+	}
 	if err != nil {
 		return fmt.Errorf("connect addr %s fail %s", addr, err.Error())
 	}
@@ -120,20 +163,50 @@ func (c *Client) connect() error {
 		c.writeTimeout = defaultTimeout
 	}
 	if c.readTimeout == 0 {
-		c.writeTimeout = defaultTimeout
+		c.readTimeout = defaultTimeout
 	}
-	conn.SetWriteDeadline(time.Now().Add(c.writeTimeout * time.Second))
-	conn.SetReadDeadline(time.Now().Add(c.readTimeout * time.Second))
 
 	c.rb = &ReadBuffer{bufio.NewReader(conn), c.readTimeout}
 	c.wb = &WriteBuffer{bufio.NewWriter(conn), c.writeTimeout}
 	c.conn = conn
+	if c.Password != "" {
+		return c.auth()
+	}
+	return nil
+}
+
+// auth authenticates a new connection
+func (c *Client) auth() error {
+	args := []string{c.Password}
+	if c.Username != "" {
+		args = []string{c.Username, c.Password}
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout * time.Second))
+	c.conn.SetReadDeadline(time.Now().Add(c.readTimeout * time.Second))
+	err := c.writeArgsWithFlush("AUTH", args)
+	var reply *Resp
+	if err == nil {
+		reply, err = c.readRely()
+	}
+	if err == nil && reply.IsError() {
+		err = fmt.Errorf("%s", reply.Value)
+	}
+	if err != nil {
+		c.Close()
+		return fmt.Errorf("auth fail %s", err.Error())
+	}
 	return nil
 }
 
 func (c *Client) writeArgsWithFlush(args ...interface{}) (err error) {
 
 	flush := func() {
+		// a command is always an array, even without arguments
+		if len(args) == 1 {
+			if _, err = c.wb.WriteArray(1); err != nil {
+				return
+			}
+		}
 		_, err = c.wb.WriteArgs(args...)
 		if err != nil {
 			return
@@ -164,6 +237,54 @@ func (c *Client) readRely() (*Resp, error) {
 	return c.rb.HandleStream()
 }
 
+// Do runs any command, given by its name and arguments such as
+// Do("SET", "k", "v")
+func (c *Client) Do(args ...string) (*Resp, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("args is empty")
+	}
+	return c.execute(args[0], args[1:])
+}
+
+// pipelining, Send buffers a command without waiting for its reply, Flush
+// writes the buffered commands and Receive reads their replies in order.
+// Receive may run in a goroutine of its own while Send and Flush write.
+func (c *Client) Send(args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("args is empty")
+	}
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return err
+		}
+	}
+	// a full buffer is written by the next Send
+	c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout * time.Second))
+	if _, err := c.wb.WriteArray(len(args)); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if _, err := c.wb.WriteBulkString(arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (c *Client) Flush() error {
+	if c.conn == nil {
+		return fmt.Errorf("nothing sent")
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout * time.Second))
+	return c.wb.Flush()
+}
+func (c *Client) Receive() (*Resp, error) {
+	if c.conn == nil {
+		return nil, fmt.Errorf("nothing sent")
+	}
+	c.conn.SetReadDeadline(time.Now().Add(c.readTimeout * time.Second))
+	return c.readRely()
+}
+
 // str command
 func (c *Client) Set(key, value string) (*Resp, error) {
 	return c.execute("SET", key, value)
@@ -270,3 +391,318 @@ func (c *Client) HMGet(value ...string) (*Resp, error) {
 func (c *Client) HMSet(key string, value map[string]interface{}) (*Resp, error) {
 	return c.execute("HMSET", key, value)
 }
+
+// pub/sub command
+func (c *Client) Publish(channel, message string) (*Resp, error) {
+	return c.execute("PUBLISH", channel, message)
+}
+
+// Subscribe replies the confirmation of the first channel, the others
+// and the published messages are read by ReceiveMessage
+func (c *Client) Subscribe(channel ...string) (*Resp, error) {
+	return c.execute("SUBSCRIBE", channel)
+}
+func (c *Client) PSubscribe(pattern ...string) (*Resp, error) {
+	return c.execute("PSUBSCRIBE", pattern)
+}
+func (c *Client) Unsubscribe(channel ...string) (*Resp, error) {
+	return c.execute("UNSUBSCRIBE", channel)
+}
+func (c *Client) PUnsubscribe(pattern ...string) (*Resp, error) {
+	return c.execute("PUNSUBSCRIBE", pattern)
+}
+
+// ReceiveMessage blocks until the next message of a subscribed connection
+func (c *Client) ReceiveMessage() (*Resp, error) {
+	if c.conn == nil {
+		return nil, fmt.Errorf("connection not subscribed")
+	}
+	c.conn.SetReadDeadline(time.Time{})
+	return c.readRely()
+}
+
+// monitor command, the commands processed by the server are then read
+// by ReceiveMessage
+func (c *Client) Monitor() (*Resp, error) {
+	return c.execute("MONITOR")
+}
+
+// transaction command, the commands issued between Multi and Exec are
+// replied QUEUED and run by Exec
+func (c *Client) Multi() (*Resp, error) {
+	return c.execute("MULTI")
+}
+func (c *Client) Exec() (*Resp, error) {
+	return c.execute("EXEC")
+}
+func (c *Client) Discard() (*Resp, error) {
+	return c.execute("DISCARD")
+}
+func (c *Client) Watch(key ...string) (*Resp, error) {
+	return c.execute("WATCH", key)
+}
+func (c *Client) Unwatch() (*Resp, error) {
+	return c.execute("UNWATCH")
+}
+
+// scripting command, keys and args are passed as KEYS and ARGV
+func (c *Client) Eval(body string, keys []string, args ...string) (*Resp, error) {
+	return c.execute("EVAL", body, strconv.Itoa(len(keys)), keys, args)
+}
+func (c *Client) EvalSha(sha string, keys []string, args ...string) (*Resp, error) {
+	return c.execute("EVALSHA", sha, strconv.Itoa(len(keys)), keys, args)
+}
+func (c *Client) ScriptLoad(body string) (*Resp, error) {
+	return c.execute("SCRIPT", "LOAD", body)
+}
+func (c *Client) ScriptExists(sha ...string) (*Resp, error) {
+	return c.execute("SCRIPT", "EXISTS", sha)
+}
+func (c *Client) ScriptFlush() (*Resp, error) {
+	return c.execute("SCRIPT", "FLUSH")
+}
+func (c *Client) ScriptKill() (*Resp, error) {
+	return c.execute("SCRIPT", "KILL")
+}
+
+// function command, libraries are loaded once and their functions called
+// by name
+func (c *Client) FunctionLoad(code string) (*Resp, error) {
+	return c.execute("FUNCTION", "LOAD", code)
+}
+func (c *Client) FunctionDelete(library string) (*Resp, error) {
+	return c.execute("FUNCTION", "DELETE", library)
+}
+func (c *Client) FunctionList() (*Resp, error) {
+	return c.execute("FUNCTION", "LIST")
+}
+func (c *Client) FunctionDump() (*Resp, error) {
+	return c.execute("FUNCTION", "DUMP")
+}
+func (c *Client) FunctionRestore(payload []byte, policy string) (*Resp, error) {
+	return c.execute("FUNCTION", "RESTORE", string(payload), policy)
+}
+func (c *Client) FCall(function string, keys []string, args ...string) (*Resp, error) {
+	return c.execute("FCALL", function, strconv.Itoa(len(keys)), keys, args)
+}
+func (c *Client) FCallRO(function string, keys []string, args ...string) (*Resp, error) {
+	return c.execute("FCALL_RO", function, strconv.Itoa(len(keys)), keys, args)
+}
+
+// keyspace command, option is "MATCH pattern", "COUNT n" or "TYPE t", the
+// reply is the next cursor and a page of elements
+func (c *Client) Keys(pattern string) (*Resp, error) {
+	return c.execute("KEYS", pattern)
+}
+func (c *Client) Scan(cursor uint64, option ...string) (*Resp, error) {
+	return c.execute("SCAN", strconv.FormatUint(cursor, 10), option)
+}
+func (c *Client) HScan(key string, cursor uint64, option ...string) (*Resp, error) {
+	return c.execute("HSCAN", key, strconv.FormatUint(cursor, 10), option)
+}
+func (c *Client) SScan(key string, cursor uint64, option ...string) (*Resp, error) {
+	return c.execute("SSCAN", key, strconv.FormatUint(cursor, 10), option)
+}
+func (c *Client) ZScan(key string, cursor uint64, option ...string) (*Resp, error) {
+	return c.execute("ZSCAN", key, strconv.FormatUint(cursor, 10), option)
+}
+func (c *Client) Type(key string) (*Resp, error) {
+	return c.execute("TYPE", key)
+}
+func (c *Client) Rename(key, newKey string) (*Resp, error) {
+	return c.execute("RENAME", key, newKey)
+}
+func (c *Client) RenameNX(key, newKey string) (*Resp, error) {
+	return c.execute("RENAMENX", key, newKey)
+}
+
+// Copy takes the options "DB n" and "REPLACE"
+func (c *Client) Copy(src, dst string, option ...string) (*Resp, error) {
+	return c.execute("COPY", src, dst, option)
+}
+func (c *Client) RandomKey() (*Resp, error) {
+	return c.execute("RANDOMKEY")
+}
+func (c *Client) Touch(key ...string) (*Resp, error) {
+	return c.execute("TOUCH", key)
+}
+func (c *Client) DBSize() (*Resp, error) {
+	return c.execute("DBSIZE")
+}
+func (c *Client) Unlink(key ...string) (*Resp, error) {
+	return c.execute("UNLINK", key)
+}
+
+// dump command, a payload of Dump is restored by Restore, on this server
+// or another one
+func (c *Client) Dump(key string) (*Resp, error) {
+	return c.execute("DUMP", key)
+}
+
+// Restore takes the options "REPLACE" and "ABSTTL"
+func (c *Client) Restore(key string, ttl int64, payload []byte, option ...string) (*Resp, error) {
+	return c.execute("RESTORE", key, strconv.FormatInt(ttl, 10), string(payload), option)
+}
+
+// Migrate moves keys to the server at host:port, option takes "COPY",
+// "REPLACE", "AUTH", password and "AUTH2", username, password
+func (c *Client) Migrate(host string, port int, keys []string, timeout time.Duration, option ...string) (*Resp, error) {
+	ms := strconv.FormatInt(int64(timeout/time.Millisecond), 10)
+	if len(keys) == 1 {
+		return c.execute("MIGRATE", host, strconv.Itoa(port), keys[0], "0", ms, option)
+	}
+	return c.execute("MIGRATE", host, strconv.Itoa(port), "", "0", ms, option, "KEYS", keys)
+}
+
+// sort command, option is the rest of the command such as
+// "BY", "weight_*", "GET", "#", "ALPHA"
+func (c *Client) Sort(key string, option ...string) (*Resp, error) {
+	return c.execute("SORT", key, option)
+}
+func (c *Client) SortRO(key string, option ...string) (*Resp, error) {
+	return c.execute("SORT_RO", key, option)
+}
+
+// object command, subcommand is "ENCODING", "REFCOUNT", "IDLETIME" or "FREQ"
+func (c *Client) Object(subcommand, key string) (*Resp, error) {
+	return c.execute("OBJECT", subcommand, key)
+}
+
+// MemoryUsage takes the options "SAMPLES n"
+func (c *Client) MemoryUsage(key string, option ...string) (*Resp, error) {
+	return c.execute("MEMORY", "USAGE", key, option)
+}
+func (c *Client) MemoryStats() (*Resp, error) {
+	return c.execute("MEMORY", "STATS")
+}
+func (c *Client) MemoryDoctor() (*Resp, error) {
+	return c.execute("MEMORY", "DOCTOR")
+}
+
+// info command, without a section every section is returned
+func (c *Client) Info(section ...string) (*Resp, error) {
+	return c.execute("INFO", section)
+}
+
+// slowlog command, SlowLogGet takes the number of entries, 10 without it
+// and all of them with -1
+func (c *Client) SlowLogGet(count ...int) (*Resp, error) {
+	if len(count) > 0 {
+		return c.execute("SLOWLOG", "GET", strconv.Itoa(count[0]))
+	}
+	return c.execute("SLOWLOG", "GET")
+}
+func (c *Client) SlowLogLen() (*Resp, error) {
+	return c.execute("SLOWLOG", "LEN")
+}
+func (c *Client) SlowLogReset() (*Resp, error) {
+	return c.execute("SLOWLOG", "RESET")
+}
+func (c *Client) LatencyLatest() (*Resp, error) {
+	return c.execute("LATENCY", "LATEST")
+}
+func (c *Client) LatencyHistory(event string) (*Resp, error) {
+	return c.execute("LATENCY", "HISTORY", event)
+}
+
+// LatencyReset resets the given events, all of them without one
+func (c *Client) LatencyReset(event ...string) (*Resp, error) {
+	return c.execute("LATENCY", "RESET", event)
+}
+
+// client command, option of ClientList is "TYPE type" or "ID id ..."
+func (c *Client) ClientList(option ...string) (*Resp, error) {
+	return c.execute("CLIENT", "LIST", option)
+}
+func (c *Client) ClientInfo() (*Resp, error) {
+	return c.execute("CLIENT", "INFO")
+}
+func (c *Client) ClientID() (*Resp, error) {
+	return c.execute("CLIENT", "ID")
+}
+func (c *Client) ClientSetName(name string) (*Resp, error) {
+	return c.execute("CLIENT", "SETNAME", name)
+}
+func (c *Client) ClientGetName() (*Resp, error) {
+	return c.execute("CLIENT", "GETNAME")
+}
+
+// ClientKill takes filters such as "ID", "12" or "ADDR", "127.0.0.1:6000"
+func (c *Client) ClientKill(filter ...string) (*Resp, error) {
+	return c.execute("CLIENT", "KILL", filter)
+}
+
+// ClientPause takes the option "WRITE" or "ALL"
+func (c *Client) ClientPause(timeout time.Duration, option ...string) (*Resp, error) {
+	return c.execute("CLIENT", "PAUSE", strconv.FormatInt(int64(timeout/time.Millisecond), 10), option)
+}
+func (c *Client) ClientUnpause() (*Resp, error) {
+	return c.execute("CLIENT", "UNPAUSE")
+}
+func (c *Client) ClientNoEvict(on bool) (*Resp, error) {
+	if on {
+		return c.execute("CLIENT", "NO-EVICT", "ON")
+	}
+	return c.execute("CLIENT", "NO-EVICT", "OFF")
+}
+
+// auth command, Auth takes a password, or a username and a password
+func (c *Client) Auth(args ...string) (*Resp, error) {
+	return c.execute("AUTH", args)
+}
+
+// acl command, ACLSetUser takes rules such as "on", ">password", "~key:*"
+// or "+@read"
+func (c *Client) ACLSetUser(username string, rules ...string) (*Resp, error) {
+	return c.execute("ACL", "SETUSER", username, rules)
+}
+func (c *Client) ACLGetUser(username string) (*Resp, error) {
+	return c.execute("ACL", "GETUSER", username)
+}
+func (c *Client) ACLDelUser(username ...string) (*Resp, error) {
+	return c.execute("ACL", "DELUSER", username)
+}
+func (c *Client) ACLList() (*Resp, error) {
+	return c.execute("ACL", "LIST")
+}
+func (c *Client) ACLWhoAmI() (*Resp, error) {
+	return c.execute("ACL", "WHOAMI")
+}
+
+// ACLLog takes a count of entries or "RESET"
+func (c *Client) ACLLog(option ...string) (*Resp, error) {
+	return c.execute("ACL", "LOG", option)
+}
+
+// shutdown command, Shutdown takes the options "NOSAVE", "SAVE", "NOW",
+// "FORCE" or "ABORT". The server closes the connection instead of replying
+// to a shutdown, then Shutdown returns a nil reply and no error.
+func (c *Client) Shutdown(option ...string) (*Resp, error) {
+	if _, err := CheckCommand("SHUTDOWN", 1+len(option)); err != nil {
+		return nil, err
+	}
+	err := c.Send(append([]string{"SHUTDOWN"}, option...)...)
+	if err == nil {
+		err = c.Flush()
+	}
+	var reply *Resp
+	if err == nil {
+		reply, err = c.Receive()
+	}
+	if err == io.EOF {
+		c.Close()
+		return nil, nil
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return reply, nil
+}
+
+// Quit asks the server to close the connection
+func (c *Client) Quit() (*Resp, error) {
+	reply, err := c.execute("QUIT")
+	c.Close()
+	return reply, err
+}
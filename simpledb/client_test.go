@@ -2,6 +2,8 @@ package simpledb
 
 import (
 	"fmt"
+	"path/filepath"
+	"strconv"
 	"testing"
 )
 
@@ -122,3 +124,60 @@ func TestDefaultClient(t *testing.T) {
 
 	}
 }
+
+func TestClient_Pipeline(t *testing.T) {
+
+	server := newTestServer()
+	server.unixSocket = filepath.Join(t.TempDir(), "simpledb.sock")
+	listener, err := server.listenUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleProcess(server.newConn(conn))
+		}
+	}()
+
+	c := &Client{
+		Host:           "unix://" + server.unixSocket,
+		ConnectTimeout: defaultTimeout,
+		readTimeout:    defaultTimeout,
+		writeTimeout:   defaultTimeout,
+	}
+	defer c.Close()
+	if resp, err := c.Do("SET", "k", "a value\r\n"); err != nil || string(resp.Value) != "OK" {
+		t.Fatalf("Do SET: got %v, %v", resp, err)
+	}
+
+	const n = 100
+	for i := 0; i < n; i++ {
+		if err := c.Send("INCR", "counter"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Send("GET", "k"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= n; i++ {
+		resp, err := c.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Value) != strconv.Itoa(i) {
+			t.Fatalf("INCR %d: got %s", i, resp.Value)
+		}
+	}
+	// a bulk string keeps its line breaks
+	if resp, err := c.Receive(); err != nil || string(resp.Value) != "a value\r\n" {
+		t.Errorf("GET: got %v, %v", resp, err)
+	}
+}
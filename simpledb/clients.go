@@ -0,0 +1,538 @@
+package simpledb
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// client commands:
+// client list|info|id|setname|getname|kill|pause|unpause|no-evict
+//
+// every connection is registered in Clients with a clientInfo, which the
+// connection updates after each command and other connections read for
+// CLIENT LIST and KILL.
+//
+// CLIENT PAUSE ms [WRITE|ALL] holds the commands of every client, or only
+// the writes, until the pause ends. CLIENT commands are never held, so
+// UNPAUSE can end it. CLIENT NO-EVICT only sets the e flag, maxmemory
+// evicts keys and never clients.
+
+const defaultUser = "default"
+
+var (
+	errClientSubcmd  = errors.New("ERR unknown subcommand, try CLIENT LIST|INFO|ID|SETNAME|GETNAME|KILL|PAUSE|UNPAUSE|NO-EVICT")
+	errClientName    = errors.New("ERR Client names cannot contain spaces, newlines or special characters.")
+	errNoSuchClient  = errors.New("ERR No such client")
+	errClientType    = errors.New("ERR Unknown client type")
+	errPauseTimeout  = errors.New("ERR timeout is not an integer or out of range")
+	errPauseMode     = errors.New("ERR Syntax error, CLIENT PAUSE timeout [WRITE|ALL]")
+	errClientOnOff   = errors.New("ERR Syntax error, try CLIENT NO-EVICT ON|OFF")
+	errClientKillArg = errors.New("ERR syntax error, try CLIENT KILL [ID id] [ADDR addr] [LADDR addr] [USER user] [TYPE type] [SKIPME yes|no]")
+)
+
+// clientInfo is what the other connections see of a connection
+type clientInfo struct {
+	mu          sync.Mutex
+	id          int64
+	conn        net.Conn
+	name        string
+	user        string
+	created     time.Time
+	lastActive  time.Time
+	lastCommand string
+	db          int
+	sub         int
+	psub        int
+	multi       int // queued commands, -1 outside a transaction
+	monitor     bool
+	noEvict     bool
+	qbuf        int // bytes read and not processed
+	omem        int // bytes written and not flushed
+}
+
+func newClientInfo(conn net.Conn) *clientInfo {
+	now := time.Now()
+	return &clientInfo{
+		mu:          sync.Mutex{},
+		conn:        conn,
+		user:        defaultUser,
+		created:     now,
+		lastActive:  now,
+		lastCommand: "NULL",
+		multi:       -1,
+	}
+}
+
+func (c *clientInfo) clientType() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.monitor:
+		return "monitor"
+	case c.sub+c.psub > 0:
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// line formats the client for CLIENT LIST and INFO
+func (c *clientInfo) line(now time.Time) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	flags := ""
+	if c.monitor {
+		flags += "O"
+	}
+	if c.sub+c.psub > 0 {
+		flags += "P"
+	}
+	if c.multi >= 0 {
+		flags += "x"
+	}
+	if c.noEvict {
+		flags += "e"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	var addr, laddr string
+	if c.conn != nil {
+		addr, laddr = connAddrs(c.conn)
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d sub=%d psub=%d multi=%d qbuf=%d omem=%d cmd=%s user=%s",
+		c.id, addr, laddr, c.name, int64(now.Sub(c.created)/time.Second), int64(now.Sub(c.lastActive)/time.Second),
+		flags, c.db, c.sub, c.psub, c.multi, c.qbuf, c.omem, c.lastCommand, c.user)
+}
+
+// clientPause is a CLIENT PAUSE in progress
+type clientPause struct {
+	until time.Time
+	all   bool          // every command, else only the writes
+	done  chan struct{} // closed when the pause ends early
+}
+
+// Clients is the registry of the connections
+type Clients struct {
+	mu      sync.RWMutex
+	nextID  int64
+	clients map[int64]*clientInfo
+	pause   *clientPause
+	// the accepted connections, counted before they are added
+	slots int64
+}
+
+func newClients() *Clients {
+	return &Clients{
+		mu:      sync.RWMutex{},
+		nextID:  1,
+		clients: make(map[int64]*clientInfo),
+	}
+}
+
+func (r *Clients) add(c *clientInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c.mu.Lock()
+	c.id = r.nextID
+	c.mu.Unlock()
+	r.nextID++
+	r.clients[c.id] = c
+}
+
+func (r *Clients) remove(c *clientInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.clients, c.id)
+}
+
+// reserve takes the slot of an accepted connection, it returns the slots
+// taken with it
+func (r *Clients) reserve() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.slots++
+	return r.slots
+}
+
+// release frees the slot of a closed connection
+func (r *Clients) release() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.slots--
+}
+
+func (r *Clients) count() int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return int64(len(r.clients))
+}
+
+// all returns the clients ordered by id
+func (r *Clients) all() []*clientInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	clients := make([]*clientInfo, 0, len(r.clients))
+	for _, c := range r.clients {
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+	return clients
+}
+
+// pauseFor holds the commands for d, the writes only unless all
+func (r *Clients) pauseFor(d time.Duration, all bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	until := time.Now().Add(d)
+	if p := r.pause; p != nil {
+		// a pause is extended, never shortened, ALL wins over WRITE
+		if p.until.After(until) {
+			until = p.until
+		}
+		all = all || p.all
+		close(p.done)
+	}
+	r.pause = &clientPause{until: until, all: all, done: make(chan struct{})}
+}
+
+func (r *Clients) unpause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pause != nil {
+		close(r.pause.done)
+		r.pause = nil
+	}
+}
+
+// waitPause returns once command is not paused
+func (r *Clients) waitPause(paused func(all bool) bool) {
+	for {
+		r.mu.RLock()
+		p := r.pause
+		r.mu.RUnlock()
+		if p == nil || !paused(p.all) {
+			return
+		}
+		wait := time.Until(p.until)
+		if wait <= 0 {
+			r.mu.Lock()
+			if r.pause == p {
+				r.pause = nil
+			}
+			r.mu.Unlock()
+			return
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-p.done:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// isWrite reports whether a command is held by CLIENT PAUSE WRITE
+func (s *Server) isWrite(command *Command, resp *Resp) bool {
+	switch {
+	case isWriteCall(command, resp), command.Name == "PUBLISH":
+		return true
+	case command.Name == "EXEC" && s.multi != nil:
+		for _, q := range s.multi.queued {
+			if isWriteCall(q.command, q.resp) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// waitPause holds command during a CLIENT PAUSE
+func (s *Server) waitPause(command *Command, resp *Resp) {
+	if command.Name == "CLIENT" {
+		return
+	}
+	s.clients.waitPause(func(all bool) bool {
+		return all || s.isWrite(command, resp)
+	})
+}
+
+// updateClient records the state of the connection after a command
+func (s *Server) updateClient(resp *Resp) {
+	c := s.client
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastActive = time.Now()
+	if resp.Type == TypeArray && len(resp.Array) > 0 {
+		c.lastCommand = strings.ToLower(string(resp.Array[0].Value))
+	}
+	c.db = s.db.id
+	c.sub, c.psub = len(s.channels), len(s.patterns)
+	c.multi = -1
+	if s.multi != nil {
+		c.multi = len(s.multi.queued)
+	}
+	c.monitor = s.monitoring
+	c.qbuf = s.rb.buf.Buffered()
+	c.omem = s.wb.buf.Buffered()
+}
+
+// clientName is the name set by CLIENT SETNAME
+func (s *Server) clientName() string {
+	if s.client == nil {
+		return ""
+	}
+	s.client.mu.Lock()
+	defer s.client.mu.Unlock()
+	return s.client.name
+}
+
+// killFilter selects the clients of CLIENT KILL
+type killFilter struct {
+	id     int64
+	addr   string
+	laddr  string
+	user   string
+	typ    string
+	skipMe bool
+}
+
+func parseKillFilter(args []*Resp) (*killFilter, error) {
+	f := &killFilter{skipMe: true}
+	if len(args) == 0 || len(args)%2 != 0 {
+		return nil, errClientKillArg
+	}
+	for i := 0; i < len(args); i += 2 {
+		value := string(args[i+1].Value)
+		switch strings.ToUpper(string(args[i].Value)) {
+		case "ID":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id <= 0 {
+				return nil, errInteger
+			}
+			f.id = id
+		case "ADDR":
+			f.addr = value
+		case "LADDR":
+			f.laddr = value
+		case "USER":
+			f.user = value
+		case "TYPE":
+			f.typ = strings.ToLower(value)
+			if f.typ != "normal" && f.typ != "pubsub" && f.typ != "monitor" {
+				return nil, errClientType
+			}
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				f.skipMe = true
+			case "no":
+				f.skipMe = false
+			default:
+				return nil, errSyntax
+			}
+		default:
+			return nil, errClientKillArg
+		}
+	}
+	return f, nil
+}
+
+func (f *killFilter) match(c *clientInfo) bool {
+	if f.typ != "" && c.clientType() != f.typ {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var addr, laddr string
+	if c.conn != nil {
+		addr, laddr = connAddrs(c.conn)
+	}
+	switch {
+	case f.id != 0 && c.id != f.id:
+		return false
+	case f.addr != "" && addr != f.addr:
+		return false
+	case f.laddr != "" && laddr != f.laddr:
+		return false
+	case f.user != "" && c.user != f.user:
+		return false
+	}
+	return true
+}
+
+// killClients closes the connections f selects, the connection of s is
+// closed once its reply is written
+func (s *Server) killClients(f *killFilter) (n int, self bool) {
+	for _, c := range s.clients.all() {
+		if !f.match(c) {
+			continue
+		}
+		if c == s.client {
+			if f.skipMe {
+				continue
+			}
+			self = true
+		} else if c.conn != nil {
+			c.conn.Close()
+		}
+		n++
+	}
+	return n, self
+}
+
+func (s *Server) clientList(args []*Resp) error {
+	var typ string
+	ids := make(map[int64]bool)
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i].Value)) {
+		case "TYPE":
+			if i+1 >= len(args) {
+				return s.replyErr(errSyntax)
+			}
+			i++
+			typ = strings.ToLower(string(args[i].Value))
+			if typ != "normal" && typ != "pubsub" && typ != "monitor" {
+				return s.replyErr(errClientType)
+			}
+		case "ID":
+			if i+1 >= len(args) {
+				return s.replyErr(errSyntax)
+			}
+			for _, arg := range args[i+1:] {
+				id, err := strconv.ParseInt(string(arg.Value), 10, 64)
+				if err != nil || id <= 0 {
+					return s.replyErr(errInteger)
+				}
+				ids[id] = true
+			}
+			i = len(args)
+		default:
+			return s.replyErr(errSyntax)
+		}
+	}
+	var b strings.Builder
+	now := time.Now()
+	for _, c := range s.clients.all() {
+		if typ != "" && c.clientType() != typ {
+			continue
+		}
+		if len(ids) > 0 && !ids[c.id] {
+			continue
+		}
+		b.WriteString(c.line(now))
+		b.WriteByte('\n')
+	}
+	return s.writeResp(NewBulkBytes([]byte(b.String())))
+}
+
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] <= ' ' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func clientCommand(s *Server, resp *Resp) error {
+	args := resp.Array[2:]
+	switch strings.ToUpper(string(resp.Array[1].Value)) {
+	case "LIST":
+		return s.clientList(args)
+	case "INFO":
+		return s.writeResp(NewBulkBytes([]byte(s.client.line(time.Now()) + "\n")))
+	case "ID":
+		return s.writeResp(NewInt([]byte(strconv.FormatInt(s.client.id, 10))))
+	case "SETNAME":
+		if len(args) != 1 {
+			return s.replyErr(errClientSubcmd)
+		}
+		name := string(args[0].Value)
+		if !validClientName(name) {
+			return s.replyErr(errClientName)
+		}
+		s.client.mu.Lock()
+		s.client.name = name
+		s.client.mu.Unlock()
+		return s.replyOk()
+	case "GETNAME":
+		if name := s.clientName(); name != "" {
+			return s.writeResp(NewBulkBytes([]byte(name)))
+		}
+		return s.replyNil()
+	case "KILL":
+		// the old form CLIENT KILL addr replies OK or an error
+		if len(args) == 1 {
+			n, self := s.killClients(&killFilter{addr: string(args[0].Value)})
+			if n == 0 {
+				return s.replyErr(errNoSuchClient)
+			}
+			err := s.replyOk()
+			if self {
+				s.conn.Close()
+			}
+			return err
+		}
+		f, err := parseKillFilter(args)
+		if err != nil {
+			return s.replyErr(err)
+		}
+		n, self := s.killClients(f)
+		err = s.writeResp(NewInt([]byte(strconv.Itoa(n))))
+		if self {
+			s.conn.Close()
+		}
+		return err
+	case "PAUSE":
+		if len(args) < 1 || len(args) > 2 {
+			return s.replyErr(errPauseMode)
+		}
+		ms, err := strconv.ParseInt(string(args[0].Value), 10, 64)
+		if err != nil || ms < 0 {
+			return s.replyErr(errPauseTimeout)
+		}
+		all := true
+		if len(args) == 2 {
+			switch strings.ToUpper(string(args[1].Value)) {
+			case "ALL":
+			case "WRITE":
+				all = false
+			default:
+				return s.replyErr(errPauseMode)
+			}
+		}
+		s.clients.pauseFor(time.Duration(ms)*time.Millisecond, all)
+		return s.replyOk()
+	case "UNPAUSE":
+		s.clients.unpause()
+		return s.replyOk()
+	case "NO-EVICT":
+		if len(args) != 1 {
+			return s.replyErr(errClientOnOff)
+		}
+		var on bool
+		switch strings.ToUpper(string(args[0].Value)) {
+		case "ON":
+			on = true
+		case "OFF":
+		default:
+			return s.replyErr(errClientOnOff)
+		}
+		s.client.mu.Lock()
+		s.client.noEvict = on
+		s.client.mu.Unlock()
+		return s.replyOk()
+	}
+	return s.replyErr(errClientSubcmd)
+}
@@ -0,0 +1,130 @@
+package simpledb
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_Client(t *testing.T) {
+
+	server := newTestServer()
+	wb, rb := pipeConn(server)
+	owb, orb := pipeConn(server)
+	call(owb, orb, "SUBSCRIBE", "news")
+
+	var tests = []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"CLIENT", "GETNAME"}, "nil"},
+		{[]interface{}{"CLIENT", "SETNAME", "my app"}, errClientName.Error()},
+		{[]interface{}{"CLIENT", "SETNAME", "worker"}, "OK"},
+		{[]interface{}{"CLIENT", "GETNAME"}, "worker"},
+		{[]interface{}{"CLIENT", "NO-EVICT", "maybe"}, errClientOnOff.Error()},
+		{[]interface{}{"CLIENT", "NO-EVICT", "ON"}, "OK"},
+		{[]interface{}{"CLIENT", "LIST", "TYPE", "replica"}, errClientType.Error()},
+		{[]interface{}{"CLIENT", "KILL", "ID", "1", "TYPE"}, errClientKillArg.Error()},
+		{[]interface{}{"CLIENT", "KILL", "127.0.0.1:1"}, errNoSuchClient.Error()},
+		{[]interface{}{"CLIENT", "STOP"}, errClientSubcmd.Error()},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Value) != test.want {
+			t.Errorf("%v: got %s, want %s", test.args, resp.Value, test.want)
+		}
+	}
+
+	resp, err := call(wb, rb, "CLIENT", "INFO")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"name=worker ", "flags=e ", "cmd=client ", "user=default"} {
+		if !strings.Contains(string(resp.Value), want) {
+			t.Errorf("CLIENT INFO: no %q in %s", want, resp.Value)
+		}
+	}
+
+	resp, err = call(wb, rb, "CLIENT", "LIST", "TYPE", "pubsub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(resp.Value)), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], "flags=P ") || !strings.Contains(lines[0], "sub=1 ") {
+		t.Fatalf("CLIENT LIST TYPE pubsub: got %q", resp.Value)
+	}
+
+	// SKIPME yes keeps the caller, the subscriber is killed
+	resp, err = call(wb, rb, "CLIENT", "KILL", "USER", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Value) != "1" {
+		t.Errorf("CLIENT KILL USER: got %s, want 1", resp.Value)
+	}
+	if _, err := orb.HandleStream(); err == nil {
+		t.Errorf("the killed connection is still open")
+	}
+	deadline := time.Now().Add(time.Second)
+	for server.clients.count() != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := server.clients.count(); n != 1 {
+		t.Errorf("got %d clients, want 1", n)
+	}
+}
+
+func TestServer_ClientPause(t *testing.T) {
+
+	server := newTestServer()
+	wb, rb := pipeConn(server)
+	owb, orb := pipeConn(server)
+
+	resp, err := call(wb, rb, "CLIENT", "PAUSE", "5000", "WRITE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Value) != "OK" {
+		t.Fatalf("CLIENT PAUSE: got %s", resp.Value)
+	}
+
+	// reads go on, the write waits for UNPAUSE
+	if resp, err := call(owb, orb, "GET", "a"); err != nil || resp.IsError() {
+		t.Fatalf("GET during a WRITE pause: %v %v", resp, err)
+	}
+	if resp, err := call(owb, orb, "FUNCTION", "LIST"); err != nil || resp.IsError() {
+		t.Fatalf("FUNCTION LIST during a WRITE pause: %v %v", resp, err)
+	}
+	done := make(chan *Resp)
+	go func() {
+		resp, _ := call(owb, orb, "SET", "a", "1")
+		done <- resp
+	}()
+	fwb, frb := pipeConn(server)
+	flushed := make(chan *Resp)
+	go func() {
+		resp, _ := call(fwb, frb, "FUNCTION", "FLUSH")
+		flushed <- resp
+	}()
+	select {
+	case <-done:
+		t.Fatal("SET ran during the pause")
+	case <-flushed:
+		t.Fatal("FUNCTION FLUSH ran during the pause")
+	case <-time.After(100 * time.Millisecond):
+	}
+	call(wb, rb, "CLIENT", "UNPAUSE")
+	for name, ran := range map[string]chan *Resp{"SET": done, "FUNCTION FLUSH": flushed} {
+		select {
+		case resp := <-ran:
+			if string(resp.Value) != "OK" {
+				t.Errorf("%s: got %s", name, resp.Value)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s still paused after UNPAUSE", name)
+		}
+	}
+}
@@ -40,19 +40,19 @@ func init() {
 	register("DECRBY", 3, 1, 'w', decreaseBy)
 	register("INCR", 2, 1, 'w', increase)
 	register("INCRBY", 3, 1, 'w', increaseBy)
-	register("APPEND", 3, 1, 'a', appends)
+	register("APPEND", 3, 1, 'w', appends)
 	register("MSET", 3, 1, 'w', multipleSet)
-	register("MGET", 2, 1, 'w', multipleGet)
+	register("MGET", 2, 1, 'r', multipleGet)
 
 	// list command
 	register("LLEN", 1, 1, 'r', lLen)
-	register("LPUSH", 1, 1, 'r', lPush)
-	register("LPOP", 1, 1, 'r', lPop)
-	register("RPUSH", 1, 1, 'r', rPush)
-	register("RPOP", 1, 1, 'r', rPop)
-	register("LREM", 1, 1, 'r', lRem)
+	register("LPUSH", 1, 1, 'w', lPush)
+	register("LPOP", 1, 1, 'w', lPop)
+	register("RPUSH", 1, 1, 'w', rPush)
+	register("RPOP", 1, 1, 'w', rPop)
+	register("LREM", 1, 1, 'w', lRem)
 	register("LINDEX", 1, 1, 'r', lIndex)
-	register("LSET", 1, 1, 'r', lSet)
+	register("LSET", 1, 1, 'w', lSet)
 	register("LRANGE", 1, 1, 'r', lRange)
 
 	// hash command
@@ -66,6 +66,7 @@ func init() {
 	register("HLEN", 2, 1, 'r', hLen)
 	register("HMGET", 3, 1, 'r', hMGet)
 	register("HMESET", 3, 1, 'w', hMSet)
+	register("HSCAN", 3, 1, 'r', hScan)
 
 	// set command
 	register("SADD", 3, 1, 'w', sAdd)
@@ -75,10 +76,11 @@ func init() {
 	register("SINTER", 3, 1, 'r', sInter)
 	register("SINTERSCORE", 3, 1, 'r', sInterScore)
 	register("SUNION", 3, 1, 'r', sUnion)
-	register("SUNIONSCORE", 3, 1, 'w', sUnionScore)
+	register("SUNIONSCORE", 3, 1, 'r', sUnionScore)
 	register("SISMEMBER", 3, 1, 'r', sIsMember)
 	register("SMEMBERS", 2, 1, 'r', sMembers)
 	register("SREM", 3, 1, 'w', sRem)
+	register("SSCAN", 3, 1, 'r', sScan)
 
 	// sorted set command
 	register("ZADD", 4, 1, 'w', zAdd)
@@ -89,9 +91,97 @@ func init() {
 	register("ZRANGEBYSCORE", 4, 1, 'r', zRangeByScore)
 	register("ZRANK", 3, 1, 'r', zRank)
 	register("ZREM", 3, 1, 'w', zRem)
+	register("ZSCAN", 3, 1, 'r', zScan)
+
+	// keyspace command
+	register("KEYS", 2, 1, 'r', keys)
+	register("SCAN", 2, 1, 'r', scan)
+	register("TYPE", 2, 1, 'r', keyType)
+	register("RENAME", 3, 1, 'w', rename)
+	register("RENAMENX", 3, 1, 'w', renameNX)
+	register("COPY", 3, 1, 'w', copyKey)
+	register("RANDOMKEY", 1, 1, 'r', randomKey)
+	register("TOUCH", 2, 1, 'r', touch)
+	register("DBSIZE", 1, 1, 'r', dbSize)
+	register("UNLINK", 2, 1, 'w', unlink)
+
+	// sort command
+	register("SORT", 2, 1, 'w', sortKey)
+	register("SORT_RO", 2, 1, 'r', sortReadOnly)
+
+	// dump command
+	register("DUMP", 2, 1, 'r', dump)
+	register("RESTORE", 4, 1, 'w', restore)
+	register("MIGRATE", 6, 1, 'w', migrate)
+
+	// object command
+	register("OBJECT", 3, 1, 'r', object)
+	register("MEMORY", 2, 1, 'r', memoryCommand)
+
+	// info command
+	register("INFO", 1, 1, 'r', info)
+
+	// slowlog command
+	register("SLOWLOG", 2, 1, 'a', slowLogCommand)
+	register("LATENCY", 2, 1, 'a', latencyCommand)
+
+	// client command
+	register("CLIENT", 2, 1, 'a', clientCommand)
+
+	// config command
+	register("CONFIG", 2, 1, 'a', configCommand)
+
+	// shutdown command
+	register("SHUTDOWN", 1, 1, 'a', shutdownCommand)
+	register("QUIT", 1, 1, 'r', quit)
+
+	// monitor command
+	register("MONITOR", 1, 1, 'a', monitor)
+
+	// acl command
+	register("AUTH", 2, 1, 'a', auth)
+	register("ACL", 2, 1, 'a', aclCommand)
+
+	// pub/sub command
+	register("SUBSCRIBE", 2, 1, 'r', subscribe)
+	register("UNSUBSCRIBE", 1, 1, 'r', unsubscribe)
+	register("PSUBSCRIBE", 2, 1, 'r', pSubscribe)
+	register("PUNSUBSCRIBE", 1, 1, 'r', pUnsubscribe)
+	register("PUBLISH", 3, 1, 'r', publish)
+
+	// transaction command
+	register("MULTI", 1, 1, 'r', multi)
+	register("EXEC", 1, 1, 'r', exec)
+	register("DISCARD", 1, 1, 'r', discard)
+	register("WATCH", 2, 1, 'r', watch)
+	register("UNWATCH", 1, 1, 'r', unwatch)
+
+	// scripting command
+	register("EVAL", 3, 1, 'w', eval)
+	register("EVALSHA", 3, 1, 'w', evalSha)
+	register("SCRIPT", 2, 1, 'a', scriptCommand)
+
+	// function command
+	register("FUNCTION", 2, 1, 'a', functionCommand)
+	register("FCALL", 3, 1, 'w', fCall)
+	register("FCALL_RO", 3, 1, 'r', fCallRO)
 
 }
 
+// writeSubcommands write although their command is not flagged 'w', they
+// are refused over maxmemory and held by CLIENT PAUSE WRITE
+var writeSubcommands = map[string]bool{
+	"FUNCTION|LOAD": true, "FUNCTION|DELETE": true, "FUNCTION|FLUSH": true, "FUNCTION|RESTORE": true,
+}
+
+// isWriteCall reports whether resp runs command as a write
+func isWriteCall(command *Command, resp *Resp) bool {
+	if command.SFlag == 'w' {
+		return true
+	}
+	return len(resp.Array) > 1 && writeSubcommands[command.Name+"|"+strings.ToUpper(string(resp.Array[1].Value))]
+}
+
 func register(name string, arity int, flag int, sFlag byte, process CommandProcess) {
 	c := &Command{name, arity, flag, sFlag, process}
 	CommandTable = append(CommandTable, c)
@@ -0,0 +1,381 @@
+package simpledb
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"simpledb/simpledb/config"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// config commands:
+// config get|set|rewrite|resetstat
+//
+// the parameters are the keys of the server section of the config file,
+// "maxmemory-policy" is read as maxmemory_policy. CONFIG SET changes the
+// running server for the parameters of mutableParams, the others are read
+// only. The timeouts apply to every connection from its next command,
+// tcp_keepalive to the connections accepted after the change.
+// CONFIG REWRITE writes the parameters back to the config file, keeping its
+// comments.
+
+var (
+	errConfigSubcmd = errors.New("ERR unknown subcommand, try CONFIG GET|SET|REWRITE|RESETSTAT")
+	errConfigArgs   = errors.New("ERR wrong number of arguments for CONFIG SET")
+	errNoConfigFile = errors.New("ERR The server is running without a config file")
+)
+
+// configParam applies a parameter changed by CONFIG SET, check refuses a
+// bad value before any parameter is applied
+type configParam struct {
+	check func(c *config.Config) error
+	apply func(s *Server, c *config.Config)
+}
+
+func checkPositive(name string, value func(c *config.Config) int64) func(c *config.Config) error {
+	return func(c *config.Config) error {
+		if value(c) <= 0 {
+			return fmt.Errorf("%s must be positive", name)
+		}
+		return nil
+	}
+}
+
+func checkNotNegative(name string, value func(c *config.Config) int64) func(c *config.Config) error {
+	return func(c *config.Config) error {
+		if value(c) < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+		return nil
+	}
+}
+
+func applyMemory(s *Server, c *config.Config) {
+	s.memory.configure(c.Server.MaxMemory, c.Server.MaxMemoryPolicy, c.Server.MaxMemorySamples)
+}
+
+func applySlowLog(s *Server, c *config.Config) {
+	s.slowLog.configure(c.Server.SlowLogSlowerThan, c.Server.SlowLogMaxLen)
+}
+
+var mutableParams = map[string]configParam{
+	"read_timeout": {
+		check: checkPositive("read_timeout", func(c *config.Config) int64 { return int64(c.Server.ReadTimeout) }),
+		apply: applyTimeouts,
+	},
+	"write_timeout": {
+		check: checkPositive("write_timeout", func(c *config.Config) int64 { return int64(c.Server.WriteTimeout) }),
+		apply: applyTimeouts,
+	},
+	"connect_timeout": {
+		check: checkPositive("connect_timeout", func(c *config.Config) int64 { return int64(c.Server.ConnectTimeout) }),
+	},
+	"notify_keyspace_events": {
+		check: func(c *config.Config) error {
+			for _, class := range c.Server.NotifyKeyspaceEvents {
+				if !strings.ContainsRune("AKEg$lshzxe", class) {
+					return fmt.Errorf("notify_keyspace_events: unknown class %q", class)
+				}
+			}
+			return nil
+		},
+		apply: func(s *Server, c *config.Config) {
+			s.pubsub.setNotifyFlags(parseNotifyFlags(c.Server.NotifyKeyspaceEvents))
+		},
+	},
+	"script_time_limit": {
+		check: checkPositive("script_time_limit", func(c *config.Config) int64 { return int64(c.Server.ScriptTimeLimit) }),
+		apply: func(s *Server, c *config.Config) {
+			s.scripts.setTimeLimit(time.Duration(c.Server.ScriptTimeLimit) * time.Millisecond)
+		},
+	},
+	"maxmemory": {
+		check: checkNotNegative("maxmemory", func(c *config.Config) int64 { return c.Server.MaxMemory }),
+		apply: applyMemory,
+	},
+	"maxmemory_policy": {
+		check: func(c *config.Config) error {
+			if !evictionPolicies[c.Server.MaxMemoryPolicy] {
+				return fmt.Errorf("maxmemory_policy: unknown policy %q", c.Server.MaxMemoryPolicy)
+			}
+			return nil
+		},
+		apply: applyMemory,
+	},
+	"maxmemory_samples": {
+		check: checkPositive("maxmemory_samples", func(c *config.Config) int64 { return int64(c.Server.MaxMemorySamples) }),
+		apply: applyMemory,
+	},
+	"slowlog_log_slower_than": {
+		apply: applySlowLog,
+	},
+	"slowlog_max_len": {
+		check: checkNotNegative("slowlog_max_len", func(c *config.Config) int64 { return int64(c.Server.SlowLogMaxLen) }),
+		apply: applySlowLog,
+	},
+	"latency_monitor_threshold": {
+		check: checkNotNegative("latency_monitor_threshold", func(c *config.Config) int64 { return c.Server.LatencyMonitorThreshold }),
+		apply: func(s *Server, c *config.Config) {
+			s.latency.setThreshold(c.Server.LatencyMonitorThreshold)
+		},
+	},
+	"requirepass": {
+		apply: func(s *Server, c *config.Config) {
+			s.acl.setRequirePass(c.Server.RequirePass)
+		},
+	},
+	"save": {
+		check: func(c *config.Config) error {
+			return checkSaveRules(c.Server.Save)
+		},
+	},
+	"dbfilename": {
+		check: func(c *config.Config) error {
+			if strings.ContainsRune(c.Server.DBFilename, '/') {
+				return fmt.Errorf("dbfilename can't be a path, just a filename")
+			}
+			return nil
+		},
+	},
+	"timeout": {
+		check: checkNotNegative("timeout", func(c *config.Config) int64 { return int64(c.Server.Timeout) }),
+	},
+	"tcp_keepalive": {
+		check: checkNotNegative("tcp_keepalive", func(c *config.Config) int64 { return int64(c.Server.TCPKeepAlive) }),
+	},
+	"maxclients": {
+		check: checkNotNegative("maxclients", func(c *config.Config) int64 { return int64(c.Server.MaxClients) }),
+	},
+	"shutdown_timeout": {
+		check: checkNotNegative("shutdown_timeout", func(c *config.Config) int64 { return int64(c.Server.ShutdownTimeout) }),
+	},
+}
+
+// checkSaveRules checks the "seconds changes" pairs of the save parameter,
+// a save after seconds when at least changes writes happened
+func checkSaveRules(rules string) error {
+	fields := strings.Fields(rules)
+	if len(fields)%2 != 0 {
+		return fmt.Errorf("save: want pairs of seconds and changes, got %q", rules)
+	}
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err1 := strconv.ParseInt(fields[i], 10, 64)
+		changes, err2 := strconv.ParseInt(fields[i+1], 10, 64)
+		if err1 != nil || err2 != nil || seconds <= 0 || changes <= 0 {
+			return fmt.Errorf("save: invalid rule %q %q", fields[i], fields[i+1])
+		}
+	}
+	return nil
+}
+
+// paramName reads a parameter name as a config file key
+func paramName(name string) string {
+	return strings.Replace(strings.ToLower(name), "-", "_", -1)
+}
+
+// connTimeouts are the read and write timeouts of the connections, apart
+// from confMu since the replies written under it need them
+type connTimeouts struct {
+	mu          sync.Mutex
+	read, write time.Duration
+}
+
+func newConnTimeouts(read, write time.Duration) *connTimeouts {
+	t := &connTimeouts{mu: sync.Mutex{}}
+	t.set(read, write)
+	return t
+}
+
+// set changes the timeouts, 0 is defaultTimeout
+func (t *connTimeouts) set(read, write time.Duration) {
+	if read == 0 {
+		read = defaultTimeout
+	}
+	if write == 0 {
+		write = defaultTimeout
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.read, t.write = read, write
+}
+
+func applyTimeouts(s *Server, c *config.Config) {
+	s.connTimeouts.set(c.Server.ReadTimeout, c.Server.WriteTimeout)
+}
+
+// timeouts returns the seconds a command has to arrive and a reply to be
+// written, read for each of them so that CONFIG SET applies at once
+func (s *Server) timeouts() (read, write time.Duration) {
+	s.connTimeouts.mu.Lock()
+	defer s.connTimeouts.mu.Unlock()
+	return s.connTimeouts.read, s.connTimeouts.write
+}
+
+// idleTimeout returns the seconds a connection waits for its next command,
+// 0 waits forever
+func (s *Server) idleTimeout() time.Duration {
+	s.confMu.RLock()
+	defer s.confMu.RUnlock()
+	return s.conf.Server.Timeout
+}
+
+// connLimits returns the keepalive period of a new connection and the
+// number of clients it can't exceed, 0 for no limit
+func (s *Server) connLimits() (keepAlive time.Duration, maxClients int) {
+	s.confMu.RLock()
+	defer s.confMu.RUnlock()
+	return s.conf.Server.TCPKeepAlive, s.conf.Server.MaxClients
+}
+
+func (s *Server) configGet(patterns []*Resp) error {
+	s.confMu.RLock()
+	defer s.confMu.RUnlock()
+
+	values := make(map[string]string)
+	for _, pattern := range patterns {
+		p := paramName(string(pattern.Value))
+		for _, name := range s.conf.Params() {
+			if matchPattern(p, name) {
+				values[name], _ = s.conf.Get(name)
+			}
+		}
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	reply := make([]*Resp, 0, 2*len(names))
+	for _, name := range names {
+		reply = append(reply, NewBulkBytes([]byte(name)), NewBulkBytes([]byte(values[name])))
+	}
+	return s.writeResp(NewArray(reply))
+}
+
+// configSet sets every parameter or none of them
+func (s *Server) configSet(args []*Resp) error {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return s.replyErr(errConfigArgs)
+	}
+	s.confMu.Lock()
+	defer s.confMu.Unlock()
+
+	c := *s.conf
+	var changed []configParam
+	seen := make(map[string]bool)
+	for i := 0; i < len(args); i += 2 {
+		name := paramName(string(args[i].Value))
+		fail := func(reason string) error {
+			return s.replyErr(fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - %s", args[i].Value, reason))
+		}
+		if _, ok := c.Get(name); !ok {
+			return fail("unknown parameter")
+		}
+		param, ok := mutableParams[name]
+		if !ok {
+			return fail("can't set immutable config")
+		}
+		if seen[name] {
+			return fail("duplicate parameter")
+		}
+		seen[name] = true
+		if err := c.Set(name, string(args[i+1].Value)); err != nil {
+			return fail(err.Error())
+		}
+		if param.check != nil {
+			if err := param.check(&c); err != nil {
+				return fail(err.Error())
+			}
+		}
+		changed = append(changed, param)
+	}
+	for _, param := range changed {
+		if param.apply != nil {
+			param.apply(s, &c)
+		}
+	}
+	*s.conf = c
+	return s.replyOk()
+}
+
+// Reload applies the mutable parameters of c that changed, as CONFIG SET
+// does, and returns their names. A read only parameter that changed is
+// logged, it needs a restart.
+func (s *Server) Reload(c *config.Config) ([]string, error) {
+	s.confMu.Lock()
+	defer s.confMu.Unlock()
+
+	updated := *s.conf
+	var names []string
+	var changed []configParam
+	for _, name := range c.Params() {
+		old, _ := s.conf.Get(name)
+		value, _ := c.Get(name)
+		if old == value {
+			continue
+		}
+		param, ok := mutableParams[name]
+		if !ok {
+			log.Printf("config %s changed, it applies after a restart", name)
+			continue
+		}
+		updated.Set(name, value)
+		if param.check != nil {
+			if err := param.check(&updated); err != nil {
+				return nil, err
+			}
+		}
+		names = append(names, name)
+		changed = append(changed, param)
+	}
+	for _, param := range changed {
+		if param.apply != nil {
+			param.apply(s, &updated)
+		}
+	}
+	updated.Path = c.Path
+	*s.conf = updated
+	return names, nil
+}
+
+func (s *Server) configRewrite() error {
+	s.confMu.RLock()
+	defer s.confMu.RUnlock()
+	if s.conf.Path == "" {
+		return s.replyErr(errNoConfigFile)
+	}
+	if err := s.conf.Rewrite(); err != nil {
+		return s.replyErr(fmt.Errorf("ERR Rewriting config file: %v", err))
+	}
+	return s.replyOk()
+}
+
+// resetStats zeroes the counters of INFO and the command stats, see
+// CONFIG RESETSTAT
+func (s *Server) resetStats() {
+	s.stats.reset()
+	s.memory.resetStats()
+}
+
+func configCommand(s *Server, resp *Resp) error {
+	args := resp.Array[2:]
+	switch strings.ToUpper(string(resp.Array[1].Value)) {
+	case "GET":
+		if len(args) == 0 {
+			return s.replyErr(errConfigSubcmd)
+		}
+		return s.configGet(args)
+	case "SET":
+		return s.configSet(args)
+	case "REWRITE":
+		return s.configRewrite()
+	case "RESETSTAT":
+		s.resetStats()
+		return s.replyOk()
+	}
+	return s.replyErr(errConfigSubcmd)
+}
@@ -1,24 +1,102 @@
 package config
 
 import (
+	"flag"
 	"fmt"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// the config is found in this order: the path given to Load, e.g. by the
+// --config flag, the SIMPLEDB_CONFIG environment variable, and without
+// either the defaults of Default, documented in config.yaml. Every field can
+// then be overridden by an environment variable named after its section and
+// yaml key, e.g. SIMPLEDB_SERVER_PORT=6380 or SIMPLEDB_CLIENT_HOST=cache.
 const (
-	defaultConfigPath = "/Users/cowboy/golang/src/simpledb/simpledb/config/config.yaml"
-	//defaultConfigPath = "/home/qiu/gopath/src/simpledb/simpledb/config/config.yaml"
+	EnvConfig = "SIMPLEDB_CONFIG"
+	envPrefix = "SIMPLEDB_"
 )
 
 type Config struct {
+	// file the config was read from
+	Path string `yaml:"-"`
+
 	Server struct {
 		Host           string        `yaml:"host"`
 		Port           int           `yaml:"port"`
 		ReadTimeout    time.Duration `yaml:"read_timeout"`
 		WriteTimeout   time.Duration `yaml:"write_timeout"`
 		ConnectTimeout time.Duration `yaml:"connect_timeout"`
+		// seconds a client may idle between commands, 0 for no limit
+		Timeout time.Duration `yaml:"timeout"`
+		// seconds between the TCP keepalive probes, 0 disables them
+		TCPKeepAlive time.Duration `yaml:"tcp_keepalive"`
+		// connections served at once, 0 for no limit
+		MaxClients int `yaml:"maxclients"`
+
+		// classes of keyspace events published over pub/sub, e.g. "KEA"
+		NotifyKeyspaceEvents string `yaml:"notify_keyspace_events"`
+		// milliseconds a script runs before SCRIPT KILL can stop it
+		ScriptTimeLimit int `yaml:"script_time_limit"`
+
+		// bytes of keyspace before keys are evicted, 0 for no limit
+		MaxMemory int64 `yaml:"maxmemory"`
+		// noeviction, allkeys-lru, allkeys-lfu, allkeys-random,
+		// volatile-lru, volatile-lfu or volatile-ttl
+		MaxMemoryPolicy string `yaml:"maxmemory_policy"`
+		// keys sampled by each eviction round
+		MaxMemorySamples int `yaml:"maxmemory_samples"`
+
+		// "seconds changes" pairs, a snapshot is saved in the background
+		// after seconds when at least changes writes happened, e.g.
+		// "3600 1 300 100"
+		Save string `yaml:"save"`
+		// file in the working directory the keyspace is saved to by the
+		// save rules and SHUTDOWN and loaded from at start, empty disables
+		// it
+		DBFilename string `yaml:"dbfilename"`
+		// seconds SHUTDOWN waits for the commands in flight
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+		// microseconds a command runs before it is logged by SLOWLOG,
+		// negative disables the log and 0 logs every command
+		SlowLogSlowerThan int64 `yaml:"slowlog_log_slower_than"`
+		// entries kept by SLOWLOG
+		SlowLogMaxLen int `yaml:"slowlog_max_len"`
+		// milliseconds of a latency spike recorded by LATENCY, 0 disables
+		// the monitor
+		LatencyMonitorThreshold int64 `yaml:"latency_monitor_threshold"`
+
+		// port of the prometheus /metrics endpoint, 0 disables it
+		MetricsPort int `yaml:"metrics_port"`
+
+		// path of a unix socket listened on besides port, only on it with
+		// port 0
+		UnixSocket string `yaml:"unixsocket"`
+		// octal mode of the socket file, e.g. "770"
+		UnixSocketPerm string `yaml:"unixsocketperm"`
+
+		// password of the default user, empty lets every connection in
+		RequirePass string `yaml:"requirepass"`
+		// file of the ACL users, loaded at start and by ACL LOAD
+		ACLFile string `yaml:"aclfile"`
+
+		// port of the TLS listener, 0 disables it
+		TLSPort       int    `yaml:"tls_port"`
+		TLSCertFile   string `yaml:"tls_cert_file"`
+		TLSKeyFile    string `yaml:"tls_key_file"`
+		TLSCACertFile string `yaml:"tls_ca_cert_file"`
+		// yes, optional or no: whether the clients need a certificate
+		// signed by the CA
+		TLSAuthClients string `yaml:"tls_auth_clients"`
+		// "CN" authenticates a client as the ACL user named by the common
+		// name of its certificate
+		TLSAuthClientsUser string `yaml:"tls_auth_clients_user"`
 	} `yaml:"server"`
 
 	Client struct {
@@ -27,29 +105,192 @@ type Config struct {
 		ReadTimeout    time.Duration `yaml:"read_timeout"`
 		WriteTimeout   time.Duration `yaml:"write_timeout"`
 		ConnectTimeout time.Duration `yaml:"connect_timeout"`
+
+		// connect over TLS, verifying the server against tls_ca_cert_file
+		// and presenting tls_cert_file when set
+		TLS           bool   `yaml:"tls"`
+		TLSCertFile   string `yaml:"tls_cert_file"`
+		TLSKeyFile    string `yaml:"tls_key_file"`
+		TLSCACertFile string `yaml:"tls_ca_cert_file"`
 	} `yaml:"client"`
 }
 
-func NewConfig(path ...string) (*Config, error) {
+// Flag registers the --config flag on fs, its value is the path of Load
+func Flag(fs *flag.FlagSet) *string {
+	return fs.String("config", "", "config file, $"+EnvConfig+" without it, the defaults without either")
+}
 
-	var (
-		curPath string
-		config  Config
-	)
-	if len(path) == 0 {
-		curPath = defaultConfigPath
-	} else if len(path) > 0 {
-		curPath = path[0]
-	} else {
-		return nil, fmt.Errorf("invalid config path")
+// Default returns the config of a server and a client without a file
+func Default() *Config {
+	c := &Config{}
+	c.Server.Host = "127.0.0.1"
+	c.Server.Port = 38391
+	c.Server.ConnectTimeout = 5
+	c.Server.ReadTimeout = 3
+	c.Server.WriteTimeout = 3
+	c.Server.TCPKeepAlive = 300
+	c.Server.MaxClients = 10000
+	c.Server.ScriptTimeLimit = 5000
+	c.Server.MaxMemoryPolicy = "noeviction"
+	c.Server.MaxMemorySamples = 5
+	c.Server.Save = "3600 1 300 100 60 10000"
+	c.Server.DBFilename = "dump.sdb"
+	c.Server.ShutdownTimeout = 10
+	c.Server.SlowLogSlowerThan = 10000
+	c.Server.SlowLogMaxLen = 128
+	c.Server.UnixSocketPerm = "700"
+	c.Server.TLSAuthClients = "yes"
+
+	c.Client.Host = "127.0.0.1"
+	c.Client.Port = 38391
+	c.Client.ConnectTimeout = 5
+	c.Client.ReadTimeout = 3
+	c.Client.WriteTimeout = 3
+	return c
+}
+
+// Load returns the config of path, of SIMPLEDB_CONFIG without a path, or
+// the defaults without either, with the environment overrides applied. The
+// fields missing from the file keep their defaults.
+func Load(path string) (*Config, error) {
+	if path == "" {
+		path = os.Getenv(EnvConfig)
+	}
+	config := Default()
+	if path != "" {
+		file, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		// an unknown key is an error, a typo would silently keep a default
+		if err := yaml.UnmarshalStrict(file, config); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		config.Path = path
 	}
-	file, err := ioutil.ReadFile(curPath)
-	if err != nil {
+	if err := config.applyEnv(os.Environ()); err != nil {
 		return nil, err
 	}
-
-	if err := yaml.Unmarshal(file, &config); err != nil {
+	if err := config.Validate(); err != nil {
 		return nil, err
 	}
-	return &config, nil
+	return config, nil
+}
+
+// NewConfig returns the config of path, see Load
+func NewConfig(path ...string) (*Config, error) {
+	if len(path) > 1 {
+		return nil, fmt.Errorf("invalid config path")
+	}
+	if len(path) == 0 {
+		return Load("")
+	}
+	if path[0] == "" {
+		return nil, fmt.Errorf("empty config path")
+	}
+	return Load(path[0])
+}
+
+// applyEnv applies the SIMPLEDB_<SECTION>_<KEY> variables of env
+func (c *Config) applyEnv(env []string) error {
+	sections := map[string]reflect.Value{
+		"SERVER": reflect.ValueOf(&c.Server).Elem(),
+		"CLIENT": reflect.ValueOf(&c.Client).Elem(),
+	}
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], envPrefix) || parts[0] == EnvConfig {
+			continue
+		}
+		name := strings.TrimPrefix(parts[0], envPrefix)
+		section := strings.SplitN(name, "_", 2)
+		v, ok := sections[section[0]]
+		if !ok || len(section) != 2 {
+			continue
+		}
+		f, ok := fieldByKey(v, strings.ToLower(section[1]))
+		if !ok {
+			return fmt.Errorf("%s: unknown field %s.%s", parts[0], strings.ToLower(section[0]), strings.ToLower(section[1]))
+		}
+		if err := setField(f, parts[1]); err != nil {
+			return fmt.Errorf("%s: %v", parts[0], err)
+		}
+	}
+	return nil
+}
+
+var (
+	evictionPolicies = map[string]bool{
+		"noeviction": true, "allkeys-lru": true, "allkeys-lfu": true, "allkeys-random": true,
+		"volatile-lru": true, "volatile-lfu": true, "volatile-ttl": true,
+	}
+	tlsAuthClients = map[string]bool{"": true, "yes": true, "optional": true, "no": true}
+)
+
+// Validate returns an error naming the first bad field
+func (c *Config) Validate() error {
+	s, cl := &c.Server, &c.Client
+	for _, port := range []struct {
+		field string
+		port  int
+	}{
+		{"server.port", s.Port}, {"server.metrics_port", s.MetricsPort},
+		{"server.tls_port", s.TLSPort}, {"client.port", cl.Port},
+	} {
+		if port.port < 0 || port.port > 65535 {
+			return fmt.Errorf("%s: %d is not a port", port.field, port.port)
+		}
+	}
+	for _, n := range []struct {
+		field string
+		value int64
+	}{
+		{"server.connect_timeout", int64(s.ConnectTimeout)}, {"server.read_timeout", int64(s.ReadTimeout)},
+		{"server.write_timeout", int64(s.WriteTimeout)}, {"server.timeout", int64(s.Timeout)},
+		{"server.tcp_keepalive", int64(s.TCPKeepAlive)}, {"server.maxclients", int64(s.MaxClients)},
+		{"server.script_time_limit", int64(s.ScriptTimeLimit)},
+		{"server.maxmemory", s.MaxMemory}, {"server.maxmemory_samples", int64(s.MaxMemorySamples)},
+		{"server.shutdown_timeout", int64(s.ShutdownTimeout)}, {"server.slowlog_max_len", int64(s.SlowLogMaxLen)},
+		{"server.latency_monitor_threshold", s.LatencyMonitorThreshold},
+		{"client.connect_timeout", int64(cl.ConnectTimeout)}, {"client.read_timeout", int64(cl.ReadTimeout)},
+		{"client.write_timeout", int64(cl.WriteTimeout)},
+	} {
+		if n.value < 0 {
+			return fmt.Errorf("%s: %d must not be negative", n.field, n.value)
+		}
+	}
+	if !evictionPolicies[s.MaxMemoryPolicy] {
+		return fmt.Errorf("server.maxmemory_policy: unknown policy %q", s.MaxMemoryPolicy)
+	}
+	for _, class := range s.NotifyKeyspaceEvents {
+		if !strings.ContainsRune("AKEg$lshzxe", class) {
+			return fmt.Errorf("server.notify_keyspace_events: unknown class %q", class)
+		}
+	}
+	if fields := strings.Fields(s.Save); len(fields)%2 != 0 {
+		return fmt.Errorf("server.save: want pairs of seconds and changes, got %q", s.Save)
+	}
+	for _, field := range strings.Fields(s.Save) {
+		if n, err := strconv.ParseInt(field, 10, 64); err != nil || n <= 0 {
+			return fmt.Errorf("server.save: %q is not a positive integer", field)
+		}
+	}
+	if strings.ContainsRune(s.DBFilename, '/') {
+		return fmt.Errorf("server.dbfilename: %q is a path, the file goes in the working directory", s.DBFilename)
+	}
+	if s.UnixSocketPerm != "" {
+		if mode, err := strconv.ParseUint(s.UnixSocketPerm, 8, 32); err != nil || mode > 0777 {
+			return fmt.Errorf("server.unixsocketperm: %q is not an octal mode", s.UnixSocketPerm)
+		}
+	}
+	if !tlsAuthClients[strings.ToLower(s.TLSAuthClients)] {
+		return fmt.Errorf("server.tls_auth_clients: %q is not yes, optional or no", s.TLSAuthClients)
+	}
+	if s.TLSPort > 0 && (s.TLSCertFile == "" || s.TLSKeyFile == "") {
+		return fmt.Errorf("server.tls_cert_file: a certificate and a key are required with tls_port")
+	}
+	if s.TLSAuthClientsUser != "" && strings.ToUpper(s.TLSAuthClientsUser) != "CN" {
+		return fmt.Errorf("server.tls_auth_clients_user: %q is not CN", s.TLSAuthClientsUser)
+	}
+	return nil
 }
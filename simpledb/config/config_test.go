@@ -1,25 +1,183 @@
 package config
 
-import "testing"
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
 
 func TestNewConfig(t *testing.T) {
 
+	os.Unsetenv(EnvConfig)
 	var tests = []struct {
 		path string
 		want string
 	}{
-		{path: "", want: "open : no such file or directory"},
+		{path: "", want: "empty config path"},
 		{path: "xxx", want: "open xxx: no such file or directory"},
-		{path: "/home/qiu/Projects/simpledb/config/config.yaml", want: ""},
-		{path: "/home/qiu/gopath/src/simpledb/simpledb/config/config.yaml", want: ""},
+		{path: "config.yaml", want: ""},
 	}
 
 	for _, test := range tests {
 		config, err := NewConfig(test.path)
+		got := ""
 		if err != nil {
-			t.Error(err)
-		} else {
-			t.Logf("%+v", config)
+			got = err.Error()
 		}
+		if got != test.want {
+			t.Errorf("NewConfig(%q): got error %q, want %q", test.path, got, test.want)
+		}
+		if err == nil && config.Path != test.path {
+			t.Errorf("NewConfig(%q): got path %q", test.path, config.Path)
+		}
+	}
+
+	// the documented config file holds the defaults
+	config, err := NewConfig("config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Path = ""
+	if !reflect.DeepEqual(config, Default()) {
+		t.Errorf("config.yaml:\n%+v\nwant the defaults:\n%+v", config, Default())
+	}
+}
+
+func TestFlag(t *testing.T) {
+
+	fs := flag.NewFlagSet("simpledb", flag.ContinueOnError)
+	path := Flag(fs)
+	if err := fs.Parse([]string{"--config", "config.yaml"}); err != nil {
+		t.Fatal(err)
+	}
+	if *path != "config.yaml" {
+		t.Errorf("--config: got %q", *path)
+	}
+}
+
+func TestLoad(t *testing.T) {
+
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	partial := write("partial.yaml", "server:\n  port: 6380\n")
+	typo := write("typo.yaml", "server:\n  prot: 6380\n")
+	badPolicy := write("policy.yaml", "server:\n  maxmemory_policy: sometimes\n")
+
+	var tests = []struct {
+		path string
+		env  map[string]string
+		want string // error
+		port int
+	}{
+		{"", nil, "", 38391},
+		{partial, nil, "", 6380},
+		{"", map[string]string{EnvConfig: partial}, "", 6380},
+		{partial, map[string]string{"SIMPLEDB_SERVER_PORT": "7000"}, "", 7000},
+		{"", map[string]string{"SIMPLEDB_SERVER_PORT": "many"}, `SIMPLEDB_SERVER_PORT: "many" is not an integer`, 0},
+		{"", map[string]string{"SIMPLEDB_SERVER_PROT": "1"}, "SIMPLEDB_SERVER_PROT: unknown field server.prot", 0},
+		{"", map[string]string{"SIMPLEDB_SERVER_PORT": "70000"}, "server.port: 70000 is not a port", 0},
+		{"", map[string]string{"SIMPLEDB_SERVER_READ_TIMEOUT": "-1"}, "server.read_timeout: -1 must not be negative", 0},
+		{"", map[string]string{"SIMPLEDB_SERVER_SAVE": "60"}, `server.save: want pairs of seconds and changes, got "60"`, 0},
+		{typo, nil, typo + ": yaml: unmarshal errors:\n  line 2: field prot not found in type struct", 0},
+		{badPolicy, nil, `server.maxmemory_policy: unknown policy "sometimes"`, 0},
+	}
+	for _, test := range tests {
+		os.Unsetenv(EnvConfig)
+		for k, v := range test.env {
+			os.Setenv(k, v)
+		}
+		config, err := Load(test.path)
+		for k := range test.env {
+			os.Unsetenv(k)
+		}
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if !strings.HasPrefix(got, test.want) || (test.want == "" && got != "") {
+			t.Errorf("Load(%q) %v: got error %q, want %q", test.path, test.env, got, test.want)
+			continue
+		}
+		if err == nil && config.Server.Port != test.port {
+			t.Errorf("Load(%q) %v: got port %d, want %d", test.path, test.env, config.Server.Port, test.port)
+		}
+		// the fields missing from the file keep their defaults
+		if err == nil && config.Server.MaxMemoryPolicy != "noeviction" {
+			t.Errorf("Load(%q): got maxmemory_policy %q", test.path, config.Server.MaxMemoryPolicy)
+		}
+	}
+}
+
+func TestConfig_Rewrite(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	original := `# test configuration
+
+server:
+  host: 127.0.0.1
+  # bytes of keyspace
+  maxmemory: 0   # no limit
+  maxmemory_policy: noeviction
+
+client:
+  port: 38391
+`
+	if err := ioutil.WriteFile(path, []byte(original), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := NewConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name  string
+		value string
+		err   bool
+	}{
+		{"maxmemory", "1048576", false},
+		{"maxmemory_policy", "allkeys-lru", false},
+		{"slowlog_max_len", "64", false},
+		{"maxmemory", "a lot", true},
+		{"no_such_param", "1", true},
+	}
+	for _, test := range tests {
+		if err := config.Set(test.name, test.value); (err != nil) != test.err {
+			t.Errorf("Set(%s, %s): got %v", test.name, test.value, err)
+		}
+	}
+	if value, _ := config.Get("maxmemory"); value != "1048576" {
+		t.Errorf("Get(maxmemory): got %s, want 1048576", value)
+	}
+	if err := config.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"# test configuration", "  # bytes of keyspace", "  maxmemory: 1048576   # no limit",
+		`  maxmemory_policy: "allkeys-lru"`, "  slowlog_max_len: 64", "  host: 127.0.0.1", "client:", "  port: 38391"} {
+		if !strings.Contains(string(data), line+"\n") {
+			t.Errorf("rewritten file: no line %q in\n%s", line, data)
+		}
+	}
+	reread, err := NewConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reread.Server.MaxMemory != 1048576 || reread.Server.MaxMemoryPolicy != "allkeys-lru" || reread.Server.SlowLogMaxLen != 64 {
+		t.Errorf("reread: got %+v", reread.Server)
 	}
 }
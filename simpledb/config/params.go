@@ -0,0 +1,198 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the server parameters are read and written by their yaml key, as
+// CONFIG GET and CONFIG SET do
+
+// Params returns the yaml keys of the server parameters, in file order
+func (c *Config) Params() []string {
+	t := reflect.TypeOf(c.Server)
+	names := make([]string, t.NumField())
+	for i := range names {
+		names[i] = t.Field(i).Tag.Get("yaml")
+	}
+	return names
+}
+
+// fieldByKey returns the field of a section struct by its yaml key
+func fieldByKey(section reflect.Value, key string) (reflect.Value, bool) {
+	for i := 0; i < section.NumField(); i++ {
+		if section.Type().Field(i).Tag.Get("yaml") == key {
+			return section.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func (c *Config) field(name string) (reflect.Value, bool) {
+	return fieldByKey(reflect.ValueOf(&c.Server).Elem(), name)
+}
+
+// Get returns the value of a server parameter, false when there is none
+func (c *Config) Get(name string) (string, bool) {
+	f, ok := c.field(name)
+	if !ok {
+		return "", false
+	}
+	if d, ok := f.Interface().(time.Duration); ok {
+		// durations are written in seconds
+		return strconv.FormatInt(int64(d), 10), true
+	}
+	return fmt.Sprint(f.Interface()), true
+}
+
+// Set parses value into a server parameter
+func (c *Config) Set(name, value string) error {
+	f, ok := c.field(name)
+	if !ok {
+		return fmt.Errorf("unknown parameter %s", name)
+	}
+	if err := setField(f, value); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// setField parses value into a field of a section
+func setField(f reflect.Value, value string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		f.SetInt(n)
+	case reflect.Bool:
+		switch strings.ToLower(value) {
+		case "yes", "true":
+			f.SetBool(true)
+		case "no", "false":
+			f.SetBool(false)
+		default:
+			return fmt.Errorf("%q is not yes or no", value)
+		}
+	default:
+		return fmt.Errorf("unsupported type %s", f.Kind())
+	}
+	return nil
+}
+
+// yamlValue formats a parameter for the config file
+func yamlValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return strconv.Quote(v.String())
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	}
+	return strconv.FormatInt(v.Int(), 10)
+}
+
+// sameValue reports whether the yaml scalar raw already holds value
+func sameValue(raw string, value reflect.Value) bool {
+	parsed := reflect.New(value.Type())
+	if err := yaml.Unmarshal([]byte(raw), parsed.Interface()); err != nil {
+		return false
+	}
+	return parsed.Elem().Interface() == value.Interface()
+}
+
+// a "  key: value  # comment" line of the server section
+var paramLine = regexp.MustCompile(`^(\s+)([A-Za-z0-9_]+):(\s*)(.*?)(\s+#.*)?$`)
+
+// Rewrite writes the server parameters to the file the config was read
+// from. Only the lines of the parameters that changed are rewritten, the
+// comments and the other sections are kept, the missing parameters are
+// added at the end of the server section.
+func (c *Config) Rewrite() error {
+	if c.Path == "" {
+		return fmt.Errorf("the config was not read from a file")
+	}
+	data, err := ioutil.ReadFile(c.Path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(data), "\n")
+
+	written := make(map[string]bool)
+	inServer, end, indent := false, -1, "  "
+	for i, line := range lines {
+		// a key at column 0 starts a section
+		if line != "" && line[0] != ' ' && line[0] != '\t' && line[0] != '#' {
+			inServer = strings.HasPrefix(line, "server:")
+			continue
+		}
+		if !inServer {
+			continue
+		}
+		m := paramLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		end, indent = i, m[1]
+		f, ok := c.field(m[2])
+		if !ok {
+			continue
+		}
+		written[m[2]] = true
+		if sameValue(m[4], f) {
+			continue
+		}
+		lines[i] = m[1] + m[2] + ": " + yamlValue(f) + m[5]
+	}
+
+	var missing []string
+	for _, name := range c.Params() {
+		f, _ := c.field(name)
+		if !written[name] && !f.IsZero() {
+			missing = append(missing, indent+name+": "+yamlValue(f))
+		}
+	}
+	if len(missing) > 0 {
+		if end < 0 {
+			return fmt.Errorf("no server section in %s", c.Path)
+		}
+		lines = append(lines[:end+1], append(missing, lines[end+1:]...)...)
+	}
+	return writeFile(c.Path, lines)
+}
+
+// writeFile replaces path through a temporary file, a failure keeps the
+// old content
+func writeFile(path string, lines []string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	w.WriteString(strings.Join(lines, "\n"))
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
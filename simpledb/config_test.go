@@ -0,0 +1,124 @@
+package simpledb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestServer_Config(t *testing.T) {
+
+	server := newTestServer()
+	wb, rb := pipeConn(server)
+
+	var tests = []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"CONFIG", "SET", "maxmemory-policy", "allkeys-lru", "maxmemory", "1000"}, "OK"},
+		{[]interface{}{"CONFIG", "SET", "maxmemory", "2000", "maxmemory_policy", "sometimes"},
+			"ERR CONFIG SET failed (possibly related to argument 'maxmemory_policy') - maxmemory_policy: unknown policy \"sometimes\""},
+		{[]interface{}{"CONFIG", "SET", "port", "1"}, "ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config"},
+		{[]interface{}{"CONFIG", "SET", "nosuchparam", "1"}, "ERR CONFIG SET failed (possibly related to argument 'nosuchparam') - unknown parameter"},
+		{[]interface{}{"CONFIG", "SET", "maxmemory", "1", "maxmemory", "2"}, "ERR CONFIG SET failed (possibly related to argument 'maxmemory') - duplicate parameter"},
+		{[]interface{}{"CONFIG", "SET", "read_timeout", "0"}, "ERR CONFIG SET failed (possibly related to argument 'read_timeout') - read_timeout must be positive"},
+		{[]interface{}{"CONFIG", "SET", "save", "3600"}, "ERR CONFIG SET failed (possibly related to argument 'save') - save: want pairs of seconds and changes, got \"3600\""},
+		{[]interface{}{"CONFIG", "SET", "maxmemory"}, errConfigArgs.Error()},
+		{[]interface{}{"CONFIG", "SET", "slowlog-log-slower-than", "0", "slowlog-max-len", "1", "save", "60 10"}, "OK"},
+		{[]interface{}{"CONFIG", "REWRITE"}, errNoConfigFile.Error()},
+		{[]interface{}{"CONFIG", "RESETSTAT"}, "OK"},
+		{[]interface{}{"CONFIG", "STOP"}, errConfigSubcmd.Error()},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Value) != test.want {
+			t.Errorf("%v: got %s, want %s", test.args, resp.Value, test.want)
+		}
+	}
+
+	// the refused CONFIG SET changed nothing
+	if maxMemory, policy := server.memory.limits(); maxMemory != 1000 || policy != policyAllKeysLRU {
+		t.Errorf("memory: got %d %s, want 1000 %s", maxMemory, policy, policyAllKeysLRU)
+	}
+	if n := server.slowLog.len(); n != 1 {
+		t.Errorf("slowlog: got %d entries, want 1 with slowlog_max_len 1", n)
+	}
+
+	resp, err := call(wb, rb, "CONFIG", "GET", "maxmemory*", "save")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"maxmemory", "1000", "maxmemory_policy", "allkeys-lru", "maxmemory_samples", "0", "save", "60 10"}
+	if len(resp.Array) != len(want) {
+		t.Fatalf("CONFIG GET: got %d values, want %v", len(resp.Array), want)
+	}
+	for i, v := range want {
+		if string(resp.Array[i].Value) != v {
+			t.Errorf("CONFIG GET %d: got %s, want %s", i, resp.Array[i].Value, v)
+		}
+	}
+
+	// requirepass is applied to the default user
+	if resp, _ := call(wb, rb, "CONFIG", "SET", "requirepass", "secret"); string(resp.Value) != "OK" {
+		t.Fatalf("CONFIG SET requirepass: got %s", resp.Value)
+	}
+	if !server.acl.authenticate(defaultUser, "secret") || server.acl.authenticate(defaultUser, "") {
+		t.Errorf("requirepass was not applied to the default user")
+	}
+}
+
+func TestServer_Reload(t *testing.T) {
+
+	server := newTestServer()
+	c := *server.conf
+	c.Path = "simpledb.yaml"
+	c.Server.MaxMemory = 1000
+	c.Server.SlowLogMaxLen = 5
+	c.Server.Port = 7000
+
+	changed, err := server.Reload(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"maxmemory", "slowlog_max_len"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("changed: got %v, want %v", changed, want)
+	}
+	if limit, _ := server.memory.limits(); limit != 1000 {
+		t.Errorf("maxmemory: got %d, want 1000", limit)
+	}
+	// the port needs a restart
+	if server.conf.Server.Port != 0 || server.conf.Path != "simpledb.yaml" {
+		t.Errorf("config: got port %d, path %s", server.conf.Server.Port, server.conf.Path)
+	}
+
+	c.Server.MaxMemory = -1
+	if _, err := server.Reload(&c); err == nil {
+		t.Errorf("maxmemory -1: no error")
+	}
+	if limit, _ := server.memory.limits(); limit != 1000 {
+		t.Errorf("refused reload: maxmemory %d, want 1000", limit)
+	}
+}
+
+func TestServer_ConfigSetTimeout(t *testing.T) {
+
+	server := newTestServer()
+	wb, rb := pipeConn(server)
+	if resp, err := call(wb, rb, "CONFIG", "SET", "read_timeout", "1"); err != nil || string(resp.Value) != "OK" {
+		t.Fatalf("CONFIG SET: got %v, %v", resp, err)
+	}
+
+	// the connection has the new read timeout to send the rest of a command
+	start := time.Now()
+	wb.buf.WriteString("*2\r\n$3\r\nGET\r\n")
+	wb.Flush()
+	if resp, err := rb.HandleStream(); err == nil {
+		t.Fatalf("an incomplete command got %v", resp)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the connection was closed after %v, want about 1s", elapsed)
+	}
+}
@@ -2,11 +2,16 @@ package simpledb
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"os"
 	"simpledb/simpledb/config"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -35,8 +40,53 @@ Set commands:
 SortedSet commands:
 	zadd, zcard, zcount, zincrby, zrange, zrangebysocre, zrank, zrem, zremrangebyrank
 
+Keyspace commands:
+	keys, scan, hscan, sscan, zscan, type, rename, renamenx, copy, randomkey, touch, dbsize, unlink
+
+Sort commands:
+	sort, sort_ro
+
+Dump commands:
+	dump, restore, migrate
+
+Object commands:
+	object encoding|refcount|idletime|freq, memory usage|stats|doctor
+
+Info commands:
+	info
+
+Slowlog commands:
+	slowlog get|len|reset, latency latest|history|reset
+
+Client commands:
+	client list|info|id|setname|getname|kill|pause|unpause|no-evict
+
+Config commands:
+	config get|set|rewrite|resetstat
+
+Monitor commands:
+	monitor
+
+ACL commands:
+	auth, acl setuser|getuser|deluser|list|users|whoami|cat|log|load|save
+
+Pub/Sub commands:
+	subscribe, unsubscribe, psubscribe, punsubscribe, publish
+
+Transaction commands:
+	multi, exec, discard, watch, unwatch
+
+Scripting commands:
+	eval, evalsha, script load|exists|flush|kill
+
+Function commands:
+	function load|list|delete|flush|dump|restore|kill, fcall, fcall_ro
+
+Shutdown commands:
+	shutdown [nosave|save] [now] [force] [abort], quit
+
 Misc:
-	expire, info, flush_all, save_to_disk, restore_from_disk, merge_from_disk, client_quit, shutdown
+	expire, flush_all, save_to_disk, restore_from_disk, merge_from_disk
 
 */
 
@@ -44,8 +94,10 @@ var (
 	empty      = errors.New("ERR value is empty")
 	errStr     = errors.New("ERR value not a string")
 	errInteger = errors.New("ERR value not a integer or out of range")
+	errSyntax  = errors.New("ERR syntax error")
+
+	errMaxClients = errors.New("ERR max number of clients reached")
 )
-var serverConfig *config.Config
 
 const (
 	defaultDictSize      = 1024
@@ -55,14 +107,57 @@ const (
 	defaultSetSize       = 1024
 )
 
+// db is a keyspace holding every data type, shared by all connections
+type db struct {
+	id    int
+	dict  *Dict
+	hash  []*Hash
+	queue *Queue
+	set   *Set
+	zSet  *SortedSet
+}
+
+// Server is handed to every CommandProcess. The listening server and each
+// accepted connection have their own Server, sharing the keyspace and the
+// server-wide state through pointers.
 type Server struct {
-	conn    net.Conn
-	command *Command
-	dict    *Dict
-	hash    []*Hash
-	queue   *Queue
-	set     *Set
-	zSet    *SortedSet
+	*db
+	conn      net.Conn
+	command   *Command
+	pubsub    *PubSub
+	watches   *Watches
+	scripts   *Scripts
+	functions *Functions
+	memory    *Memory
+	clients   *Clients
+	stats     *Stats
+	slowLog   *SlowLog
+	latency   *Latency
+	monitors  *Monitors
+	scans     *Scans
+	acl       *ACL
+	// the running config, changed by CONFIG SET
+	conf   *config.Config
+	confMu *sync.RWMutex
+	// the timeouts of the running config, see timeouts
+	connTimeouts *connTimeouts
+	life         *lifecycle
+	// serializes the writes of the snapshot file
+	saveMu *sync.Mutex
+	// serializes command execution, which keeps transactions atomic
+	cmdMu *sync.Mutex
+
+	// per connection state
+	client   *clientInfo
+	user     string // ACL user, empty until authenticated
+	wmu      *sync.Mutex
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	multi    *transaction
+	watched  map[watchKey]struct{}
+	dirtyCAS bool // a watched key was modified
+	// the connection streams the commands, see MONITOR
+	monitoring bool
 
 	ConnectTimeout time.Duration
 	readTimeout    time.Duration
@@ -74,98 +169,428 @@ type Server struct {
 	file   string
 	host   string
 	port   int
+
+	metricsPort int
+
+	unixSocket     string
+	unixSocketPerm os.FileMode
+
+	tlsPort       int
+	tlsConfig     *tls.Config
+	tlsUserFromCN bool // see tls_auth_clients_user
 }
 
-func init() {
-	var err error
-	serverConfig, err = config.NewConfig()
+// NewServer returns a server of conf, without one the config is loaded by
+// config.Load from SIMPLEDB_CONFIG or the defaults
+func NewServer(conf ...*config.Config) *Server {
+
+	var serverConfig *config.Config
+	if len(conf) > 0 {
+		serverConfig = conf[0]
+	} else {
+		var err error
+		if serverConfig, err = config.Load(""); err != nil {
+			log.Fatal(err)
+		}
+	}
+	pubsub := newPubSub()
+	pubsub.setNotifyFlags(parseNotifyFlags(serverConfig.Server.NotifyKeyspaceEvents))
+	memory := newMemory(serverConfig.Server.MaxMemory, serverConfig.Server.MaxMemoryPolicy,
+		serverConfig.Server.MaxMemorySamples)
+	acl := newACL(serverConfig.Server.RequirePass, serverConfig.Server.ACLFile)
+	if serverConfig.Server.ACLFile != "" {
+		if _, err := acl.load(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	unixSocketPerm, err := parseSocketPerm(serverConfig.Server.UnixSocketPerm)
 	if err != nil {
 		log.Fatal(err)
 	}
-}
-
-func NewServer() *Server {
-
-	return &Server{
+	var tlsConfig *tls.Config
+	if serverConfig.Server.TLSPort > 0 {
+		tlsConfig, err = newServerTLSConfig(serverConfig.Server.TLSCertFile, serverConfig.Server.TLSKeyFile,
+			serverConfig.Server.TLSCACertFile, serverConfig.Server.TLSAuthClients)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	server := &Server{
+		db:             &db{},
+		pubsub:         pubsub,
+		watches:        newWatches(),
+		scripts:        newScripts(time.Duration(serverConfig.Server.ScriptTimeLimit) * time.Millisecond),
+		functions:      newFunctions(),
+		memory:         memory,
+		clients:        newClients(),
+		stats:          newStats(),
+		slowLog:        newSlowLog(serverConfig.Server.SlowLogSlowerThan, serverConfig.Server.SlowLogMaxLen),
+		latency:        newLatency(serverConfig.Server.LatencyMonitorThreshold),
+		monitors:       newMonitors(),
+		scans:          newScans(),
+		acl:            acl,
+		conf:           serverConfig,
+		confMu:         &sync.RWMutex{},
+		connTimeouts:   newConnTimeouts(serverConfig.Server.ReadTimeout, serverConfig.Server.WriteTimeout),
+		life:           newLifecycle(),
+		saveMu:         &sync.Mutex{},
+		cmdMu:          &sync.Mutex{},
 		host:           serverConfig.Server.Host,
 		port:           serverConfig.Server.Port,
+		metricsPort:    serverConfig.Server.MetricsPort,
+		unixSocket:     serverConfig.Server.UnixSocket,
+		unixSocketPerm: unixSocketPerm,
+		tlsPort:        serverConfig.Server.TLSPort,
+		tlsConfig:      tlsConfig,
+		tlsUserFromCN:  strings.ToUpper(serverConfig.Server.TLSAuthClientsUser) == "CN",
 		ConnectTimeout: serverConfig.Server.ConnectTimeout,
 		readTimeout:    serverConfig.Server.ReadTimeout,
 		writeTimeout:   serverConfig.Server.WriteTimeout,
 	}
+	n, err := server.loadSnapshot()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if n > 0 {
+		log.Printf("db loaded from %s: %d keys", serverConfig.Server.DBFilename, n)
+	}
+	return server
 }
 
-func (s *Server) Run() {
-	server := NewServer()
-	server.listen()
-}
-
-func (s *Server) Close() error {
-	return s.conn.Close()
+// Run serves the clients until Shutdown
+func (s *Server) Run() error {
+	return s.listen()
 }
 
 func (s *Server) listen() error {
 
-	addr := fmt.Sprintf("%s:%d", s.host, s.port)
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		return fmt.Errorf("unable to listen on %v, %v\n", addr, err.Error())
+	// port 0 listens on the unix socket only
+	var listeners []net.Listener
+	if s.port > 0 || s.unixSocket == "" {
+		addr := fmt.Sprintf("%s:%d", s.host, s.port)
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("unable to listen on %v, %v\n", addr, err.Error())
+		}
+		log.Println("listen on: ", addr)
+		listeners = append(listeners, listener)
+	}
+	if s.unixSocket != "" {
+		listener, err := s.listenUnix()
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, listener)
+	}
+	if s.metricsPort > 0 {
+		go s.serveMetrics()
+	}
+	go s.saveCron()
+	if s.tlsConfig != nil {
+		go func() {
+			if err := s.listenTLS(); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+	for _, listener := range listeners {
+		s.life.add(listener)
+	}
+	for _, listener := range listeners[1:] {
+		go s.serve(listener)
 	}
-	log.Println("listen on: ", addr)
+	return s.serve(listeners[0])
+}
 
+// serve accepts the connections of listener
+func (s *Server) serve(listener net.Listener) error {
 	for {
 		conn, err := listener.Accept()
 		if err == nil && conn != nil {
 			log.Printf("accept from: [%s][%s]", conn.RemoteAddr().Network(), conn.RemoteAddr().String())
 
-			if s.writeTimeout == 0 {
-				s.writeTimeout = defaultTimeout
-			}
-			if s.readTimeout == 0 {
-				s.writeTimeout = defaultTimeout
+			keepAlive, maxClients := s.connLimits()
+			setKeepAlive(conn, keepAlive)
+			// the slot is taken now, the client is only added once its
+			// connection is set up
+			if n := s.clients.reserve(); maxClients > 0 && n > int64(maxClients) {
+				go s.reject(conn)
+				continue
 			}
-			conn.SetWriteDeadline(time.Now().Add(s.writeTimeout * time.Second))
-			conn.SetReadDeadline(time.Now().Add(s.readTimeout * time.Second))
-			s.rb = &ReadBuffer{bufio.NewReader(conn), s.readTimeout}
-			s.wb = &WriteBuffer{bufio.NewWriter(conn), s.writeTimeout}
-			s.conn = conn
-
-			go handleProcess(s)
+			go func() {
+				defer s.clients.release()
+				handleProcess(s.newConn(conn))
+			}()
 		}
 		if err != nil {
+			if s.life.isClosing() {
+				return nil
+			}
 			log.Fatal("accept err: ", err)
 			return err
 		}
 	}
 }
 
-func handleProcess(s *Server) {
+// setKeepAlive sends the TCP keepalive probes of conn every period, 0
+// sends none
+func setKeepAlive(conn net.Conn, period time.Duration) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	tcp.SetKeepAlive(period > 0)
+	if period > 0 {
+		tcp.SetKeepAlivePeriod(period * time.Second)
+	}
+}
 
-	resp, err := s.rb.HandleStream()
-	if err != nil {
-		log.Fatal(err)
+// reject closes a connection over maxclients after telling the client why
+func (s *Server) reject(conn net.Conn) {
+	defer s.clients.release()
+	log.Printf("reject [%s]: max number of clients reached", conn.RemoteAddr().String())
+	s.stats.reject()
+	_, writeTimeout := s.timeouts()
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout * time.Second))
+	w := &WriteBuffer{bufio.NewWriter(conn), writeTimeout}
+	w.WriteError(errMaxClients)
+	w.Flush()
+	conn.Close()
+}
+
+// deadlineWriter gives each write to the connection the write timeout, a
+// client that stops reading its replies is dropped
+type deadlineWriter struct {
+	conn net.Conn
+	s    *Server
+}
+
+func (w deadlineWriter) Write(p []byte) (int, error) {
+	_, timeout := w.s.timeouts()
+	w.conn.SetWriteDeadline(time.Now().Add(timeout * time.Second))
+	return w.conn.Write(p)
+}
+
+// newConn returns the Server of an accepted connection
+func (s *Server) newConn(conn net.Conn) *Server {
+	c := *s
+	c.conn = conn
+	c.readTimeout, c.writeTimeout = s.timeouts()
+	c.rb = &ReadBuffer{bufio.NewReader(conn), c.readTimeout}
+	c.wb = &WriteBuffer{bufio.NewWriter(deadlineWriter{conn, s}), c.writeTimeout}
+	c.client = newClientInfo(conn)
+	c.setUser("")
+	if s.acl.defaultNoPass() {
+		c.setUser(defaultUser)
 	}
-	if resp.Type == TypeArray {
-		arity := len(resp.Array)
-		name := string(resp.Array[0].Value)
+	c.wmu = &sync.Mutex{}
+	c.channels = make(map[string]struct{})
+	c.patterns = make(map[string]struct{})
+	c.watched = make(map[watchKey]struct{})
+	return &c
+}
+
+// clientAddr is the address of the client, empty without a connection
+func (s *Server) clientAddr() string {
+	if s.conn == nil {
+		return ""
+	}
+	addr, _ := connAddrs(s.conn)
+	return addr
+}
 
-		log.Printf("name: %s, arity: %d", name, arity)
-		command, err := CheckCommand(name, arity)
+func handleProcess(s *Server) {
+	if conn, ok := s.conn.(*tls.Conn); ok {
+		conn.SetDeadline(time.Now().Add(s.readTimeout * time.Second))
+		err := s.handshake(conn)
+		conn.SetDeadline(time.Time{})
 		if err != nil {
-			log.Fatal(err)
+			log.Printf("tls handshake with [%s] err: %v", s.conn.RemoteAddr().String(), err)
+			s.conn.Close()
+			return
+		}
+	}
+	s.clients.add(s.client)
+	s.stats.connection()
+	defer func() {
+		s.clients.remove(s.client)
+		s.pubsub.unsubscribeAll(s)
+		s.monitors.remove(s)
+		s.watches.unwatchAll(s)
+		s.conn.Close()
+	}()
+
+	for {
+		if err := s.waitCommand(); err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				log.Printf("closing idle client [%s]", s.conn.RemoteAddr().String())
+			} else if err != io.EOF {
+				log.Printf("read from [%s] err: %v", s.conn.RemoteAddr().String(), err)
+			}
+			return
 		}
-		// append only write command to file
-		if command.SFlag == 'w' {
-			go s.appendFile()
+		resp, err := s.rb.HandleStream()
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("read from [%s] err: %v", s.conn.RemoteAddr().String(), err)
+			}
+			return
 		}
-		go command.Process(s, resp)
+		s.wmu.Lock()
+		s.process(resp)
+		s.updateClient(resp)
+		s.wmu.Unlock()
+	}
+}
+
+// waitCommand waits for the next command of the connection, which may idle
+// for the idle timeout, then gives the command the read timeout to arrive.
+// Subscribers and monitors are never idle, they wait for their messages.
+func (s *Server) waitCommand() error {
+	idle := s.idleTimeout()
+	if idle > 0 && !s.subscribed() && !s.monitoring {
+		s.conn.SetReadDeadline(time.Now().Add(idle * time.Second))
 	} else {
+		s.conn.SetReadDeadline(time.Time{})
+	}
+	if _, err := s.rb.buf.Peek(1); err != nil {
+		return err
+	}
+	readTimeout, _ := s.timeouts()
+	s.conn.SetReadDeadline(time.Now().Add(readTimeout * time.Second))
+	return nil
+}
+
+func (s *Server) process(resp *Resp) {
+
+	if resp.Type != TypeArray {
 		s.writeArgs(resp.Value)
+		return
+	}
+	if len(resp.Array) == 0 {
+		s.replyErr(emptyCommand)
+		return
+	}
+	arity := len(resp.Array)
+	name := string(resp.Array[0].Value)
+
+	log.Printf("name: %s, arity: %d", name, arity)
+	command, err := CheckCommand(name, arity)
+	if err != nil {
+		s.discardTransactionOnError()
+		s.replyErr(err)
+		return
+	}
+	// QUIT is answered in any state
+	if command.Name == "QUIT" {
+		s.call(command, resp)
+		return
+	}
+	if s.monitoring {
+		s.replyErr(errMonitoring)
+		return
+	}
+	if s.user == "" && command.Name != "AUTH" {
+		s.discardTransactionOnError()
+		s.replyErr(errNoAuth)
+		return
 	}
+	// commands of a transaction are checked when queued
+	context := "toplevel"
+	if s.multi != nil {
+		context = "multi"
+	}
+	if err := s.checkACL(command, resp, context); err != nil {
+		s.discardTransactionOnError()
+		s.replyErr(err)
+		return
+	}
+	if s.subscribed() && !isPubSubCommand(command) {
+		s.replyErr(errSubscribed)
+		return
+	}
+	if s.multi != nil && command.Name == "SHUTDOWN" {
+		s.discardTransactionOnError()
+		s.replyErr(errShutdownInMulti)
+		return
+	}
+	if s.multi != nil && !isTransactionCommand(command) {
+		s.queueCommand(command, resp)
+		return
+	}
+	// SHUTDOWN waits for the commands in flight, it cannot be one of them
+	if command.Name == "SHUTDOWN" {
+		s.call(command, resp)
+		return
+	}
+	s.waitPause(command, resp)
+	// subscribers never wait for cmdMu, publishers hold it while
+	// writing to them
+	if isPubSubCommand(command) {
+		s.call(command, resp)
+		return
+	}
+	// a script over its time limit holds cmdMu, SCRIPT KILL can stop it
+	if isScriptKill(command, resp) {
+		s.call(command, resp)
+		return
+	}
+	if s.scripts.busy() {
+		s.replyErr(errBusy)
+		return
+	}
+	s.life.enter()
+	defer s.life.exit()
+	s.cmdMu.Lock()
+	// the server shut down while the command waited
+	if s.life.isClosing() {
+		s.cmdMu.Unlock()
+		return
+	}
+	s.call(command, resp)
+	s.cmdMu.Unlock()
+}
 
+// call runs a checked command
+func (s *Server) call(command *Command, resp *Resp) {
+	write := isWriteCall(command, resp)
+	if write && !freeingCommands[command.Name] {
+		if err := s.freeMemory(); err != nil {
+			s.replyErr(err)
+			return
+		}
+	}
+	// append only write command to file
+	if write {
+		go s.appendFile()
+	}
+	s.monitors.feed(s, resp)
+	s.countCommand(command, resp)
+	start := time.Now()
+	if err := command.Process(s, resp); err != nil {
+		log.Printf("process %s err: %v", command.Name, err)
+	}
+	elapsed := time.Since(start)
+	s.stats.observe(command.Name, elapsed)
+	s.slowLog.record(s, resp, elapsed)
+	s.latency.add(eventCommand, elapsed)
+	if !noTouchCommands[command.Name] {
+		s.memory.access(resp)
+	}
 }
 
 func (s *Server) writeArgs(args ...interface{}) (err error) {
+	// a list reply is an array, even with one or no element
+	if len(args) == 1 {
+		if list, ok := args[0].([]string); ok {
+			if _, err = s.wb.WriteArray(len(list)); err != nil {
+				return
+			}
+		}
+	}
 	_, err = s.wb.WriteArgs(args...)
 	if err != nil {
 		return
@@ -234,6 +659,15 @@ func (s *Server) replyErr(errs error) (err error) {
 	return
 }
 
+func (s *Server) writeResp(r *Resp) (err error) {
+	_, err = s.wb.WriteResp(r)
+	if err != nil {
+		return
+	}
+	err = s.flush()
+	return
+}
+
 func (s *Server) flush() (err error) {
 	return s.wb.Flush()
 }
@@ -1,8 +1,241 @@
 package simpledb
 
-import "testing"
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"simpledb/simpledb/config"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
 
 func TestNewServer(t *testing.T) {
-	server := NewServer()
-	server.Run()
+
+	conf := config.Default()
+	conf.Server.Port = 0
+	conf.Server.DBFilename = filepath.Join(t.TempDir(), "dump.sdb")
+	server := NewServer(conf)
+	client, ran := runUnix(t, server)
+	if resp, err := client.Set("k", "v"); err != nil || string(resp.Value) != "OK" {
+		t.Fatalf("SET: got %v, %v", resp, err)
+	}
+	if err := server.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	stopped(t, server, ran)
+}
+
+// newTestServer returns a server that is not listening, connections are
+// attached with pipeConn
+func newTestServer() *Server {
+	return &Server{
+		db:           &db{},
+		pubsub:       newPubSub(),
+		watches:      newWatches(),
+		scripts:      newScripts(0),
+		functions:    newFunctions(),
+		memory:       newMemory(0, "", 0),
+		clients:      newClients(),
+		stats:        newStats(),
+		slowLog:      newSlowLog(-1, 0),
+		latency:      newLatency(0),
+		monitors:     newMonitors(),
+		scans:        newScans(),
+		acl:          newACL("", ""),
+		conf:         newTestConfig(),
+		confMu:       &sync.RWMutex{},
+		connTimeouts: newConnTimeouts(defaultTimeout, defaultTimeout),
+		life:         newLifecycle(),
+		saveMu:       &sync.Mutex{},
+		user:         defaultUser,
+		cmdMu:        &sync.Mutex{},
+		readTimeout:  defaultTimeout,
+		writeTimeout: defaultTimeout,
+	}
+}
+
+func newTestConfig() *config.Config {
+	c := &config.Config{}
+	c.Server.ReadTimeout = defaultTimeout
+	c.Server.WriteTimeout = defaultTimeout
+	c.Server.ConnectTimeout = defaultTimeout
+	return c
+}
+
+// pipeConn serves one loopback connection of s and returns the client side
+func pipeConn(s *Server) (*WriteBuffer, *ReadBuffer) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer listener.Close()
+	local, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	remote, err := listener.Accept()
+	if err != nil {
+		panic(err)
+	}
+	go handleProcess(s.newConn(remote))
+	return &WriteBuffer{bufio.NewWriter(local), defaultTimeout},
+		&ReadBuffer{bufio.NewReader(local), defaultTimeout}
+}
+
+// serveTCP serves s on a loopback listener until the test ends, it returns
+// the port
+func serveTCP(t *testing.T, s *Server) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleProcess(s.newConn(conn))
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// call sends one command on a pipeConn connection and reads its reply
+func call(wb *WriteBuffer, rb *ReadBuffer, args ...interface{}) (*Resp, error) {
+	if _, err := wb.WriteArgs(args...); err != nil {
+		return nil, err
+	}
+	if err := wb.Flush(); err != nil {
+		return nil, err
+	}
+	return rb.HandleStream()
+}
+
+func TestServer_IdleTimeout(t *testing.T) {
+
+	server := newTestServer()
+	server.connTimeouts.set(1, defaultTimeout)
+	server.conf.Server.Timeout = 2
+	client, ran := runUnix(t, server)
+	defer func() {
+		server.Close()
+		stopped(t, server, ran)
+	}()
+
+	// an active connection outlives the read timeout
+	for i := 0; i < 3; i++ {
+		if resp, err := client.Set("k", "v"); err != nil || string(resp.Value) != "OK" {
+			t.Fatalf("SET after %ds: got %v, %v", i, resp, err)
+		}
+		time.Sleep(600 * time.Millisecond)
+	}
+
+	conn, err := net.Dial("unix", server.unixSocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	wb, rb := &WriteBuffer{bufio.NewWriter(conn), defaultTimeout}, &ReadBuffer{bufio.NewReader(conn), defaultTimeout}
+	if resp, err := call(wb, rb, "SUBSCRIBE", "news"); err != nil || len(resp.Array) != 3 {
+		t.Fatalf("SUBSCRIBE: got %v, %v", resp, err)
+	}
+
+	// the idle client is closed, the subscriber stays
+	for i := 0; server.clients.count() != 1; i++ {
+		if i == 400 {
+			t.Fatalf("%d connections after the idle timeout, want 1", server.clients.count())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if resp, err := call(wb, rb, "UNSUBSCRIBE", "news"); err != nil || len(resp.Array) != 3 {
+		t.Errorf("UNSUBSCRIBE after the idle timeout: got %v, %v", resp, err)
+	}
+}
+
+func TestServer_MaxClients(t *testing.T) {
+
+	server := newTestServer()
+	server.conf.Server.MaxClients = 1
+	client, ran := runUnix(t, server)
+	defer func() {
+		server.Close()
+		stopped(t, server, ran)
+	}()
+	if resp, err := client.Set("k", "v"); err != nil || string(resp.Value) != "OK" {
+		t.Fatalf("SET: got %v, %v", resp, err)
+	}
+
+	conn, err := net.Dial("unix", server.unixSocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	rb := &ReadBuffer{bufio.NewReader(conn), defaultTimeout}
+	conn.SetReadDeadline(time.Now().Add(defaultTimeout * time.Second))
+	if resp, err := rb.HandleStream(); err != nil || string(resp.Value) != errMaxClients.Error() {
+		t.Errorf("over maxclients: got %v, %v", resp, err)
+	}
+	if _, err := rb.HandleStream(); err == nil {
+		t.Errorf("the rejected connection is open")
+	}
+
+	resp, err := client.Info("stats")
+	if err != nil || !strings.Contains(string(resp.Value), "rejected_connections:1\r\n") {
+		t.Errorf("INFO stats: got %v, %v", resp, err)
+	}
+}
+
+func TestServer_MaxClientsAccept(t *testing.T) {
+
+	server := newTestServer()
+	server.conf.Server.MaxClients = 2
+	_, ran := runUnix(t, server)
+	defer func() {
+		server.Close()
+		stopped(t, server, ran)
+	}()
+
+	// the connections are dialed before any of them is set up, the slots
+	// are taken at accept
+	var conns []net.Conn
+	for i := 0; i < 5; i++ {
+		conn, err := net.Dial("unix", server.unixSocket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	rejected := 0
+	for _, conn := range conns {
+		rb := &ReadBuffer{bufio.NewReader(conn), defaultTimeout}
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		if resp, err := rb.HandleStream(); err == nil && string(resp.Value) == errMaxClients.Error() {
+			rejected++
+		}
+	}
+	if rejected != 3 {
+		t.Errorf("got %d connections rejected, want 3", rejected)
+	}
+
+	// the slots of the rejected and the closed connections are free again
+	for _, conn := range conns {
+		conn.Close()
+	}
+	for i := 0; ; i++ {
+		server.clients.mu.RLock()
+		slots := server.clients.slots
+		server.clients.mu.RUnlock()
+		if slots == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("%d slots taken after the connections closed", slots)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
 }
@@ -0,0 +1,368 @@
+package simpledb
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dump commands:
+// dump, restore, migrate
+//
+// a DUMP payload is the type of the value, the value, the payload version
+// and a crc64 checksum, see sealPayload. Strings are a uvarint length and
+// the bytes, collections a uvarint count and their elements, sorted set
+// scores are float64 bits.
+
+const (
+	dumpString byte = iota
+	dumpList
+	dumpSet
+	dumpZSet
+	dumpHash
+)
+
+var (
+	errBusyKey   = errors.New("BUSYKEY Target key name already exists.")
+	errTTL       = errors.New("ERR Invalid TTL value, must be >= 0")
+	errNoExpire  = errors.New("ERR keys with a time to live are not supported")
+	errMigrateDB = errors.New("ERR destination db must be 0, the target has a single keyspace")
+)
+
+type dumpWriter struct {
+	buf []byte
+}
+
+func (w *dumpWriter) uvarint(n int) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf = append(w.buf, b[:binary.PutUvarint(b[:], uint64(n))]...)
+}
+
+func (w *dumpWriter) string(s string) {
+	w.uvarint(len(s))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *dumpWriter) float(f float64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+	w.buf = append(w.buf, b[:]...)
+}
+
+type dumpReader struct {
+	buf []byte
+	err error
+}
+
+func (r *dumpReader) uvarint() int {
+	n, size := binary.Uvarint(r.buf)
+	if size <= 0 || n > uint64(len(r.buf)) {
+		r.err = errPayload
+		r.buf = nil
+		return 0
+	}
+	r.buf = r.buf[size:]
+	return int(n)
+}
+
+func (r *dumpReader) string() string {
+	n := r.uvarint()
+	if n > len(r.buf) {
+		r.err = errPayload
+		r.buf = nil
+		return ""
+	}
+	s := string(r.buf[:n])
+	r.buf = r.buf[n:]
+	return s
+}
+
+func (r *dumpReader) float() float64 {
+	if len(r.buf) < 8 {
+		r.err = errPayload
+		r.buf = nil
+		return 0
+	}
+	f := math.Float64frombits(binary.LittleEndian.Uint64(r.buf))
+	r.buf = r.buf[8:]
+	return f
+}
+
+// dumpValue serializes a value returned by lookupKey
+func dumpValue(typ string, value interface{}) []byte {
+	w := &dumpWriter{}
+	switch typ {
+	case typeString:
+		w.buf = append(w.buf, dumpString)
+		w.string(fmt.Sprint(value))
+	case typeList:
+		l := value.(*list.List)
+		w.buf = append(w.buf, dumpList)
+		w.uvarint(l.Len())
+		for e := l.Front(); e != nil; e = e.Next() {
+			w.string(fmt.Sprint(e.Value))
+		}
+	case typeSet:
+		m := value.(*sMember)
+		w.buf = append(w.buf, dumpSet)
+		w.uvarint(len(m.val))
+		for member := range m.val {
+			w.string(member)
+		}
+	case typeZSet:
+		members := value.(memberSlice)
+		w.buf = append(w.buf, dumpZSet)
+		w.uvarint(len(members))
+		for _, m := range members {
+			w.string(m.member)
+			w.float(m.score)
+		}
+	case typeHash:
+		fields := value.(map[string]string)
+		w.buf = append(w.buf, dumpHash)
+		w.uvarint(len(fields))
+		for field, v := range fields {
+			w.string(field)
+			w.string(v)
+		}
+	}
+	return sealPayload(w.buf)
+}
+
+// restoreValue parses a DUMP payload into a value for storeKey
+func restoreValue(payload []byte) (string, interface{}, error) {
+	body, err := openPayload(payload)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(body) == 0 {
+		return "", nil, errPayload
+	}
+	r := &dumpReader{buf: body[1:]}
+
+	var (
+		typ   string
+		value interface{}
+	)
+	switch body[0] {
+	case dumpString:
+		typ, value = typeString, r.string()
+	case dumpList:
+		l := list.New()
+		for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+			l.PushBack(r.string())
+		}
+		typ, value = typeList, l
+	case dumpSet:
+		m := &sMember{val: make(map[string]interface{})}
+		for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+			m.val[r.string()] = nil
+		}
+		typ, value = typeSet, m
+	case dumpZSet:
+		var members memberSlice
+		for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+			member := r.string()
+			members = append(members, zMember{member: member, score: r.float()})
+		}
+		members.Sort()
+		typ, value = typeZSet, members
+	case dumpHash:
+		fields := make(map[string]string)
+		for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+			field := r.string()
+			fields[field] = r.string()
+		}
+		typ, value = typeHash, fields
+	default:
+		return "", nil, errPayload
+	}
+	if r.err != nil || len(r.buf) != 0 {
+		return "", nil, errPayload
+	}
+	return typ, value, nil
+}
+
+func dump(s *Server, resp *Resp) error {
+	value, typ := s.lookupKey(string(resp.Array[1].Value))
+	if typ == typeNone {
+		return s.replyNil()
+	}
+	return s.writeResp(NewBulkBytes(dumpValue(typ, value)))
+}
+
+// restore takes a ttl of 0: keys do not expire in simpledb, a ttl is
+// refused rather than ignored
+func restore(s *Server, resp *Resp) error {
+	key := string(resp.Array[1].Value)
+	ttl, err := strconv.ParseInt(string(resp.Array[2].Value), 10, 64)
+	if err != nil {
+		return s.replyErr(errInteger)
+	}
+	if ttl < 0 {
+		return s.replyErr(errTTL)
+	}
+	replace, absTTL := false, false
+	for _, arg := range resp.Array[4:] {
+		switch strings.ToUpper(string(arg.Value)) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTTL = true
+		default:
+			return s.replyErr(errSyntax)
+		}
+	}
+	if ttl > 0 {
+		// an absolute ttl in the past means the key is already expired
+		if absTTL && ttl <= time.Now().UnixNano()/int64(time.Millisecond) {
+			return s.replyOk()
+		}
+		return s.replyErr(errNoExpire)
+	}
+
+	typ, value, err := restoreValue(resp.Array[3].Value)
+	if err != nil {
+		return s.replyErr(err)
+	}
+	if _, old := s.lookupKey(key); old != typeNone && !replace {
+		return s.replyErr(errBusyKey)
+	}
+	s.storeKey(key, typ, value)
+	s.notify(notifyGeneric, "restore", key)
+	return s.replyOk()
+}
+
+// migrate restores keys on another server inside a transaction, then
+// removes them here unless COPY. cmdMu is held for the whole transfer, so
+// the keys do not change meanwhile.
+func migrate(s *Server, resp *Resp) error {
+	host := string(resp.Array[1].Value)
+	port, err := strconv.Atoi(string(resp.Array[2].Value))
+	if err != nil {
+		return s.replyErr(errInteger)
+	}
+	keys := []string{string(resp.Array[3].Value)}
+	dbIndex, err := strconv.Atoi(string(resp.Array[4].Value))
+	if err != nil {
+		return s.replyErr(errInteger)
+	}
+	timeout, err := strconv.Atoi(string(resp.Array[5].Value))
+	if err != nil || timeout < 0 {
+		return s.replyErr(errInteger)
+	}
+	copyKeys, replace := false, false
+	var username, password string
+	args := resp.Array[6:]
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i].Value)) {
+		case "COPY":
+			copyKeys = true
+		case "REPLACE":
+			replace = true
+		case "AUTH":
+			if i+1 >= len(args) {
+				return s.replyErr(errSyntax)
+			}
+			password = string(args[i+1].Value)
+			i++
+		case "AUTH2":
+			if i+2 >= len(args) {
+				return s.replyErr(errSyntax)
+			}
+			username, password = string(args[i+1].Value), string(args[i+2].Value)
+			i += 2
+		case "KEYS":
+			if keys[0] != "" {
+				return s.replyErr(errors.New("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string"))
+			}
+			keys = nil
+			for _, key := range args[i+1:] {
+				keys = append(keys, string(key.Value))
+			}
+			i = len(args)
+		default:
+			return s.replyErr(errSyntax)
+		}
+	}
+	if dbIndex != 0 {
+		return s.replyErr(errMigrateDB)
+	}
+
+	type payload struct {
+		key  string
+		data []byte
+	}
+	var payloads []payload
+	for _, key := range keys {
+		if value, typ := s.lookupKey(key); typ != typeNone {
+			payloads = append(payloads, payload{key, dumpValue(typ, value)})
+		}
+	}
+	if len(payloads) == 0 {
+		return s.writeResp(NewString([]byte("NOKEY")))
+	}
+
+	// the client counts whole seconds
+	seconds := time.Duration((timeout + 999) / 1000)
+	if seconds == 0 {
+		seconds = defaultTimeout
+	}
+	target := &Client{
+		Host:           host,
+		Port:           port,
+		Username:       username,
+		Password:       password,
+		ConnectTimeout: seconds,
+		readTimeout:    seconds,
+		writeTimeout:   seconds,
+	}
+	defer target.Close()
+
+	transfer := func() error {
+		if _, err := target.Multi(); err != nil {
+			return err
+		}
+		for _, p := range payloads {
+			args := []string{p.key, "0", string(p.data)}
+			if replace {
+				args = append(args, "REPLACE")
+			}
+			if _, err := target.execute("RESTORE", args); err != nil {
+				return err
+			}
+		}
+		reply, err := target.Exec()
+		if err != nil {
+			return err
+		}
+		if reply.IsError() {
+			return fmt.Errorf("ERR Target instance replied with error: %s", reply.Value)
+		}
+		for _, r := range reply.Array {
+			if r.IsError() {
+				return fmt.Errorf("ERR Target instance replied with error: %s", r.Value)
+			}
+		}
+		return nil
+	}
+	if err := transfer(); err != nil {
+		if !strings.HasPrefix(err.Error(), "ERR ") {
+			err = fmt.Errorf("IOERR error or timeout reading to target instance: %s", err.Error())
+		}
+		return s.replyErr(err)
+	}
+
+	if !copyKeys {
+		for _, p := range payloads {
+			s.removeKey(p.key)
+			s.notify(notifyGeneric, "del", p.key)
+		}
+	}
+	return s.replyOk()
+}
@@ -0,0 +1,126 @@
+package simpledb
+
+import (
+	"container/list"
+	"strconv"
+	"testing"
+)
+
+func TestDumpValue(t *testing.T) {
+
+	l := list.New()
+	l.PushBack("a")
+	l.PushBack("b")
+
+	var tests = []struct {
+		typ   string
+		value interface{}
+	}{
+		{typeString, "simpledb"},
+		{typeList, l},
+		{typeSet, &sMember{val: map[string]interface{}{"x": nil, "y": nil}}},
+		{typeZSet, memberSlice{{"a", 1}, {"b", 2.5}}},
+		{typeHash, map[string]string{"f": "v", "g": ""}},
+	}
+	for _, test := range tests {
+		payload := dumpValue(test.typ, test.value)
+		typ, value, err := restoreValue(payload)
+		if err != nil {
+			t.Fatalf("%s: %v", test.typ, err)
+		}
+		if typ != test.typ || valueLen(typ, value) != valueLen(test.typ, test.value) {
+			t.Errorf("%s: got %s of %d elements", test.typ, typ, valueLen(typ, value))
+		}
+		if string(dumpValue(typ, value)) != string(payload) && typ != typeSet && typ != typeHash {
+			t.Errorf("%s: the payload changed after restore", test.typ)
+		}
+
+		payload[0] = 9
+		if _, _, err := restoreValue(payload); err != errPayload {
+			t.Errorf("%s: corrupted payload restored, err %v", test.typ, err)
+		}
+	}
+}
+
+func TestServer_DumpRestore(t *testing.T) {
+
+	server := newTestServer()
+	server.zSet = newSortedSet()
+	server.zSet.zAdd("rank", 1, "a")
+	server.zSet.zAdd("rank", 2, "b")
+	wb, rb := pipeConn(server)
+
+	resp, err := call(wb, rb, "DUMP", "rank")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := string(resp.Value)
+
+	var tests = []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"DUMP", "missing"}, "nil"},
+		{[]interface{}{"RESTORE", "rank", "0", payload}, errBusyKey.Error()},
+		{[]interface{}{"RESTORE", "copy", "0", payload}, "OK"},
+		{[]interface{}{"ZCARD", "copy"}, "2"},
+		{[]interface{}{"RESTORE", "copy", "0", payload, "REPLACE"}, "OK"},
+		{[]interface{}{"RESTORE", "bad", "0", payload[1:]}, errPayload.Error()},
+		{[]interface{}{"RESTORE", "ttl", "-1", payload}, errTTL.Error()},
+		{[]interface{}{"RESTORE", "ttl", "1000", payload}, errNoExpire.Error()},
+		{[]interface{}{"RESTORE", "ttl", "1", payload, "ABSTTL"}, "OK"},
+		{[]interface{}{"TYPE", "ttl"}, "none"},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Value) != test.want {
+			t.Errorf("%v %v: got %s, want %s", test.args[0], test.args[1], resp.Value, test.want)
+		}
+	}
+}
+
+func TestServer_Migrate(t *testing.T) {
+
+	source := newTestServer()
+	source.dict = newDict()
+	source.dict.add("a", "1")
+	source.dict.add("b", "2")
+	source.dict.add("c", "3")
+	target := newTestServer()
+	target.dict = newDict()
+	target.dict.add("c", "old")
+	port := serveTCP(t, target)
+	wb, rb := pipeConn(source)
+
+	p := strconv.Itoa(port)
+	var tests = []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"MIGRATE", "127.0.0.1", p, "a", "0", "1000"}, "OK"},
+		{[]interface{}{"MIGRATE", "127.0.0.1", p, "missing", "0", "1000"}, "NOKEY"},
+		{[]interface{}{"MIGRATE", "127.0.0.1", p, "b", "1", "1000"}, errMigrateDB.Error()},
+		{[]interface{}{"MIGRATE", "127.0.0.1", p, "", "0", "1000", "COPY", "KEYS", "b", "c"}, "ERR Target instance replied with error: " + errBusyKey.Error()},
+		{[]interface{}{"MIGRATE", "127.0.0.1", p, "", "0", "1000", "COPY", "REPLACE", "KEYS", "b", "c"}, "OK"},
+		{[]interface{}{"EXISTS", "a"}, "0"},
+		{[]interface{}{"EXISTS", "b"}, "1"},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Value) != test.want {
+			t.Errorf("%v: got %s, want %s", test.args, resp.Value, test.want)
+		}
+	}
+
+	for key, want := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+		if v, err := target.dict.get(key); err != nil || v != want {
+			t.Errorf("target %s: got %v, want %s", key, v, want)
+		}
+	}
+}
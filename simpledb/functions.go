@@ -0,0 +1,533 @@
+package simpledb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"simpledb/simpledb/script"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// function commands:
+// function load|list|delete|flush|dump|restore|kill, fcall, fcall_ro
+//
+// a library is script code starting with a "#!lua name=<library>" line, it
+// registers named functions with redis.register_function when loaded:
+//
+//	#!lua name=mylib
+//	redis.register_function('hello', function(keys, args) return 'hello ' .. args[1] end)
+//	redis.register_function{function_name='get', callback=function(keys, args)
+//		return redis.call('GET', keys[1])
+//	end, flags={'no-writes'}}
+//
+// FUNCTION is a write command so that loading libraries goes with the
+// other writes to the append only file. The libraries are saved with the
+// keyspace in the snapshot, see saveSnapshot.
+
+var (
+	errLibraryMetadata = errors.New("ERR Missing library metadata")
+	errLibraryName     = errors.New("ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	errFunctionName    = errors.New("ERR Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	errNoFunctions     = errors.New("ERR No functions registered")
+	errNoLibrary       = errors.New("ERR Library not found")
+	errNoFunction      = errors.New("ERR Function not found")
+	errFunctionRO      = errors.New("ERR Can not execute a script with write flag using *_ro command.")
+	errFunctionSubcmd  = errors.New("ERR Unknown FUNCTION subcommand or wrong number of arguments")
+	errRestorePolicy   = errors.New("ERR Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE.")
+	errLoadTimeout     = errors.New("ERR FUNCTION LOAD timeout")
+)
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// flags a function can register with
+var functionFlags = map[string]bool{
+	"no-writes": true, "allow-oom": true, "allow-stale": true,
+	"no-cluster": true, "allow-cross-slot-keys": true,
+}
+
+type function struct {
+	name        string
+	description string
+	flags       []string
+	callback    script.Value
+	library     *library
+}
+
+func (f *function) readOnly() bool {
+	for _, flag := range f.flags {
+		if flag == "no-writes" {
+			return true
+		}
+	}
+	return false
+}
+
+// library is a loaded library, its functions run in the interpreter that
+// loaded it so that they keep the values they close over
+type library struct {
+	name      string
+	code      string
+	in        *script.Interp
+	functions map[string]*function
+}
+
+// Functions holds the loaded libraries
+type Functions struct {
+	mu        sync.Mutex
+	libraries map[string]*library
+	functions map[string]*function
+}
+
+func newFunctions() *Functions {
+	return &Functions{
+		mu:        sync.Mutex{},
+		libraries: make(map[string]*library),
+		functions: make(map[string]*function),
+	}
+}
+
+// libraryMetadata parses the "#!lua name=<library>" line of code, it
+// returns the library name and the code after the line
+func libraryMetadata(code string) (string, string, error) {
+	if !strings.HasPrefix(code, "#!") {
+		return "", "", errLibraryMetadata
+	}
+	line, body := code, ""
+	if i := strings.IndexByte(code, '\n'); i >= 0 {
+		line, body = code[:i], code[i:]
+	}
+	fields := strings.Fields(line[2:])
+	if len(fields) == 0 {
+		return "", "", errLibraryMetadata
+	}
+	if fields[0] != "lua" {
+		return "", "", fmt.Errorf("ERR Engine '%s' not found", fields[0])
+	}
+	var name string
+	for _, field := range fields[1:] {
+		if !strings.HasPrefix(field, "name=") {
+			return "", "", fmt.Errorf("ERR Invalid metadata value given: %s", field)
+		}
+		name = strings.TrimPrefix(field, "name=")
+	}
+	if name == "" {
+		return "", "", errLibraryMetadata
+	}
+	if !validName.MatchString(name) {
+		return "", "", errLibraryName
+	}
+	return name, body, nil
+}
+
+// newLibrary runs the code of a library and collects the functions it
+// registers, the code may run for timeLimit
+func newLibrary(code string, timeLimit time.Duration) (*library, error) {
+	name, body, err := libraryMetadata(code)
+	if err != nil {
+		return nil, err
+	}
+	chunk, err := script.Compile("user_function", body)
+	if err != nil {
+		return nil, fmt.Errorf("ERR Error compiling function: %s", err.Error())
+	}
+
+	lib := &library{
+		name:      name,
+		code:      code,
+		in:        script.New(),
+		functions: make(map[string]*function),
+	}
+	redis := scriptHelpers()
+	redis.Set("register_function", script.GoFunction(func(in *script.Interp, args []script.Value) ([]script.Value, error) {
+		f, err := registerFunction(args)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := lib.functions[f.name]; ok {
+			return nil, fmt.Errorf("Function already exists in the library")
+		}
+		f.library = lib
+		lib.functions[f.name] = f
+		return nil, nil
+	}))
+	lib.in.SetGlobal("redis", redis)
+	start := time.Now()
+	lib.in.Hook = func() error {
+		if time.Since(start) > timeLimit {
+			return errLoadTimeout
+		}
+		return nil
+	}
+
+	if _, err := lib.in.Run(chunk); err != nil {
+		if err == errLoadTimeout {
+			return nil, err
+		}
+		return nil, fmt.Errorf("ERR Error registering functions: %s", err.Error())
+	}
+	lib.in.Hook = nil
+	if len(lib.functions) == 0 {
+		return nil, errNoFunctions
+	}
+	return lib, nil
+}
+
+// registerFunction reads the arguments of redis.register_function, either
+// a name and a callback or a table with named arguments
+func registerFunction(args []script.Value) (*function, error) {
+	f := &function{}
+	if len(args) == 1 {
+		t, ok := args[0].(*script.Table)
+		if !ok {
+			return nil, fmt.Errorf("calling redis.register_function with a single argument is only applicable to Lua table")
+		}
+		name, _ := t.GetString("function_name").(string)
+		f.name = name
+		f.callback = t.GetString("callback")
+		if description, ok := t.GetString("description").(string); ok {
+			f.description = description
+		}
+		if flags, ok := t.GetString("flags").(*script.Table); ok {
+			for i := 1; i <= flags.Len(); i++ {
+				flag, _ := flags.Get(float64(i)).(string)
+				if !functionFlags[flag] {
+					return nil, fmt.Errorf("unknown flag given")
+				}
+				f.flags = append(f.flags, flag)
+			}
+		}
+	} else if len(args) == 2 {
+		name, _ := args[0].(string)
+		f.name = name
+		f.callback = args[1]
+	} else {
+		return nil, fmt.Errorf("wrong number of arguments to redis.register_function")
+	}
+
+	if !validName.MatchString(f.name) {
+		return nil, fmt.Errorf("%s", strings.TrimPrefix(errFunctionName.Error(), "ERR "))
+	}
+	switch f.callback.(type) {
+	case *script.Function, script.GoFunction:
+	default:
+		return nil, fmt.Errorf("callback must be a function")
+	}
+	return f, nil
+}
+
+// install adds libraries, a library that exists is an error unless
+// replace, functions must not clash with the ones of other libraries
+func (fs *Functions) install(libs []*library, replace bool) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	replaced := make(map[string]bool)
+	for _, lib := range libs {
+		if _, ok := fs.libraries[lib.name]; ok {
+			if !replace {
+				return fmt.Errorf("ERR Library '%s' already exists", lib.name)
+			}
+			replaced[lib.name] = true
+		}
+	}
+	owner := make(map[string]string)
+	for name, f := range fs.functions {
+		if !replaced[f.library.name] {
+			owner[name] = f.library.name
+		}
+	}
+	for _, lib := range libs {
+		for name := range lib.functions {
+			if _, ok := owner[name]; ok {
+				return fmt.Errorf("ERR Function %s already exists", name)
+			}
+			owner[name] = lib.name
+		}
+	}
+
+	for _, lib := range libs {
+		if old, ok := fs.libraries[lib.name]; ok {
+			for name := range old.functions {
+				delete(fs.functions, name)
+			}
+		}
+		fs.libraries[lib.name] = lib
+		for name, f := range lib.functions {
+			fs.functions[name] = f
+		}
+	}
+	return nil
+}
+
+func (fs *Functions) delete(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	lib, ok := fs.libraries[name]
+	if !ok {
+		return errNoLibrary
+	}
+	for fn := range lib.functions {
+		delete(fs.functions, fn)
+	}
+	delete(fs.libraries, name)
+	return nil
+}
+
+func (fs *Functions) flush() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.libraries = make(map[string]*library)
+	fs.functions = make(map[string]*function)
+}
+
+func (fs *Functions) lookup(name string) (*function, bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	f, ok := fs.functions[name]
+	return f, ok
+}
+
+// sortedLibraries returns the libraries ordered by name
+func (fs *Functions) sortedLibraries() []*library {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	libs := make([]*library, 0, len(fs.libraries))
+	for _, lib := range fs.libraries {
+		libs = append(libs, lib)
+	}
+	sort.Slice(libs, func(i, j int) bool { return libs[i].name < libs[j].name })
+	return libs
+}
+
+// dump serializes the code of every library, a library is loaded again
+// from its code on restore
+func (fs *Functions) dump() []byte {
+	var body []byte
+	size := make([]byte, binary.MaxVarintLen64)
+	for _, lib := range fs.sortedLibraries() {
+		n := binary.PutUvarint(size, uint64(len(lib.code)))
+		body = append(body, size[:n]...)
+		body = append(body, lib.code...)
+	}
+	return sealPayload(body)
+}
+
+// parseDump returns the library codes of a dump payload
+func parseDump(payload []byte) ([]string, error) {
+	body, err := openPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	var codes []string
+	for len(body) > 0 {
+		n, size := binary.Uvarint(body)
+		if size <= 0 || uint64(len(body)-size) < n {
+			return nil, errPayload
+		}
+		body = body[size:]
+		codes = append(codes, string(body[:n]))
+		body = body[n:]
+	}
+	return codes, nil
+}
+
+// restoreLibraries loads the libraries of a dump payload again
+func (s *Server) restoreLibraries(payload []byte) ([]*library, error) {
+	codes, err := parseDump(payload)
+	if err != nil {
+		return nil, err
+	}
+	var libs []*library
+	for _, code := range codes {
+		lib, err := newLibrary(code, s.scripts.limit())
+		if err != nil {
+			return nil, err
+		}
+		libs = append(libs, lib)
+	}
+	return libs, nil
+}
+
+func (l *library) resp(withCode bool) *Resp {
+	names := make([]string, 0, len(l.functions))
+	for name := range l.functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var functions []*Resp
+	for _, name := range names {
+		f := l.functions[name]
+		description := NewString([]byte("nil"))
+		if f.description != "" {
+			description = NewBulkBytes([]byte(f.description))
+		}
+		var flags []*Resp
+		for _, flag := range f.flags {
+			flags = append(flags, NewBulkBytes([]byte(flag)))
+		}
+		functions = append(functions, NewArray([]*Resp{
+			NewBulkBytes([]byte("name")), NewBulkBytes([]byte(f.name)),
+			NewBulkBytes([]byte("description")), description,
+			NewBulkBytes([]byte("flags")), NewArray(flags),
+		}))
+	}
+	fields := []*Resp{
+		NewBulkBytes([]byte("library_name")), NewBulkBytes([]byte(l.name)),
+		NewBulkBytes([]byte("engine")), NewBulkBytes([]byte("LUA")),
+		NewBulkBytes([]byte("functions")), NewArray(functions),
+	}
+	if withCode {
+		fields = append(fields, NewBulkBytes([]byte("library_code")), NewBulkBytes([]byte(l.code)))
+	}
+	return NewArray(fields)
+}
+
+// functionCommand runs FUNCTION, LOAD, DELETE, FLUSH and RESTORE are its
+// writeSubcommands
+func functionCommand(s *Server, resp *Resp) error {
+	sub := strings.ToUpper(string(resp.Array[1].Value))
+	args := resp.Array[2:]
+
+	switch sub {
+	case "LOAD":
+		if len(args) == 0 || len(args) > 2 {
+			break
+		}
+		replace := false
+		if len(args) == 2 {
+			if strings.ToUpper(string(args[0].Value)) != "REPLACE" {
+				return s.replyErr(errSyntax)
+			}
+			replace = true
+		}
+		lib, err := newLibrary(string(args[len(args)-1].Value), s.scripts.limit())
+		if err != nil {
+			return s.replyErr(err)
+		}
+		if err := s.functions.install([]*library{lib}, replace); err != nil {
+			return s.replyErr(err)
+		}
+		return s.writeResp(NewBulkBytes([]byte(lib.name)))
+
+	case "LIST":
+		pattern, withCode := "*", false
+		for i := 0; i < len(args); i++ {
+			switch strings.ToUpper(string(args[i].Value)) {
+			case "WITHCODE":
+				withCode = true
+			case "LIBRARYNAME":
+				if i+1 == len(args) {
+					return s.replyErr(errSyntax)
+				}
+				i++
+				pattern = string(args[i].Value)
+			default:
+				return s.replyErr(errSyntax)
+			}
+		}
+		var libs []*Resp
+		for _, lib := range s.functions.sortedLibraries() {
+			if matchPattern(pattern, lib.name) {
+				libs = append(libs, lib.resp(withCode))
+			}
+		}
+		return s.writeResp(NewArray(libs))
+
+	case "DELETE":
+		if len(args) != 1 {
+			break
+		}
+		if err := s.functions.delete(string(args[0].Value)); err != nil {
+			return s.replyErr(err)
+		}
+		return s.replyOk()
+
+	case "FLUSH":
+		if len(args) > 1 {
+			break
+		}
+		s.functions.flush()
+		return s.replyOk()
+
+	case "DUMP":
+		if len(args) != 0 {
+			break
+		}
+		return s.writeResp(NewBulkBytes(s.functions.dump()))
+
+	case "RESTORE":
+		if len(args) == 0 || len(args) > 2 {
+			break
+		}
+		policy := "APPEND"
+		if len(args) == 2 {
+			policy = strings.ToUpper(string(args[1].Value))
+		}
+		if policy != "APPEND" && policy != "REPLACE" && policy != "FLUSH" {
+			return s.replyErr(errRestorePolicy)
+		}
+		libs, err := s.restoreLibraries(args[0].Value)
+		if err != nil {
+			return s.replyErr(err)
+		}
+		if policy == "FLUSH" {
+			s.functions.flush()
+		}
+		if err := s.functions.install(libs, policy == "REPLACE"); err != nil {
+			return s.replyErr(err)
+		}
+		return s.replyOk()
+
+	case "KILL":
+		if len(args) != 0 {
+			break
+		}
+		if err := s.scripts.kill(); err != nil {
+			return s.replyErr(err)
+		}
+		return s.replyOk()
+	}
+	return s.replyErr(errFunctionSubcmd)
+}
+
+// callFunction runs a function with the keys and args of resp, a read
+// only call refuses functions that may write
+func (s *Server) callFunction(resp *Resp, readOnly bool) error {
+	f, ok := s.functions.lookup(string(resp.Array[1].Value))
+	if !ok {
+		return s.replyErr(errNoFunction)
+	}
+	if readOnly && !f.readOnly() {
+		return s.replyErr(errFunctionRO)
+	}
+	keys, argv, err := scriptArgs(resp)
+	if err != nil {
+		return s.replyErr(err)
+	}
+
+	running := s.scripts.begin()
+	defer s.scripts.end()
+	running.readOnly = readOnly || f.readOnly()
+
+	in := f.library.in
+	in.SetGlobal("redis", s.scriptLib(running))
+	in.Hook = killHook(running)
+	values, err := in.Call(f.callback, keys, argv)
+	in.Hook = nil
+	return s.replyScript(f.name, values, err)
+}
+
+func fCall(s *Server, resp *Resp) error {
+	return s.callFunction(resp, false)
+}
+
+func fCallRO(s *Server, resp *Resp) error {
+	return s.callFunction(resp, true)
+}
@@ -0,0 +1,188 @@
+package simpledb
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testLibrary = `#!lua name=mylib
+local prefix = 'hello '
+redis.register_function('hello', function(keys, args) return prefix .. args[1] end)
+redis.register_function('setget', function(keys, args)
+	redis.call('SET', keys[1], args[1])
+	return redis.call('GET', keys[1])
+end)
+redis.register_function{function_name='readonly_set', callback=function(keys, args)
+	return redis.call('SET', keys[1], args[1])
+end, flags={'no-writes'}, description='tries to write'}
+redis.register_function{function_name='readonly_get', callback=function(keys, args)
+	return redis.call('GET', keys[1])
+end, flags={'no-writes'}}
+redis.register_function{function_name='readonly_push', callback=function(keys, args)
+	return redis.call('LPUSH', keys[1], args[1])
+end, flags={'no-writes'}}
+`
+
+func TestServer_FCall(t *testing.T) {
+
+	server := newTestServer()
+	wb, rb := pipeConn(server)
+
+	var tests = []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"FCALL", "hello", "0", "world"}, errNoFunction.Error()},
+		{[]interface{}{"FUNCTION", "LOAD", testLibrary}, "mylib"},
+		{[]interface{}{"FUNCTION", "LOAD", testLibrary}, "ERR Library 'mylib' already exists"},
+		{[]interface{}{"FUNCTION", "LOAD", "REPLACE", testLibrary}, "mylib"},
+		{[]interface{}{"FUNCTION", "LOAD", "#!lua name=other\nredis.register_function('hello', function() return 1 end)"}, "ERR Function hello already exists"},
+		{[]interface{}{"FUNCTION", "LOAD", "return 1"}, errLibraryMetadata.Error()},
+		{[]interface{}{"FUNCTION", "LOAD", "#!lua name=empty\nlocal a = 1"}, errNoFunctions.Error()},
+		{[]interface{}{"FCALL", "hello", "0", "world"}, "hello world"},
+		{[]interface{}{"FCALL", "setget", "1", "name", "simpledb"}, "simpledb"},
+		{[]interface{}{"FCALL_RO", "setget", "1", "name", "simpledb"}, errFunctionRO.Error()},
+		{[]interface{}{"FCALL_RO", "readonly_get", "1", "name"}, "simpledb"},
+		{[]interface{}{"FCALL_RO", "readonly_set", "1", "name", "other"}, errReadOnlyScript.Error()},
+		{[]interface{}{"FCALL", "readonly_set", "1", "name", "other"}, errReadOnlyScript.Error()},
+		{[]interface{}{"GET", "name"}, "simpledb"},
+		{[]interface{}{"FCALL_RO", "readonly_push", "1", "list", "a"}, errReadOnlyScript.Error()},
+		{[]interface{}{"FCALL", "readonly_push", "1", "list", "a"}, errReadOnlyScript.Error()},
+		{[]interface{}{"LLEN", "list"}, "0"},
+		{[]interface{}{"EVAL", "return redis.call('FCALL', 'hello', 0, 'x')", "0"}, errNoScriptCmd.Error()},
+		{[]interface{}{"FUNCTION", "DELETE", "mylib"}, "OK"},
+		{[]interface{}{"FUNCTION", "DELETE", "mylib"}, errNoLibrary.Error()},
+		{[]interface{}{"FCALL", "hello", "0", "world"}, errNoFunction.Error()},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Value) != test.want {
+			t.Errorf("%v %v: got %s, want %s", test.args[0], test.args[1], resp.Value, test.want)
+		}
+	}
+}
+
+func TestServer_FunctionList(t *testing.T) {
+
+	server := newTestServer()
+	wb, rb := pipeConn(server)
+
+	call(wb, rb, "FUNCTION", "LOAD", testLibrary)
+	call(wb, rb, "FUNCTION", "LOAD", "#!lua name=other\nredis.register_function('one', function() return 1 end)")
+
+	resp, err := call(wb, rb, "FUNCTION", "LIST", "LIBRARYNAME", "my*", "WITHCODE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Array) != 1 {
+		t.Fatalf("FUNCTION LIST: got %d libraries, want 1", len(resp.Array))
+	}
+	lib := resp.Array[0].Array
+	if len(lib) != 8 || string(lib[1].Value) != "mylib" || string(lib[7].Value) != testLibrary {
+		t.Errorf("FUNCTION LIST: got %v", lib)
+	}
+	functions := lib[5].Array
+	if len(functions) != 5 {
+		t.Fatalf("FUNCTION LIST: got %d functions, want 5", len(functions))
+	}
+	for _, f := range functions {
+		if string(f.Array[1].Value) != "readonly_set" {
+			continue
+		}
+		if string(f.Array[3].Value) != "tries to write" || len(f.Array[5].Array) != 1 {
+			t.Errorf("readonly_set: got description %q and %d flags", f.Array[3].Value, len(f.Array[5].Array))
+		}
+	}
+
+	resp, err = call(wb, rb, "FUNCTION", "LIST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Array) != 2 || string(resp.Array[0].Array[1].Value) != "mylib" || string(resp.Array[1].Array[1].Value) != "other" {
+		t.Errorf("FUNCTION LIST: got %v", resp)
+	}
+}
+
+func TestServer_FunctionDumpRestore(t *testing.T) {
+
+	server := newTestServer()
+	wb, rb := pipeConn(server)
+
+	call(wb, rb, "FUNCTION", "LOAD", testLibrary)
+	resp, err := call(wb, rb, "FUNCTION", "DUMP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsBulkBytes() {
+		t.Fatalf("FUNCTION DUMP: got %v", resp)
+	}
+	payload := string(resp.Value)
+	corrupted := strings.Replace(payload, "hello", "HELLO", 1)
+
+	var tests = []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"FUNCTION", "RESTORE", payload}, "ERR Library 'mylib' already exists"},
+		{[]interface{}{"FUNCTION", "RESTORE", payload, "REPLACE"}, "OK"},
+		{[]interface{}{"FUNCTION", "FLUSH"}, "OK"},
+		{[]interface{}{"FCALL", "hello", "0", "world"}, errNoFunction.Error()},
+		{[]interface{}{"FUNCTION", "RESTORE", corrupted}, errPayload.Error()},
+		{[]interface{}{"FUNCTION", "RESTORE", payload, "MERGE"}, errRestorePolicy.Error()},
+		{[]interface{}{"FUNCTION", "RESTORE", payload}, "OK"},
+		{[]interface{}{"FCALL", "hello", "0", "world"}, "hello world"},
+		{[]interface{}{"FUNCTION", "RESTORE", payload, "FLUSH"}, "OK"},
+		{[]interface{}{"FCALL", "hello", "0", "again"}, "hello again"},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Value) != test.want {
+			t.Errorf("%v %v: got %s, want %s", test.args[1], test.args[len(test.args)-1], resp.Value, test.want)
+		}
+	}
+}
+
+func TestServer_FunctionsRestart(t *testing.T) {
+
+	file := filepath.Join(t.TempDir(), "dump.sdb")
+	server := newTestServer()
+	server.conf.Server.DBFilename = file
+	wb, rb := pipeConn(server)
+	call(wb, rb, "FUNCTION", "LOAD", testLibrary)
+	call(wb, rb, "SET", "name", "simpledb")
+	if _, err := server.saveSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newTestServer()
+	restarted.conf.Server.DBFilename = file
+	if _, err := restarted.loadSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	wb, rb = pipeConn(restarted)
+
+	var tests = []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"FCALL", "hello", "0", "again"}, "hello again"},
+		{[]interface{}{"FCALL_RO", "readonly_get", "1", "name"}, "simpledb"},
+		{[]interface{}{"FUNCTION", "LOAD", testLibrary}, "ERR Library 'mylib' already exists"},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Value) != test.want {
+			t.Errorf("%v %v: got %s, want %s", test.args[0], test.args[1], resp.Value, test.want)
+		}
+	}
+}
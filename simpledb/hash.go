@@ -9,7 +9,7 @@ type Hash struct {
 }
 
 func newHash() []*Hash {
-	return make([]*Hash, defaultHashSize)
+	return make([]*Hash, 0, defaultHashSize)
 }
 
 func getFiled(hash []*Hash, key string) (map[string]string, error) {
@@ -33,6 +33,7 @@ func hDel(s *Server, resp *Resp) error {
 		}
 		delete(filed, string(f.Value))
 	}
+	s.notify(notifyHash, "hdel", key)
 	return s.reply1()
 }
 
@@ -77,9 +78,14 @@ func hSet(s *Server, resp *Resp) error {
 	field := string(resp.Array[2].Value)
 	value := string(resp.Array[3].Value)
 
-	h := make(map[string]string)
-	h[field] = value
-	s.hash = append(s.hash, &Hash{key: key, filed: h})
+	if fields, err := getFiled(s.hash, key); err == nil {
+		fields[field] = value
+	} else {
+		h := make(map[string]string)
+		h[field] = value
+		s.hash = append(s.hash, &Hash{key: key, filed: h})
+	}
+	s.notify(notifyHash, "hset", key)
 	return s.reply1()
 }
 
@@ -189,6 +195,7 @@ func hMSet(s *Server, resp *Resp) error {
 	for _, hash := range s.hash {
 		if hash.key == key {
 			store(hash.filed)
+			s.notify(notifyHash, "hset", key)
 			return s.reply1()
 		}
 	}
@@ -196,6 +203,39 @@ func hMSet(s *Server, resp *Resp) error {
 	h := &Hash{key: key, filed: make(map[string]string, defaultHashSize)}
 	store(h.filed)
 	s.hash = append(s.hash, h)
+	s.notify(notifyHash, "hset", key)
 
 	return s.replyNil()
 }
+
+func hScan(s *Server, resp *Resp) error {
+	opts, err := parseScan(resp.Array[2:], "NOVALUES")
+	if err != nil {
+		return s.replyErr(err)
+	}
+	key := string(resp.Array[1].Value)
+	fields, err := getFiled(s.hash, key)
+	if err != nil {
+		return s.replyScan(0, nil)
+	}
+	page, cursor, err := s.scans.page(typeHash+":"+key, opts.cursor, opts.count, func(fn func(string)) {
+		for field := range fields {
+			fn(field)
+		}
+	})
+	if err != nil {
+		return s.replyErr(err)
+	}
+	var result []string
+	for _, field := range page {
+		value, ok := fields[field]
+		if !ok || !matchPattern(opts.pattern, field) {
+			continue
+		}
+		result = append(result, field)
+		if !opts.noValues {
+			result = append(result, value)
+		}
+	}
+	return s.replyScan(cursor, result)
+}
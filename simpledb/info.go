@@ -0,0 +1,365 @@
+package simpledb
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// info command:
+// info [section ...]
+//
+// INFO replies "# Section" headers followed by key:value lines, the
+// sections are server, clients, memory, persistence, stats, replication
+// and keyspace. Without a section, or with "all", "default" or
+// "everything", every section is returned.
+
+// Version of the server, reported by INFO
+const Version = "0.1.0"
+
+const (
+	// instantaneous_ops_per_sec averages the samples of the last
+	// opsSamples * opsSampleInterval
+	opsSamples        = 16
+	opsSampleInterval = 100 * time.Millisecond
+)
+
+var infoSections = []string{"server", "clients", "memory", "persistence", "stats", "replication", "keyspace"}
+
+// read commands whose first argument is not a key, they do not count as
+// keyspace hits or misses
+var keylessCommands = map[string]bool{
+	"KEYS": true, "SCAN": true, "RANDOMKEY": true, "DBSIZE": true,
+	"SUBSCRIBE": true, "UNSUBSCRIBE": true, "PSUBSCRIBE": true, "PUNSUBSCRIBE": true,
+	"PUBLISH": true, "MULTI": true, "EXEC": true, "DISCARD": true, "WATCH": true,
+	"UNWATCH": true, "OBJECT": true, "MEMORY": true, "FCALL_RO": true, "INFO": true,
+}
+
+// Stats are the server counters reported by INFO
+type Stats struct {
+	mu          sync.Mutex
+	start       time.Time
+	connections int64 // connections accepted
+	processed   int64 // commands processed
+	hits        int64 // read commands finding their key
+	misses      int64
+	rejected    int64 // connections refused over maxclients
+	dirty       int64 // writes since the last save
+	lastSave    time.Time
+	lastSaveTry time.Time
+	saveFailed  bool // the last save of the snapshot failed
+
+	commandStats map[string]*commandStat
+
+	samples        [opsSamples]int64
+	sampleIndex    int
+	sampleTime     time.Time
+	sampleCommands int64
+}
+
+func newStats() *Stats {
+	now := time.Now()
+	return &Stats{
+		mu:         sync.Mutex{},
+		start:      now,
+		lastSave:   now,
+		sampleTime: now,
+
+		commandStats: make(map[string]*commandStat),
+	}
+}
+
+// reset zeroes the counters, see CONFIG RESETSTAT
+func (st *Stats) reset() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.connections, st.processed, st.hits, st.misses, st.rejected = 0, 0, 0, 0, 0
+	st.commandStats = make(map[string]*commandStat)
+	st.samples, st.sampleIndex, st.sampleCommands = [opsSamples]int64{}, 0, 0
+	st.sampleTime = time.Now()
+}
+
+func (st *Stats) connection() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.connections++
+}
+
+func (st *Stats) reject() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.rejected++
+}
+
+func (st *Stats) called(write bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.processed++
+	if write {
+		st.dirty++
+	}
+	st.sample(time.Now())
+}
+
+func (st *Stats) lookup(hit bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if hit {
+		st.hits++
+	} else {
+		st.misses++
+	}
+}
+
+// sample records the commands per second since the last sample, at most
+// once per opsSampleInterval
+func (st *Stats) sample(now time.Time) {
+	elapsed := now.Sub(st.sampleTime)
+	if elapsed < opsSampleInterval {
+		return
+	}
+	st.samples[st.sampleIndex] = (st.processed - st.sampleCommands) * int64(time.Second) / int64(elapsed)
+	st.sampleIndex = (st.sampleIndex + 1) % opsSamples
+	st.sampleTime = now
+	st.sampleCommands = st.processed
+}
+
+func (st *Stats) opsPerSec() int64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	// an idle server takes no sample on its own
+	st.sample(time.Now())
+	var sum int64
+	for _, ops := range st.samples {
+		sum += ops
+	}
+	return sum / opsSamples
+}
+
+// snapshot returns a copy of the counters
+func (st *Stats) snapshot() Stats {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return Stats{
+		start:       st.start,
+		connections: st.connections,
+		processed:   st.processed,
+		hits:        st.hits,
+		misses:      st.misses,
+		rejected:    st.rejected,
+		dirty:       st.dirty,
+		lastSave:    st.lastSave,
+		lastSaveTry: st.lastSaveTry,
+		saveFailed:  st.saveFailed,
+	}
+}
+
+// saved records a save of the snapshot taken after dirty writes, a failed
+// one keeps the changes
+func (st *Stats) saved(ok bool, dirty int64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.saveFailed = !ok
+	st.lastSaveTry = time.Now()
+	if ok {
+		st.dirty -= dirty
+		st.lastSave = st.lastSaveTry
+	}
+}
+
+// countCommand counts a command and, for a read command, whether its key
+// exists
+func (s *Server) countCommand(command *Command, resp *Resp) {
+	s.stats.called(isWriteCall(command, resp))
+	if command.SFlag != 'r' || len(resp.Array) < 2 || keylessCommands[command.Name] {
+		return
+	}
+	_, typ := s.lookupKey(string(resp.Array[1].Value))
+	s.stats.lookup(typ != typeNone)
+}
+
+// humanBytes formats n like redis, e.g. 1.50K
+func humanBytes(n int64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	f := float64(n)
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%.2f%s", f, units[i])
+}
+
+type infoField struct {
+	name  string
+	value interface{}
+}
+
+func (s *Server) infoSection(section string) []infoField {
+	st := s.stats.snapshot()
+	switch section {
+	case "server":
+		s.confMu.RLock()
+		configFile := s.conf.Path
+		s.confMu.RUnlock()
+		uptime := int64(time.Since(st.start) / time.Second)
+		return []infoField{
+			{"simpledb_version", Version},
+			{"go_version", runtime.Version()},
+			{"os", runtime.GOOS + " " + runtime.GOARCH},
+			{"process_id", os.Getpid()},
+			{"tcp_port", s.port},
+			{"uptime_in_seconds", uptime},
+			{"uptime_in_days", uptime / 86400},
+			{"config_file", configFile},
+		}
+	case "clients":
+		return []infoField{
+			{"connected_clients", s.clients.count()},
+			{"blocked_clients", 0},
+		}
+	case "memory":
+		var rt runtime.MemStats
+		runtime.ReadMemStats(&rt)
+		r := s.memoryReport()
+		return []infoField{
+			{"used_memory", r.used},
+			{"used_memory_human", humanBytes(r.used)},
+			{"used_memory_dataset", r.dataset},
+			{"used_memory_clients", r.clients},
+			{"used_memory_rss", rt.Sys},
+			{"used_memory_rss_human", humanBytes(int64(rt.Sys))},
+			{"heap_allocated", rt.HeapAlloc},
+			{"maxmemory", r.maxMemory},
+			{"maxmemory_human", humanBytes(r.maxMemory)},
+			{"maxmemory_policy", r.policy},
+		}
+	case "persistence":
+		// the snapshot is saved by SHUTDOWN and by the save rules, see
+		// saveCron
+		saveStatus := "ok"
+		if st.saveFailed {
+			saveStatus = "err"
+		}
+		return []infoField{
+			{"loading", 0},
+			{"rdb_changes_since_last_save", st.dirty},
+			{"rdb_bgsave_in_progress", 0},
+			{"rdb_last_save_time", st.lastSave.Unix()},
+			{"rdb_last_bgsave_status", saveStatus},
+			{"aof_enabled", 0},
+			{"aof_rewrite_in_progress", 0},
+			{"aof_last_write_status", "ok"},
+		}
+	case "stats":
+		channels, patterns := s.pubsub.counts()
+		return []infoField{
+			{"total_connections_received", st.connections},
+			{"total_commands_processed", st.processed},
+			{"instantaneous_ops_per_sec", s.stats.opsPerSec()},
+			{"rejected_connections", st.rejected},
+			{"expired_keys", 0},
+			{"evicted_keys", s.memory.evictedKeys()},
+			{"keyspace_hits", st.hits},
+			{"keyspace_misses", st.misses},
+			{"pubsub_channels", channels},
+			{"pubsub_patterns", patterns},
+		}
+	case "replication":
+		return []infoField{
+			{"role", "master"},
+			{"connected_slaves", 0},
+		}
+	case "keyspace":
+		var keys int64
+		s.forEachKey(func(key, typ string) {
+			keys++
+		})
+		if keys == 0 {
+			return nil
+		}
+		// keys have no time to live, none expires
+		return []infoField{
+			{fmt.Sprintf("db%d", s.db.id), fmt.Sprintf("keys=%d,expires=0,avg_ttl=0", keys)},
+		}
+	}
+	return nil
+}
+
+// Banner is the startup summary of the server: version, process, the
+// addresses it listens on, its config and the keys loaded
+func (s *Server) Banner() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "simpledb %s (%s %s/%s), pid %d\n", Version, runtime.Version(), runtime.GOOS, runtime.GOARCH, os.Getpid())
+	if s.port > 0 || s.unixSocket == "" {
+		fmt.Fprintf(&b, "port: %s:%d\n", s.host, s.port)
+	}
+	if s.unixSocket != "" {
+		fmt.Fprintf(&b, "unix socket: %s\n", s.unixSocket)
+	}
+	if s.tlsConfig != nil {
+		fmt.Fprintf(&b, "tls port: %s:%d\n", s.host, s.tlsPort)
+	}
+	if s.metricsPort > 0 {
+		fmt.Fprintf(&b, "metrics: http://%s:%d/metrics\n", s.host, s.metricsPort)
+	}
+	s.confMu.RLock()
+	configFile := s.conf.Path
+	s.confMu.RUnlock()
+	if configFile == "" {
+		configFile = "none, using the defaults"
+	}
+	fmt.Fprintf(&b, "config: %s\n", configFile)
+	fmt.Fprintf(&b, "db loaded: %d keys, %s\n", s.memory.keyCount(), humanBytes(s.memory.usedMemory()))
+	return b.String()
+}
+
+// info formats the sections
+func (s *Server) info(sections ...string) string {
+	var b strings.Builder
+	for _, section := range sections {
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + strings.ToUpper(section[:1]) + section[1:] + "\r\n")
+		for _, field := range s.infoSection(section) {
+			fmt.Fprintf(&b, "%s:%v\r\n", field.name, field.value)
+		}
+	}
+	return b.String()
+}
+
+func info(s *Server, resp *Resp) error {
+	var sections []string
+	seen := make(map[string]bool)
+	for _, arg := range resp.Array[1:] {
+		section := strings.ToLower(string(arg.Value))
+		switch section {
+		case "all", "default", "everything":
+			for _, name := range infoSections {
+				seen[name] = true
+			}
+		default:
+			seen[section] = true
+		}
+	}
+	if len(resp.Array) == 1 {
+		sections = infoSections
+	}
+	// known sections in their order, an unknown section is empty
+	for _, name := range infoSections {
+		if seen[name] {
+			sections = append(sections, name)
+		}
+	}
+	return s.writeResp(NewBulkBytes([]byte(s.info(sections...))))
+}
@@ -0,0 +1,73 @@
+package simpledb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHumanBytes(t *testing.T) {
+
+	var tests = []struct {
+		n    int64
+		want string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1536, "1.50K"},
+		{3 * 1024 * 1024, "3.00M"},
+	}
+	for _, test := range tests {
+		if got := humanBytes(test.n); got != test.want {
+			t.Errorf("humanBytes(%d): got %s, want %s", test.n, got, test.want)
+		}
+	}
+}
+
+func TestServer_Info(t *testing.T) {
+
+	server := newTestServer()
+	wb, rb := pipeConn(server)
+
+	call(wb, rb, "SET", "a", "1")
+	call(wb, rb, "GET", "a")
+	call(wb, rb, "GET", "missing")
+
+	resp, err := call(wb, rb, "INFO", []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := string(resp.Value)
+	for _, section := range []string{"# Server", "# Clients", "# Memory", "# Persistence", "# Stats", "# Replication", "# Keyspace"} {
+		if !strings.Contains(all, section+"\r\n") {
+			t.Errorf("INFO: no %s section", section)
+		}
+	}
+
+	var tests = []struct {
+		section string
+		want    []string
+		absent  []string
+	}{
+		{"stats", []string{"keyspace_hits:1\r\n", "keyspace_misses:1\r\n", "total_connections_received:1\r\n"}, []string{"# Server"}},
+		{"CLIENTS", []string{"connected_clients:1\r\n"}, nil},
+		{"keyspace", []string{"db0:keys=1,expires=0,avg_ttl=0\r\n"}, nil},
+		{"persistence", []string{"rdb_changes_since_last_save:1\r\n", "aof_enabled:0\r\n"}, nil},
+		{"nothing", nil, []string{"#"}},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, "INFO", test.section)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range test.want {
+			if !strings.Contains(string(resp.Value), want) {
+				t.Errorf("INFO %s: no %q in %q", test.section, want, resp.Value)
+			}
+		}
+		for _, absent := range test.absent {
+			if strings.Contains(string(resp.Value), absent) {
+				t.Errorf("INFO %s: %q in %q", test.section, absent, resp.Value)
+			}
+		}
+	}
+}
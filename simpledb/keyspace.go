@@ -0,0 +1,574 @@
+package simpledb
+
+import (
+	"container/list"
+	"errors"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keyspace commands:
+// keys, scan, type, rename, renamenx, copy, randomkey, touch, dbsize, unlink
+
+const (
+	typeNone   = "none"
+	typeString = "string"
+	typeList   = "list"
+	typeHash   = "hash"
+	typeSet    = "set"
+	typeZSet   = "zset"
+
+	defaultScanCount = 10
+	// the scans kept at once and the names they keep, see Scans
+	maxScans     = 1024
+	maxScanNames = 1 << 20
+	scanIdleTime = 30 * time.Second
+)
+
+var (
+	errCursor     = errors.New("ERR invalid cursor")
+	errNoSuchKey  = errors.New("ERR no such key")
+	errSameObject = errors.New("ERR source and destination objects are the same")
+	errDBIndex    = errors.New("ERR DB index is out of range")
+)
+
+// forEachKey calls fn with every key of the keyspace and its type, empty
+// collections are not keys. A name used by two types is reported once,
+// with the first type holding it.
+func (d *db) forEachKey(fn func(key, typ string)) {
+	seen := make(map[string]bool)
+	visit := func(key, typ string) {
+		if !seen[key] {
+			seen[key] = true
+			fn(key, typ)
+		}
+	}
+	if d.dict != nil {
+		d.dict.mu.RLock()
+		for key := range d.dict.data {
+			visit(key, typeString)
+		}
+		d.dict.mu.RUnlock()
+	}
+	if d.queue != nil {
+		d.queue.mu.RLock()
+		for key, l := range d.queue.data {
+			if l.Len() > 0 {
+				visit(key, typeList)
+			}
+		}
+		d.queue.mu.RUnlock()
+	}
+	for _, h := range d.hash {
+		if len(h.filed) > 0 {
+			visit(h.key, typeHash)
+		}
+	}
+	if d.set != nil {
+		d.set.mu.RLock()
+		for key, m := range d.set.data {
+			if len(m.val) > 0 {
+				visit(key, typeSet)
+			}
+		}
+		d.set.mu.RUnlock()
+	}
+	if d.zSet != nil {
+		d.zSet.mu.RLock()
+		for key, m := range d.zSet.data {
+			if len(m) > 0 {
+				visit(key, typeZSet)
+			}
+		}
+		d.zSet.mu.RUnlock()
+	}
+}
+
+// Scans holds the running scans. A scan copies the names of its collection
+// when it starts and every page takes the next count of them, so a page
+// costs count whatever the size of the collection. The cursor is the id of
+// the scan and the offset of the page, an element that stays in the
+// collection for the whole scan is returned exactly once.
+//
+// A client may abandon a scan, so a new scan first drops the scans idle
+// for scanIdleTime, then the least recently used ones past maxScans scans
+// or maxScanNames names. The cursor of a dropped scan is invalid.
+type Scans struct {
+	mu       sync.Mutex
+	next     uint32
+	scans    map[uint32]*scanState
+	names    int // kept by all the scans
+	maxNames int
+	idle     time.Duration
+}
+
+type scanState struct {
+	// the collection scanned, a cursor of another one is invalid
+	owner string
+	names []string
+	used  time.Time
+}
+
+func newScans() *Scans {
+	return &Scans{
+		mu:       sync.Mutex{},
+		scans:    make(map[uint32]*scanState),
+		maxNames: maxScanNames,
+		idle:     scanIdleTime,
+	}
+}
+
+// page returns the names from cursor, the cursor of the next page, 0 when
+// the scan is done. The cursor 0 starts a scan of the names of each. The
+// names may have left the collection since the scan started.
+func (sc *Scans) page(owner string, cursor uint64, count int, each func(fn func(name string))) ([]string, uint64, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	id, offset := uint32(cursor>>32), int(uint32(cursor))
+	if cursor == 0 {
+		var names []string
+		each(func(name string) {
+			names = append(names, name)
+		})
+		if len(names) <= count {
+			return names, 0, nil
+		}
+		id = sc.start(owner, names)
+	}
+	state, ok := sc.scans[id]
+	if !ok || state.owner != owner || offset > len(state.names) {
+		return nil, 0, errCursor
+	}
+	state.used = time.Now()
+
+	end := offset + count
+	if end >= len(state.names) {
+		sc.drop(id)
+		return state.names[offset:], 0, nil
+	}
+	return state.names[offset:end], uint64(id)<<32 | uint64(end), nil
+}
+
+// start keeps the names of a new scan. A scan with more than maxNames
+// names is kept alone.
+func (sc *Scans) start(owner string, names []string) uint32 {
+	now := time.Now()
+	for id, state := range sc.scans {
+		if now.Sub(state.used) >= sc.idle {
+			sc.drop(id)
+		}
+	}
+	for len(sc.scans) > 0 && (len(sc.scans) >= maxScans || sc.names+len(names) > sc.maxNames) {
+		var oldest uint32
+		for id, state := range sc.scans {
+			if oldest == 0 || state.used.Before(sc.scans[oldest].used) {
+				oldest = id
+			}
+		}
+		sc.drop(oldest)
+	}
+	for {
+		sc.next++
+		if _, ok := sc.scans[sc.next]; sc.next != 0 && !ok {
+			break
+		}
+	}
+	sc.scans[sc.next] = &scanState{owner: owner, names: names, used: now}
+	sc.names += len(names)
+	return sc.next
+}
+
+func (sc *Scans) drop(id uint32) {
+	sc.names -= len(sc.scans[id].names)
+	delete(sc.scans, id)
+}
+
+type scanOptions struct {
+	cursor   uint64
+	pattern  string
+	count    int
+	typ      string
+	noValues bool
+}
+
+// parseScan parses "cursor [MATCH pattern] [COUNT count]" and the options
+// allowed by the command: TYPE for SCAN, NOVALUES for HSCAN
+func parseScan(args []*Resp, allowed ...string) (*scanOptions, error) {
+	cursor, err := strconv.ParseUint(string(args[0].Value), 10, 64)
+	if err != nil {
+		return nil, errCursor
+	}
+	opts := &scanOptions{cursor: cursor, pattern: "*", count: defaultScanCount}
+	isAllowed := func(option string) bool {
+		for _, a := range allowed {
+			if a == option {
+				return true
+			}
+		}
+		return false
+	}
+	for i := 1; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i].Value))
+		switch {
+		case option == "NOVALUES" && isAllowed(option):
+			opts.noValues = true
+			continue
+		case i+1 == len(args):
+			return nil, errSyntax
+		}
+		value := string(args[i+1].Value)
+		i++
+		switch {
+		case option == "MATCH":
+			opts.pattern = value
+		case option == "COUNT":
+			opts.count, err = strconv.Atoi(value)
+			if err != nil {
+				return nil, errInteger
+			}
+			if opts.count < 1 {
+				return nil, errSyntax
+			}
+		case option == "TYPE" && isAllowed(option):
+			opts.typ = strings.ToLower(value)
+		default:
+			return nil, errSyntax
+		}
+	}
+	return opts, nil
+}
+
+// replyScan writes the next cursor and the elements of a page
+func (s *Server) replyScan(cursor uint64, elements []string) error {
+	array := make([]*Resp, len(elements))
+	for i, e := range elements {
+		array[i] = NewBulkBytes([]byte(e))
+	}
+	return s.writeResp(NewArray([]*Resp{
+		NewBulkBytes([]byte(strconv.FormatUint(cursor, 10))),
+		NewArray(array),
+	}))
+}
+
+func keys(s *Server, resp *Resp) error {
+	pattern := string(resp.Array[1].Value)
+	result := []string{}
+	s.forEachKey(func(key, typ string) {
+		if matchPattern(pattern, key) {
+			result = append(result, key)
+		}
+	})
+	sort.Strings(result)
+	return s.writeArgs(result)
+}
+
+func scan(s *Server, resp *Resp) error {
+	opts, err := parseScan(resp.Array[1:], "TYPE")
+	if err != nil {
+		return s.replyErr(err)
+	}
+	page, cursor, err := s.scans.page("", opts.cursor, opts.count, func(fn func(string)) {
+		s.forEachKey(func(key, typ string) {
+			fn(key)
+		})
+	})
+	if err != nil {
+		return s.replyErr(err)
+	}
+	var result []string
+	for _, key := range page {
+		if !matchPattern(opts.pattern, key) {
+			continue
+		}
+		_, typ := s.lookupKey(key)
+		if typ == typeNone || opts.typ != "" && typ != opts.typ {
+			continue
+		}
+		result = append(result, key)
+	}
+	return s.replyScan(cursor, result)
+}
+
+// lookupKey returns the value of key and its type: a string, the
+// *list.List of a list, the fields of a hash, the *sMember of a set or the
+// memberSlice of a sorted set
+func (d *db) lookupKey(key string) (interface{}, string) {
+	if d.dict != nil {
+		if v, err := d.dict.get(key); err == nil {
+			return v, typeString
+		}
+	}
+	if d.queue != nil {
+		d.queue.mu.RLock()
+		l, ok := d.queue.data[key]
+		d.queue.mu.RUnlock()
+		if ok && l.Len() > 0 {
+			return l, typeList
+		}
+	}
+	if fields, err := getFiled(d.hash, key); err == nil && len(fields) > 0 {
+		return fields, typeHash
+	}
+	if d.set != nil {
+		d.set.mu.RLock()
+		m, ok := d.set.data[key]
+		d.set.mu.RUnlock()
+		if ok && len(m.val) > 0 {
+			return m, typeSet
+		}
+	}
+	if d.zSet != nil {
+		d.zSet.mu.RLock()
+		m, ok := d.zSet.data[key]
+		d.zSet.mu.RUnlock()
+		if ok && len(m) > 0 {
+			return m, typeZSet
+		}
+	}
+	return nil, typeNone
+}
+
+// removeKey deletes key from every type and returns the value it had
+func (d *db) removeKey(key string) (interface{}, string) {
+	value, typ := d.lookupKey(key)
+	if d.dict != nil {
+		d.dict.delete(key)
+	}
+	if d.queue != nil {
+		d.queue.remove(key)
+	}
+	// the order of the hashes does not matter, the last one takes the slot
+	for i := len(d.hash) - 1; i >= 0; i-- {
+		if d.hash[i].key == key {
+			last := len(d.hash) - 1
+			d.hash[i] = d.hash[last]
+			d.hash[last] = nil
+			d.hash = d.hash[:last]
+		}
+	}
+	if d.set != nil {
+		d.set.mu.Lock()
+		delete(d.set.data, key)
+		d.set.mu.Unlock()
+	}
+	if d.zSet != nil {
+		d.zSet.mu.Lock()
+		delete(d.zSet.data, key)
+		d.zSet.mu.Unlock()
+	}
+	return value, typ
+}
+
+// storeKey sets key to a value returned by lookupKey, any previous value
+// of key is removed
+func (d *db) storeKey(key, typ string, value interface{}) {
+	d.removeKey(key)
+	switch typ {
+	case typeString:
+		if d.dict == nil {
+			d.dict = newDict()
+		}
+		d.dict.add(key, value)
+	case typeList:
+		if d.queue == nil {
+			d.queue = newQueue()
+		}
+		d.queue.mu.Lock()
+		d.queue.data[key] = value.(*list.List)
+		d.queue.mu.Unlock()
+	case typeHash:
+		if d.hash == nil {
+			d.hash = newHash()
+		}
+		d.hash = append(d.hash, &Hash{key: key, filed: value.(map[string]string)})
+	case typeSet:
+		if d.set == nil {
+			d.set = newSet()
+		}
+		d.set.mu.Lock()
+		d.set.data[key] = value.(*sMember)
+		d.set.mu.Unlock()
+	case typeZSet:
+		if d.zSet == nil {
+			d.zSet = newSortedSet()
+		}
+		d.zSet.mu.Lock()
+		d.zSet.data[key] = value.(memberSlice)
+		d.zSet.mu.Unlock()
+	}
+}
+
+// copyValue returns a deep copy of a value returned by lookupKey
+func copyValue(typ string, value interface{}) interface{} {
+	switch typ {
+	case typeList:
+		l := list.New()
+		for e := value.(*list.List).Front(); e != nil; e = e.Next() {
+			l.PushBack(e.Value)
+		}
+		return l
+	case typeHash:
+		fields := make(map[string]string, len(value.(map[string]string)))
+		for k, v := range value.(map[string]string) {
+			fields[k] = v
+		}
+		return fields
+	case typeSet:
+		m := &sMember{val: make(map[string]interface{}, len(value.(*sMember).val))}
+		for k, v := range value.(*sMember).val {
+			m.val[k] = v
+		}
+		return m
+	case typeZSet:
+		return append(memberSlice(nil), value.(memberSlice)...)
+	}
+	return value
+}
+
+// valueLen is the number of elements of a value
+func valueLen(typ string, value interface{}) int {
+	switch typ {
+	case typeList:
+		return value.(*list.List).Len()
+	case typeHash:
+		return len(value.(map[string]string))
+	case typeSet:
+		return len(value.(*sMember).val)
+	case typeZSet:
+		return len(value.(memberSlice))
+	}
+	return 1
+}
+
+func keyType(s *Server, resp *Resp) error {
+	_, typ := s.lookupKey(string(resp.Array[1].Value))
+	return s.writeResp(NewString([]byte(typ)))
+}
+
+// renameKey moves src to dst, unless nx and dst exists
+func (s *Server) renameKey(src, dst string, nx bool) (bool, error) {
+	value, typ := s.lookupKey(src)
+	if typ == typeNone {
+		return false, errNoSuchKey
+	}
+	if src == dst {
+		return !nx, nil
+	}
+	if _, dstType := s.lookupKey(dst); nx && dstType != typeNone {
+		return false, nil
+	}
+	s.removeKey(src)
+	s.storeKey(dst, typ, value)
+	s.notify(notifyGeneric, "rename_from", src)
+	s.notify(notifyGeneric, "rename_to", dst)
+	return true, nil
+}
+
+func rename(s *Server, resp *Resp) error {
+	if _, err := s.renameKey(string(resp.Array[1].Value), string(resp.Array[2].Value), false); err != nil {
+		return s.replyErr(err)
+	}
+	return s.replyOk()
+}
+
+func renameNX(s *Server, resp *Resp) error {
+	renamed, err := s.renameKey(string(resp.Array[1].Value), string(resp.Array[2].Value), true)
+	if err != nil {
+		return s.replyErr(err)
+	}
+	if renamed {
+		return s.reply1()
+	}
+	return s.reply0()
+}
+
+func copyKey(s *Server, resp *Resp) error {
+	src := string(resp.Array[1].Value)
+	dst := string(resp.Array[2].Value)
+	replace := false
+	args := resp.Array[3:]
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i].Value)) {
+		case "REPLACE":
+			replace = true
+		case "DB":
+			if i+1 == len(args) {
+				return s.replyErr(errSyntax)
+			}
+			i++
+			id, err := strconv.Atoi(string(args[i].Value))
+			if err != nil {
+				return s.replyErr(errInteger)
+			}
+			// there is a single keyspace
+			if id != s.db.id {
+				return s.replyErr(errDBIndex)
+			}
+		default:
+			return s.replyErr(errSyntax)
+		}
+	}
+	if src == dst {
+		return s.replyErr(errSameObject)
+	}
+
+	value, typ := s.lookupKey(src)
+	if typ == typeNone {
+		return s.reply0()
+	}
+	if _, dstType := s.lookupKey(dst); dstType != typeNone && !replace {
+		return s.reply0()
+	}
+	s.storeKey(dst, typ, copyValue(typ, value))
+	s.notify(notifyGeneric, "copy_to", dst)
+	return s.reply1()
+}
+
+func randomKey(s *Server, resp *Resp) error {
+	var all []string
+	s.forEachKey(func(key, typ string) {
+		all = append(all, key)
+	})
+	if len(all) == 0 {
+		return s.replyNil()
+	}
+	return s.writeResp(NewBulkBytes([]byte(all[rand.Intn(len(all))])))
+}
+
+func touch(s *Server, resp *Resp) error {
+	var n int64
+	for _, arg := range resp.Array[1:] {
+		if _, typ := s.lookupKey(string(arg.Value)); typ != typeNone {
+			n++
+		}
+	}
+	return s.writeResp(NewInt([]byte(strconv.FormatInt(n, 10))))
+}
+
+func dbSize(s *Server, resp *Resp) error {
+	var n int64
+	s.forEachKey(func(key, typ string) {
+		n++
+	})
+	return s.writeResp(NewInt([]byte(strconv.FormatInt(n, 10))))
+}
+
+// unlink removes keys like DEL, the removed values are left to the garbage
+// collector, which frees them in the background
+func unlink(s *Server, resp *Resp) error {
+	var n int64
+	for _, arg := range resp.Array[1:] {
+		key := string(arg.Value)
+		if _, typ := s.removeKey(key); typ == typeNone {
+			continue
+		}
+		s.notify(notifyGeneric, "del", key)
+		n++
+	}
+	return s.writeResp(NewInt([]byte(strconv.FormatInt(n, 10))))
+}
@@ -0,0 +1,394 @@
+package simpledb
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestScans_Page(t *testing.T) {
+
+	var names []string
+	for i := 0; i < 100; i++ {
+		names = append(names, "key:"+strconv.Itoa(i))
+	}
+	walks := 0
+	each := func(fn func(string)) {
+		walks++
+		for _, name := range names {
+			fn(name)
+		}
+	}
+
+	// keys added during the scan must not hide the keys present from
+	// the start, the collection is only walked when the scan starts
+	scans := newScans()
+	seen := make(map[string]int)
+	var cursor uint64
+	for pages := 0; ; pages++ {
+		var page []string
+		var err error
+		page, cursor, err = scans.page("", cursor, 7, each)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > 7 {
+			t.Errorf("page %d: got %d names, want 7", pages, len(page))
+		}
+		for _, name := range page {
+			seen[name]++
+		}
+		names = append(names, "new:"+strconv.Itoa(pages))
+		if cursor == 0 {
+			break
+		}
+	}
+	for i := 0; i < 100; i++ {
+		name := "key:" + strconv.Itoa(i)
+		if seen[name] != 1 {
+			t.Errorf("%s returned %d times, want 1", name, seen[name])
+		}
+	}
+	if walks != 1 {
+		t.Errorf("the collection was walked %d times, want 1", walks)
+	}
+	if n := len(scans.scans); n != 0 {
+		t.Errorf("%d scans kept after the end", n)
+	}
+
+	// a cursor of another collection, of a finished or a dropped scan is
+	// invalid
+	_, cursor, _ = scans.page("", 0, 7, each)
+	if _, _, err := scans.page("other", cursor, 7, each); err != errCursor {
+		t.Errorf("cursor of another collection: got %v, want %v", err, errCursor)
+	}
+	for i := 0; i < maxScans; i++ {
+		scans.page("", 0, 7, each)
+	}
+	if _, _, err := scans.page("", cursor, 7, each); err != errCursor {
+		t.Errorf("cursor of a dropped scan: got %v, want %v", err, errCursor)
+	}
+	if n := len(scans.scans); n != maxScans {
+		t.Errorf("%d scans kept, want %d", n, maxScans)
+	}
+}
+
+func TestScans_Abandoned(t *testing.T) {
+
+	var names []string
+	for i := 0; i < 100; i++ {
+		names = append(names, "key:"+strconv.Itoa(i))
+	}
+	each := func(fn func(string)) {
+		for _, name := range names {
+			fn(name)
+		}
+	}
+
+	// the abandoned scans keep at most maxNames names
+	scans := newScans()
+	scans.maxNames = 1000
+	for i := 0; i < 5000; i++ {
+		if _, cursor, err := scans.page("", 0, 10, each); err != nil || cursor == 0 {
+			t.Fatalf("scan %d: got cursor %d, %v", i, cursor, err)
+		}
+		if scans.names > scans.maxNames {
+			t.Fatalf("scan %d: %d names kept, want at most %d", i, scans.names, scans.maxNames)
+		}
+	}
+	if n := len(scans.scans); n != 10 {
+		t.Errorf("%d scans kept, want 10", n)
+	}
+
+	// a scan bigger than maxNames is kept alone
+	scans.maxNames = 50
+	_, cursor, _ := scans.page("", 0, 10, each)
+	if n := len(scans.scans); n != 1 || scans.names != 100 {
+		t.Errorf("%d scans of %d names kept, want 1 of 100", n, scans.names)
+	}
+
+	// an idle scan is dropped by the next one
+	scans.maxNames = 1000
+	scans.idle = 10 * time.Millisecond
+	time.Sleep(20 * time.Millisecond)
+	scans.page("", 0, 10, each)
+	if _, _, err := scans.page("", cursor, 10, each); err != errCursor {
+		t.Errorf("cursor of an idle scan: got %v, want %v", err, errCursor)
+	}
+	if n := len(scans.scans); n != 1 || scans.names != 100 {
+		t.Errorf("%d scans of %d names kept, want 1 of 100", n, scans.names)
+	}
+}
+
+func TestServer_Keys(t *testing.T) {
+
+	server := newTestServer()
+	server.dict = newDict()
+	server.dict.add("user:1", "a")
+	server.dict.add("user:2", "b")
+	server.dict.add("order:1", "c")
+	server.queue = newQueue()
+	server.queue.pushBack("user:list", "x")
+	wb, rb := pipeConn(server)
+
+	var tests = []struct {
+		pattern string
+		want    []string
+	}{
+		{"*", []string{"order:1", "user:1", "user:2", "user:list"}},
+		{"user:?", []string{"user:1", "user:2"}},
+		{"nothing*", []string{}},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, "KEYS", test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Array) != len(test.want) {
+			t.Errorf("KEYS %s: got %d keys, want %d", test.pattern, len(resp.Array), len(test.want))
+			continue
+		}
+		for i, key := range resp.Array {
+			if string(key.Value) != test.want[i] {
+				t.Errorf("KEYS %s: got %s, want %s", test.pattern, key.Value, test.want[i])
+			}
+		}
+	}
+}
+
+func TestServer_Scan(t *testing.T) {
+
+	server := newTestServer()
+	server.dict = newDict()
+	for i := 0; i < 50; i++ {
+		server.dict.add("str:"+strconv.Itoa(i), "v")
+	}
+	server.set = newSet()
+	server.set.add("set:0", "a", "b")
+	wb, rb := pipeConn(server)
+
+	scanAll := func(args ...interface{}) map[string]int {
+		seen := make(map[string]int)
+		cursor := "0"
+		for {
+			resp, err := call(wb, rb, append([]interface{}{"SCAN", cursor}, args...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Array) != 2 {
+				t.Fatalf("SCAN: got %v", resp)
+			}
+			for _, key := range resp.Array[1].Array {
+				seen[string(key.Value)]++
+			}
+			cursor = string(resp.Array[0].Value)
+			if cursor == "0" {
+				return seen
+			}
+		}
+	}
+
+	if seen := scanAll("COUNT", "5"); len(seen) != 51 {
+		t.Errorf("SCAN: got %d keys, want 51", len(seen))
+	}
+	if seen := scanAll("MATCH", "str:1*"); len(seen) != 11 {
+		t.Errorf("SCAN MATCH: got %d keys, want 11", len(seen))
+	}
+	if seen := scanAll("TYPE", "set"); len(seen) != 1 || seen["set:0"] != 1 {
+		t.Errorf("SCAN TYPE: got %v", seen)
+	}
+
+	// a key deleted during the scan is not returned
+	resp, err := call(wb, rb, "SCAN", "0", "COUNT", "5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor := string(resp.Array[0].Value)
+	call(wb, rb, "DEL", "str:49", "str:0")
+	var count int
+	for cursor != "0" {
+		if resp, err = call(wb, rb, "SCAN", cursor, "COUNT", "100"); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range resp.Array[1].Array {
+			if k := string(key.Value); k == "str:49" || k == "str:0" {
+				t.Errorf("SCAN: got the deleted key %s", k)
+			}
+			count++
+		}
+		cursor = string(resp.Array[0].Value)
+	}
+	if count > 51-5 || count < 51-5-2 {
+		t.Errorf("SCAN after 5 keys: got %d keys", count)
+	}
+
+	resp, err = call(wb, rb, "SCAN", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Value) != errCursor.Error() {
+		t.Errorf("SCAN x: got %s, want %s", resp.Value, errCursor.Error())
+	}
+}
+
+func TestServer_CollectionScan(t *testing.T) {
+
+	server := newTestServer()
+	server.hash = newHash()
+	server.hash = append(server.hash, &Hash{key: "h", filed: map[string]string{"f1": "v1", "f2": "v2", "g1": "v3"}})
+	server.set = newSet()
+	server.set.add("s", "m1", "m2", "m3")
+	server.zSet = newSortedSet()
+	server.zSet.zAdd("z", 1.5, "a")
+	server.zSet.zAdd("z", 2, "b")
+	wb, rb := pipeConn(server)
+
+	var tests = []struct {
+		args []interface{}
+		want int
+	}{
+		{[]interface{}{"HSCAN", "h", "0"}, 6},
+		{[]interface{}{"HSCAN", "h", "0", "MATCH", "f*"}, 4},
+		{[]interface{}{"HSCAN", "h", "0", "NOVALUES"}, 3},
+		{[]interface{}{"HSCAN", "missing", "0"}, 0},
+		{[]interface{}{"SSCAN", "s", "0"}, 3},
+		{[]interface{}{"SSCAN", "s", "0", "MATCH", "m1"}, 1},
+		{[]interface{}{"ZSCAN", "z", "0"}, 4},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Array) != 2 {
+			t.Fatalf("%v: got %v", test.args, resp)
+		}
+		if string(resp.Array[0].Value) != "0" || len(resp.Array[1].Array) != test.want {
+			t.Errorf("%v: got cursor %s and %d elements, want %d", test.args, resp.Array[0].Value, len(resp.Array[1].Array), test.want)
+		}
+	}
+
+	resp, err := call(wb, rb, "SSCAN", "s", "0", "TYPE", "set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Value) != errSyntax.Error() {
+		t.Errorf("SSCAN TYPE: got %s, want %s", resp.Value, errSyntax.Error())
+	}
+}
+
+func TestServer_KeyManagement(t *testing.T) {
+
+	server := newTestServer()
+	server.dict = newDict()
+	server.dict.add("name", "simpledb")
+	server.queue = newQueue()
+	server.queue.pushBack("queue", "a")
+	server.queue.pushBack("queue", "b")
+	server.hash = newHash()
+	server.hash = append(server.hash, &Hash{key: "user", filed: map[string]string{"id": "1"}})
+	server.set = newSet()
+	server.set.add("tags", "x", "y")
+	server.zSet = newSortedSet()
+	server.zSet.zAdd("rank", 1, "a")
+	wb, rb := pipeConn(server)
+
+	var tests = []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"DBSIZE", []string{}}, "5"},
+		{[]interface{}{"TYPE", "name"}, "string"},
+		{[]interface{}{"TYPE", "queue"}, "list"},
+		{[]interface{}{"TYPE", "user"}, "hash"},
+		{[]interface{}{"TYPE", "tags"}, "set"},
+		{[]interface{}{"TYPE", "rank"}, "zset"},
+		{[]interface{}{"TYPE", "missing"}, "none"},
+		{[]interface{}{"RENAME", "missing", "other"}, errNoSuchKey.Error()},
+		{[]interface{}{"RENAME", "queue", "jobs"}, "OK"},
+		{[]interface{}{"TYPE", "queue"}, "none"},
+		{[]interface{}{"LLEN", "jobs"}, "2"},
+		{[]interface{}{"RENAMENX", "jobs", "name"}, "0"},
+		{[]interface{}{"RENAMENX", "jobs", "queue"}, "1"},
+		{[]interface{}{"RENAME", "name", "user"}, "OK"},
+		{[]interface{}{"TYPE", "user"}, "string"},
+		{[]interface{}{"COPY", "tags", "tags"}, errSameObject.Error()},
+		{[]interface{}{"COPY", "tags", "labels"}, "1"},
+		{[]interface{}{"SREM", "labels", "x"}, ""},
+		{[]interface{}{"SCARD", "tags"}, "2"},
+		{[]interface{}{"COPY", "rank", "labels"}, "0"},
+		{[]interface{}{"COPY", "rank", "labels", "REPLACE"}, "1"},
+		{[]interface{}{"TYPE", "labels"}, "zset"},
+		{[]interface{}{"COPY", "rank", "other", "DB", "1"}, errDBIndex.Error()},
+		{[]interface{}{"TOUCH", "rank", "labels", "missing"}, "2"},
+		{[]interface{}{"UNLINK", "rank", "labels", "missing"}, "2"},
+		{[]interface{}{"DBSIZE", []string{}}, "3"},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.want != "" && string(resp.Value) != test.want {
+			t.Errorf("%v: got %s, want %s", test.args, resp.Value, test.want)
+		}
+	}
+
+	resp, err := call(wb, rb, "RANDOMKEY", []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, typ := server.lookupKey(string(resp.Value)); typ == typeNone {
+		t.Errorf("RANDOMKEY: got %s, not a key", resp.Value)
+	}
+}
+
+func TestRemoveKeyHash(t *testing.T) {
+
+	server := newTestServer()
+	server.hash = newHash()
+	for _, key := range []string{"a", "b", "c"} {
+		server.hash = append(server.hash, &Hash{key: key, filed: map[string]string{"f": key}})
+	}
+	// the deleted slot is reused, no hole is left
+	server.removeKey("a")
+	if len(server.hash) != 2 {
+		t.Fatalf("%d hashes left, want 2", len(server.hash))
+	}
+	for _, key := range []string{"b", "c"} {
+		if fields, err := getFiled(server.hash, key); err != nil || fields["f"] != key {
+			t.Errorf("%s: got %v, %v", key, fields, err)
+		}
+	}
+	server.removeKey("c")
+	server.removeKey("b")
+	if len(server.hash) != 0 {
+		t.Errorf("%d hashes left, want 0", len(server.hash))
+	}
+}
+
+func TestUnlinkLarge(t *testing.T) {
+
+	server := newTestServer()
+	server.set = newSet()
+	for i := 0; i < 1000; i++ {
+		server.set.add("big", strconv.Itoa(i))
+	}
+	value, _ := server.lookupKey("big")
+	wb, rb := pipeConn(server)
+
+	resp, err := call(wb, rb, "UNLINK", "big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Value) != "1" {
+		t.Errorf("UNLINK: got %s, want 1", resp.Value)
+	}
+	if _, typ := server.lookupKey("big"); typ != typeNone {
+		t.Errorf("UNLINK: big is still a %s", typ)
+	}
+	// the removed value is left as it was for whoever still holds it
+	if n := valueLen(typeSet, value); n != 1000 {
+		t.Errorf("UNLINK: the removed set has %d members, want 1000", n)
+	}
+}
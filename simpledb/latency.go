@@ -0,0 +1,176 @@
+package simpledb
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latency commands:
+// latency latest|history event|reset [event ...]
+//
+// an event taking at least latency_monitor_threshold milliseconds is a
+// spike, the monitor keeps one sample per second and event, the worst of
+// that second, for the last latencyHistoryLen seconds with a spike. A
+// threshold of 0 disables the monitor.
+//
+// commands are reported as eventCommand and the snapshot, which holds the
+// commands while the keyspace is serialized, as eventFork. eventAOFFsync
+// and eventExpireCycle are the events of the append only file and the
+// expiry cycle, which are not there yet.
+
+const (
+	eventCommand     = "command"
+	eventFork        = "fork"
+	eventAOFFsync    = "aof-fsync-always"
+	eventExpireCycle = "expire-cycle"
+
+	latencyHistoryLen = 160
+)
+
+var errLatencySubcmd = errors.New("ERR unknown subcommand, try LATENCY LATEST|HISTORY event|RESET [event ...]")
+
+type latencySample struct {
+	time    int64 // unix seconds
+	latency int64 // milliseconds
+}
+
+type latencyEvent struct {
+	history []latencySample // oldest first
+	max     int64
+}
+
+// Latency records the latency spikes per event
+type Latency struct {
+	mu        sync.Mutex
+	threshold time.Duration // 0 disables the monitor
+	events    map[string]*latencyEvent
+}
+
+func newLatency(thresholdMs int64) *Latency {
+	return &Latency{
+		mu:        sync.Mutex{},
+		threshold: time.Duration(thresholdMs) * time.Millisecond,
+		events:    make(map[string]*latencyEvent),
+	}
+}
+
+func (l *Latency) setThreshold(thresholdMs int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.threshold = time.Duration(thresholdMs) * time.Millisecond
+}
+
+// add records that event took d when it is a spike
+func (l *Latency) add(event string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.threshold <= 0 || d < l.threshold {
+		return
+	}
+	e, ok := l.events[event]
+	if !ok {
+		e = &latencyEvent{}
+		l.events[event] = e
+	}
+	sample := latencySample{time.Now().Unix(), int64(d / time.Millisecond)}
+	if sample.latency > e.max {
+		e.max = sample.latency
+	}
+	if n := len(e.history); n > 0 && e.history[n-1].time == sample.time {
+		if sample.latency > e.history[n-1].latency {
+			e.history[n-1].latency = sample.latency
+		}
+		return
+	}
+	e.history = append(e.history, sample)
+	if len(e.history) > latencyHistoryLen {
+		e.history = e.history[1:]
+	}
+}
+
+// latest replies event, time and latency of the last spike and the max
+// latency of every event
+func (l *Latency) latest() []*Resp {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	names := make([]string, 0, len(l.events))
+	for name := range l.events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var reply []*Resp
+	for _, name := range names {
+		e := l.events[name]
+		last := e.history[len(e.history)-1]
+		reply = append(reply, NewArray([]*Resp{
+			NewBulkBytes([]byte(name)),
+			NewInt([]byte(strconv.FormatInt(last.time, 10))),
+			NewInt([]byte(strconv.FormatInt(last.latency, 10))),
+			NewInt([]byte(strconv.FormatInt(e.max, 10))),
+		}))
+	}
+	return reply
+}
+
+func (l *Latency) history(event string) []*Resp {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var reply []*Resp
+	if e, ok := l.events[event]; ok {
+		for _, sample := range e.history {
+			reply = append(reply, NewArray([]*Resp{
+				NewInt([]byte(strconv.FormatInt(sample.time, 10))),
+				NewInt([]byte(strconv.FormatInt(sample.latency, 10))),
+			}))
+		}
+	}
+	return reply
+}
+
+// reset drops the given events, all of them without one, it returns the
+// number of events dropped
+func (l *Latency) reset(events ...string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := 0
+	if len(events) == 0 {
+		n = len(l.events)
+		l.events = make(map[string]*latencyEvent)
+		return n
+	}
+	for _, event := range events {
+		if _, ok := l.events[event]; ok {
+			delete(l.events, event)
+			n++
+		}
+	}
+	return n
+}
+
+func latencyCommand(s *Server, resp *Resp) error {
+	switch strings.ToUpper(string(resp.Array[1].Value)) {
+	case "LATEST":
+		return s.writeResp(NewArray(s.latency.latest()))
+	case "HISTORY":
+		if len(resp.Array) != 3 {
+			return s.replyErr(errLatencySubcmd)
+		}
+		return s.writeResp(NewArray(s.latency.history(string(resp.Array[2].Value))))
+	case "RESET":
+		var events []string
+		for _, arg := range resp.Array[2:] {
+			events = append(events, string(arg.Value))
+		}
+		n := s.latency.reset(events...)
+		return s.writeResp(NewInt([]byte(strconv.Itoa(n))))
+	}
+	return s.replyErr(errLatencySubcmd)
+}
@@ -184,6 +184,7 @@ func lPush(s *Server, resp *Resp) error {
 	value := string(resp.Array[2].Value)
 
 	l := s.queue.pushFront(key, value)
+	s.notify(notifyList, "lpush", key)
 	return s.writeArgs(l)
 }
 
@@ -197,6 +198,7 @@ func lPop(s *Server, resp *Resp) error {
 	if err != nil {
 		return s.replyErr(err)
 	}
+	s.notify(notifyList, "lpop", key)
 	return s.writeArgs(val)
 }
 
@@ -210,6 +212,7 @@ func rPush(s *Server, resp *Resp) error {
 	value := string(resp.Array[2].Value)
 
 	l := s.queue.pushBack(key, value)
+	s.notify(notifyList, "rpush", key)
 	return s.writeArgs(l)
 }
 
@@ -223,6 +226,7 @@ func rPop(s *Server, resp *Resp) error {
 	if err != nil {
 		return s.replyErr(err)
 	}
+	s.notify(notifyList, "rpop", key)
 	return s.writeArgs(val)
 }
 
@@ -233,6 +237,7 @@ func lRem(s *Server, resp *Resp) error {
 	}
 	key := string(resp.Array[1].Value)
 	s.queue.remove(key)
+	s.notify(notifyList, "lrem", key)
 	return s.reply1()
 
 }
@@ -269,6 +274,7 @@ func lSet(s *Server, resp *Resp) error {
 	if err != nil {
 		return s.reply0()
 	}
+	s.notify(notifyList, "lset", key)
 	return s.reply1()
 }
 
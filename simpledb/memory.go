@@ -0,0 +1,432 @@
+package simpledb
+
+import (
+	"container/list"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memory accounting and eviction
+//
+// every key modified by a command goes through notify, which records the
+// estimated size of its value here. When maxmemory is set and exceeded, a
+// write command first evicts keys chosen by the policy, or fails with OOM
+// under noeviction.
+//
+// like redis the policies are approximated: each round samples a few keys
+// into a pool of the best candidates seen so far and evicts the best one.
+
+const (
+	policyNoEviction    = "noeviction"
+	policyAllKeysLRU    = "allkeys-lru"
+	policyAllKeysLFU    = "allkeys-lfu"
+	policyAllKeysRandom = "allkeys-random"
+	policyVolatileLRU   = "volatile-lru"
+	policyVolatileLFU   = "volatile-lfu"
+	policyVolatileTTL   = "volatile-ttl"
+
+	defaultMaxMemorySamples = 5
+	evictionPoolSize        = 16
+
+	// LFU counters follow redis: a new key starts at lfuInitValue, the
+	// counter grows logarithmically and decays by one per lfuDecayTime
+	lfuInitValue = 5
+	lfuLogFactor = 10
+	lfuDecayTime = time.Minute
+
+	// rough per key and per element overheads of the go structures
+	keyOverhead     = 64
+	elementOverhead = 40
+)
+
+var errOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+
+var evictionPolicies = map[string]bool{
+	policyNoEviction: true, policyAllKeysLRU: true, policyAllKeysLFU: true,
+	policyAllKeysRandom: true, policyVolatileLRU: true, policyVolatileLFU: true,
+	policyVolatileTTL: true,
+}
+
+// commands that only free memory are allowed over maxmemory
+var freeingCommands = map[string]bool{
+	"DEL": true, "UNLINK": true, "LPOP": true, "RPOP": true, "LREM": true,
+}
+
+// introspection commands do not count as an access of their keys
+var noTouchCommands = map[string]bool{
+	"OBJECT": true, "MEMORY": true,
+}
+
+// keyMeta is what eviction knows of a key
+type keyMeta struct {
+	typ      string
+	size     int64
+	access   int64 // unix nano of the last access, for LRU
+	lfu      uint8 // logarithmic access counter, for LFU
+	lfuDecay int64 // unix nano of the last LFU decay
+	// unix nano the key expires at, 0 without a time to live. Keys have
+	// no time to live yet, so the volatile policies find nothing to evict
+	// and behave as noeviction, as in redis without volatile keys.
+	expireAt int64
+}
+
+// Memory tracks the memory used by the keyspace and evicts keys over
+// maxMemory
+type Memory struct {
+	mu        sync.Mutex
+	maxMemory int64
+	policy    string
+	samples   int
+	used      int64
+	evicted   int64
+	keys      map[string]*keyMeta
+	pool      []evictionCandidate
+}
+
+type evictionCandidate struct {
+	key   string
+	score float64 // higher is evicted first
+}
+
+func newMemory(maxMemory int64, policy string, samples int) *Memory {
+	if !evictionPolicies[policy] {
+		if policy != "" {
+			log.Printf("unknown maxmemory policy %q, using %s", policy, policyNoEviction)
+		}
+		policy = policyNoEviction
+	}
+	if samples <= 0 {
+		samples = defaultMaxMemorySamples
+	}
+	return &Memory{
+		mu:        sync.Mutex{},
+		maxMemory: maxMemory,
+		policy:    policy,
+		samples:   samples,
+		keys:      make(map[string]*keyMeta),
+	}
+}
+
+// configure changes the limit, the policy and the samples at runtime, see
+// CONFIG SET
+func (mem *Memory) configure(maxMemory int64, policy string, samples int) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	mem.maxMemory, mem.policy, mem.samples = maxMemory, policy, samples
+	// the scores of the pool were given by the old policy
+	mem.pool = nil
+}
+
+// limits returns maxmemory and the policy
+func (mem *Memory) limits() (int64, string) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	return mem.maxMemory, mem.policy
+}
+
+func (mem *Memory) resetStats() {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	mem.evicted = 0
+}
+
+// valueSize estimates the memory of a value returned by lookupKey
+func valueSize(key, typ string, value interface{}) int64 {
+	return sampledSize(key, typ, value, 0)
+}
+
+// sampledSize estimates the memory of a value from its first samples
+// elements, or from all of them when samples is 0
+func sampledSize(key, typ string, value interface{}, samples int) int64 {
+	var (
+		elements int64 // elements in the value
+		seen     int64 // elements measured
+		size     int64 // bytes of the measured elements
+	)
+	measure := func(n int) {
+		elements++
+		if samples == 0 || seen < int64(samples) {
+			seen++
+			size += elementOverhead + int64(n)
+		}
+	}
+	switch typ {
+	case typeString:
+		if v, ok := value.(string); ok {
+			return int64(keyOverhead + len(key) + len(v))
+		}
+	case typeList:
+		for e := value.(*list.List).Front(); e != nil; e = e.Next() {
+			v, _ := e.Value.(string)
+			measure(len(v))
+		}
+	case typeHash:
+		for f, v := range value.(map[string]string) {
+			measure(len(f) + len(v))
+		}
+	case typeSet:
+		for member := range value.(*sMember).val {
+			measure(len(member))
+		}
+	case typeZSet:
+		for _, m := range value.(memberSlice) {
+			measure(8 + len(m.member))
+		}
+	}
+	if seen > 0 && seen < elements {
+		size = size * elements / seen
+	}
+	return int64(keyOverhead+len(key)) + size
+}
+
+// lfuIncr increments a counter with a probability that falls as it grows
+func lfuIncr(counter uint8) uint8 {
+	if counter == math.MaxUint8 {
+		return counter
+	}
+	base := float64(counter) - lfuInitValue
+	if base < 0 {
+		base = 0
+	}
+	if rand.Float64() < 1/(base*lfuLogFactor+1) {
+		counter++
+	}
+	return counter
+}
+
+// lfuDecr lowers a counter by one per lfuDecayTime since the last decay
+func (m *keyMeta) lfuDecr(now int64) {
+	periods := (now - m.lfuDecay) / int64(lfuDecayTime)
+	if periods <= 0 {
+		return
+	}
+	if int64(m.lfu) > periods {
+		m.lfu -= uint8(periods)
+	} else {
+		m.lfu = 0
+	}
+	m.lfuDecay = now
+}
+
+func (m *keyMeta) touch(now int64) {
+	m.lfuDecr(now)
+	m.lfu = lfuIncr(m.lfu)
+	m.access = now
+}
+
+// update records the size of key after a change, or forgets a removed key
+func (mem *Memory) update(d *db, key string) {
+	value, typ := d.lookupKey(key)
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	meta, ok := mem.keys[key]
+	if typ == typeNone {
+		if ok {
+			mem.used -= meta.size
+			delete(mem.keys, key)
+		}
+		return
+	}
+	now := time.Now().UnixNano()
+	if !ok {
+		meta = &keyMeta{lfu: lfuInitValue, lfuDecay: now}
+		mem.keys[key] = meta
+	}
+	size := valueSize(key, typ, value)
+	mem.used += size - meta.size
+	meta.typ = typ
+	meta.size = size
+	meta.touch(now)
+}
+
+// access updates the clocks of the arguments of a command that are keys
+func (mem *Memory) access(resp *Resp) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	for _, arg := range resp.Array[1:] {
+		if meta, ok := mem.keys[string(arg.Value)]; ok {
+			meta.touch(now)
+		}
+	}
+}
+
+// meta returns a copy of the metadata of key, with the LFU counter
+// decayed to now
+func (mem *Memory) meta(key string) (keyMeta, bool) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	meta, ok := mem.keys[key]
+	if !ok {
+		return keyMeta{}, false
+	}
+	m := *meta
+	m.lfuDecr(time.Now().UnixNano())
+	return m, true
+}
+
+// biggest returns the n biggest keys, biggest first
+func (mem *Memory) biggest(n int) []string {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	keys := make([]string, 0, len(mem.keys))
+	for key := range mem.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := mem.keys[keys[i]], mem.keys[keys[j]]
+		if a.size != b.size {
+			return a.size > b.size
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+func (mem *Memory) keyCount() int64 {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	return int64(len(mem.keys))
+}
+
+// keyTypes returns the number of keys of each type
+func (mem *Memory) keyTypes() map[string]int64 {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	types := make(map[string]int64)
+	for _, meta := range mem.keys {
+		types[meta.typ]++
+	}
+	return types
+}
+
+func (mem *Memory) usedMemory() int64 {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	return mem.used
+}
+
+func (mem *Memory) evictedKeys() int64 {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	return mem.evicted
+}
+
+func (mem *Memory) overLimit() bool {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	return mem.maxMemory > 0 && mem.used > mem.maxMemory
+}
+
+// score is how much the policy wants to evict meta, -1 when it may not
+func (mem *Memory) score(meta *keyMeta, now int64) float64 {
+	volatile := mem.policy == policyVolatileLRU || mem.policy == policyVolatileLFU ||
+		mem.policy == policyVolatileTTL
+	if volatile && meta.expireAt == 0 {
+		return -1
+	}
+	switch mem.policy {
+	case policyAllKeysLRU, policyVolatileLRU:
+		return float64(now - meta.access)
+	case policyAllKeysLFU, policyVolatileLFU:
+		// decay without writing, the key was not accessed
+		decayed := *meta
+		decayed.lfuDecr(now)
+		return float64(math.MaxUint8 - decayed.lfu)
+	case policyVolatileTTL:
+		return float64(math.MaxInt64 - meta.expireAt)
+	case policyAllKeysRandom:
+		return rand.Float64()
+	}
+	return -1
+}
+
+// nextVictim samples keys into the pool and returns the best candidate,
+// false when the policy finds none
+func (mem *Memory) nextVictim() (string, bool) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	if mem.policy == policyNoEviction {
+		return "", false
+	}
+	now := time.Now().UnixNano()
+	// map iteration starts at a random key, which is the sampling
+	n := 0
+	for key, meta := range mem.keys {
+		if n == mem.samples {
+			break
+		}
+		n++
+		score := mem.score(meta, now)
+		if score < 0 {
+			continue
+		}
+		mem.addCandidate(evictionCandidate{key, score})
+	}
+	for len(mem.pool) > 0 {
+		best := mem.pool[0]
+		mem.pool = mem.pool[1:]
+		if _, ok := mem.keys[best.key]; ok {
+			return best.key, true
+		}
+	}
+	// the sample had no candidate, look at every key before giving up
+	var best *evictionCandidate
+	for key, meta := range mem.keys {
+		if score := mem.score(meta, now); score >= 0 && (best == nil || score > best.score) {
+			best = &evictionCandidate{key, score}
+		}
+	}
+	if best == nil {
+		return "", false
+	}
+	return best.key, true
+}
+
+// addCandidate keeps the pool sorted by score, best first
+func (mem *Memory) addCandidate(c evictionCandidate) {
+	for i, p := range mem.pool {
+		if p.key == c.key {
+			mem.pool = append(mem.pool[:i], mem.pool[i+1:]...)
+			break
+		}
+	}
+	i := sort.Search(len(mem.pool), func(i int) bool { return mem.pool[i].score < c.score })
+	mem.pool = append(mem.pool, evictionCandidate{})
+	copy(mem.pool[i+1:], mem.pool[i:])
+	mem.pool[i] = c
+	if len(mem.pool) > evictionPoolSize {
+		mem.pool = mem.pool[:evictionPoolSize]
+	}
+}
+
+// freeMemory evicts keys until the memory is under maxmemory, it returns
+// errOOM when the policy can not free enough
+func (s *Server) freeMemory() error {
+	for s.memory.overLimit() {
+		key, ok := s.memory.nextVictim()
+		if !ok {
+			return errOOM
+		}
+		s.removeKey(key)
+		s.memory.mu.Lock()
+		s.memory.evicted++
+		s.memory.mu.Unlock()
+		s.notify(notifyEvicted, "evicted", key)
+	}
+	return nil
+}
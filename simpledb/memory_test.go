@@ -0,0 +1,131 @@
+package simpledb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLFUCounter(t *testing.T) {
+
+	var counter uint8 = lfuInitValue
+	for i := 0; i < 1000; i++ {
+		counter = lfuIncr(counter)
+	}
+	if counter <= lfuInitValue || counter == 255 {
+		t.Errorf("lfuIncr: got %d after 1000 accesses", counter)
+	}
+
+	meta := &keyMeta{lfu: 10}
+	meta.lfuDecr(int64(3 * lfuDecayTime))
+	if meta.lfu != 7 {
+		t.Errorf("lfuDecr: got %d, want 7", meta.lfu)
+	}
+}
+
+// setValues writes keys of about 165 bytes each
+func setValues(t *testing.T, wb *WriteBuffer, rb *ReadBuffer, keys ...string) {
+	value := strings.Repeat("v", 100)
+	for _, key := range keys {
+		resp, err := call(wb, rb, "SET", key, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Value) != "OK" {
+			t.Fatalf("SET %s: got %s", key, resp.Value)
+		}
+	}
+}
+
+func TestServer_MaxMemoryNoEviction(t *testing.T) {
+
+	server := newTestServer()
+	server.memory = newMemory(400, policyNoEviction, 0)
+	wb, rb := pipeConn(server)
+
+	setValues(t, wb, rb, "a", "b", "c")
+	if used := server.memory.usedMemory(); used != 3*165 {
+		t.Errorf("used memory: got %d, want %d", used, 3*165)
+	}
+
+	var tests = []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"SET", "d", "v"}, errOOM.Error()},
+		{[]interface{}{"GET", "a"}, strings.Repeat("v", 100)},
+		{[]interface{}{"LPUSH", "l", "x"}, errOOM.Error()},
+		{[]interface{}{"RPUSH", "l", "x"}, errOOM.Error()},
+		{[]interface{}{"APPEND", "a", "x"}, errOOM.Error()},
+		// a read is served over maxmemory, the reply is an array
+		{[]interface{}{"MGET", "a", "b"}, ""},
+		{[]interface{}{"LLEN", "l"}, "0"},
+		{[]interface{}{"LPOP", "l"}, "nil"},
+		// FUNCTION only writes with LOAD, DELETE, FLUSH and RESTORE
+		{[]interface{}{"FUNCTION", "LIST"}, ""},
+		{[]interface{}{"FUNCTION", "FLUSH"}, errOOM.Error()},
+		{[]interface{}{"FUNCTION", "LOAD", "#!lua name=lib\nredis.register_function('f', function() return 1 end)"}, errOOM.Error()},
+		{[]interface{}{"DEL", "a"}, "OK"},
+		{[]interface{}{"SET", "d", "v"}, "OK"},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Value) != test.want {
+			t.Errorf("%v: got %s, want %s", test.args, resp.Value, test.want)
+		}
+	}
+}
+
+func TestServer_MaxMemoryEviction(t *testing.T) {
+
+	var tests = []struct {
+		policy  string
+		evicted string
+	}{
+		{policyAllKeysLRU, "b"},
+		{policyAllKeysLFU, "b"},
+	}
+	for _, test := range tests {
+		server := newTestServer()
+		server.memory = newMemory(400, test.policy, 16)
+		wb, rb := pipeConn(server)
+
+		setValues(t, wb, rb, "a", "b", "c")
+		for i := 0; i < 200; i++ {
+			call(wb, rb, "GET", "a")
+			if test.policy == policyAllKeysLFU {
+				call(wb, rb, "GET", "c")
+			}
+		}
+		setValues(t, wb, rb, "d")
+
+		for _, key := range []string{"a", "b", "c", "d"} {
+			_, typ := server.lookupKey(key)
+			if evicted := typ == typeNone; evicted != (key == test.evicted) {
+				t.Errorf("%s: %s evicted %v", test.policy, key, evicted)
+			}
+		}
+		if n := server.memory.evictedKeys(); n != 1 {
+			t.Errorf("%s: got %d evicted keys, want 1", test.policy, n)
+		}
+	}
+}
+
+func TestServer_MaxMemoryVolatile(t *testing.T) {
+
+	server := newTestServer()
+	server.memory = newMemory(400, policyVolatileLRU, 0)
+	wb, rb := pipeConn(server)
+
+	// no key has a time to live, there is nothing to evict
+	setValues(t, wb, rb, "a", "b", "c")
+	resp, err := call(wb, rb, "SET", "d", "v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Value) != errOOM.Error() {
+		t.Errorf("SET: got %s, want %s", resp.Value, errOOM.Error())
+	}
+}
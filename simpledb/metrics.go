@@ -0,0 +1,164 @@
+package simpledb
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prometheus metrics
+//
+// with metrics_port set the server serves the prometheus text format on
+// http://host:metrics_port/metrics: command calls and latencies keyed by
+// Command.Name, clients, keys per type, memory, evictions and persistence.
+
+// upper bounds in seconds of the command latency histogram
+var latencyBuckets = []float64{0.00001, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// commandStat is the calls and latency histogram of a command
+type commandStat struct {
+	calls    int64
+	duration time.Duration
+	buckets  []int64 // calls per latency bucket, not cumulative
+}
+
+// observe records a call of command that took d
+func (st *Stats) observe(command string, d time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	stat, ok := st.commandStats[command]
+	if !ok {
+		stat = &commandStat{buckets: make([]int64, len(latencyBuckets)+1)}
+		st.commandStats[command] = stat
+	}
+	stat.calls++
+	stat.duration += d
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(latencyBuckets, seconds)
+	stat.buckets[i]++
+}
+
+// commands returns a copy of the command stats
+func (st *Stats) commands() map[string]commandStat {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	stats := make(map[string]commandStat, len(st.commandStats))
+	for name, stat := range st.commandStats {
+		c := *stat
+		c.buckets = append([]int64(nil), stat.buckets...)
+		stats[name] = c
+	}
+	return stats
+}
+
+// metricsWriter writes the prometheus text format
+type metricsWriter struct {
+	w io.Writer
+}
+
+func (m *metricsWriter) header(name, typ, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (m *metricsWriter) sample(name, labels string, value interface{}) {
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(m.w, "%s%s %v\n", name, labels, value)
+}
+
+func (m *metricsWriter) metric(name, typ, help string, value interface{}) {
+	m.header(name, typ, help)
+	m.sample(name, "", value)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// writeMetrics writes every metric of the server
+func (s *Server) writeMetrics(w io.Writer) {
+	m := &metricsWriter{w}
+	st := s.stats.snapshot()
+	r := s.memoryReport()
+
+	m.metric("simpledb_uptime_seconds", "gauge", "Seconds since the server started.",
+		int64(time.Since(st.start)/time.Second))
+	m.metric("simpledb_connected_clients", "gauge", "Open client connections.",
+		s.clients.count())
+	m.metric("simpledb_connections_received_total", "counter", "Connections accepted.", st.connections)
+	m.metric("simpledb_commands_processed_total", "counter", "Commands processed.", st.processed)
+	m.metric("simpledb_keyspace_hits_total", "counter", "Read commands finding their key.", st.hits)
+	m.metric("simpledb_keyspace_misses_total", "counter", "Read commands not finding their key.", st.misses)
+
+	m.metric("simpledb_memory_used_bytes", "gauge", "Memory used by the keyspace and the buffers.", r.used)
+	m.metric("simpledb_memory_dataset_bytes", "gauge", "Memory used by the keyspace.", r.dataset)
+	m.metric("simpledb_memory_max_bytes", "gauge", "maxmemory, 0 without a limit.", r.maxMemory)
+	m.metric("simpledb_evicted_keys_total", "counter", "Keys evicted over maxmemory.", r.evicted)
+	m.metric("simpledb_expired_keys_total", "counter", "Keys expired.", 0)
+
+	m.metric("simpledb_rdb_changes_since_last_save", "gauge", "Writes since the last save.", st.dirty)
+	m.metric("simpledb_rdb_last_save_timestamp_seconds", "gauge", "Unix time of the last save.", st.lastSave.Unix())
+	m.metric("simpledb_aof_enabled", "gauge", "1 when the append only file is on.", 0)
+
+	// the keys are counted by the memory accounting, a scrape does not
+	// wait for a running command or script
+	keys := s.memory.keyTypes()
+	m.header("simpledb_keys", "gauge", "Keys per type.")
+	for _, typ := range []string{typeString, typeList, typeHash, typeSet, typeZSet} {
+		m.sample("simpledb_keys", fmt.Sprintf("db=\"%d\",type=%q", s.db.id, typ), keys[typ])
+	}
+
+	commands := s.stats.commands()
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	m.header("simpledb_command_calls_total", "counter", "Calls per command.")
+	for _, name := range names {
+		m.sample("simpledb_command_calls_total", fmt.Sprintf("cmd=%q", strings.ToLower(name)), commands[name].calls)
+	}
+	m.header("simpledb_command_duration_seconds", "histogram", "Latency of the commands.")
+	for _, name := range names {
+		stat := commands[name]
+		label := fmt.Sprintf("cmd=%q", strings.ToLower(name))
+		var cumulative int64
+		for i, bound := range latencyBuckets {
+			cumulative += stat.buckets[i]
+			m.sample("simpledb_command_duration_seconds_bucket", fmt.Sprintf("%s,le=%q", label, formatFloat(bound)), cumulative)
+		}
+		m.sample("simpledb_command_duration_seconds_bucket", label+`,le="+Inf"`, stat.calls)
+		m.sample("simpledb_command_duration_seconds_sum", label, formatFloat(stat.duration.Seconds()))
+		m.sample("simpledb_command_duration_seconds_count", label, stat.calls)
+	}
+}
+
+func (s *Server) metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.writeMetrics(w)
+	})
+	return mux
+}
+
+// serveMetrics serves /metrics on the metrics port until it fails or the
+// server shuts down
+func (s *Server) serveMetrics() {
+	addr := fmt.Sprintf("%s:%d", s.host, s.metricsPort)
+	server := &http.Server{Addr: addr, Handler: s.metricsHandler()}
+	s.life.addHTTP(server)
+	log.Println("metrics on: ", addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("metrics on %s err: %v", addr, err)
+	}
+}
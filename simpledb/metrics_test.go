@@ -0,0 +1,108 @@
+package simpledb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsObserve(t *testing.T) {
+
+	stats := newStats()
+	stats.observe("GET", 50*time.Microsecond)
+	stats.observe("GET", 2*time.Second)
+	stats.observe("GET", time.Minute)
+
+	stat := stats.commands()["GET"]
+	if stat.calls != 3 {
+		t.Errorf("calls: got %d, want 3", stat.calls)
+	}
+	// 50µs is in (0.00001, 0.0001], 2s in (1, 5], a minute over every bound
+	want := map[int]int64{1: 1, len(latencyBuckets) - 1: 1, len(latencyBuckets): 1}
+	for i, n := range stat.buckets {
+		if n != want[i] {
+			t.Errorf("bucket %d: got %d, want %d", i, n, want[i])
+		}
+	}
+}
+
+func TestServer_Metrics(t *testing.T) {
+
+	server := newTestServer()
+	wb, rb := pipeConn(server)
+
+	call(wb, rb, "SET", "a", "1")
+	call(wb, rb, "GET", "a")
+	call(wb, rb, "RPUSH", "l", "x")
+
+	// a running command does not hold the scrape
+	server.cmdMu.Lock()
+	defer server.cmdMu.Unlock()
+	scraped := make(chan []byte, 1)
+	go func() {
+		recorder := httptest.NewRecorder()
+		server.metricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		body, _ := ioutil.ReadAll(recorder.Body)
+		scraped <- body
+	}()
+	var body []byte
+	select {
+	case body = <-scraped:
+	case <-time.After(time.Second):
+		t.Fatal("the scrape waits for cmdMu")
+	}
+
+	for _, want := range []string{
+		"# TYPE simpledb_command_duration_seconds histogram\n",
+		`simpledb_command_calls_total{cmd="get"} 1` + "\n",
+		`simpledb_command_duration_seconds_bucket{cmd="set",le="+Inf"} 1` + "\n",
+		`simpledb_command_duration_seconds_count{cmd="rpush"} 1` + "\n",
+		`simpledb_keys{db="0",type="string"} 1` + "\n",
+		`simpledb_keys{db="0",type="list"} 1` + "\n",
+		"simpledb_connected_clients 1\n",
+		"simpledb_commands_processed_total 3\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics: no %q", want)
+		}
+	}
+}
+
+func TestServer_MetricsShutdown(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	server := newTestServer()
+	server.host = "127.0.0.1"
+	server.metricsPort = port
+	_, ran := runUnix(t, server)
+	url := fmt.Sprintf("http://127.0.0.1:%d/metrics", port)
+	for i := 0; ; i++ {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if i == 100 {
+			t.Fatalf("metrics: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	server.Close()
+	stopped(t, server, ran)
+	if resp, err := http.Get(url); err == nil {
+		resp.Body.Close()
+		t.Errorf("metrics served after Close")
+	}
+}
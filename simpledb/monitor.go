@@ -0,0 +1,175 @@
+package simpledb
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// monitor command:
+// monitor
+//
+// MONITOR turns the connection into a stream of the commands processed by
+// the server, one line per command like redis:
+//
+//	+1339518083.107412 [0 127.0.0.1:60866] "keys" "*"
+//
+// passwords are redacted. A monitoring connection runs no other command,
+// so it never waits for cmdMu while the writers of its stream hold it.
+
+var errMonitoring = errors.New("ERR the connection is in MONITOR mode, no other command is allowed")
+
+const redacted = "(redacted)"
+
+// Monitors are the connections in MONITOR mode
+type Monitors struct {
+	mu      sync.RWMutex
+	clients map[*Server]struct{}
+}
+
+func newMonitors() *Monitors {
+	return &Monitors{
+		mu:      sync.RWMutex{},
+		clients: make(map[*Server]struct{}),
+	}
+}
+
+func (m *Monitors) add(s *Server) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clients[s] = struct{}{}
+}
+
+func (m *Monitors) remove(s *Server) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.clients, s)
+}
+
+// redactArgs returns the arguments of a command with the passwords
+// replaced, args is not modified
+func redactArgs(args []string) []string {
+	if len(args) == 0 {
+		return args
+	}
+	out := append([]string(nil), args...)
+	switch strings.ToUpper(out[0]) {
+	case "AUTH":
+		for i := 1; i < len(out); i++ {
+			out[i] = redacted
+		}
+	case "CONFIG":
+		// CONFIG SET parameter value [parameter value ...]
+		if len(out) > 1 && strings.ToUpper(out[1]) == "SET" {
+			for i := 2; i+1 < len(out); i += 2 {
+				if strings.ToLower(out[i]) == "requirepass" {
+					out[i+1] = redacted
+				}
+			}
+		}
+	case "ACL":
+		// ACL SETUSER username rule [rule ...]
+		if len(out) > 2 && strings.ToUpper(out[1]) == "SETUSER" {
+			for i := 3; i < len(out); i++ {
+				if strings.HasPrefix(out[i], ">") || strings.HasPrefix(out[i], "<") {
+					out[i] = out[i][:1] + redacted
+				}
+			}
+		}
+	case "MIGRATE":
+		for i := 6; i < len(out); i++ {
+			switch strings.ToUpper(out[i]) {
+			case "AUTH":
+				if i+1 < len(out) {
+					out[i+1] = redacted
+				}
+			case "AUTH2":
+				for j := i + 1; j < i+3 && j < len(out); j++ {
+					out[j] = redacted
+				}
+			}
+		}
+	}
+	return out
+}
+
+// monitorLine formats a command for the monitors, without the leading +
+func monitorLine(now time.Time, db int, addr string, args []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d.%06d [%d %s]", now.Unix(), now.Nanosecond()/1000, db, addr)
+	for _, arg := range redactArgs(args) {
+		b.WriteByte(' ')
+		b.WriteString(quoteArg(arg))
+	}
+	return b.String()
+}
+
+// quoteArg quotes arg with the escapes of redis
+func quoteArg(arg string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(arg); i++ {
+		c := arg[i]
+		switch c {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				b.WriteString(`\x` + strconv.FormatUint(uint64(c)|0x100, 16)[1:])
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// feed writes the command of from to every monitor
+func (m *Monitors) feed(from *Server, resp *Resp) {
+	m.mu.RLock()
+	if len(m.clients) == 0 {
+		m.mu.RUnlock()
+		return
+	}
+	var monitors []*Server
+	for c := range m.clients {
+		monitors = append(monitors, c)
+	}
+	m.mu.RUnlock()
+
+	args := make([]string, len(resp.Array))
+	for i, arg := range resp.Array {
+		args[i] = string(arg.Value)
+	}
+	line := monitorLine(time.Now(), from.db.id, from.clientAddr(), args)
+	for _, c := range monitors {
+		if c.wmu == from.wmu {
+			continue
+		}
+		c.wmu.Lock()
+		c.wb.WriteString(line)
+		c.flush()
+		c.wmu.Unlock()
+	}
+}
+
+func monitor(s *Server, resp *Resp) error {
+	s.monitoring = true
+	s.monitors.add(s)
+	return s.replyOk()
+}
@@ -0,0 +1,72 @@
+package simpledb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactArgs(t *testing.T) {
+
+	var tests = []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"AUTH", "secret"}, []string{"AUTH", redacted}},
+		{[]string{"auth", "user", "secret"}, []string{"auth", redacted, redacted}},
+		{[]string{"CONFIG", "SET", "maxmemory", "1mb", "requirepass", "secret"},
+			[]string{"CONFIG", "SET", "maxmemory", "1mb", "requirepass", redacted}},
+		{[]string{"CONFIG", "GET", "requirepass"}, []string{"CONFIG", "GET", "requirepass"}},
+		{[]string{"MIGRATE", "h", "1", "", "0", "10", "AUTH", "secret", "KEYS", "a"},
+			[]string{"MIGRATE", "h", "1", "", "0", "10", "AUTH", redacted, "KEYS", "a"}},
+		{[]string{"SET", "requirepass", "x"}, []string{"SET", "requirepass", "x"}},
+	}
+	for _, test := range tests {
+		if got := redactArgs(test.args); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("redactArgs(%q): got %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+func TestMonitorLine(t *testing.T) {
+
+	now := time.Unix(1339518083, 107412000)
+	line := monitorLine(now, 0, "127.0.0.1:60866", []string{"set", "k", "a \"b\"\n\x01"})
+	want := `1339518083.107412 [0 127.0.0.1:60866] "set" "k" "a \"b\"\n\x01"`
+	if line != want {
+		t.Errorf("got %s, want %s", line, want)
+	}
+}
+
+func TestServer_Monitor(t *testing.T) {
+
+	server := newTestServer()
+	mwb, mrb := pipeConn(server)
+	wb, rb := pipeConn(server)
+
+	resp, err := call(mwb, mrb, "MONITOR", []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Value) != "OK" {
+		t.Fatalf("MONITOR: got %s", resp.Value)
+	}
+
+	call(wb, rb, "SET", "a", "x y")
+	line, err := mrb.HandleStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(line.Value), `] "SET" "a" "x y"`) || !strings.Contains(string(line.Value), " [0 127.0.0.1:") {
+		t.Errorf("monitor: got %s", line.Value)
+	}
+
+	resp, err = call(mwb, mrb, "GET", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Value) != errMonitoring.Error() {
+		t.Errorf("GET in MONITOR mode: got %s, want %s", resp.Value, errMonitoring.Error())
+	}
+}
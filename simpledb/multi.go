@@ -0,0 +1,171 @@
+package simpledb
+
+import (
+	"errors"
+	"sync"
+)
+
+// transaction commands:
+// multi, exec, discard, watch, unwatch
+
+var (
+	errNestedMulti  = errors.New("ERR MULTI calls can not be nested")
+	errExecNoMulti  = errors.New("ERR EXEC without MULTI")
+	errDiscardMulti = errors.New("ERR DISCARD without MULTI")
+	errWatchInMulti = errors.New("ERR WATCH inside MULTI is not allowed")
+	errExecAbort    = errors.New("EXECABORT Transaction discarded because of previous errors.")
+)
+
+type queuedCommand struct {
+	command *Command
+	resp    *Resp
+}
+
+// transaction holds the commands queued by a connection after MULTI
+type transaction struct {
+	queued  []queuedCommand
+	aborted bool // a command failed to queue, EXEC answers EXECABORT
+}
+
+type watchKey struct {
+	db  int
+	key string
+}
+
+// Watches keeps the connections watching every key
+type Watches struct {
+	mu   sync.Mutex
+	keys map[watchKey]map[*Server]struct{}
+}
+
+func newWatches() *Watches {
+	return &Watches{
+		mu:   sync.Mutex{},
+		keys: make(map[watchKey]map[*Server]struct{}),
+	}
+}
+
+func (w *Watches) watch(s *Server, key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	k := watchKey{db: s.db.id, key: key}
+	if _, ok := s.watched[k]; ok {
+		return
+	}
+	if _, ok := w.keys[k]; !ok {
+		w.keys[k] = make(map[*Server]struct{})
+	}
+	w.keys[k][s] = struct{}{}
+	s.watched[k] = struct{}{}
+}
+
+func (w *Watches) unwatchAll(s *Server) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for k := range s.watched {
+		delete(w.keys[k], s)
+		if len(w.keys[k]) == 0 {
+			delete(w.keys, k)
+		}
+		delete(s.watched, k)
+	}
+	s.dirtyCAS = false
+}
+
+// touch marks the connections watching key, their next EXEC fails
+func (w *Watches) touch(db int, key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for s := range w.keys[watchKey{db: db, key: key}] {
+		s.dirtyCAS = true
+	}
+}
+
+func (w *Watches) dirty(s *Server) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return s.dirtyCAS
+}
+
+// isTransactionCommand reports whether command runs immediately inside MULTI
+func isTransactionCommand(command *Command) bool {
+	switch command.Name {
+	case "MULTI", "EXEC", "DISCARD", "WATCH":
+		return true
+	}
+	return false
+}
+
+func (s *Server) queueCommand(command *Command, resp *Resp) error {
+	s.multi.queued = append(s.multi.queued, queuedCommand{command: command, resp: resp})
+	return s.writeArgs("QUEUED")
+}
+
+// discardTransactionOnError makes EXEC fail after a command was refused
+// while queuing
+func (s *Server) discardTransactionOnError() {
+	if s.multi != nil {
+		s.multi.aborted = true
+	}
+}
+
+func multi(s *Server, resp *Resp) error {
+	if s.multi != nil {
+		return s.replyErr(errNestedMulti)
+	}
+	s.multi = &transaction{}
+	return s.replyOk()
+}
+
+func exec(s *Server, resp *Resp) error {
+	if s.multi == nil {
+		return s.replyErr(errExecNoMulti)
+	}
+	t := s.multi
+	s.multi = nil
+	dirty := s.watches.dirty(s)
+	s.watches.unwatchAll(s)
+
+	if t.aborted {
+		return s.replyErr(errExecAbort)
+	}
+	if dirty {
+		return s.replyNil()
+	}
+	// every reply is written as an element of the EXEC array, cmdMu is
+	// held so no other connection runs in between
+	if _, err := s.wb.WriteArray(len(t.queued)); err != nil {
+		return err
+	}
+	for _, q := range t.queued {
+		s.call(q.command, q.resp)
+	}
+	return s.flush()
+}
+
+func discard(s *Server, resp *Resp) error {
+	if s.multi == nil {
+		return s.replyErr(errDiscardMulti)
+	}
+	s.multi = nil
+	s.watches.unwatchAll(s)
+	return s.replyOk()
+}
+
+func watch(s *Server, resp *Resp) error {
+	if s.multi != nil {
+		return s.replyErr(errWatchInMulti)
+	}
+	for _, arg := range resp.Array[1:] {
+		s.watches.watch(s, string(arg.Value))
+	}
+	return s.replyOk()
+}
+
+func unwatch(s *Server, resp *Resp) error {
+	s.watches.unwatchAll(s)
+	return s.replyOk()
+}
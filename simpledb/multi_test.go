@@ -0,0 +1,119 @@
+package simpledb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestServer_Exec(t *testing.T) {
+
+	server := newTestServer()
+	wb, rb := pipeConn(server)
+
+	var tests = []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"SET", "stock", "10"}, "OK"},
+		{[]interface{}{"MULTI", []string{}}, "OK"},
+		{[]interface{}{"DECRBY", "stock", "3"}, "QUEUED"},
+		{[]interface{}{"GET", "stock"}, "QUEUED"},
+		{[]interface{}{"MULTI", []string{}}, "ERR MULTI calls can not be nested"},
+		// the replies of DECRBY and GET
+		{[]interface{}{"EXEC", []string{}}, "7 7"},
+		{[]interface{}{"GET", "stock"}, "7"},
+		{[]interface{}{"EXEC", []string{}}, "ERR EXEC without MULTI"},
+		{[]interface{}{"DISCARD", []string{}}, "ERR DISCARD without MULTI"},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := string(resp.Value)
+		if resp.IsArray() {
+			values := make([]string, len(resp.Array))
+			for i, r := range resp.Array {
+				values[i] = string(r.Value)
+			}
+			got = strings.Join(values, " ")
+		}
+		if got != test.want {
+			t.Errorf("%v: got %s, want %s", test.args, got, test.want)
+		}
+	}
+}
+
+func TestServer_ExecAbort(t *testing.T) {
+
+	server := newTestServer()
+	wb, rb := pipeConn(server)
+
+	var tests = []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"MULTI", []string{}}, "OK"},
+		{[]interface{}{"SET", "foo", "bar"}, "QUEUED"},
+		{[]interface{}{"NOSUCHCOMMAND", "foo"}, "lack of command"},
+		{[]interface{}{"EXEC", []string{}}, errExecAbort.Error()},
+		{[]interface{}{"EXISTS", "foo"}, "0"},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Value) != test.want {
+			t.Errorf("%v: got %s, want %s", test.args, resp.Value, test.want)
+		}
+	}
+}
+
+func TestServer_Watch(t *testing.T) {
+
+	server := newTestServer()
+	wb, rb := pipeConn(server)
+	otherWb, otherRb := pipeConn(server)
+
+	var tests = []struct {
+		other bool
+		args  []interface{}
+		want  string
+	}{
+		{false, []interface{}{"SET", "counter", "1"}, "OK"},
+		{false, []interface{}{"WATCH", "counter"}, "OK"},
+		{true, []interface{}{"INCR", "counter"}, "2"},
+		{false, []interface{}{"MULTI", []string{}}, "OK"},
+		{false, []interface{}{"WATCH", "counter"}, errWatchInMulti.Error()},
+		{false, []interface{}{"INCR", "counter"}, "QUEUED"},
+		{false, []interface{}{"EXEC", []string{}}, "nil"},
+		{false, []interface{}{"GET", "counter"}, "2"},
+
+		// the watch is gone after EXEC
+		{false, []interface{}{"WATCH", "counter"}, "OK"},
+		{false, []interface{}{"UNWATCH", []string{}}, "OK"},
+		{true, []interface{}{"INCR", "counter"}, "3"},
+		{false, []interface{}{"MULTI", []string{}}, "OK"},
+		{false, []interface{}{"INCR", "counter"}, "QUEUED"},
+		{false, []interface{}{"EXEC", []string{}}, ""},
+		{false, []interface{}{"GET", "counter"}, "4"},
+	}
+	for _, test := range tests {
+		var (
+			resp *Resp
+			err  error
+		)
+		if test.other {
+			resp, err = call(otherWb, otherRb, test.args...)
+		} else {
+			resp, err = call(wb, rb, test.args...)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Value) != test.want {
+			t.Errorf("%v: got %s, want %s", test.args, resp.Value, test.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package simpledb
+
+import "strconv"
+
+// keyspace notifications, every command that changes the keyspace reports
+// what happened through notify, which publishes the event on
+//
+//	__keyspace@<db>__:<key>    with the event name as message
+//	__keyevent@<db>__:<event>  with the key as message
+//
+// when the class of the event is enabled by notify_keyspace_events.
+
+const (
+	notifyKeyspace = 1 << iota // K
+	notifyKeyevent             // E
+	notifyGeneric              // g
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZSet                 // z
+	notifyExpired              // x
+	notifyEvicted              // e
+
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet |
+		notifyHash | notifyZSet | notifyExpired | notifyEvicted // A
+)
+
+// parseNotifyFlags turns a flag set such as "KEA" into event classes,
+// unknown flags are ignored
+func parseNotifyFlags(classes string) int {
+	var flags int
+	for _, c := range classes {
+		switch c {
+		case 'A':
+			flags |= notifyAll
+		case 'g':
+			flags |= notifyGeneric
+		case '$':
+			flags |= notifyString
+		case 'l':
+			flags |= notifyList
+		case 's':
+			flags |= notifySet
+		case 'h':
+			flags |= notifyHash
+		case 'z':
+			flags |= notifyZSet
+		case 'x':
+			flags |= notifyExpired
+		case 'e':
+			flags |= notifyEvicted
+		case 'K':
+			flags |= notifyKeyspace
+		case 'E':
+			flags |= notifyKeyevent
+		}
+	}
+	return flags
+}
+
+// notifyFlagsString is the inverse of parseNotifyFlags
+func notifyFlagsString(flags int) string {
+	var classes []byte
+	if flags&notifyAll == notifyAll {
+		classes = append(classes, 'A')
+	} else {
+		for _, f := range []struct {
+			flag int
+			c    byte
+		}{
+			{notifyGeneric, 'g'}, {notifyString, '$'}, {notifyList, 'l'},
+			{notifySet, 's'}, {notifyHash, 'h'}, {notifyZSet, 'z'},
+			{notifyExpired, 'x'}, {notifyEvicted, 'e'},
+		} {
+			if flags&f.flag != 0 {
+				classes = append(classes, f.c)
+			}
+		}
+	}
+	if flags&notifyKeyspace != 0 {
+		classes = append(classes, 'K')
+	}
+	if flags&notifyKeyevent != 0 {
+		classes = append(classes, 'E')
+	}
+	return string(classes)
+}
+
+// notify is the central hook for keyspace events, it is also where a
+// modified key invalidates the transactions watching it and has its memory
+// accounted
+func (s *Server) notify(class int, event, key string) {
+	s.watches.touch(s.db.id, key)
+	s.memory.update(s.db, key)
+
+	flags := s.pubsub.getNotifyFlags()
+	if flags&class == 0 {
+		return
+	}
+	dbId := strconv.Itoa(s.db.id)
+	if flags&notifyKeyspace != 0 {
+		s.pubsub.publish(s, "__keyspace@"+dbId+"__:"+key, event)
+	}
+	if flags&notifyKeyevent != 0 {
+		s.pubsub.publish(s, "__keyevent@"+dbId+"__:"+event, key)
+	}
+}
@@ -0,0 +1,88 @@
+package simpledb
+
+import "testing"
+
+func TestParseNotifyFlags(t *testing.T) {
+
+	var tests = []struct {
+		classes string
+		want    string
+	}{
+		{"", ""},
+		{"KEA", "AKE"},
+		{"Kg$", "g$K"},
+		{"E$lshzxeg", "AE"},
+		{"Kz?", "zK"},
+	}
+
+	for _, test := range tests {
+		got := notifyFlagsString(parseNotifyFlags(test.classes))
+		if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.classes, got, test.want)
+		}
+	}
+}
+
+func TestServer_Notify(t *testing.T) {
+
+	server := newTestServer()
+	server.pubsub.setNotifyFlags(parseNotifyFlags("KE$g"))
+
+	subWb, subRb := pipeConn(server)
+	resp, err := call(subWb, subRb, "PSUBSCRIBE", "__key*__:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Array) != 3 || string(resp.Array[0].Value) != "psubscribe" || string(resp.Array[1].Value) != "__key*__:*" {
+		t.Fatalf("PSUBSCRIBE: got %v", resp)
+	}
+
+	wb, rb := pipeConn(server)
+	var tests = []struct {
+		args    []interface{}
+		channel string
+		message string
+	}{
+		{[]interface{}{"SET", "foo", "bar"}, "__keyspace@0__:foo", "set"},
+		{[]interface{}{"INCR", "counter"}, "__keyspace@0__:counter", "incrby"},
+		{[]interface{}{"DECR", "counter"}, "__keyspace@0__:counter", "decrby"},
+		{[]interface{}{"DECRBY", "counter", "2"}, "__keyspace@0__:counter", "decrby"},
+		{[]interface{}{"DEL", "foo"}, "__keyspace@0__:foo", "del"},
+	}
+	for _, test := range tests {
+		if _, err := call(wb, rb, test.args...); err != nil {
+			t.Fatal(err)
+		}
+		keyspace, err := subRb.HandleStream()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyevent, err := subRb.HandleStream()
+		if err != nil {
+			t.Fatal(err)
+		}
+		channel, message := string(keyspace.Array[2].Value), string(keyspace.Array[3].Value)
+		if channel != test.channel || message != test.message {
+			t.Errorf("%v: got %s %s, want %s %s", test.args, channel, message, test.channel, test.message)
+		}
+		if string(keyevent.Array[2].Value) != "__keyevent@0__:"+test.message {
+			t.Errorf("%v: got keyevent %s", test.args, keyevent.Array[2].Value)
+		}
+	}
+
+	// list events are not enabled
+	if _, err := call(wb, rb, "LPUSH", "queue", "a"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = call(wb, rb, "PUBLISH", "__keyspace@0__:queue", "done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := subRb.HandleStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(message.Array[3].Value) != "done" {
+		t.Errorf("got %s, want done", message.Array[3].Value)
+	}
+}
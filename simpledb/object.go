@@ -0,0 +1,216 @@
+package simpledb
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// object commands:
+// object encoding|refcount|idletime|freq, memory usage|stats|doctor
+//
+// OBJECT ENCODING names the go structure holding the value: strings are
+// "int" or "raw", lists a "linkedlist", hashes and sets a "hashtable",
+// sorted sets a "sortedslice". Values are never shared, REFCOUNT is 1.
+
+const (
+	// bufio buffers of a connection, one to read and one to write
+	clientBufferSize = 2 * 4096
+
+	defaultUsageSamples = 5
+	doctorBigKeyPercent = 50
+	doctorUsedPercent   = 90
+)
+
+var (
+	errObjectSubcmd = errors.New("ERR unknown subcommand, try OBJECT ENCODING|REFCOUNT|IDLETIME|FREQ key")
+	errMemorySubcmd = errors.New("ERR unknown subcommand, try MEMORY USAGE key [SAMPLES count]|STATS|DOCTOR")
+	errNotLFU       = errors.New("ERR An LFU maxmemory policy is not selected, access frequency not tracked")
+	errNotLRU       = errors.New("ERR An LFU maxmemory policy is selected, idle time not tracked")
+)
+
+func objectEncoding(typ string, value interface{}) string {
+	switch typ {
+	case typeString:
+		if _, err := strconv.ParseInt(fmt.Sprint(value), 10, 64); err == nil {
+			return "int"
+		}
+		return "raw"
+	case typeList:
+		return "linkedlist"
+	case typeHash, typeSet:
+		return "hashtable"
+	case typeZSet:
+		return "sortedslice"
+	}
+	return ""
+}
+
+func (mem *Memory) lfuPolicy() bool {
+	_, policy := mem.limits()
+	return policy == policyAllKeysLFU || policy == policyVolatileLFU
+}
+
+func object(s *Server, resp *Resp) error {
+	subcommand := strings.ToUpper(string(resp.Array[1].Value))
+	if len(resp.Array) != 3 {
+		return s.replyErr(errObjectSubcmd)
+	}
+	key := string(resp.Array[2].Value)
+	value, typ := s.lookupKey(key)
+	if typ == typeNone {
+		return s.replyNil()
+	}
+	meta, _ := s.memory.meta(key)
+
+	switch subcommand {
+	case "ENCODING":
+		return s.writeResp(NewBulkBytes([]byte(objectEncoding(typ, value))))
+	case "REFCOUNT":
+		return s.reply1()
+	case "IDLETIME":
+		if s.memory.lfuPolicy() {
+			return s.replyErr(errNotLRU)
+		}
+		idle := time.Duration(time.Now().UnixNano()-meta.access) / time.Second
+		return s.writeResp(NewInt([]byte(strconv.FormatInt(int64(idle), 10))))
+	case "FREQ":
+		if !s.memory.lfuPolicy() {
+			return s.replyErr(errNotLFU)
+		}
+		return s.writeResp(NewInt([]byte(strconv.Itoa(int(meta.lfu)))))
+	}
+	return s.replyErr(errObjectSubcmd)
+}
+
+func memoryCommand(s *Server, resp *Resp) error {
+	switch strings.ToUpper(string(resp.Array[1].Value)) {
+	case "USAGE":
+		return s.memoryUsage(resp.Array[2:])
+	case "STATS":
+		return s.writeResp(NewArray(s.memoryStats()))
+	case "DOCTOR":
+		return s.writeResp(NewBulkBytes([]byte(s.memoryDoctor())))
+	}
+	return s.replyErr(errMemorySubcmd)
+}
+
+// memoryUsage replies the estimated bytes of a key, collections are
+// estimated from SAMPLES elements, all of them with SAMPLES 0
+func (s *Server) memoryUsage(args []*Resp) error {
+	if len(args) != 1 && len(args) != 3 {
+		return s.replyErr(errMemorySubcmd)
+	}
+	samples := defaultUsageSamples
+	if len(args) == 3 {
+		if strings.ToUpper(string(args[1].Value)) != "SAMPLES" {
+			return s.replyErr(errSyntax)
+		}
+		n, err := strconv.Atoi(string(args[2].Value))
+		if err != nil || n < 0 {
+			return s.replyErr(errInteger)
+		}
+		samples = n
+	}
+	key := string(args[0].Value)
+	value, typ := s.lookupKey(key)
+	if typ == typeNone {
+		return s.replyNil()
+	}
+	size := sampledSize(key, typ, value, samples)
+	return s.writeResp(NewInt([]byte(strconv.FormatInt(size, 10))))
+}
+
+type memoryStat struct {
+	name  string
+	value int64
+}
+
+// memoryReport is the overall breakdown of MEMORY STATS and DOCTOR
+type memoryReport struct {
+	maxMemory int64
+	policy    string
+	used      int64 // keyspace and buffers
+	keys      int64
+	dataset   int64
+	clients   int64
+	aof       int64
+	evicted   int64
+}
+
+func (s *Server) memoryReport() memoryReport {
+	maxMemory, policy := s.memory.limits()
+	r := memoryReport{
+		maxMemory: maxMemory,
+		policy:    policy,
+		keys:      s.memory.keyCount(),
+		dataset:   s.memory.usedMemory(),
+		clients:   s.clients.count() * clientBufferSize,
+		evicted:   s.memory.evictedKeys(),
+	}
+	if s.aofBuf != nil {
+		r.aof = int64(s.aofBuf.buf.Buffered())
+	}
+	r.used = r.dataset + r.clients + r.aof
+	return r
+}
+
+func (s *Server) memoryStats() []*Resp {
+	r := s.memoryReport()
+	stats := []memoryStat{
+		{"total.allocated", r.used},
+		{"maxmemory", r.maxMemory},
+		{"clients.normal", r.clients},
+		{"aof.buffer", r.aof},
+		{"keys.count", r.keys},
+		{"overhead.total", r.keys*keyOverhead + r.clients + r.aof},
+		{"dataset.bytes", r.dataset - r.keys*keyOverhead},
+		{"evicted.keys", r.evicted},
+	}
+	var reply []*Resp
+	for _, stat := range stats {
+		reply = append(reply, NewBulkBytes([]byte(stat.name)),
+			NewInt([]byte(strconv.FormatInt(stat.value, 10))))
+	}
+	percentage := "0.00"
+	if r.used > 0 {
+		percentage = strconv.FormatFloat(float64(r.dataset)*100/float64(r.used), 'f', 2, 64)
+	}
+	return append(reply, NewBulkBytes([]byte("dataset.percentage")), NewBulkBytes([]byte(percentage)))
+}
+
+// memoryDoctor returns one hint per line about what is using memory
+func (s *Server) memoryDoctor() string {
+	r := s.memoryReport()
+	if r.keys == 0 {
+		return "The keyspace is empty, there is nothing to report."
+	}
+	var hints []string
+	if r.maxMemory > 0 && r.used*100 > r.maxMemory*doctorUsedPercent {
+		hint := fmt.Sprintf("Used memory is %d%% of maxmemory (%d of %d bytes).",
+			r.used*100/r.maxMemory, r.used, r.maxMemory)
+		if r.policy == policyNoEviction {
+			hint += " The policy is noeviction, writes fail with OOM past maxmemory."
+		}
+		hints = append(hints, hint)
+	}
+	if r.evicted > 0 {
+		hints = append(hints, fmt.Sprintf("%d keys were evicted to stay under maxmemory, consider raising it.", r.evicted))
+	}
+	if biggest := s.memory.biggest(1); r.keys > 1 && len(biggest) == 1 {
+		if meta, ok := s.memory.meta(biggest[0]); ok && meta.size*100 > r.dataset*doctorBigKeyPercent {
+			hints = append(hints, fmt.Sprintf("The key %q holds %d%% of the keyspace (%d bytes).",
+				biggest[0], meta.size*100/r.dataset, meta.size))
+		}
+	}
+	if r.clients > r.dataset {
+		hints = append(hints, fmt.Sprintf("Client buffers use more memory than the keyspace (%d bytes for %d connections).",
+			r.clients, r.clients/clientBufferSize))
+	}
+	if len(hints) == 0 {
+		return "No memory problems detected."
+	}
+	return strings.Join(hints, "\n")
+}
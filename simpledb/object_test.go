@@ -0,0 +1,104 @@
+package simpledb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSampledSize(t *testing.T) {
+
+	set := &sMember{val: make(map[string]interface{})}
+	for _, member := range []string{"aa", "bb", "cc", "dd"} {
+		set.val[member] = nil
+	}
+	all := valueSize("s", typeSet, set)
+	if want := int64(keyOverhead + 1 + 4*(elementOverhead+2)); all != want {
+		t.Errorf("valueSize: got %d, want %d", all, want)
+	}
+	// members have the same size, a sample extrapolates exactly
+	if sampled := sampledSize("s", typeSet, set, 2); sampled != all {
+		t.Errorf("sampledSize: got %d, want %d", sampled, all)
+	}
+}
+
+func TestServer_Object(t *testing.T) {
+
+	server := newTestServer()
+	wb, rb := pipeConn(server)
+
+	call(wb, rb, "SET", "n", "42")
+	call(wb, rb, "SET", "s", "hello")
+	call(wb, rb, "RPUSH", "l", "a")
+
+	var tests = []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"OBJECT", "ENCODING", "n"}, "int"},
+		{[]interface{}{"OBJECT", "ENCODING", "s"}, "raw"},
+		{[]interface{}{"OBJECT", "ENCODING", "l"}, "linkedlist"},
+		{[]interface{}{"OBJECT", "REFCOUNT", "s"}, "1"},
+		{[]interface{}{"OBJECT", "IDLETIME", "s"}, "0"},
+		{[]interface{}{"OBJECT", "FREQ", "s"}, errNotLFU.Error()},
+		{[]interface{}{"OBJECT", "ENCODING", "missing"}, "nil"},
+		{[]interface{}{"OBJECT", "LOUDNESS", "s"}, errObjectSubcmd.Error()},
+		{[]interface{}{"MEMORY", "USAGE", "s"}, "70"},
+		{[]interface{}{"MEMORY", "USAGE", "s", "SAMPLES", "0"}, "70"},
+		{[]interface{}{"MEMORY", "USAGE", "missing"}, "nil"},
+		{[]interface{}{"MEMORY", "USAGE", "s", "SAMPLES", "x"}, errInteger.Error()},
+		{[]interface{}{"MEMORY", "PURGE"}, errMemorySubcmd.Error()},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Value) != test.want {
+			t.Errorf("%v: got %s, want %s", test.args, resp.Value, test.want)
+		}
+	}
+
+	server.memory.policy = policyAllKeysLFU
+	resp, err := call(wb, rb, "OBJECT", "FREQ", "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Value) == "" || resp.IsError() {
+		t.Errorf("OBJECT FREQ: got %s", resp.Value)
+	}
+}
+
+func TestServer_MemoryStats(t *testing.T) {
+
+	server := newTestServer()
+	server.memory = newMemory(1000, policyNoEviction, 0)
+	wb, rb := pipeConn(server)
+
+	call(wb, rb, "SET", "small", "v")
+	call(wb, rb, "SET", "big", strings.Repeat("v", 800))
+
+	resp, err := call(wb, rb, "MEMORY", "STATS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := make(map[string]string)
+	for i := 0; i+1 < len(resp.Array); i += 2 {
+		stats[string(resp.Array[i].Value)] = string(resp.Array[i+1].Value)
+	}
+	if stats["keys.count"] != "2" {
+		t.Errorf("keys.count: got %s, want 2", stats["keys.count"])
+	}
+	if stats["clients.normal"] != "8192" {
+		t.Errorf("clients.normal: got %s, want 8192", stats["clients.normal"])
+	}
+
+	resp, err = call(wb, rb, "MEMORY", "DOCTOR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hint := range []string{"of maxmemory", `"big"`} {
+		if !strings.Contains(string(resp.Value), hint) {
+			t.Errorf("MEMORY DOCTOR: %q has no %s hint", resp.Value, hint)
+		}
+	}
+}
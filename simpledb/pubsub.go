@@ -0,0 +1,252 @@
+package simpledb
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// pub/sub commands:
+// subscribe, unsubscribe, psubscribe, punsubscribe, publish
+
+var (
+	errSubscribed = errors.New("ERR only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT allowed in this context")
+)
+
+// PubSub keeps the subscribers of every channel and pattern
+type PubSub struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Server]struct{}
+	patterns map[string]map[*Server]struct{}
+
+	notifyFlags int32 // keyspace event classes, see notify.go
+}
+
+func newPubSub() *PubSub {
+	return &PubSub{
+		mu:       sync.RWMutex{},
+		channels: make(map[string]map[*Server]struct{}),
+		patterns: make(map[string]map[*Server]struct{}),
+	}
+}
+
+func (p *PubSub) setNotifyFlags(flags int) {
+	atomic.StoreInt32(&p.notifyFlags, int32(flags))
+}
+
+func (p *PubSub) getNotifyFlags() int {
+	return int(atomic.LoadInt32(&p.notifyFlags))
+}
+
+func (p *PubSub) subscribe(s *Server, channel string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.channels[channel]; !ok {
+		p.channels[channel] = make(map[*Server]struct{})
+	}
+	p.channels[channel][s] = struct{}{}
+	s.channels[channel] = struct{}{}
+}
+
+func (p *PubSub) unsubscribe(s *Server, channel string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := s.channels[channel]; !ok {
+		return false
+	}
+	delete(s.channels, channel)
+	delete(p.channels[channel], s)
+	if len(p.channels[channel]) == 0 {
+		delete(p.channels, channel)
+	}
+	return true
+}
+
+func (p *PubSub) pSubscribe(s *Server, pattern string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.patterns[pattern]; !ok {
+		p.patterns[pattern] = make(map[*Server]struct{})
+	}
+	p.patterns[pattern][s] = struct{}{}
+	s.patterns[pattern] = struct{}{}
+}
+
+func (p *PubSub) pUnsubscribe(s *Server, pattern string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := s.patterns[pattern]; !ok {
+		return false
+	}
+	delete(s.patterns, pattern)
+	delete(p.patterns[pattern], s)
+	if len(p.patterns[pattern]) == 0 {
+		delete(p.patterns, pattern)
+	}
+	return true
+}
+
+// unsubscribeAll drops every subscription of a closing connection
+func (p *PubSub) unsubscribeAll(s *Server) {
+	for channel := range s.channels {
+		p.unsubscribe(s, channel)
+	}
+	for pattern := range s.patterns {
+		p.pUnsubscribe(s, pattern)
+	}
+}
+
+// publish sends message to the subscribers of channel and of every
+// pattern matching it, it returns the number of receivers
+func (p *PubSub) publish(from *Server, channel, message string) int {
+
+	type delivery struct {
+		to      *Server
+		pattern string
+	}
+	var deliveries []delivery
+
+	// collect the receivers first, so no connection is written while
+	// holding the registry lock
+	p.mu.RLock()
+	for sub := range p.channels[channel] {
+		deliveries = append(deliveries, delivery{to: sub})
+	}
+	for pattern, subs := range p.patterns {
+		if !matchPattern(pattern, channel) {
+			continue
+		}
+		for sub := range subs {
+			deliveries = append(deliveries, delivery{to: sub, pattern: pattern})
+		}
+	}
+	p.mu.RUnlock()
+
+	for _, d := range deliveries {
+		if d.to != from {
+			d.to.wmu.Lock()
+		}
+		if d.pattern == "" {
+			d.to.wb.WriteArgs("message", channel, message)
+		} else {
+			d.to.wb.WriteArgs("pmessage", d.pattern, channel, message)
+		}
+		d.to.flush()
+		if d.to != from {
+			d.to.wmu.Unlock()
+		}
+	}
+	return len(deliveries)
+}
+
+// counts returns the channels and the patterns with a subscriber
+func (p *PubSub) counts() (int, int) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.channels), len(p.patterns)
+}
+
+func (s *Server) subscribed() bool {
+	return len(s.channels)+len(s.patterns) > 0
+}
+
+func (s *Server) subscriptions() int {
+	return len(s.channels) + len(s.patterns)
+}
+
+// isPubSubCommand reports whether command is allowed in subscribed mode
+func isPubSubCommand(command *Command) bool {
+	switch command.Name {
+	case "SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE":
+		return true
+	}
+	return false
+}
+
+func subscribe(s *Server, resp *Resp) error {
+
+	for _, arg := range resp.Array[1:] {
+		channel := string(arg.Value)
+		s.pubsub.subscribe(s, channel)
+		if _, err := s.wb.WriteArgs("subscribe", channel, s.subscriptions()); err != nil {
+			return err
+		}
+	}
+	return s.flush()
+}
+
+func unsubscribe(s *Server, resp *Resp) error {
+
+	var channels []string
+	for _, arg := range resp.Array[1:] {
+		channels = append(channels, string(arg.Value))
+	}
+	if len(channels) == 0 {
+		for channel := range s.channels {
+			channels = append(channels, channel)
+		}
+	}
+	if len(channels) == 0 {
+		if _, err := s.wb.WriteArgs("unsubscribe", "", s.subscriptions()); err != nil {
+			return err
+		}
+		return s.flush()
+	}
+	for _, channel := range channels {
+		s.pubsub.unsubscribe(s, channel)
+		if _, err := s.wb.WriteArgs("unsubscribe", channel, s.subscriptions()); err != nil {
+			return err
+		}
+	}
+	return s.flush()
+}
+
+func pSubscribe(s *Server, resp *Resp) error {
+
+	for _, arg := range resp.Array[1:] {
+		pattern := string(arg.Value)
+		s.pubsub.pSubscribe(s, pattern)
+		if _, err := s.wb.WriteArgs("psubscribe", pattern, s.subscriptions()); err != nil {
+			return err
+		}
+	}
+	return s.flush()
+}
+
+func pUnsubscribe(s *Server, resp *Resp) error {
+
+	var patterns []string
+	for _, arg := range resp.Array[1:] {
+		patterns = append(patterns, string(arg.Value))
+	}
+	if len(patterns) == 0 {
+		for pattern := range s.patterns {
+			patterns = append(patterns, pattern)
+		}
+	}
+	if len(patterns) == 0 {
+		if _, err := s.wb.WriteArgs("punsubscribe", "", s.subscriptions()); err != nil {
+			return err
+		}
+		return s.flush()
+	}
+	for _, pattern := range patterns {
+		s.pubsub.pUnsubscribe(s, pattern)
+		if _, err := s.wb.WriteArgs("punsubscribe", pattern, s.subscriptions()); err != nil {
+			return err
+		}
+	}
+	return s.flush()
+}
+
+func publish(s *Server, resp *Resp) error {
+	channel := string(resp.Array[1].Value)
+	message := string(resp.Array[2].Value)
+
+	receivers := s.pubsub.publish(s, channel, message)
+	return s.writeArgs(receivers)
+}
@@ -0,0 +1,104 @@
+package simpledb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPubSub_Publish(t *testing.T) {
+
+	server := newTestServer()
+	subWb, subRb := pipeConn(server)
+	pubWb, pubRb := pipeConn(server)
+
+	resp, err := call(subWb, subRb, "SUBSCRIBE", "news", "sport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReply(t, resp, "subscribe", "news", "1")
+	resp, err = subRb.HandleStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReply(t, resp, "subscribe", "sport", "2")
+	if _, err := call(subWb, subRb, "PSUBSCRIBE", "n*"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = call(subWb, subRb, "SET", "foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() {
+		t.Errorf("SET in subscribed mode: got %s, want error", resp.Value)
+	}
+
+	var tests = []struct {
+		channel   string
+		receivers string
+		// the kinds of the messages the subscriber gets
+		messages []string
+	}{
+		{"news", "2", []string{"message", "pmessage"}},
+		{"sport", "1", []string{"message"}},
+		{"nothing", "1", []string{"pmessage"}},
+		{"other", "0", nil},
+	}
+	for _, test := range tests {
+		resp, err := call(pubWb, pubRb, "PUBLISH", test.channel, "hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Value) != test.receivers {
+			t.Errorf("PUBLISH %s: got %s receivers, want %s", test.channel, resp.Value, test.receivers)
+		}
+		for _, kind := range test.messages {
+			message, err := subRb.HandleStream()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// a pmessage has the pattern before the channel
+			want := []string{kind, test.channel, "hello"}
+			if kind == "pmessage" {
+				want = []string{kind, "n*", test.channel, "hello"}
+			}
+			checkReply(t, message, want...)
+		}
+	}
+
+	// the pattern is left, the count goes down to it
+	resp, err = call(subWb, subRb, "UNSUBSCRIBE", "news", "sport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReply(t, resp, "unsubscribe", "news", "2")
+	resp, err = subRb.HandleStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReply(t, resp, "unsubscribe", "sport", "1")
+	resp, err = call(pubWb, pubRb, "PUBLISH", "news", "bye")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Value) != "1" {
+		t.Errorf("PUBLISH after UNSUBSCRIBE: got %s receivers, want 1", resp.Value)
+	}
+	resp, err = subRb.HandleStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReply(t, resp, "pmessage", "n*", "news", "bye")
+}
+
+// checkReply checks the elements of an array reply
+func checkReply(t *testing.T, resp *Resp, want ...string) {
+	t.Helper()
+	got := make([]string, len(resp.Array))
+	for i, e := range resp.Array {
+		got[i] = string(e.Value)
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
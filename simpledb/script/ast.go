@@ -0,0 +1,115 @@
+package script
+
+// expressions
+
+type expr interface{}
+
+type constExpr struct {
+	value Value
+}
+
+type nameExpr struct {
+	name string
+}
+
+type indexExpr struct {
+	obj, key expr
+	line     int
+}
+
+type callExpr struct {
+	fn     expr
+	method string // a:method(args) when not empty
+	args   []expr
+	line   int
+}
+
+type funcExpr struct {
+	name   string
+	params []string
+	body   *block
+}
+
+type binaryExpr struct {
+	op   string
+	l, r expr
+	line int
+}
+
+type unaryExpr struct {
+	op   string
+	x    expr
+	line int
+}
+
+type tableItem struct {
+	key   expr // nil for positional items
+	value expr
+}
+
+type tableExpr struct {
+	items []tableItem
+}
+
+// statements
+
+type stmt interface{}
+
+type block struct {
+	stmts []stmt
+}
+
+type localStmt struct {
+	names []string
+	exprs []expr
+}
+
+type assignStmt struct {
+	targets []expr
+	exprs   []expr
+	line    int
+}
+
+type callStmt struct {
+	call *callExpr
+}
+
+type doStmt struct {
+	body *block
+}
+
+type whileStmt struct {
+	cond expr
+	body *block
+}
+
+type repeatStmt struct {
+	body *block
+	cond expr
+}
+
+type ifStmt struct {
+	conds  []expr
+	blocks []*block
+	orElse *block
+}
+
+type numericForStmt struct {
+	name              string
+	start, stop, step expr
+	body              *block
+	line              int
+}
+
+type genericForStmt struct {
+	names []string
+	exprs []expr
+	body  *block
+	line  int
+}
+
+type returnStmt struct {
+	exprs []expr
+}
+
+type breakStmt struct{}
@@ -0,0 +1,709 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const maxCallDepth = 200
+
+// Error is raised by a script, by error() or by a failing builtin. Value is
+// the error object, usually a string.
+type Error struct {
+	Value Value
+}
+
+func (e *Error) Error() string {
+	if t, ok := e.Value.(*Table); ok {
+		if msg, ok := t.GetString("err").(string); ok {
+			return msg
+		}
+	}
+	return ToString(e.Value)
+}
+
+// abortError carries a Hook error through the script, pcall does not
+// catch it
+type abortError struct {
+	err error
+}
+
+func (e *abortError) Error() string {
+	return e.err.Error()
+}
+
+// Chunk is a compiled script
+type Chunk struct {
+	name string
+	body *block
+}
+
+// Compile parses src, name is used in error positions
+func Compile(name, src string) (*Chunk, error) {
+	l := &lexer{name: name, src: src, line: 1}
+	tokens, err := l.tokens()
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{name: name, tokens: tokens}
+	body, err := p.chunk()
+	if err != nil {
+		return nil, err
+	}
+	return &Chunk{name: name, body: body}, nil
+}
+
+// Interp runs chunks against its globals
+type Interp struct {
+	Globals *Table
+	// Hook is called on every loop iteration and function call, an error
+	// aborts the script, it is how the host enforces time limits
+	Hook func() error
+
+	name  string // running chunk, for error positions
+	depth int
+}
+
+// New returns an interpreter with the standard library loaded
+func New() *Interp {
+	in := &Interp{Globals: NewTable()}
+	openLibs(in)
+	return in
+}
+
+// Run executes a chunk and returns the values of its return statement
+func (in *Interp) Run(c *Chunk) ([]Value, error) {
+	in.name = c.name
+	in.depth = 0
+	env := newScope(nil)
+	f, values, err := in.execBlock(c.body, env)
+	if err != nil {
+		return nil, unwrapAbort(err)
+	}
+	if f == flowReturn {
+		return values, nil
+	}
+	return nil, nil
+}
+
+// Call calls a script or builtin function
+func (in *Interp) Call(fn Value, args ...Value) ([]Value, error) {
+	values, err := in.call(fn, args, 0)
+	return values, unwrapAbort(err)
+}
+
+func unwrapAbort(err error) error {
+	var abort *abortError
+	if errors.As(err, &abort) {
+		return abort.err
+	}
+	return err
+}
+
+// SetGlobal is a shortcut to define host values
+func (in *Interp) SetGlobal(name string, v Value) {
+	in.Globals.Set(name, v)
+}
+
+type cell struct {
+	v Value
+}
+
+type scope struct {
+	vars   map[string]*cell
+	parent *scope
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{vars: make(map[string]*cell), parent: parent}
+}
+
+func (s *scope) lookup(name string) *cell {
+	for ; s != nil; s = s.parent {
+		if c, ok := s.vars[name]; ok {
+			return c
+		}
+	}
+	return nil
+}
+
+type flow int
+
+const (
+	flowNormal flow = iota
+	flowBreak
+	flowReturn
+)
+
+func (in *Interp) errorf(line int, format string, args ...interface{}) error {
+	return &Error{Value: fmt.Sprintf("%s:%d: %s", in.name, line, fmt.Sprintf(format, args...))}
+}
+
+func (in *Interp) hook() error {
+	if in.Hook != nil {
+		if err := in.Hook(); err != nil {
+			return &abortError{err: err}
+		}
+	}
+	return nil
+}
+
+func (in *Interp) execBlock(b *block, env *scope) (flow, []Value, error) {
+	for _, s := range b.stmts {
+		f, values, err := in.exec(s, env)
+		if err != nil || f != flowNormal {
+			return f, values, err
+		}
+	}
+	return flowNormal, nil, nil
+}
+
+func (in *Interp) exec(s stmt, env *scope) (flow, []Value, error) {
+	switch s := s.(type) {
+	case *block:
+		// statements declared in the enclosing scope, see local function
+		return in.execBlock(s, env)
+
+	case *localStmt:
+		values, err := in.evalList(s.exprs, env)
+		if err != nil {
+			return flowNormal, nil, err
+		}
+		for i, name := range s.names {
+			var v Value
+			if i < len(values) {
+				v = values[i]
+			}
+			env.vars[name] = &cell{v: v}
+		}
+
+	case *assignStmt:
+		values, err := in.evalList(s.exprs, env)
+		if err != nil {
+			return flowNormal, nil, err
+		}
+		for i, target := range s.targets {
+			var v Value
+			if i < len(values) {
+				v = values[i]
+			}
+			if err := in.assign(target, v, env, s.line); err != nil {
+				return flowNormal, nil, err
+			}
+		}
+
+	case *callStmt:
+		if _, err := in.evalCall(s.call, env); err != nil {
+			return flowNormal, nil, err
+		}
+
+	case *doStmt:
+		return in.execBlock(s.body, newScope(env))
+
+	case *whileStmt:
+		for {
+			if err := in.hook(); err != nil {
+				return flowNormal, nil, err
+			}
+			cond, err := in.eval(s.cond, env)
+			if err != nil {
+				return flowNormal, nil, err
+			}
+			if !truthy(cond) {
+				break
+			}
+			f, values, err := in.execBlock(s.body, newScope(env))
+			if err != nil || f == flowReturn {
+				return f, values, err
+			}
+			if f == flowBreak {
+				break
+			}
+		}
+
+	case *repeatStmt:
+		for {
+			if err := in.hook(); err != nil {
+				return flowNormal, nil, err
+			}
+			body := newScope(env)
+			f, values, err := in.execBlock(s.body, body)
+			if err != nil || f == flowReturn {
+				return f, values, err
+			}
+			if f == flowBreak {
+				break
+			}
+			// the condition sees the locals of the body
+			cond, err := in.eval(s.cond, body)
+			if err != nil {
+				return flowNormal, nil, err
+			}
+			if truthy(cond) {
+				break
+			}
+		}
+
+	case *ifStmt:
+		for i, c := range s.conds {
+			cond, err := in.eval(c, env)
+			if err != nil {
+				return flowNormal, nil, err
+			}
+			if truthy(cond) {
+				return in.execBlock(s.blocks[i], newScope(env))
+			}
+		}
+		if s.orElse != nil {
+			return in.execBlock(s.orElse, newScope(env))
+		}
+
+	case *numericForStmt:
+		return in.execNumericFor(s, env)
+
+	case *genericForStmt:
+		return in.execGenericFor(s, env)
+
+	case *returnStmt:
+		values, err := in.evalList(s.exprs, env)
+		return flowReturn, values, err
+
+	case *breakStmt:
+		return flowBreak, nil, nil
+
+	default:
+		return flowNormal, nil, fmt.Errorf("unknown statement %T", s)
+	}
+	return flowNormal, nil, nil
+}
+
+func (in *Interp) execNumericFor(s *numericForStmt, env *scope) (flow, []Value, error) {
+	var bounds [3]float64
+	for i, e := range []expr{s.start, s.stop, s.step} {
+		if e == nil {
+			bounds[i] = 1
+			continue
+		}
+		v, err := in.eval(e, env)
+		if err != nil {
+			return flowNormal, nil, err
+		}
+		n, ok := ToNumber(v)
+		if !ok {
+			return flowNormal, nil, in.errorf(s.line, "'for' value must be a number")
+		}
+		bounds[i] = n
+	}
+	start, stop, step := bounds[0], bounds[1], bounds[2]
+	if step == 0 {
+		return flowNormal, nil, in.errorf(s.line, "'for' step is zero")
+	}
+	for i := start; step > 0 && i <= stop || step < 0 && i >= stop; i += step {
+		if err := in.hook(); err != nil {
+			return flowNormal, nil, err
+		}
+		body := newScope(env)
+		body.vars[s.name] = &cell{v: i}
+		f, values, err := in.execBlock(s.body, body)
+		if err != nil || f == flowReturn {
+			return f, values, err
+		}
+		if f == flowBreak {
+			break
+		}
+	}
+	return flowNormal, nil, nil
+}
+
+func (in *Interp) execGenericFor(s *genericForStmt, env *scope) (flow, []Value, error) {
+	values, err := in.evalList(s.exprs, env)
+	if err != nil {
+		return flowNormal, nil, err
+	}
+	values = append(values, nil, nil, nil)
+	iterator, state, control := values[0], values[1], values[2]
+	for {
+		if err := in.hook(); err != nil {
+			return flowNormal, nil, err
+		}
+		results, err := in.call(iterator, []Value{state, control}, s.line)
+		if err != nil {
+			return flowNormal, nil, err
+		}
+		if len(results) == 0 || results[0] == nil {
+			break
+		}
+		control = results[0]
+		body := newScope(env)
+		for i, name := range s.names {
+			var v Value
+			if i < len(results) {
+				v = results[i]
+			}
+			body.vars[name] = &cell{v: v}
+		}
+		f, values, err := in.execBlock(s.body, body)
+		if err != nil || f == flowReturn {
+			return f, values, err
+		}
+		if f == flowBreak {
+			break
+		}
+	}
+	return flowNormal, nil, nil
+}
+
+func (in *Interp) assign(target expr, v Value, env *scope, line int) error {
+	switch t := target.(type) {
+	case *nameExpr:
+		if c := env.lookup(t.name); c != nil {
+			c.v = v
+			return nil
+		}
+		return in.Globals.Set(t.name, v)
+	case *indexExpr:
+		obj, err := in.eval(t.obj, env)
+		if err != nil {
+			return err
+		}
+		key, err := in.eval(t.key, env)
+		if err != nil {
+			return err
+		}
+		table, ok := obj.(*Table)
+		if !ok {
+			return in.errorf(t.line, "attempt to index a %s value", typeName(obj))
+		}
+		if err := table.Set(key, v); err != nil {
+			return in.errorf(t.line, "%s", err.Error())
+		}
+		return nil
+	}
+	return in.errorf(line, "cannot assign to %T", target)
+}
+
+// evalList evaluates expressions, the last one expands to all its values
+func (in *Interp) evalList(exprs []expr, env *scope) ([]Value, error) {
+	var values []Value
+	for i, e := range exprs {
+		if call, ok := e.(*callExpr); ok && i == len(exprs)-1 {
+			results, err := in.evalCall(call, env)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, results...)
+			continue
+		}
+		v, err := in.eval(e, env)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (in *Interp) eval(e expr, env *scope) (Value, error) {
+	switch e := e.(type) {
+	case *constExpr:
+		return e.value, nil
+
+	case *nameExpr:
+		if c := env.lookup(e.name); c != nil {
+			return c.v, nil
+		}
+		return in.Globals.GetString(e.name), nil
+
+	case *indexExpr:
+		obj, err := in.eval(e.obj, env)
+		if err != nil {
+			return nil, err
+		}
+		key, err := in.eval(e.key, env)
+		if err != nil {
+			return nil, err
+		}
+		return in.index(obj, key, e.line)
+
+	case *callExpr:
+		results, err := in.evalCall(e, env)
+		if err != nil || len(results) == 0 {
+			return nil, err
+		}
+		return results[0], nil
+
+	case *funcExpr:
+		return &Function{fn: e, env: env}, nil
+
+	case *tableExpr:
+		t := NewTable()
+		for i, item := range e.items {
+			if item.key == nil {
+				if call, ok := item.value.(*callExpr); ok && i == len(e.items)-1 {
+					results, err := in.evalCall(call, env)
+					if err != nil {
+						return nil, err
+					}
+					for _, v := range results {
+						t.Append(v)
+					}
+					continue
+				}
+				v, err := in.eval(item.value, env)
+				if err != nil {
+					return nil, err
+				}
+				t.Set(float64(t.Len()+1), v)
+				continue
+			}
+			k, err := in.eval(item.key, env)
+			if err != nil {
+				return nil, err
+			}
+			v, err := in.eval(item.value, env)
+			if err != nil {
+				return nil, err
+			}
+			if err := t.Set(k, v); err != nil {
+				return nil, err
+			}
+		}
+		return t, nil
+
+	case *unaryExpr:
+		x, err := in.eval(e.x, env)
+		if err != nil {
+			return nil, err
+		}
+		switch e.op {
+		case "not":
+			return !truthy(x), nil
+		case "-":
+			n, ok := ToNumber(x)
+			if !ok {
+				return nil, in.errorf(e.line, "attempt to perform arithmetic on a %s value", typeName(x))
+			}
+			return -n, nil
+		case "#":
+			switch x := x.(type) {
+			case string:
+				return float64(len(x)), nil
+			case *Table:
+				return float64(x.Len()), nil
+			}
+			return nil, in.errorf(e.line, "attempt to get length of a %s value", typeName(x))
+		}
+
+	case *binaryExpr:
+		return in.evalBinary(e, env)
+	}
+	return nil, fmt.Errorf("unknown expression %T", e)
+}
+
+func (in *Interp) index(obj, key Value, line int) (Value, error) {
+	switch o := obj.(type) {
+	case *Table:
+		return o.Get(key), nil
+	case string:
+		// strings index the string library, for s:upper()
+		if lib, ok := in.Globals.GetString("string").(*Table); ok {
+			return lib.Get(key), nil
+		}
+	}
+	return nil, in.errorf(line, "attempt to index a %s value", typeName(obj))
+}
+
+func (in *Interp) evalBinary(e *binaryExpr, env *scope) (Value, error) {
+	switch e.op {
+	case "()":
+		return in.eval(e.l, env)
+	case "and":
+		l, err := in.eval(e.l, env)
+		if err != nil || !truthy(l) {
+			return l, err
+		}
+		return in.eval(e.r, env)
+	case "or":
+		l, err := in.eval(e.l, env)
+		if err != nil || truthy(l) {
+			return l, err
+		}
+		return in.eval(e.r, env)
+	}
+
+	l, err := in.eval(e.l, env)
+	if err != nil {
+		return nil, err
+	}
+	r, err := in.eval(e.r, env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "==":
+		return equals(l, r), nil
+	case "~=":
+		return !equals(l, r), nil
+	case "<", "<=", ">", ">=":
+		return in.compare(e.op, l, r, e.line)
+	case "..":
+		ls, lok := concatString(l)
+		rs, rok := concatString(r)
+		if !lok || !rok {
+			bad := l
+			if lok {
+				bad = r
+			}
+			return nil, in.errorf(e.line, "attempt to concatenate a %s value", typeName(bad))
+		}
+		return ls + rs, nil
+	}
+
+	a, aok := ToNumber(l)
+	b, bok := ToNumber(r)
+	if !aok || !bok {
+		bad := l
+		if aok {
+			bad = r
+		}
+		return nil, in.errorf(e.line, "attempt to perform arithmetic on a %s value", typeName(bad))
+	}
+	switch e.op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		return a / b, nil
+	case "%":
+		return a - math.Floor(a/b)*b, nil
+	case "^":
+		return math.Pow(a, b), nil
+	}
+	return nil, in.errorf(e.line, "unknown operator %s", e.op)
+}
+
+func concatString(v Value) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64:
+		return formatNumber(v), true
+	}
+	return "", false
+}
+
+func equals(l, r Value) bool {
+	if _, ok := l.(GoFunction); ok {
+		return false
+	}
+	if _, ok := r.(GoFunction); ok {
+		return false
+	}
+	return l == r
+}
+
+func (in *Interp) compare(op string, l, r Value, line int) (Value, error) {
+	var less, eq bool
+	switch a := l.(type) {
+	case float64:
+		b, ok := r.(float64)
+		if !ok {
+			return nil, in.errorf(line, "attempt to compare number with %s", typeName(r))
+		}
+		less, eq = a < b, a == b
+	case string:
+		b, ok := r.(string)
+		if !ok {
+			return nil, in.errorf(line, "attempt to compare string with %s", typeName(r))
+		}
+		less, eq = a < b, a == b
+	default:
+		return nil, in.errorf(line, "attempt to compare two %s values", typeName(l))
+	}
+	switch op {
+	case "<":
+		return less, nil
+	case "<=":
+		return less || eq, nil
+	case ">":
+		return !less && !eq, nil
+	}
+	return !less, nil
+}
+
+func (in *Interp) evalCall(e *callExpr, env *scope) ([]Value, error) {
+	fn, err := in.eval(e.fn, env)
+	if err != nil {
+		return nil, err
+	}
+	var args []Value
+	if e.method != "" {
+		self := fn
+		if fn, err = in.index(self, e.method, e.line); err != nil {
+			return nil, err
+		}
+		args = append(args, self)
+	}
+	values, err := in.evalList(e.args, env)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, values...)
+	return in.call(fn, args, e.line)
+}
+
+func (in *Interp) call(fn Value, args []Value, line int) ([]Value, error) {
+	if err := in.hook(); err != nil {
+		return nil, err
+	}
+	in.depth++
+	defer func() { in.depth-- }()
+	if in.depth > maxCallDepth {
+		return nil, in.errorf(line, "stack overflow")
+	}
+
+	switch f := fn.(type) {
+	case GoFunction:
+		results, err := f(in, args)
+		if err != nil {
+			var (
+				scriptErr *Error
+				abort     *abortError
+			)
+			if errors.As(err, &scriptErr) || errors.As(err, &abort) {
+				return nil, err
+			}
+			// position builtin failures like lua does
+			msg := err.Error()
+			if !strings.HasPrefix(msg, in.name+":") {
+				msg = fmt.Sprintf("%s:%d: %s", in.name, line, msg)
+			}
+			return nil, &Error{Value: msg}
+		}
+		return results, nil
+	case *Function:
+		env := newScope(f.env)
+		for i, param := range f.fn.params {
+			var v Value
+			if i < len(args) {
+				v = args[i]
+			}
+			env.vars[param] = &cell{v: v}
+		}
+		flow, values, err := in.execBlock(f.fn.body, env)
+		if err != nil {
+			return nil, err
+		}
+		if flow == flowReturn {
+			return values, nil
+		}
+		return nil, nil
+	}
+	return nil, in.errorf(line, "attempt to call a %s value", typeName(fn))
+}
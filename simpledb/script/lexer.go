@@ -0,0 +1,267 @@
+package script
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokName
+	tokNumber
+	tokString
+	tokKeyword
+	tokOp
+)
+
+type token struct {
+	typ  tokenType
+	text string  // name, keyword, operator or string value
+	num  float64 // value of a number
+	line int
+}
+
+func (t token) String() string {
+	switch t.typ {
+	case tokEOF:
+		return "<eof>"
+	case tokString:
+		return strconv.Quote(t.text)
+	case tokNumber:
+		return strconv.FormatFloat(t.num, 'g', -1, 64)
+	}
+	return t.text
+}
+
+var keywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true,
+	"end": true, "false": true, "for": true, "function": true, "if": true,
+	"in": true, "local": true, "nil": true, "not": true, "or": true,
+	"repeat": true, "return": true, "then": true, "true": true,
+	"until": true, "while": true,
+}
+
+// operators, longest first
+var operators = []string{
+	"...", "..", "==", "~=", "<=", ">=",
+	"+", "-", "*", "/", "%", "^", "#", "<", ">", "=",
+	"(", ")", "{", "}", "[", "]", ";", ":", ",", ".",
+}
+
+type lexer struct {
+	name string
+	src  string
+	pos  int
+	line int
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", l.name, l.line, fmt.Sprintf(format, args...))
+}
+
+// tokens splits the whole source, the parser works on the slice
+func (l *lexer) tokens() ([]token, error) {
+	var tokens []token
+	for {
+		t, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+		if t.typ == tokEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) skipSpaceAndComments() error {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case strings.HasPrefix(l.src[l.pos:], "--"):
+			l.pos += 2
+			if level, ok := l.longBracket(); ok {
+				if _, err := l.readLong(level); err != nil {
+					return err
+				}
+				continue
+			}
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// longBracket reports whether a [[ or [==[ opening starts at pos
+func (l *lexer) longBracket() (int, bool) {
+	if l.pos >= len(l.src) || l.src[l.pos] != '[' {
+		return 0, false
+	}
+	i := l.pos + 1
+	for i < len(l.src) && l.src[i] == '=' {
+		i++
+	}
+	if i < len(l.src) && l.src[i] == '[' {
+		return i - l.pos - 1, true
+	}
+	return 0, false
+}
+
+func (l *lexer) readLong(level int) (string, error) {
+	l.pos += level + 2
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := strings.Index(l.src[l.pos:], closing)
+	if end < 0 {
+		return "", l.errorf("unfinished long string or comment")
+	}
+	s := l.src[l.pos : l.pos+end]
+	l.line += strings.Count(s, "\n")
+	l.pos += end + len(closing)
+	// a newline right after the opening bracket is skipped
+	if strings.HasPrefix(s, "\r\n") {
+		s = s[2:]
+	} else if strings.HasPrefix(s, "\n") {
+		s = s[1:]
+	}
+	return s, nil
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func (l *lexer) next() (token, error) {
+	if err := l.skipSpaceAndComments(); err != nil {
+		return token{}, err
+	}
+	if l.pos >= len(l.src) {
+		return token{typ: tokEOF, line: l.line}, nil
+	}
+	c := l.src[l.pos]
+	start := l.pos
+
+	switch {
+	case isLetter(c):
+		for l.pos < len(l.src) && (isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		word := l.src[start:l.pos]
+		if keywords[word] {
+			return token{typ: tokKeyword, text: word, line: l.line}, nil
+		}
+		return token{typ: tokName, text: word, line: l.line}, nil
+
+	case isDigit(c) || c == '.' && l.pos+1 < len(l.src) && isDigit(l.src[l.pos+1]):
+		return l.number()
+
+	case c == '"' || c == '\'':
+		return l.quoted(c)
+
+	case c == '[':
+		if level, ok := l.longBracket(); ok {
+			s, err := l.readLong(level)
+			if err != nil {
+				return token{}, err
+			}
+			return token{typ: tokString, text: s, line: l.line}, nil
+		}
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return token{typ: tokOp, text: op, line: l.line}, nil
+		}
+	}
+	return token{}, l.errorf("unexpected symbol near '%c'", c)
+}
+
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	if strings.HasPrefix(l.src[l.pos:], "0x") || strings.HasPrefix(l.src[l.pos:], "0X") {
+		l.pos += 2
+		for l.pos < len(l.src) && strings.IndexByte("0123456789abcdefABCDEF", l.src[l.pos]) >= 0 {
+			l.pos++
+		}
+		n, err := strconv.ParseInt(l.src[start+2:l.pos], 16, 64)
+		if err != nil {
+			return token{}, l.errorf("malformed number near '%s'", l.src[start:l.pos])
+		}
+		return token{typ: tokNumber, num: float64(n), line: l.line}, nil
+	}
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if isDigit(c) || c == '.' {
+			l.pos++
+		} else if (c == 'e' || c == 'E') && l.pos+1 < len(l.src) {
+			l.pos++
+			if l.src[l.pos] == '+' || l.src[l.pos] == '-' {
+				l.pos++
+			}
+		} else {
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(l.src[start:l.pos], 64)
+	if err != nil {
+		return token{}, l.errorf("malformed number near '%s'", l.src[start:l.pos])
+	}
+	return token{typ: tokNumber, num: n, line: l.line}, nil
+}
+
+func (l *lexer) quoted(quote byte) (token, error) {
+	var b strings.Builder
+	l.pos++
+	for {
+		if l.pos >= len(l.src) || l.src[l.pos] == '\n' {
+			return token{}, l.errorf("unfinished string")
+		}
+		c := l.src[l.pos]
+		l.pos++
+		if c == quote {
+			return token{typ: tokString, text: b.String(), line: l.line}, nil
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		if l.pos >= len(l.src) {
+			return token{}, l.errorf("unfinished string")
+		}
+		e := l.src[l.pos]
+		l.pos++
+		switch e {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '0':
+			b.WriteByte(0)
+		case '\\', '"', '\'':
+			b.WriteByte(e)
+		case '\n':
+			l.line++
+			b.WriteByte('\n')
+		default:
+			return token{}, l.errorf("invalid escape sequence '\\%c'", e)
+		}
+	}
+}
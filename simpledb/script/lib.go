@@ -0,0 +1,545 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// the standard library, a subset of lua's base, string, table and math
+
+func openLibs(in *Interp) {
+	base := map[string]GoFunction{
+		"type":     baseType,
+		"tostring": baseToString,
+		"tonumber": baseToNumber,
+		"pairs":    basePairs,
+		"ipairs":   baseIPairs,
+		"error":    baseError,
+		"assert":   baseAssert,
+		"pcall":    basePCall,
+		"unpack":   tableUnpack,
+	}
+	for name, fn := range base {
+		in.SetGlobal(name, fn)
+	}
+
+	in.SetGlobal("string", newLib(map[string]GoFunction{
+		"len":    stringLen,
+		"sub":    stringSub,
+		"upper":  stringUpper,
+		"lower":  stringLower,
+		"rep":    stringRep,
+		"format": stringFormat,
+		"find":   stringFind,
+		"byte":   stringByte,
+		"char":   stringChar,
+	}))
+	in.SetGlobal("table", newLib(map[string]GoFunction{
+		"insert": tableInsert,
+		"remove": tableRemove,
+		"concat": tableConcat,
+		"getn":   tableGetn,
+		"unpack": tableUnpack,
+	}))
+	mathLib := newLib(map[string]GoFunction{
+		"floor": mathFloor,
+		"ceil":  mathCeil,
+		"abs":   mathAbs,
+		"max":   mathMax,
+		"min":   mathMin,
+		"sqrt":  mathSqrt,
+	})
+	mathLib.Set("huge", math.Inf(1))
+	mathLib.Set("pi", math.Pi)
+	in.SetGlobal("math", mathLib)
+}
+
+func newLib(fns map[string]GoFunction) *Table {
+	t := NewTable()
+	for name, fn := range fns {
+		t.Set(name, fn)
+	}
+	return t
+}
+
+func arg(args []Value, i int) Value {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
+
+func checkNumber(args []Value, i int, fn string) (float64, error) {
+	n, ok := ToNumber(arg(args, i))
+	if !ok {
+		return 0, fmt.Errorf("bad argument #%d to '%s' (number expected, got %s)", i+1, fn, typeName(arg(args, i)))
+	}
+	return n, nil
+}
+
+func checkString(args []Value, i int, fn string) (string, error) {
+	switch v := arg(args, i).(type) {
+	case string:
+		return v, nil
+	case float64:
+		return formatNumber(v), nil
+	}
+	return "", fmt.Errorf("bad argument #%d to '%s' (string expected, got %s)", i+1, fn, typeName(arg(args, i)))
+}
+
+func checkTable(args []Value, i int, fn string) (*Table, error) {
+	t, ok := arg(args, i).(*Table)
+	if !ok {
+		return nil, fmt.Errorf("bad argument #%d to '%s' (table expected, got %s)", i+1, fn, typeName(arg(args, i)))
+	}
+	return t, nil
+}
+
+func optNumber(args []Value, i int, fn string, def float64) (float64, error) {
+	if arg(args, i) == nil {
+		return def, nil
+	}
+	return checkNumber(args, i, fn)
+}
+
+// base
+
+func baseType(in *Interp, args []Value) ([]Value, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("bad argument #1 to 'type' (value expected)")
+	}
+	return []Value{typeName(args[0])}, nil
+}
+
+func baseToString(in *Interp, args []Value) ([]Value, error) {
+	return []Value{ToString(arg(args, 0))}, nil
+}
+
+func baseToNumber(in *Interp, args []Value) ([]Value, error) {
+	if n, ok := ToNumber(arg(args, 0)); ok {
+		return []Value{n}, nil
+	}
+	return []Value{nil}, nil
+}
+
+func basePairs(in *Interp, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 0, "pairs")
+	if err != nil {
+		return nil, err
+	}
+	keys := t.keys()
+	i := 0
+	next := GoFunction(func(in *Interp, args []Value) ([]Value, error) {
+		for i < len(keys) {
+			k := keys[i]
+			i++
+			if v := t.Get(k); v != nil {
+				return []Value{k, v}, nil
+			}
+		}
+		return []Value{nil}, nil
+	})
+	return []Value{next, t, nil}, nil
+}
+
+func baseIPairs(in *Interp, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 0, "ipairs")
+	if err != nil {
+		return nil, err
+	}
+	next := GoFunction(func(in *Interp, args []Value) ([]Value, error) {
+		i, _ := ToNumber(arg(args, 1))
+		v := t.Get(i + 1)
+		if v == nil {
+			return []Value{nil}, nil
+		}
+		return []Value{i + 1, v}, nil
+	})
+	return []Value{next, t, float64(0)}, nil
+}
+
+func baseError(in *Interp, args []Value) ([]Value, error) {
+	return nil, &Error{Value: arg(args, 0)}
+}
+
+func baseAssert(in *Interp, args []Value) ([]Value, error) {
+	if truthy(arg(args, 0)) {
+		return args, nil
+	}
+	if msg := arg(args, 1); msg != nil {
+		return nil, &Error{Value: msg}
+	}
+	return nil, fmt.Errorf("assertion failed!")
+}
+
+func basePCall(in *Interp, args []Value) ([]Value, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("bad argument #1 to 'pcall' (value expected)")
+	}
+	results, err := in.call(args[0], args[1:], 0)
+	if err != nil {
+		var scriptErr *Error
+		if !errors.As(err, &scriptErr) {
+			// aborted by the host, not catchable
+			return nil, err
+		}
+		return []Value{false, scriptErr.Value}, nil
+	}
+	return append([]Value{true}, results...), nil
+}
+
+// string
+
+func stringLen(in *Interp, args []Value) ([]Value, error) {
+	s, err := checkString(args, 0, "len")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{float64(len(s))}, nil
+}
+
+// strIndex converts a lua string position, negative from the end, into a
+// 0 based offset clamped to the string
+func strIndex(i float64, n int) int {
+	p := int(i)
+	if p < 0 {
+		p = n + p + 1
+	}
+	if p < 1 {
+		p = 1
+	}
+	if p > n+1 {
+		p = n + 1
+	}
+	return p - 1
+}
+
+func stringSub(in *Interp, args []Value) ([]Value, error) {
+	s, err := checkString(args, 0, "sub")
+	if err != nil {
+		return nil, err
+	}
+	i, err := optNumber(args, 1, "sub", 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := optNumber(args, 2, "sub", -1)
+	if err != nil {
+		return nil, err
+	}
+	start := strIndex(i, len(s))
+	end := strIndex(j, len(s)) + 1
+	if end > len(s) {
+		end = len(s)
+	}
+	if start >= end {
+		return []Value{""}, nil
+	}
+	return []Value{s[start:end]}, nil
+}
+
+func stringUpper(in *Interp, args []Value) ([]Value, error) {
+	s, err := checkString(args, 0, "upper")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{strings.ToUpper(s)}, nil
+}
+
+func stringLower(in *Interp, args []Value) ([]Value, error) {
+	s, err := checkString(args, 0, "lower")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{strings.ToLower(s)}, nil
+}
+
+func stringRep(in *Interp, args []Value) ([]Value, error) {
+	s, err := checkString(args, 0, "rep")
+	if err != nil {
+		return nil, err
+	}
+	n, err := checkNumber(args, 1, "rep")
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		n = 0
+	}
+	if float64(len(s))*n > 512*1024*1024 {
+		return nil, fmt.Errorf("resulting string too large")
+	}
+	return []Value{strings.Repeat(s, int(n))}, nil
+}
+
+// stringFormat supports %d %i %s %q %f %g %x %X %c and %%
+func stringFormat(in *Interp, args []Value) ([]Value, error) {
+	format, err := checkString(args, 0, "format")
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	next := 1
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		j := i + 1
+		for j < len(format) && strings.IndexByte("-+ #0123456789.", format[j]) >= 0 {
+			j++
+		}
+		if j >= len(format) {
+			return nil, fmt.Errorf("invalid option '%%' to 'format'")
+		}
+		spec, verb := format[i+1:j], format[j]
+		i = j
+		switch verb {
+		case '%':
+			b.WriteByte('%')
+			continue
+		case 'd', 'i', 'x', 'X', 'c':
+			n, err := checkNumber(args, next, "format")
+			if err != nil {
+				return nil, err
+			}
+			if verb == 'i' {
+				verb = 'd'
+			}
+			fmt.Fprintf(&b, "%"+spec+string(verb), int64(n))
+		case 'f', 'g', 'e', 'G', 'E':
+			n, err := checkNumber(args, next, "format")
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, "%"+spec+string(verb), n)
+		case 's':
+			fmt.Fprintf(&b, "%"+spec+"s", ToString(arg(args, next)))
+		case 'q':
+			s, err := checkString(args, next, "format")
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, "%q", s)
+		default:
+			return nil, fmt.Errorf("invalid option '%%%c' to 'format'", verb)
+		}
+		next++
+	}
+	return []Value{b.String()}, nil
+}
+
+// stringFind only does plain searches, patterns are not supported
+func stringFind(in *Interp, args []Value) ([]Value, error) {
+	s, err := checkString(args, 0, "find")
+	if err != nil {
+		return nil, err
+	}
+	sub, err := checkString(args, 1, "find")
+	if err != nil {
+		return nil, err
+	}
+	init, err := optNumber(args, 2, "find", 1)
+	if err != nil {
+		return nil, err
+	}
+	start := strIndex(init, len(s))
+	i := strings.Index(s[start:], sub)
+	if i < 0 {
+		return []Value{nil}, nil
+	}
+	return []Value{float64(start + i + 1), float64(start + i + len(sub))}, nil
+}
+
+func stringByte(in *Interp, args []Value) ([]Value, error) {
+	s, err := checkString(args, 0, "byte")
+	if err != nil {
+		return nil, err
+	}
+	i, err := optNumber(args, 1, "byte", 1)
+	if err != nil {
+		return nil, err
+	}
+	p := strIndex(i, len(s))
+	if p >= len(s) {
+		return nil, nil
+	}
+	return []Value{float64(s[p])}, nil
+}
+
+func stringChar(in *Interp, args []Value) ([]Value, error) {
+	b := make([]byte, len(args))
+	for i := range args {
+		n, err := checkNumber(args, i, "char")
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 || n > 255 {
+			return nil, fmt.Errorf("bad argument #%d to 'char' (value out of range)", i+1)
+		}
+		b[i] = byte(n)
+	}
+	return []Value{string(b)}, nil
+}
+
+// table
+
+func tableInsert(in *Interp, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 0, "insert")
+	if err != nil {
+		return nil, err
+	}
+	switch len(args) {
+	case 2:
+		t.Append(args[1])
+	case 3:
+		pos, err := checkNumber(args, 1, "insert")
+		if err != nil {
+			return nil, err
+		}
+		n := t.Len()
+		if pos < 1 || int(pos) > n+1 {
+			return nil, fmt.Errorf("bad argument #2 to 'insert' (position out of bounds)")
+		}
+		for i := n; i >= int(pos); i-- {
+			t.Set(float64(i+1), t.Get(float64(i)))
+		}
+		t.Set(pos, args[2])
+	default:
+		return nil, fmt.Errorf("wrong number of arguments to 'insert'")
+	}
+	return nil, nil
+}
+
+func tableRemove(in *Interp, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 0, "remove")
+	if err != nil {
+		return nil, err
+	}
+	n := t.Len()
+	pos, err := optNumber(args, 1, "remove", float64(n))
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return []Value{nil}, nil
+	}
+	if pos < 1 || int(pos) > n {
+		return nil, fmt.Errorf("bad argument #2 to 'remove' (position out of bounds)")
+	}
+	v := t.Get(pos)
+	for i := int(pos); i < n; i++ {
+		t.Set(float64(i), t.Get(float64(i+1)))
+	}
+	t.Set(float64(n), nil)
+	return []Value{v}, nil
+}
+
+func tableConcat(in *Interp, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 0, "concat")
+	if err != nil {
+		return nil, err
+	}
+	sep := ""
+	if arg(args, 1) != nil {
+		if sep, err = checkString(args, 1, "concat"); err != nil {
+			return nil, err
+		}
+	}
+	parts := make([]string, 0, t.Len())
+	for i := 1; i <= t.Len(); i++ {
+		s, ok := concatString(t.Get(float64(i)))
+		if !ok {
+			return nil, fmt.Errorf("invalid value (at index %d) in table for 'concat'", i)
+		}
+		parts = append(parts, s)
+	}
+	return []Value{strings.Join(parts, sep)}, nil
+}
+
+func tableGetn(in *Interp, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 0, "getn")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{float64(t.Len())}, nil
+}
+
+func tableUnpack(in *Interp, args []Value) ([]Value, error) {
+	t, err := checkTable(args, 0, "unpack")
+	if err != nil {
+		return nil, err
+	}
+	values := make([]Value, 0, t.Len())
+	for i := 1; i <= t.Len(); i++ {
+		values = append(values, t.Get(float64(i)))
+	}
+	return values, nil
+}
+
+// math
+
+func mathFloor(in *Interp, args []Value) ([]Value, error) {
+	n, err := checkNumber(args, 0, "floor")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{math.Floor(n)}, nil
+}
+
+func mathCeil(in *Interp, args []Value) ([]Value, error) {
+	n, err := checkNumber(args, 0, "ceil")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{math.Ceil(n)}, nil
+}
+
+func mathAbs(in *Interp, args []Value) ([]Value, error) {
+	n, err := checkNumber(args, 0, "abs")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{math.Abs(n)}, nil
+}
+
+func mathSqrt(in *Interp, args []Value) ([]Value, error) {
+	n, err := checkNumber(args, 0, "sqrt")
+	if err != nil {
+		return nil, err
+	}
+	return []Value{math.Sqrt(n)}, nil
+}
+
+func mathMax(in *Interp, args []Value) ([]Value, error) {
+	max, err := checkNumber(args, 0, "max")
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(args); i++ {
+		n, err := checkNumber(args, i, "max")
+		if err != nil {
+			return nil, err
+		}
+		max = math.Max(max, n)
+	}
+	return []Value{max}, nil
+}
+
+func mathMin(in *Interp, args []Value) ([]Value, error) {
+	min, err := checkNumber(args, 0, "min")
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(args); i++ {
+		n, err := checkNumber(args, i, "min")
+		if err != nil {
+			return nil, err
+		}
+		min = math.Min(min, n)
+	}
+	return []Value{min}, nil
+}
@@ -0,0 +1,609 @@
+package script
+
+import "fmt"
+
+type parser struct {
+	name   string
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.name, p.peek().line, fmt.Sprintf(format, args...))
+}
+
+// is reports whether the next token is the keyword or operator text
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return (t.typ == tokKeyword || t.typ == tokOp) && t.text == text
+}
+
+func (p *parser) accept(text string) bool {
+	if p.is(text) {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("'%s' expected near '%s'", text, p.peek())
+	}
+	return nil
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.peek()
+	if t.typ != tokName {
+		return "", p.errorf("<name> expected near '%s'", t)
+	}
+	p.advance()
+	return t.text, nil
+}
+
+// blockEnd reports whether the next token closes a block
+func (p *parser) blockEnd() bool {
+	t := p.peek()
+	if t.typ == tokEOF {
+		return true
+	}
+	return t.typ == tokKeyword && (t.text == "end" || t.text == "else" ||
+		t.text == "elseif" || t.text == "until")
+}
+
+func (p *parser) chunk() (*block, error) {
+	b, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().typ != tokEOF {
+		return nil, p.errorf("'<eof>' expected near '%s'", p.peek())
+	}
+	return b, nil
+}
+
+func (p *parser) block() (*block, error) {
+	b := &block{}
+	for !p.blockEnd() {
+		if p.accept(";") {
+			continue
+		}
+		if p.is("return") {
+			s, err := p.returnStmt()
+			if err != nil {
+				return nil, err
+			}
+			b.stmts = append(b.stmts, s)
+			p.accept(";")
+			if !p.blockEnd() {
+				return nil, p.errorf("'end' expected near '%s'", p.peek())
+			}
+			break
+		}
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		b.stmts = append(b.stmts, s)
+	}
+	return b, nil
+}
+
+func (p *parser) returnStmt() (stmt, error) {
+	p.advance()
+	if p.blockEnd() || p.is(";") {
+		return &returnStmt{}, nil
+	}
+	exprs, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	return &returnStmt{exprs: exprs}, nil
+}
+
+func (p *parser) statement() (stmt, error) {
+	t := p.peek()
+	if t.typ == tokKeyword {
+		switch t.text {
+		case "local":
+			p.advance()
+			if p.accept("function") {
+				name, err := p.ident()
+				if err != nil {
+					return nil, err
+				}
+				fn, err := p.funcBody(name)
+				if err != nil {
+					return nil, err
+				}
+				// declared before the body runs, so it can recurse
+				return &block{stmts: []stmt{
+					&localStmt{names: []string{name}},
+					&assignStmt{targets: []expr{&nameExpr{name}}, exprs: []expr{fn}, line: t.line},
+				}}, nil
+			}
+			var names []string
+			for {
+				name, err := p.ident()
+				if err != nil {
+					return nil, err
+				}
+				names = append(names, name)
+				if !p.accept(",") {
+					break
+				}
+			}
+			var exprs []expr
+			if p.accept("=") {
+				var err error
+				if exprs, err = p.exprList(); err != nil {
+					return nil, err
+				}
+			}
+			return &localStmt{names: names, exprs: exprs}, nil
+
+		case "function":
+			p.advance()
+			name, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			var target expr = &nameExpr{name}
+			fullName := name
+			method := false
+			for p.is(".") || p.is(":") {
+				method = p.advance().text == ":"
+				field, err := p.ident()
+				if err != nil {
+					return nil, err
+				}
+				fullName += "." + field
+				target = &indexExpr{obj: target, key: &constExpr{field}, line: t.line}
+				if method {
+					break
+				}
+			}
+			fn, err := p.funcBody(fullName)
+			if err != nil {
+				return nil, err
+			}
+			if method {
+				fn.params = append([]string{"self"}, fn.params...)
+			}
+			return &assignStmt{targets: []expr{target}, exprs: []expr{fn}, line: t.line}, nil
+
+		case "do":
+			p.advance()
+			body, err := p.block()
+			if err != nil {
+				return nil, err
+			}
+			return &doStmt{body: body}, p.expect("end")
+
+		case "while":
+			p.advance()
+			cond, err := p.expr(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("do"); err != nil {
+				return nil, err
+			}
+			body, err := p.block()
+			if err != nil {
+				return nil, err
+			}
+			return &whileStmt{cond: cond, body: body}, p.expect("end")
+
+		case "repeat":
+			p.advance()
+			body, err := p.block()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("until"); err != nil {
+				return nil, err
+			}
+			cond, err := p.expr(0)
+			if err != nil {
+				return nil, err
+			}
+			return &repeatStmt{body: body, cond: cond}, nil
+
+		case "if":
+			return p.ifStmt()
+
+		case "for":
+			return p.forStmt()
+
+		case "break":
+			p.advance()
+			return &breakStmt{}, nil
+		}
+	}
+
+	// assignment or call
+	e, err := p.suffixedExpr()
+	if err != nil {
+		return nil, err
+	}
+	if call, ok := e.(*callExpr); ok && !p.is("=") && !p.is(",") {
+		return &callStmt{call: call}, nil
+	}
+	targets := []expr{e}
+	for p.accept(",") {
+		e, err := p.suffixedExpr()
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, e)
+	}
+	for _, target := range targets {
+		switch target.(type) {
+		case *nameExpr, *indexExpr:
+		default:
+			return nil, p.errorf("syntax error near '%s'", p.peek())
+		}
+	}
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+	exprs, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	return &assignStmt{targets: targets, exprs: exprs, line: t.line}, nil
+}
+
+func (p *parser) ifStmt() (stmt, error) {
+	s := &ifStmt{}
+	p.advance()
+	for {
+		cond, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("then"); err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		s.conds = append(s.conds, cond)
+		s.blocks = append(s.blocks, body)
+		if !p.accept("elseif") {
+			break
+		}
+	}
+	if p.accept("else") {
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		s.orElse = body
+	}
+	return s, p.expect("end")
+}
+
+func (p *parser) forStmt() (stmt, error) {
+	line := p.advance().line
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if p.accept("=") {
+		s := &numericForStmt{name: name, line: line}
+		if s.start, err = p.expr(0); err != nil {
+			return nil, err
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		if s.stop, err = p.expr(0); err != nil {
+			return nil, err
+		}
+		if p.accept(",") {
+			if s.step, err = p.expr(0); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect("do"); err != nil {
+			return nil, err
+		}
+		if s.body, err = p.block(); err != nil {
+			return nil, err
+		}
+		return s, p.expect("end")
+	}
+
+	s := &genericForStmt{names: []string{name}, line: line}
+	for p.accept(",") {
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		s.names = append(s.names, name)
+	}
+	if err := p.expect("in"); err != nil {
+		return nil, err
+	}
+	if s.exprs, err = p.exprList(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("do"); err != nil {
+		return nil, err
+	}
+	if s.body, err = p.block(); err != nil {
+		return nil, err
+	}
+	return s, p.expect("end")
+}
+
+func (p *parser) funcBody(name string) (*funcExpr, error) {
+	fn := &funcExpr{name: name}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if !p.is(")") {
+		for {
+			if p.is("...") {
+				return nil, p.errorf("varargs are not supported")
+			}
+			param, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			fn.params = append(fn.params, param)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	fn.body = body
+	return fn, p.expect("end")
+}
+
+func (p *parser) exprList() ([]expr, error) {
+	var exprs []expr
+	for {
+		e, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+		if !p.accept(",") {
+			return exprs, nil
+		}
+	}
+}
+
+// binary operator priorities, left and right
+var binaryPriority = map[string][2]int{
+	"or": {1, 1}, "and": {2, 2},
+	"<": {3, 3}, ">": {3, 3}, "<=": {3, 3}, ">=": {3, 3}, "~=": {3, 3}, "==": {3, 3},
+	"..": {9, 8},
+	"+":  {10, 10}, "-": {10, 10},
+	"*": {11, 11}, "/": {11, 11}, "%": {11, 11},
+	"^": {14, 13},
+}
+
+const unaryPriority = 12
+
+// expr parses operators binding tighter than limit
+func (p *parser) expr(limit int) (expr, error) {
+	var (
+		left expr
+		err  error
+	)
+	t := p.peek()
+	if p.is("not") || p.is("-") || p.is("#") {
+		p.advance()
+		x, err := p.expr(unaryPriority)
+		if err != nil {
+			return nil, err
+		}
+		left = &unaryExpr{op: t.text, x: x, line: t.line}
+	} else if left, err = p.simpleExpr(); err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		if t.typ != tokOp && t.typ != tokKeyword {
+			return left, nil
+		}
+		priority, ok := binaryPriority[t.text]
+		if !ok || priority[0] <= limit {
+			return left, nil
+		}
+		p.advance()
+		right, err := p.expr(priority[1])
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: t.text, l: left, r: right, line: t.line}
+	}
+}
+
+func (p *parser) simpleExpr() (expr, error) {
+	t := p.peek()
+	switch t.typ {
+	case tokNumber:
+		p.advance()
+		return &constExpr{t.num}, nil
+	case tokString:
+		p.advance()
+		return &constExpr{t.text}, nil
+	case tokKeyword:
+		switch t.text {
+		case "nil":
+			p.advance()
+			return &constExpr{nil}, nil
+		case "true":
+			p.advance()
+			return &constExpr{true}, nil
+		case "false":
+			p.advance()
+			return &constExpr{false}, nil
+		case "function":
+			p.advance()
+			return p.funcBody("anonymous")
+		}
+	case tokOp:
+		switch t.text {
+		case "{":
+			return p.table()
+		case "...":
+			return nil, p.errorf("varargs are not supported")
+		}
+	}
+	return p.suffixedExpr()
+}
+
+func (p *parser) primaryExpr() (expr, error) {
+	t := p.peek()
+	if t.typ == tokName {
+		p.advance()
+		return &nameExpr{t.text}, nil
+	}
+	if p.accept("(") {
+		e, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		// parentheses truncate a call to a single value
+		if call, ok := e.(*callExpr); ok {
+			e = &binaryExpr{op: "()", l: call, line: t.line}
+		}
+		return e, p.expect(")")
+	}
+	return nil, p.errorf("unexpected symbol near '%s'", t)
+}
+
+func (p *parser) suffixedExpr() (expr, error) {
+	e, err := p.primaryExpr()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		switch {
+		case p.accept("."):
+			name, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			e = &indexExpr{obj: e, key: &constExpr{name}, line: t.line}
+		case p.accept("["):
+			key, err := p.expr(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			e = &indexExpr{obj: e, key: key, line: t.line}
+		case p.accept(":"):
+			name, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			args, err := p.callArgs()
+			if err != nil {
+				return nil, err
+			}
+			e = &callExpr{fn: e, method: name, args: args, line: t.line}
+		case p.is("(") || p.is("{") || t.typ == tokString:
+			args, err := p.callArgs()
+			if err != nil {
+				return nil, err
+			}
+			e = &callExpr{fn: e, args: args, line: t.line}
+		default:
+			return e, nil
+		}
+	}
+}
+
+func (p *parser) callArgs() ([]expr, error) {
+	t := p.peek()
+	if t.typ == tokString {
+		p.advance()
+		return []expr{&constExpr{t.text}}, nil
+	}
+	if p.is("{") {
+		table, err := p.table()
+		if err != nil {
+			return nil, err
+		}
+		return []expr{table}, nil
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if p.accept(")") {
+		return nil, nil
+	}
+	args, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	return args, p.expect(")")
+}
+
+func (p *parser) table() (expr, error) {
+	t := &tableExpr{}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for !p.is("}") {
+		var item tableItem
+		if p.accept("[") {
+			key, err := p.expr(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			item.key = key
+		} else if p.peek().typ == tokName && p.tokens[p.pos+1].typ == tokOp && p.tokens[p.pos+1].text == "=" {
+			item.key = &constExpr{p.advance().text}
+			p.advance()
+		}
+		value, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		item.value = value
+		t.items = append(t.items, item)
+		if !p.accept(",") && !p.accept(";") {
+			break
+		}
+	}
+	return t, p.expect("}")
+}
@@ -0,0 +1,141 @@
+package script
+
+import (
+	"errors"
+	"testing"
+)
+
+func run(t *testing.T, src string) ([]Value, error) {
+	chunk, err := Compile("test", src)
+	if err != nil {
+		return nil, err
+	}
+	return New().Run(chunk)
+}
+
+func TestRun(t *testing.T) {
+
+	var tests = []struct {
+		src  string
+		want Value
+	}{
+		{"return 1 + 2 * 3", float64(7)},
+		{"return (1 + 2) * 3", float64(9)},
+		{"return 2 ^ 3 ^ 2", float64(512)},
+		{"return -2 ^ 2", float64(-4)},
+		{"return 7 % 3", float64(1)},
+		{"return 'a' .. 'b' .. 1", "ab1"},
+		{"return 1 < 2 and 'yes' or 'no'", "yes"},
+		{"return nil or false", false},
+		{"return not nil", true},
+		{"return #'hello'", float64(5)},
+		{"local t = {1, 2, 3} return #t", float64(3)},
+		{"local t = {a = 1, ['b'] = 2} return t.a + t['b']", float64(3)},
+		{"local x = 0 for i = 1, 10 do x = x + i end return x", float64(55)},
+		{"local x = 0 for i = 10, 1, -2 do x = x + i end return x", float64(30)},
+		{"local x = 0 while true do x = x + 1 if x == 5 then break end end return x", float64(5)},
+		{"local x = 0 repeat local y = x x = x + 1 until y >= 3 return x", float64(4)},
+		{"local s = '' for k, v in ipairs({'a', 'b'}) do s = s .. k .. v end return s", "1a2b"},
+		{"local s = 0 for k, v in pairs({a = 1, b = 2, 3}) do s = s + v end return s", float64(6)},
+		{"local function fib(n) if n < 2 then return n end return fib(n-1) + fib(n-2) end return fib(15)", float64(610)},
+		{"local function counter() local n = 0 return function() n = n + 1 return n end end local c = counter() c() return c()", float64(2)},
+		{"local a, b = (function() return 1, 2 end)() return b", float64(2)},
+		{"local t = {} t.x = {} t.x.y = 'deep' return t.x.y", "deep"},
+		{"return ('abc'):upper()", "ABC"},
+		{"return string.sub('hello', 2, -2)", "ell"},
+		{"return string.format('%s=%d', 'n', 42)", "n=42"},
+		{"local t = {} table.insert(t, 'a') table.insert(t, 1, 'b') return table.concat(t, ',')", "b,a"},
+		{"return tonumber('10') + 1", float64(11)},
+		{"return tostring(1.5)", "1.5"},
+		{"return type({})", "table"},
+		{"return math.max(1, 5, 3)", float64(5)},
+		{"local ok, err = pcall(function() error('boom') end) return err", "boom"},
+		{"return select", nil},
+		{"-- comment\nreturn [[long\nstring]]", "long\nstring"},
+		{"--[[ block\ncomment ]] return 1", float64(1)},
+		{"if false then return 1 elseif nil then return 2 else return 3 end", float64(3)},
+	}
+
+	for _, test := range tests {
+		values, err := run(t, test.src)
+		if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+			continue
+		}
+		var got Value
+		if len(values) > 0 {
+			got = values[0]
+		}
+		if got != test.want {
+			t.Errorf("%q: got %v, want %v", test.src, got, test.want)
+		}
+	}
+}
+
+func TestRunError(t *testing.T) {
+
+	var tests = []struct {
+		src  string
+		want string
+	}{
+		{"return 1 +", "test:1: unexpected symbol near '<eof>'"},
+		{"x = ", "test:1: unexpected symbol near '<eof>'"},
+		{"local t = nil\nreturn t.x", "test:2: attempt to index a nil value"},
+		{"return {} + 1", "test:1: attempt to perform arithmetic on a table value"},
+		{"return 1 < 'a'", "test:1: attempt to compare number with string"},
+		{"undefined()", "test:1: attempt to call a nil value"},
+		{"error('custom')", "custom"},
+		{"local function f() return f() end return f()", "test:1: stack overflow"},
+		{"return string.rep()", "test:1: bad argument #1 to 'rep' (string expected, got nil)"},
+	}
+
+	for _, test := range tests {
+		_, err := run(t, test.src)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("%q: got %v, want %s", test.src, err, test.want)
+		}
+	}
+}
+
+func TestHook(t *testing.T) {
+
+	killed := errors.New("killed")
+	chunk, err := Compile("test", "local pcall = pcall while true do pcall(function() end) end")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := New()
+	calls := 0
+	in.Hook = func() error {
+		calls++
+		if calls > 1000 {
+			return killed
+		}
+		return nil
+	}
+	if _, err := in.Run(chunk); err != killed {
+		t.Errorf("got %v, want %v", err, killed)
+	}
+}
+
+func TestGoFunction(t *testing.T) {
+
+	in := New()
+	in.SetGlobal("add", GoFunction(func(in *Interp, args []Value) ([]Value, error) {
+		a, _ := ToNumber(arg(args, 0))
+		b, _ := ToNumber(arg(args, 1))
+		return []Value{a + b}, nil
+	}))
+	chunk, err := Compile("test", "return add(KEYS[1], 2), #KEYS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.SetGlobal("KEYS", NewArray("40"))
+	values, err := in.Run(chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[0] != float64(42) || values[1] != float64(1) {
+		t.Errorf("got %v", values)
+	}
+}
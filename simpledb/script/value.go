@@ -0,0 +1,226 @@
+package script
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Value is a script value: nil, bool, float64, string, *Table, *Function
+// or GoFunction
+type Value interface{}
+
+// GoFunction is a function implemented by the host
+type GoFunction func(in *Interp, args []Value) ([]Value, error)
+
+// Function is a function defined by a script, with the scope it closes over
+type Function struct {
+	fn  *funcExpr
+	env *scope
+}
+
+// Table is the only data structure of the language, its sequence part
+// 1..n is kept in an array
+type Table struct {
+	array []Value
+	hash  map[Value]Value
+}
+
+func NewTable() *Table {
+	return &Table{hash: make(map[Value]Value)}
+}
+
+// NewArray returns a table holding values as its sequence
+func NewArray(values ...Value) *Table {
+	t := NewTable()
+	for _, v := range values {
+		t.Append(v)
+	}
+	return t
+}
+
+// arrayIndex returns the 0 based array position of an integer key
+func arrayIndex(k Value) (int, bool) {
+	n, ok := k.(float64)
+	if !ok || n != math.Floor(n) || n < 1 || n > math.MaxInt32 {
+		return 0, false
+	}
+	return int(n) - 1, true
+}
+
+func (t *Table) Get(k Value) Value {
+	if i, ok := arrayIndex(k); ok && i < len(t.array) {
+		return t.array[i]
+	}
+	if _, ok := k.(GoFunction); ok {
+		return nil
+	}
+	return t.hash[k]
+}
+
+// GetString is a shortcut for string keys
+func (t *Table) GetString(k string) Value {
+	return t.hash[k]
+}
+
+func (t *Table) Set(k, v Value) error {
+	switch n := k.(type) {
+	case nil:
+		return fmt.Errorf("table index is nil")
+	case float64:
+		if math.IsNaN(n) {
+			return fmt.Errorf("table index is NaN")
+		}
+	case GoFunction:
+		return fmt.Errorf("builtin functions can not index a table")
+	}
+	i, ok := arrayIndex(k)
+	if !ok || i > len(t.array) {
+		if v == nil {
+			delete(t.hash, k)
+		} else {
+			t.hash[k] = v
+		}
+		return nil
+	}
+	if i < len(t.array) {
+		t.array[i] = v
+		if v == nil && i == len(t.array)-1 {
+			// keep the sequence free of trailing nils
+			for len(t.array) > 0 && t.array[len(t.array)-1] == nil {
+				t.array = t.array[:len(t.array)-1]
+			}
+		}
+		return nil
+	}
+	if v == nil {
+		return nil
+	}
+	t.array = append(t.array, v)
+	// following integer keys move from the hash into the sequence
+	for {
+		next := float64(len(t.array) + 1)
+		v, ok := t.hash[next]
+		if !ok {
+			return nil
+		}
+		delete(t.hash, next)
+		t.array = append(t.array, v)
+	}
+}
+
+func (t *Table) Append(v Value) {
+	t.Set(float64(len(t.array)+1), v)
+}
+
+// Len is the length of the sequence part, the # operator
+func (t *Table) Len() int {
+	return len(t.array)
+}
+
+// keys returns the keys in iteration order: the sequence, then the other
+// keys sorted so that iteration is deterministic
+func (t *Table) keys() []Value {
+	keys := make([]Value, 0, len(t.array)+len(t.hash))
+	for i, v := range t.array {
+		if v != nil {
+			keys = append(keys, float64(i+1))
+		}
+	}
+	var others []Value
+	for k := range t.hash {
+		others = append(others, k)
+	}
+	sort.Slice(others, func(i, j int) bool {
+		a, b := others[i], others[j]
+		if typeName(a) != typeName(b) {
+			return typeName(a) < typeName(b)
+		}
+		switch a := a.(type) {
+		case float64:
+			return a < b.(float64)
+		case string:
+			return a < b.(string)
+		case bool:
+			return !a && b.(bool)
+		}
+		return fmt.Sprintf("%p", a) < fmt.Sprintf("%p", b)
+	})
+	return append(keys, others...)
+}
+
+func typeName(v Value) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *Table:
+		return "table"
+	case *Function, GoFunction:
+		return "function"
+	}
+	return "userdata"
+}
+
+// truthy follows lua: only nil and false are false
+func truthy(v Value) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	return true
+}
+
+func formatNumber(n float64) string {
+	if n == math.Floor(n) && math.Abs(n) < 1e15 {
+		return strconv.FormatInt(int64(n), 10)
+	}
+	return fmt.Sprintf("%.14g", n)
+}
+
+// ToString converts a value the way tostring does
+func ToString(v Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return formatNumber(v)
+	case string:
+		return v
+	case *Table:
+		return fmt.Sprintf("table: %p", v)
+	case *Function:
+		return fmt.Sprintf("function: %p", v)
+	case GoFunction:
+		return fmt.Sprintf("builtin: %p", v)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// ToNumber converts numbers and numeric strings
+func ToNumber(v Value) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		s := strings.TrimSpace(v)
+		if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+			n, err := strconv.ParseInt(s[2:], 16, 64)
+			return float64(n), err == nil
+		}
+		n, err := strconv.ParseFloat(s, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,495 @@
+package simpledb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"simpledb/simpledb/script"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// scripting commands:
+// eval, evalsha, script load|exists|flush|kill
+//
+// scripts are written in the lua subset of the script package, they get
+// KEYS and ARGV and run commands with redis.call and redis.pcall. A script
+// runs while holding cmdMu, so it is atomic like a transaction.
+
+var (
+	errNoScript       = errors.New("NOSCRIPT No matching script. Please use EVAL.")
+	errBusy           = errors.New("BUSY Redis is busy running a script. You can only call SCRIPT KILL or SHUTDOWN NOSAVE.")
+	errNotBusy        = errors.New("NOTBUSY No scripts in execution right now.")
+	errUnkillable     = errors.New("UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.")
+	errScriptKilled   = errors.New("ERR Script killed by user with SCRIPT KILL...")
+	errNumKeys        = errors.New("ERR Number of keys can't be greater than number of args")
+	errNegativeKeys   = errors.New("ERR Number of keys can't be negative")
+	errNoScriptCmd    = errors.New("ERR This command is not allowed from scripts")
+	errScriptArgs     = errors.New("ERR Lua redis() command arguments must be strings or integers")
+	errScriptNoArgs   = errors.New("ERR Please specify at least one argument for redis.call()")
+	errScriptNoReply  = errors.New("ERR command returned no reply")
+	errScriptSubcmd   = errors.New("ERR Unknown SCRIPT subcommand or wrong number of arguments")
+	errReadOnlyScript = errors.New("ERR Write commands are not allowed from read-only scripts")
+	defaultScriptTime = 5000 * time.Millisecond
+)
+
+// commands a script can not call
+var noScriptCommands = map[string]bool{
+	"EVAL": true, "EVALSHA": true, "SCRIPT": true,
+	"FUNCTION": true, "FCALL": true, "FCALL_RO": true,
+	"MULTI": true, "EXEC": true, "DISCARD": true, "WATCH": true, "UNWATCH": true,
+	"SUBSCRIBE": true, "UNSUBSCRIBE": true, "PSUBSCRIBE": true, "PUNSUBSCRIBE": true,
+	"AUTH": true, "SHUTDOWN": true, "QUIT": true,
+}
+
+type cachedScript struct {
+	body  string
+	chunk *script.Chunk
+}
+
+// runningScript is the script holding cmdMu
+type runningScript struct {
+	start    time.Time
+	killed   int32
+	wrote    bool // a write command ran, the script can not be killed
+	readOnly bool // write commands are refused
+}
+
+// Scripts is the script cache and the state of the running script
+type Scripts struct {
+	mu        sync.Mutex
+	cache     map[string]*cachedScript
+	running   *runningScript
+	timeLimit time.Duration
+}
+
+func newScripts(timeLimit time.Duration) *Scripts {
+	if timeLimit <= 0 {
+		timeLimit = defaultScriptTime
+	}
+	return &Scripts{
+		mu:        sync.Mutex{},
+		cache:     make(map[string]*cachedScript),
+		timeLimit: timeLimit,
+	}
+}
+
+func (sc *Scripts) setTimeLimit(timeLimit time.Duration) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if timeLimit <= 0 {
+		timeLimit = defaultScriptTime
+	}
+	sc.timeLimit = timeLimit
+}
+
+func (sc *Scripts) limit() time.Duration {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.timeLimit
+}
+
+func sha1hex(body string) string {
+	sum := sha1.Sum([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// load compiles and caches a script, it returns its sha1
+func (sc *Scripts) load(body string) (string, *script.Chunk, error) {
+	sha := sha1hex(body)
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if c, ok := sc.cache[sha]; ok {
+		return sha, c.chunk, nil
+	}
+	chunk, err := script.Compile("user_script", body)
+	if err != nil {
+		return "", nil, fmt.Errorf("ERR Error compiling script (new function): %s", err.Error())
+	}
+	sc.cache[sha] = &cachedScript{body: body, chunk: chunk}
+	return sha, chunk, nil
+}
+
+func (sc *Scripts) lookup(sha string) (*script.Chunk, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	c, ok := sc.cache[strings.ToLower(sha)]
+	if !ok {
+		return nil, false
+	}
+	return c.chunk, true
+}
+
+func (sc *Scripts) flush() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.cache = make(map[string]*cachedScript)
+}
+
+func (sc *Scripts) begin() *runningScript {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.running = &runningScript{start: time.Now()}
+	return sc.running
+}
+
+func (sc *Scripts) end() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.running = nil
+}
+
+// busy reports whether a script runs past the time limit
+func (sc *Scripts) busy() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.running != nil && time.Since(sc.running.start) > sc.timeLimit
+}
+
+func (sc *Scripts) kill() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.running == nil {
+		return errNotBusy
+	}
+	if sc.running.wrote {
+		return errUnkillable
+	}
+	atomic.StoreInt32(&sc.running.killed, 1)
+	return nil
+}
+
+func (sc *Scripts) markWrite(r *runningScript) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	r.wrote = true
+}
+
+// isScriptKill reports whether command is SCRIPT KILL or FUNCTION KILL,
+// which run without waiting for the busy script
+func isScriptKill(command *Command, resp *Resp) bool {
+	return (command.Name == "SCRIPT" || command.Name == "FUNCTION") && len(resp.Array) == 2 &&
+		strings.ToUpper(string(resp.Array[1].Value)) == "KILL"
+}
+
+// callCapture runs a command and returns its reply instead of writing it
+// to the connection
+func (s *Server) callCapture(command *Command, resp *Resp) (*Resp, error) {
+	var buf bytes.Buffer
+	c := *s
+	c.wb = &WriteBuffer{bufio.NewWriter(&buf), s.writeTimeout}
+	c.multi = nil
+	c.call(command, resp)
+	if err := c.wb.Flush(); err != nil {
+		return nil, err
+	}
+	if buf.Len() == 0 {
+		return nil, errScriptNoReply
+	}
+	rb := &ReadBuffer{bufio.NewReader(&buf), s.readTimeout}
+	return rb.HandleStream()
+}
+
+// respToValue converts a command reply for a script, nil replies become
+// false as in redis
+func respToValue(r *Resp) script.Value {
+	switch r.Type {
+	case TypeInt:
+		n, err := strconv.ParseFloat(string(r.Value), 64)
+		if err != nil {
+			return string(r.Value)
+		}
+		return n
+	case TypeString:
+		if string(r.Value) == "nil" {
+			return false
+		}
+		return string(r.Value)
+	case TypeBulkBytes:
+		return string(r.Value)
+	case TypeError:
+		t := script.NewTable()
+		t.Set("err", string(r.Value))
+		return t
+	case TypeArray:
+		t := script.NewTable()
+		for i, e := range r.Array {
+			t.Set(float64(i+1), respToValue(e))
+		}
+		return t
+	}
+	return nil
+}
+
+// valueToResp converts a script result for the client: numbers are
+// truncated to integers, tables are arrays unless they hold err or ok
+func valueToResp(v script.Value) *Resp {
+	switch v := v.(type) {
+	case nil:
+		return NewString([]byte("nil"))
+	case bool:
+		if v {
+			return NewInt([]byte("1"))
+		}
+		return NewString([]byte("nil"))
+	case float64:
+		return NewInt([]byte(strconv.FormatInt(int64(v), 10)))
+	case string:
+		return NewBulkBytes([]byte(v))
+	case *script.Table:
+		if msg, ok := v.GetString("err").(string); ok {
+			return NewError([]byte(msg))
+		}
+		if status, ok := v.GetString("ok").(string); ok {
+			return NewString([]byte(status))
+		}
+		var array []*Resp
+		for i := 1; i <= v.Len(); i++ {
+			array = append(array, valueToResp(v.Get(float64(i))))
+		}
+		return NewArray(array)
+	}
+	return NewString([]byte("nil"))
+}
+
+// scriptCall is redis.call and redis.pcall, a failing redis.call raises
+// the error in the script
+func (s *Server) scriptCall(running *runningScript, raise bool) script.GoFunction {
+	return func(in *script.Interp, args []script.Value) ([]script.Value, error) {
+		if len(args) == 0 {
+			return nil, errScriptNoArgs
+		}
+		var array []*Resp
+		for _, arg := range args {
+			switch a := arg.(type) {
+			case string:
+				array = append(array, NewBulkBytes([]byte(a)))
+			case float64:
+				array = append(array, NewBulkBytes([]byte(strconv.FormatFloat(a, 'f', -1, 64))))
+			default:
+				return nil, errScriptArgs
+			}
+		}
+		result := func(err error) ([]script.Value, error) {
+			reply := respToValue(NewError([]byte(err.Error())))
+			if raise {
+				return nil, &script.Error{Value: reply}
+			}
+			return []script.Value{reply}, nil
+		}
+
+		command, err := CheckCommand(string(array[0].Value), len(array))
+		if err != nil {
+			return result(err)
+		}
+		if noScriptCommands[command.Name] {
+			return result(errNoScriptCmd)
+		}
+		if err := s.checkACL(command, NewArray(array), "lua"); err != nil {
+			return result(err)
+		}
+		if command.SFlag == 'w' {
+			if running.readOnly {
+				return result(errReadOnlyScript)
+			}
+			s.scripts.markWrite(running)
+		}
+		reply, err := s.callCapture(command, NewArray(array))
+		if err != nil {
+			return result(err)
+		}
+		if reply.IsError() {
+			return result(errors.New(string(reply.Value)))
+		}
+		return []script.Value{respToValue(reply)}, nil
+	}
+}
+
+// scriptLib is the redis table of a running script
+func (s *Server) scriptLib(running *runningScript) *script.Table {
+	redis := scriptHelpers()
+	redis.Set("call", s.scriptCall(running, true))
+	redis.Set("pcall", s.scriptCall(running, false))
+	return redis
+}
+
+// scriptHelpers returns the redis table without the functions that run
+// commands
+func scriptHelpers() *script.Table {
+	redis := script.NewTable()
+	redis.Set("error_reply", script.GoFunction(func(in *script.Interp, args []script.Value) ([]script.Value, error) {
+		t := script.NewTable()
+		if len(args) > 0 {
+			t.Set("err", script.ToString(args[0]))
+		}
+		return []script.Value{t}, nil
+	}))
+	redis.Set("status_reply", script.GoFunction(func(in *script.Interp, args []script.Value) ([]script.Value, error) {
+		t := script.NewTable()
+		if len(args) > 0 {
+			t.Set("ok", script.ToString(args[0]))
+		}
+		return []script.Value{t}, nil
+	}))
+	redis.Set("sha1hex", script.GoFunction(func(in *script.Interp, args []script.Value) ([]script.Value, error) {
+		if len(args) == 0 {
+			return nil, errors.New("wrong number of arguments")
+		}
+		return []script.Value{sha1hex(script.ToString(args[0]))}, nil
+	}))
+	redis.Set("log", script.GoFunction(func(in *script.Interp, args []script.Value) ([]script.Value, error) {
+		var parts []string
+		if len(args) < 2 {
+			return nil, nil
+		}
+		for _, arg := range args[1:] {
+			parts = append(parts, script.ToString(arg))
+		}
+		log.Printf("script: %s", strings.Join(parts, " "))
+		return nil, nil
+	}))
+	for i, level := range []string{"LOG_DEBUG", "LOG_VERBOSE", "LOG_NOTICE", "LOG_WARNING"} {
+		redis.Set(level, float64(i))
+	}
+	return redis
+}
+
+// scriptArgs returns the KEYS and ARGV tables of resp, the number of keys
+// is resp.Array[2]
+func scriptArgs(resp *Resp) (keys, argv *script.Table, err error) {
+	numKeys, err := strconv.Atoi(string(resp.Array[2].Value))
+	if err != nil {
+		return nil, nil, errInteger
+	}
+	if numKeys < 0 {
+		return nil, nil, errNegativeKeys
+	}
+	if numKeys > len(resp.Array)-3 {
+		return nil, nil, errNumKeys
+	}
+	keys, argv = script.NewTable(), script.NewTable()
+	for _, arg := range resp.Array[3 : 3+numKeys] {
+		keys.Append(string(arg.Value))
+	}
+	for _, arg := range resp.Array[3+numKeys:] {
+		argv.Append(string(arg.Value))
+	}
+	return keys, argv, nil
+}
+
+// killHook stops the script once SCRIPT KILL marked it
+func killHook(running *runningScript) func() error {
+	return func() error {
+		if atomic.LoadInt32(&running.killed) == 1 {
+			return errScriptKilled
+		}
+		return nil
+	}
+}
+
+// replyScript writes the first value returned by a script, or its error,
+// name tells which script failed
+func (s *Server) replyScript(name string, values []script.Value, err error) error {
+	if err == errScriptKilled {
+		return s.replyErr(err)
+	}
+	if err != nil {
+		var scriptErr *script.Error
+		if errors.As(err, &scriptErr) {
+			if t, ok := scriptErr.Value.(*script.Table); ok {
+				// a failed redis.call or error(redis.error_reply(...))
+				return s.writeResp(valueToResp(t))
+			}
+		}
+		return s.replyErr(fmt.Errorf("ERR Error running script (call to %s): %s", name, err.Error()))
+	}
+	if len(values) == 0 {
+		return s.replyNil()
+	}
+	return s.writeResp(valueToResp(values[0]))
+}
+
+// runScript runs a compiled script with the KEYS and ARGV of resp
+func (s *Server) runScript(sha string, chunk *script.Chunk, resp *Resp) error {
+	keys, argv, err := scriptArgs(resp)
+	if err != nil {
+		return s.replyErr(err)
+	}
+
+	running := s.scripts.begin()
+	defer s.scripts.end()
+
+	in := script.New()
+	in.SetGlobal("KEYS", keys)
+	in.SetGlobal("ARGV", argv)
+	in.SetGlobal("redis", s.scriptLib(running))
+	in.Hook = killHook(running)
+
+	values, err := in.Run(chunk)
+	return s.replyScript("f_"+sha, values, err)
+}
+
+func eval(s *Server, resp *Resp) error {
+	sha, chunk, err := s.scripts.load(string(resp.Array[1].Value))
+	if err != nil {
+		return s.replyErr(err)
+	}
+	return s.runScript(sha, chunk, resp)
+}
+
+func evalSha(s *Server, resp *Resp) error {
+	sha := strings.ToLower(string(resp.Array[1].Value))
+	chunk, ok := s.scripts.lookup(sha)
+	if !ok {
+		return s.replyErr(errNoScript)
+	}
+	return s.runScript(sha, chunk, resp)
+}
+
+func scriptCommand(s *Server, resp *Resp) error {
+	sub := strings.ToUpper(string(resp.Array[1].Value))
+	args := resp.Array[2:]
+
+	switch {
+	case sub == "LOAD" && len(args) == 1:
+		sha, _, err := s.scripts.load(string(args[0].Value))
+		if err != nil {
+			return s.replyErr(err)
+		}
+		return s.writeArgs(sha)
+
+	case sub == "EXISTS" && len(args) > 0:
+		if _, err := s.wb.WriteArray(len(args)); err != nil {
+			return err
+		}
+		for _, arg := range args {
+			var exists int64
+			if _, ok := s.scripts.lookup(string(arg.Value)); ok {
+				exists = 1
+			}
+			if _, err := s.wb.WriteInt64(exists); err != nil {
+				return err
+			}
+		}
+		return s.flush()
+
+	case sub == "FLUSH" && len(args) <= 1:
+		s.scripts.flush()
+		return s.replyOk()
+
+	case sub == "KILL" && len(args) == 0:
+		if err := s.scripts.kill(); err != nil {
+			return s.replyErr(err)
+		}
+		return s.replyOk()
+	}
+	return s.replyErr(errScriptSubcmd)
+}
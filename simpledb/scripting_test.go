@@ -0,0 +1,152 @@
+package simpledb
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_Eval(t *testing.T) {
+
+	server := newTestServer()
+	wb, rb := pipeConn(server)
+
+	var tests = []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"EVAL", "return 1", "0"}, "1"},
+		{[]interface{}{"EVAL", "return KEYS[1] .. ARGV[1]", "1", "foo", "bar"}, "foobar"},
+		{[]interface{}{"EVAL", "return redis.call('SET', KEYS[1], ARGV[1])", "1", "name", "simpledb"}, "OK"},
+		{[]interface{}{"EVAL", "return redis.call('GET', KEYS[1])", "1", "name"}, "simpledb"},
+		{[]interface{}{"EVAL", "redis.call('INCR', KEYS[1]) return redis.call('INCRBY', KEYS[1], 2)", "1", "counter"}, "3"},
+		{[]interface{}{"EVAL", "return redis.call('INCR', KEYS[1])", "1", "name"}, errInteger.Error()},
+		{[]interface{}{"EVAL", "local r = redis.pcall('INCR', KEYS[1]) return r.err", "1", "name"}, errInteger.Error()},
+		{[]interface{}{"EVAL", "return redis.call('EVAL', 'return 1', 0)", "0"}, errNoScriptCmd.Error()},
+		{[]interface{}{"EVAL", "return redis.error_reply('MY error')", "0"}, "MY error"},
+		{[]interface{}{"EVAL", "return redis.status_reply('FINE')", "0"}, "FINE"},
+		{[]interface{}{"EVAL", "return 3.9", "0"}, "3"},
+		{[]interface{}{"EVAL", "return 1", "2", "foo"}, errNumKeys.Error()},
+		{[]interface{}{"EVAL", "return 1", "-1"}, errNegativeKeys.Error()},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Value) != test.want {
+			t.Errorf("%v: got %s, want %s", test.args[1], resp.Value, test.want)
+		}
+	}
+
+	resp, err := call(wb, rb, "EVAL", "return {1, 'two', {3}}", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsArray() || len(resp.Array) != 3 || string(resp.Array[1].Value) != "two" || !resp.Array[2].IsArray() {
+		t.Errorf("EVAL table: got %v", resp)
+	}
+
+	resp, err = call(wb, rb, "EVAL", "return (", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() || !strings.HasPrefix(string(resp.Value), "ERR Error compiling script") {
+		t.Errorf("EVAL syntax error: got %s", resp.Value)
+	}
+}
+
+func TestServer_EvalSha(t *testing.T) {
+
+	server := newTestServer()
+	wb, rb := pipeConn(server)
+
+	body := "return ARGV[1] .. ARGV[2]"
+	sha := sha1hex(body)
+
+	var tests = []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"EVALSHA", sha, "0", "a", "b"}, errNoScript.Error()},
+		{[]interface{}{"SCRIPT", "LOAD", body}, sha},
+		{[]interface{}{"EVALSHA", sha, "0", "a", "b"}, "ab"},
+		{[]interface{}{"EVALSHA", strings.ToUpper(sha), "0", "c", "d"}, "cd"},
+		{[]interface{}{"SCRIPT", "FLUSH"}, "OK"},
+		{[]interface{}{"EVALSHA", sha, "0", "a", "b"}, errNoScript.Error()},
+		{[]interface{}{"SCRIPT", "KILL"}, errNotBusy.Error()},
+		{[]interface{}{"SCRIPT", "HELLO"}, errScriptSubcmd.Error()},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Value) != test.want {
+			t.Errorf("%v: got %s, want %s", test.args, resp.Value, test.want)
+		}
+	}
+
+	call(wb, rb, "SCRIPT", "LOAD", body)
+	resp, err := call(wb, rb, "SCRIPT", "EXISTS", sha, sha1hex("return 2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Array) != 2 || string(resp.Array[0].Value) != "1" || string(resp.Array[1].Value) != "0" {
+		t.Errorf("SCRIPT EXISTS: got %v", resp)
+	}
+}
+
+func TestServer_ScriptKill(t *testing.T) {
+
+	server := newTestServer()
+	server.scripts = newScripts(20 * time.Millisecond)
+	wb, rb := pipeConn(server)
+	otherWb, otherRb := pipeConn(server)
+
+	if _, err := wb.WriteArgs("EVAL", "while true do end", "0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wb.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// other clients are refused once the script is over its time limit
+	deadline := time.Now().Add(5 * time.Second)
+	for !server.scripts.busy() {
+		if time.Now().After(deadline) {
+			t.Fatal("script never became busy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	resp, err := call(otherWb, otherRb, "GET", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Value) != errBusy.Error() {
+		t.Errorf("GET: got %s, want %s", resp.Value, errBusy.Error())
+	}
+
+	resp, err = call(otherWb, otherRb, "SCRIPT", "KILL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Value) != "OK" {
+		t.Errorf("SCRIPT KILL: got %s", resp.Value)
+	}
+	resp, err = rb.HandleStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Value) != errScriptKilled.Error() {
+		t.Errorf("EVAL: got %s, want %s", resp.Value, errScriptKilled.Error())
+	}
+
+	resp, err = call(otherWb, otherRb, "SET", "foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Value) != "OK" {
+		t.Errorf("SET after kill: got %s", resp.Value)
+	}
+}
@@ -180,6 +180,7 @@ func sAdd(s *Server, resp *Resp) error {
 		members = append(members, string(member.Value))
 	}
 	size := s.set.add(key, members...)
+	s.notify(notifySet, "sadd", key)
 	return s.writeArgs(size)
 }
 
@@ -289,5 +290,41 @@ func sRem(s *Server, resp *Resp) error {
 	member := string(resp.Array[2].Value)
 
 	result := s.set.sRem(key, member)
+	if result {
+		s.notify(notifySet, "srem", key)
+	}
 	return s.writeArgs(result)
 }
+
+func sScan(s *Server, resp *Resp) error {
+	opts, err := parseScan(resp.Array[2:])
+	if err != nil {
+		return s.replyErr(err)
+	}
+	if s.set == nil {
+		return s.replyScan(0, nil)
+	}
+	key := string(resp.Array[1].Value)
+	s.set.mu.RLock()
+	m, ok := s.set.data[key]
+	if !ok {
+		s.set.mu.RUnlock()
+		return s.replyScan(0, nil)
+	}
+	page, cursor, err := s.scans.page(typeSet+":"+key, opts.cursor, opts.count, func(fn func(string)) {
+		for member := range m.val {
+			fn(member)
+		}
+	})
+	var result []string
+	for _, member := range page {
+		if _, ok := m.val[member]; ok && matchPattern(opts.pattern, member) {
+			result = append(result, member)
+		}
+	}
+	s.set.mu.RUnlock()
+	if err != nil {
+		return s.replyErr(err)
+	}
+	return s.replyScan(cursor, result)
+}
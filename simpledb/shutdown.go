@@ -0,0 +1,337 @@
+package simpledb
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shutdown commands:
+// shutdown [nosave|save] [now] [force] [abort], quit
+//
+// a shutdown first waits up to shutdown_timeout for the commands in flight,
+// the new commands wait meanwhile and SHUTDOWN ABORT cancels it. The
+// keyspace is then saved to dbfilename, as the save rules say unless SAVE
+// or NOSAVE, and a failed save keeps the server running unless FORCE. The
+// listeners and the connections are closed last, NOW skips the wait.
+
+const drainTimeout = 10 * time.Second
+
+var (
+	errShutdownSyntax   = errors.New("ERR syntax error, try SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE] | ABORT")
+	errShutdownInMulti  = errors.New("ERR Command not allowed inside a transaction")
+	errShutdownRunning  = errors.New("ERR shutdown already in progress")
+	errNoShutdown       = errors.New("ERR No shutdown in progress.")
+	errShutdownFailed   = errors.New("ERR Errors trying to SHUTDOWN. Check logs.")
+	errShutdownBusySave = errors.New("BUSY Redis is busy running a script, the keyspace can't be saved. You can only call SCRIPT KILL or SHUTDOWN NOSAVE.")
+)
+
+const (
+	saveByRules = iota // save when there are save rules
+	saveAlways
+	saveNever
+)
+
+type shutdownOptions struct {
+	save  int
+	now   bool // skip the wait for the commands in flight
+	force bool // stop even when the save fails
+}
+
+// lifecycle is the listeners and the http servers of a running server and
+// the state of its shutdown, shared by its connections
+type lifecycle struct {
+	mu        sync.Mutex
+	listeners []net.Listener
+	servers   []*http.Server
+	// closed when the pending shutdown ends, nil without one
+	pending  chan struct{}
+	inflight int // commands between enter and exit
+	closing  bool
+	done     chan struct{} // closed once the server is shut down
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{
+		mu:   sync.Mutex{},
+		done: make(chan struct{}),
+	}
+}
+
+// add tracks a listener, it is closed right away when the server is
+// shutting down
+func (l *lifecycle) add(listener net.Listener) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closing {
+		listener.Close()
+		return
+	}
+	l.listeners = append(l.listeners, listener)
+}
+
+// addHTTP tracks an http server, it is closed with the listeners
+func (l *lifecycle) addHTTP(server *http.Server) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closing {
+		server.Close()
+		return
+	}
+	l.servers = append(l.servers, server)
+}
+
+// enter counts a command in flight, it waits while a shutdown is pending
+func (l *lifecycle) enter() {
+	l.mu.Lock()
+	for l.pending != nil {
+		pending := l.pending
+		l.mu.Unlock()
+		<-pending
+		l.mu.Lock()
+	}
+	l.inflight++
+	l.mu.Unlock()
+}
+
+func (l *lifecycle) exit() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+}
+
+// begin starts a shutdown, nil when one is already under way
+func (l *lifecycle) begin() chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closing || l.pending != nil {
+		return nil
+	}
+	l.pending = make(chan struct{})
+	return l.pending
+}
+
+// resume ends the pending shutdown, the waiting commands go on. It returns
+// false when pending is not the shutdown pending anymore.
+func (l *lifecycle) resume(pending chan struct{}) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if pending == nil || l.pending != pending {
+		return false
+	}
+	close(l.pending)
+	l.pending = nil
+	return true
+}
+
+// abort cancels the pending shutdown, see SHUTDOWN ABORT
+func (l *lifecycle) abort() bool {
+	l.mu.Lock()
+	pending := l.pending
+	l.mu.Unlock()
+	return l.resume(pending)
+}
+
+// settle waits up to timeout for the commands in flight, it returns false
+// when the shutdown was aborted meanwhile
+func (l *lifecycle) settle(pending chan struct{}, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		l.mu.Lock()
+		aborted, inflight := l.pending != pending, l.inflight
+		l.mu.Unlock()
+		if aborted {
+			return false
+		}
+		if inflight == 0 || time.Now().After(deadline) {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// close closes the listeners, the commands waiting on the pending shutdown
+// go on to find the server closing
+func (l *lifecycle) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closing = true
+	for _, listener := range l.listeners {
+		listener.Close()
+	}
+	for _, server := range l.servers {
+		server.Close()
+	}
+	if l.pending != nil {
+		close(l.pending)
+		l.pending = nil
+	}
+}
+
+func (l *lifecycle) isClosing() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closing
+}
+
+// Done is closed once the server is shut down
+func (s *Server) Done() <-chan struct{} {
+	return s.life.done
+}
+
+// Shutdown stops the server as SHUTDOWN does, the keyspace is saved when
+// there are save rules. It returns once the connections are closed, or
+// with an error when the server keeps running.
+func (s *Server) Shutdown() error {
+	if err := s.shutdown(shutdownOptions{save: saveByRules}); err != nil {
+		return err
+	}
+	<-s.life.done
+	return nil
+}
+
+// Close stops the server at once, without saving the keyspace
+func (s *Server) Close() error {
+	if err := s.shutdown(shutdownOptions{save: saveNever, now: true, force: true}); err != nil {
+		return err
+	}
+	<-s.life.done
+	return nil
+}
+
+// shutdown stops the server, see shutdown. The connections are drained in
+// the background, Done is closed after them.
+func (s *Server) shutdown(opts shutdownOptions) error {
+	pending := s.life.begin()
+	if pending == nil {
+		return errShutdownRunning
+	}
+	s.confMu.RLock()
+	timeout := s.conf.Server.ShutdownTimeout * time.Second
+	save := opts.save == saveAlways || opts.save == saveByRules && s.conf.Server.Save != ""
+	s.confMu.RUnlock()
+
+	log.Println("shutdown requested")
+	// a busy script would hold the wait until the timeout
+	if s.scripts.busy() && !save {
+		opts.now = true
+	}
+	if !opts.now && !s.life.settle(pending, timeout) {
+		log.Println("shutdown aborted")
+		return errShutdownFailed
+	}
+
+	// a busy script holds cmdMu, without a save the server stops anyway
+	locked := true
+	if s.scripts.busy() && !save {
+		s.scripts.kill()
+		locked = false
+	} else {
+		s.cmdMu.Lock()
+	}
+	if save {
+		if n, err := s.saveSnapshot(); err != nil {
+			log.Printf("save the snapshot err: %v", err)
+			if !opts.force {
+				s.cmdMu.Unlock()
+				s.life.resume(pending)
+				return errShutdownFailed
+			}
+		} else {
+			log.Printf("saved %d keys", n)
+		}
+	}
+	if s.aofBuf != nil && locked {
+		if err := s.aofBuf.Flush(); err != nil {
+			log.Printf("flush the append only buffer err: %v", err)
+		}
+	}
+	s.life.close()
+	for _, c := range s.clients.all() {
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	}
+	if locked {
+		s.cmdMu.Unlock()
+	}
+
+	go func() {
+		deadline := time.Now().Add(drainTimeout)
+		for s.clients.count() > 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := s.clients.count(); n > 0 {
+			log.Printf("%d connections still open after %v", n, drainTimeout)
+		}
+		if s.unixSocket != "" {
+			os.Remove(s.unixSocket)
+		}
+		log.Println("simpledb is now ready to exit, bye bye...")
+		close(s.life.done)
+	}()
+	return nil
+}
+
+func parseShutdown(args []*Resp) (opts shutdownOptions, abort bool, err error) {
+	for _, arg := range args {
+		switch strings.ToUpper(string(arg.Value)) {
+		case "NOSAVE":
+			if opts.save == saveAlways {
+				return opts, false, errShutdownSyntax
+			}
+			opts.save = saveNever
+		case "SAVE":
+			if opts.save == saveNever {
+				return opts, false, errShutdownSyntax
+			}
+			opts.save = saveAlways
+		case "NOW":
+			opts.now = true
+		case "FORCE":
+			opts.force = true
+		case "ABORT":
+			abort = true
+		default:
+			return opts, false, errShutdownSyntax
+		}
+	}
+	if abort && len(args) > 1 {
+		return opts, false, errShutdownSyntax
+	}
+	return opts, abort, nil
+}
+
+// shutdownCommand runs outside cmdMu, it waits for the other commands. On
+// success there is no reply, the connection is closed.
+func shutdownCommand(s *Server, resp *Resp) error {
+	opts, abort, err := parseShutdown(resp.Array[1:])
+	if err != nil {
+		return s.replyErr(err)
+	}
+	if abort {
+		if !s.life.abort() {
+			return s.replyErr(errNoShutdown)
+		}
+		return s.replyOk()
+	}
+	if s.scripts.busy() && opts.save != saveNever {
+		return s.replyErr(errShutdownBusySave)
+	}
+	if err := s.shutdown(opts); err != nil {
+		return s.replyErr(err)
+	}
+	return nil
+}
+
+// quit closes the connection once OK is written
+func quit(s *Server, resp *Resp) error {
+	err := s.replyOk()
+	s.conn.Close()
+	return err
+}
@@ -0,0 +1,209 @@
+package simpledb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// runUnix runs s on a unix socket, it returns a client of s and the result
+// of Run
+func runUnix(t *testing.T, s *Server) (*Client, chan error) {
+	s.unixSocket = filepath.Join(t.TempDir(), "simpledb.sock")
+	ran := make(chan error, 1)
+	go func() { ran <- s.Run() }()
+
+	for i := 0; ; i++ {
+		if _, err := os.Stat(s.unixSocket); err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatal("the server is not listening")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	client := &Client{
+		Host:           "unix://" + s.unixSocket,
+		ConnectTimeout: defaultTimeout,
+		readTimeout:    defaultTimeout,
+		writeTimeout:   defaultTimeout,
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, ran
+}
+
+// stopped checks that Run returned and Done is closed
+func stopped(t *testing.T, s *Server, ran chan error) {
+	select {
+	case err := <-ran:
+		if err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return")
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Errorf("Done is not closed")
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+
+	server := newTestServer()
+	client, ran := runUnix(t, server)
+	if resp, err := client.Set("k", "v"); err != nil || string(resp.Value) != "OK" {
+		t.Fatalf("SET: got %v, %v", resp, err)
+	}
+
+	if err := server.Shutdown(); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	stopped(t, server, ran)
+	if n := server.clients.count(); n != 0 {
+		t.Errorf("%d connections left", n)
+	}
+	if _, err := os.Stat(server.unixSocket); !os.IsNotExist(err) {
+		t.Errorf("the unix socket is left: %v", err)
+	}
+	// a second Shutdown returns at once
+	if err := server.Shutdown(); err != errShutdownRunning {
+		t.Errorf("second Shutdown: got %v, want %v", err, errShutdownRunning)
+	}
+}
+
+func TestServer_ShutdownCommand(t *testing.T) {
+
+	server := newTestServer()
+	server.conf.Server.Save = "3600 1"
+	server.conf.Server.DBFilename = filepath.Join(t.TempDir(), "nodir", "dump.sdb")
+	client, ran := runUnix(t, server)
+	client.Set("k", "v")
+
+	var tests = []struct {
+		args []string
+		want string
+	}{
+		{[]string{"NOW", "BOGUS"}, errShutdownSyntax.Error()},
+		{[]string{"SAVE", "NOSAVE"}, errShutdownSyntax.Error()},
+		{[]string{"ABORT", "NOW"}, errShutdownSyntax.Error()},
+		{[]string{"ABORT"}, errNoShutdown.Error()},
+		// the snapshot can't be saved, the server keeps running
+		{[]string{"NOW"}, errShutdownFailed.Error()},
+		{[]string{"SAVE", "NOW"}, errShutdownFailed.Error()},
+	}
+	for _, test := range tests {
+		resp, err := client.Shutdown(test.args...)
+		if err != nil || resp == nil || string(resp.Value) != test.want {
+			t.Errorf("SHUTDOWN %v: got %v, %v, want %q", test.args, resp, err, test.want)
+		}
+	}
+
+	client.Multi()
+	if resp, err := client.Shutdown(); err != nil || string(resp.Value) != errShutdownInMulti.Error() {
+		t.Errorf("SHUTDOWN in MULTI: got %v, %v", resp, err)
+	}
+	if resp, err := client.Exec(); err != nil || !resp.IsError() {
+		t.Errorf("EXEC after SHUTDOWN: got %v, %v", resp, err)
+	}
+
+	if resp, err := client.Get("k"); err != nil || string(resp.Value) != "v" {
+		t.Fatalf("GET after a failed shutdown: got %v, %v", resp, err)
+	}
+	if resp, err := client.Shutdown("NOW", "FORCE"); err != nil || resp != nil {
+		t.Fatalf("SHUTDOWN FORCE: got %v, %v", resp, err)
+	}
+	stopped(t, server, ran)
+}
+
+func TestServer_ShutdownSave(t *testing.T) {
+
+	file := filepath.Join(t.TempDir(), "dump.sdb")
+	server := newTestServer()
+	server.conf.Server.DBFilename = file
+	client, ran := runUnix(t, server)
+	client.Set("k", "v")
+	client.Rpush("l", "a")
+
+	// without save rules SAVE is needed
+	if resp, err := client.Shutdown("SAVE"); err != nil || resp != nil {
+		t.Fatalf("SHUTDOWN SAVE: got %v, %v", resp, err)
+	}
+	stopped(t, server, ran)
+
+	restarted := newTestServer()
+	restarted.conf.Server.DBFilename = file
+	if n, err := restarted.loadSnapshot(); err != nil || n != 2 {
+		t.Fatalf("load: got %d, %v", n, err)
+	}
+	if value, typ := restarted.lookupKey("k"); typ != typeString || value != "v" {
+		t.Errorf("k: got %s %v", typ, value)
+	}
+}
+
+func TestServer_ShutdownAbort(t *testing.T) {
+
+	server := newTestServer()
+	server.conf.Server.ShutdownTimeout = 10
+	client, ran := runUnix(t, server)
+
+	// a command in flight holds the shutdown
+	server.life.enter()
+	shut := make(chan error, 1)
+	go func() { shut <- server.Shutdown() }()
+	for i := 0; ; i++ {
+		server.life.mu.Lock()
+		pending := server.life.pending != nil
+		server.life.mu.Unlock()
+		if pending {
+			break
+		}
+		if i == 100 {
+			t.Fatal("the shutdown is not pending")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if resp, err := client.Shutdown("ABORT"); err != nil || string(resp.Value) != "OK" {
+		t.Fatalf("SHUTDOWN ABORT: got %v, %v", resp, err)
+	}
+	server.life.exit()
+	select {
+	case err := <-shut:
+		if err != errShutdownFailed {
+			t.Errorf("aborted Shutdown: got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the aborted Shutdown did not return")
+	}
+	if resp, err := client.Set("k", "v"); err != nil || string(resp.Value) != "OK" {
+		t.Fatalf("SET after ABORT: got %v, %v", resp, err)
+	}
+
+	if err := server.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	stopped(t, server, ran)
+}
+
+func TestServer_Quit(t *testing.T) {
+
+	server := newTestServer()
+	client, ran := runUnix(t, server)
+	if resp, err := client.Quit(); err != nil || string(resp.Value) != "OK" {
+		t.Fatalf("QUIT: got %v, %v", resp, err)
+	}
+	// the client connects again
+	if resp, err := client.Set("k", "v"); err != nil || string(resp.Value) != "OK" {
+		t.Fatalf("SET after QUIT: got %v, %v", resp, err)
+	}
+	for i := 0; server.clients.count() != 1; i++ {
+		if i == 100 {
+			t.Fatalf("%d connections, want 1", server.clients.count())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	server.Close()
+	stopped(t, server, ran)
+}
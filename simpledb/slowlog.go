@@ -0,0 +1,178 @@
+package simpledb
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// slowlog commands:
+// slowlog get [count]|len|reset
+//
+// a command running longer than slowlog_log_slower_than microseconds is
+// recorded with its arguments, the client address and its duration. The
+// log keeps the slowlog_max_len newest entries, a negative threshold
+// disables it and 0 records every command.
+
+const (
+	defaultSlowLogGet = 10
+	// arguments and bytes of an argument recorded by an entry
+	slowLogMaxArgc   = 32
+	slowLogMaxArgLen = 128
+)
+
+var errSlowLogSubcmd = errors.New("ERR unknown subcommand, try SLOWLOG GET [count]|LEN|RESET")
+
+type slowLogEntry struct {
+	id       int64
+	time     int64 // unix seconds
+	duration time.Duration
+	args     []string
+	addr     string
+	name     string
+}
+
+// SlowLog is a bounded log of the slow commands, newest first
+type SlowLog struct {
+	mu        sync.Mutex
+	nextID    int64
+	threshold time.Duration // negative disables the log
+	maxLen    int
+	entries   []slowLogEntry
+}
+
+func newSlowLog(slowerThan int64, maxLen int) *SlowLog {
+	return &SlowLog{
+		mu:        sync.Mutex{},
+		threshold: time.Duration(slowerThan) * time.Microsecond,
+		maxLen:    maxLen,
+	}
+}
+
+// configure changes the threshold and the length at runtime, the oldest
+// entries over maxLen are dropped
+func (l *SlowLog) configure(slowerThan int64, maxLen int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.threshold = time.Duration(slowerThan) * time.Microsecond
+	l.maxLen = maxLen
+	if maxLen < 0 {
+		maxLen = 0
+	}
+	if len(l.entries) > maxLen {
+		l.entries = l.entries[:maxLen]
+	}
+}
+
+// slowLogArgs truncates the arguments of a command for an entry, the
+// passwords are redacted as for MONITOR
+func slowLogArgs(resp *Resp) []string {
+	all := make([]string, len(resp.Array))
+	for i, arg := range resp.Array {
+		all[i] = string(arg.Value)
+	}
+	var args []string
+	for i, value := range redactArgs(all) {
+		if i == slowLogMaxArgc-1 && len(resp.Array) > slowLogMaxArgc {
+			args = append(args, fmt.Sprintf("... (%d more arguments)", len(resp.Array)-i))
+			break
+		}
+		if len(value) > slowLogMaxArgLen {
+			value = fmt.Sprintf("%s... (%d more bytes)", value[:slowLogMaxArgLen], len(value)-slowLogMaxArgLen)
+		}
+		args = append(args, value)
+	}
+	return args
+}
+
+// record logs a command that took d when it is over the threshold
+func (l *SlowLog) record(s *Server, resp *Resp, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.threshold < 0 || d < l.threshold || l.maxLen <= 0 {
+		return
+	}
+	entry := slowLogEntry{
+		id:       l.nextID,
+		time:     time.Now().Unix(),
+		duration: d,
+		args:     slowLogArgs(resp),
+		addr:     s.clientAddr(),
+		name:     s.clientName(),
+	}
+	l.nextID++
+	l.entries = append([]slowLogEntry{entry}, l.entries...)
+	if len(l.entries) > l.maxLen {
+		l.entries = l.entries[:l.maxLen]
+	}
+}
+
+// get returns the count newest entries, all of them with a negative count
+func (l *SlowLog) get(count int) []slowLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if count < 0 || count > len(l.entries) {
+		count = len(l.entries)
+	}
+	return append([]slowLogEntry(nil), l.entries[:count]...)
+}
+
+func (l *SlowLog) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+func (l *SlowLog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = nil
+}
+
+func (e slowLogEntry) resp() *Resp {
+	args := make([]*Resp, len(e.args))
+	for i, arg := range e.args {
+		args[i] = NewBulkBytes([]byte(arg))
+	}
+	return NewArray([]*Resp{
+		NewInt([]byte(strconv.FormatInt(e.id, 10))),
+		NewInt([]byte(strconv.FormatInt(e.time, 10))),
+		NewInt([]byte(strconv.FormatInt(int64(e.duration/time.Microsecond), 10))),
+		NewArray(args),
+		NewBulkBytes([]byte(e.addr)),
+		NewBulkBytes([]byte(e.name)),
+	})
+}
+
+func slowLogCommand(s *Server, resp *Resp) error {
+	switch strings.ToUpper(string(resp.Array[1].Value)) {
+	case "GET":
+		count := defaultSlowLogGet
+		if len(resp.Array) > 3 {
+			return s.replyErr(errSlowLogSubcmd)
+		}
+		if len(resp.Array) == 3 {
+			n, err := strconv.Atoi(string(resp.Array[2].Value))
+			if err != nil || n < -1 {
+				return s.replyErr(errInteger)
+			}
+			count = n
+		}
+		var entries []*Resp
+		for _, entry := range s.slowLog.get(count) {
+			entries = append(entries, entry.resp())
+		}
+		return s.writeResp(NewArray(entries))
+	case "LEN":
+		return s.writeResp(NewInt([]byte(strconv.Itoa(s.slowLog.len()))))
+	case "RESET":
+		s.slowLog.reset()
+		return s.replyOk()
+	}
+	return s.replyErr(errSlowLogSubcmd)
+}
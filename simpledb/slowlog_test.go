@@ -0,0 +1,104 @@
+package simpledb
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowLogArgs(t *testing.T) {
+
+	var array []*Resp
+	for i := 0; i < 40; i++ {
+		array = append(array, NewBulkBytes([]byte(strconv.Itoa(i))))
+	}
+	array[1] = NewBulkBytes([]byte(strings.Repeat("x", slowLogMaxArgLen+10)))
+	args := slowLogArgs(NewArray(array))
+
+	if len(args) != slowLogMaxArgc {
+		t.Fatalf("got %d arguments, want %d", len(args), slowLogMaxArgc)
+	}
+	if want := strings.Repeat("x", slowLogMaxArgLen) + "... (10 more bytes)"; args[1] != want {
+		t.Errorf("long argument: got %s", args[1])
+	}
+	if want := "... (9 more arguments)"; args[slowLogMaxArgc-1] != want {
+		t.Errorf("last argument: got %s, want %s", args[slowLogMaxArgc-1], want)
+	}
+}
+
+func TestServer_SlowLog(t *testing.T) {
+
+	server := newTestServer()
+	server.slowLog = newSlowLog(0, 2)
+	wb, rb := pipeConn(server)
+
+	call(wb, rb, "SET", "a", "1")
+	call(wb, rb, "GET", "a")
+	call(wb, rb, "GET", "b")
+
+	resp, err := call(wb, rb, "SLOWLOG", "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the log keeps the two newest commands, newest first
+	if len(resp.Array) != 2 {
+		t.Fatalf("SLOWLOG GET: got %d entries, want 2", len(resp.Array))
+	}
+	entry := resp.Array[0].Array
+	if len(entry) != 6 || string(entry[0].Value) != "2" || string(entry[3].Array[1].Value) != "b" {
+		t.Errorf("SLOWLOG GET: got %v", resp.Array[0])
+	}
+	if string(entry[4].Value) == "" {
+		t.Errorf("SLOWLOG GET: no client address")
+	}
+
+	var tests = []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"SLOWLOG", "LEN"}, "2"},
+		{[]interface{}{"SLOWLOG", "RESET"}, "OK"},
+		// SLOWLOG RESET is itself logged once done
+		{[]interface{}{"SLOWLOG", "LEN"}, "1"},
+		{[]interface{}{"SLOWLOG", "GET", "x"}, errInteger.Error()},
+		{[]interface{}{"SLOWLOG", "FIND"}, errSlowLogSubcmd.Error()},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Value) != test.want {
+			t.Errorf("%v: got %s, want %s", test.args, resp.Value, test.want)
+		}
+	}
+}
+
+func TestLatency(t *testing.T) {
+
+	latency := newLatency(10)
+	latency.add(eventCommand, time.Millisecond)
+	if len(latency.latest()) != 0 {
+		t.Errorf("a latency under the threshold is recorded")
+	}
+	latency.add(eventCommand, 20*time.Millisecond)
+	latency.add(eventCommand, 50*time.Millisecond)
+	latency.add(eventFork, 30*time.Millisecond)
+
+	latest := latency.latest()
+	if len(latest) != 2 {
+		t.Fatalf("LATEST: got %d events, want 2", len(latest))
+	}
+	// spikes of the same second are one sample, the worst one
+	history := latency.history(eventCommand)
+	if len(history) != 1 || string(history[0].Array[1].Value) != "50" {
+		t.Errorf("HISTORY: got %v", history)
+	}
+	if n := latency.reset(eventFork, "missing"); n != 1 {
+		t.Errorf("RESET: got %d, want 1", n)
+	}
+	if n := latency.reset(); n != 1 {
+		t.Errorf("RESET: got %d, want 1", n)
+	}
+}
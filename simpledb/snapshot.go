@@ -0,0 +1,226 @@
+package simpledb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshot
+//
+// the keyspace and the function libraries are saved to dbfilename by
+// SHUTDOWN and, whenever a save rule is met, in the background. They are
+// loaded from it at start. The file is snapshotMagic, the
+// FUNCTION DUMP payload of the libraries, the count of keys, then each key
+// and its DUMP payload, see dumpValue.
+
+const snapshotMagic = "SIMPLEDB0002"
+
+var errSnapshot = errors.New("bad snapshot file")
+
+// dbFilename returns the snapshot file, empty when there is none
+func (s *Server) dbFilename() string {
+	s.confMu.RLock()
+	defer s.confMu.RUnlock()
+	return s.conf.Server.DBFilename
+}
+
+// saveRule is a "seconds changes" pair of the save parameter
+type saveRule struct {
+	seconds, changes int64
+}
+
+// a failed background save is tried again after saveRetryDelay
+const saveRetryDelay = 5 * time.Second
+
+// parseSaveRules reads the rules checked by checkSaveRules
+func parseSaveRules(rules string) []saveRule {
+	fields := strings.Fields(rules)
+	var parsed []saveRule
+	for i := 0; i+1 < len(fields); i += 2 {
+		seconds, _ := strconv.ParseInt(fields[i], 10, 64)
+		changes, _ := strconv.ParseInt(fields[i+1], 10, 64)
+		parsed = append(parsed, saveRule{seconds, changes})
+	}
+	return parsed
+}
+
+// snapshot serializes the libraries and the keyspace, the caller holds
+// cmdMu. The time the commands wait for it is reported as eventFork.
+func (s *Server) snapshot() ([]byte, int) {
+	start := time.Now()
+	defer func() { s.latency.add(eventFork, time.Since(start)) }()
+
+	var keys []string
+	s.forEachKey(func(key, typ string) {
+		keys = append(keys, key)
+	})
+	w := &dumpWriter{buf: []byte(snapshotMagic)}
+	w.string(string(s.functions.dump()))
+	w.uvarint(len(keys))
+	for _, key := range keys {
+		value, typ := s.lookupKey(key)
+		w.string(key)
+		w.string(string(dumpValue(typ, value)))
+	}
+	return w.buf, len(keys)
+}
+
+// writeSnapshot replaces file with data through a temporary file
+func writeSnapshot(file string, data []byte) error {
+	tmp := file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	bw.Write(data)
+	if err = bw.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// saveSnapshot saves the snapshot, the caller holds cmdMu
+func (s *Server) saveSnapshot() (int, error) {
+	file := s.dbFilename()
+	if file == "" {
+		return 0, fmt.Errorf("no dbfilename to save to")
+	}
+	data, n := s.snapshot()
+	dirty := s.stats.snapshot().dirty
+
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	err := writeSnapshot(file, data)
+	s.stats.saved(err == nil, dirty)
+	return n, err
+}
+
+// backgroundSave saves the snapshot without holding cmdMu while the file
+// is written, the commands go on meanwhile
+func (s *Server) backgroundSave() (int, error) {
+	file := s.dbFilename()
+	s.cmdMu.Lock()
+	data, n := s.snapshot()
+	dirty := s.stats.snapshot().dirty
+	taken := time.Now()
+	s.cmdMu.Unlock()
+
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	// a save since, e.g. by SHUTDOWN, is newer
+	if s.stats.snapshot().lastSave.After(taken) {
+		return n, nil
+	}
+	err := writeSnapshot(file, data)
+	s.stats.saved(err == nil, dirty)
+	return n, err
+}
+
+// saveDue reports whether a save rule is met at now
+func (s *Server) saveDue(now time.Time) bool {
+	s.confMu.RLock()
+	rules := parseSaveRules(s.conf.Server.Save)
+	file := s.conf.Server.DBFilename
+	s.confMu.RUnlock()
+	if file == "" {
+		return false
+	}
+	st := s.stats.snapshot()
+	if st.saveFailed && now.Sub(st.lastSaveTry) < saveRetryDelay {
+		return false
+	}
+	for _, rule := range rules {
+		if st.dirty >= rule.changes && now.Sub(st.lastSave) >= time.Duration(rule.seconds)*time.Second {
+			return true
+		}
+	}
+	return false
+}
+
+// saveCron saves the snapshot in the background whenever a save rule is
+// met, until the server shuts down
+func (s *Server) saveCron() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.life.done:
+			return
+		case now := <-ticker.C:
+			if s.life.isClosing() || !s.saveDue(now) {
+				continue
+			}
+			if n, err := s.backgroundSave(); err != nil {
+				log.Printf("background save err: %v", err)
+			} else {
+				log.Printf("background saved %d keys", n)
+			}
+		}
+	}
+}
+
+// loadSnapshot restores the libraries and the keys of the snapshot file, a
+// missing file loads nothing
+func (s *Server) loadSnapshot() (int, error) {
+	file := s.dbFilename()
+	if file == "" {
+		return 0, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < len(snapshotMagic) || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return 0, fmt.Errorf("%s: %v", file, errSnapshot)
+	}
+	r := &dumpReader{buf: data[len(snapshotMagic):]}
+	functions := r.string()
+	if r.err != nil {
+		return 0, fmt.Errorf("%s: %v", file, errSnapshot)
+	}
+	libs, err := s.restoreLibraries([]byte(functions))
+	if err != nil {
+		return 0, fmt.Errorf("%s: functions: %v", file, err)
+	}
+	if err := s.functions.install(libs, true); err != nil {
+		return 0, fmt.Errorf("%s: functions: %v", file, err)
+	}
+	n := r.uvarint()
+	for i := 0; i < n && r.err == nil; i++ {
+		key := r.string()
+		payload := r.string()
+		if r.err != nil {
+			break
+		}
+		typ, value, err := restoreValue([]byte(payload))
+		if err != nil {
+			return i, fmt.Errorf("%s: key %q: %v", file, key, err)
+		}
+		s.storeKey(key, typ, value)
+		s.memory.update(s.db, key)
+	}
+	if r.err != nil || len(r.buf) != 0 {
+		return 0, fmt.Errorf("%s: %v", file, errSnapshot)
+	}
+	return n, nil
+}
@@ -0,0 +1,171 @@
+package simpledb
+
+import (
+	"container/list"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_Snapshot(t *testing.T) {
+
+	file := filepath.Join(t.TempDir(), "dump.sdb")
+	l := list.New()
+	l.PushBack("a")
+	l.PushBack("b")
+	values := map[string]struct {
+		typ   string
+		value interface{}
+	}{
+		"s": {typeString, "simpledb"},
+		"l": {typeList, l},
+		"z": {typeZSet, memberSlice{{"a", 1}, {"b", 2.5}}},
+		"h": {typeHash, map[string]string{"f": "v"}},
+		"x": {typeSet, &sMember{val: map[string]interface{}{"x": nil}}},
+	}
+
+	server := newTestServer()
+	server.conf.Server.DBFilename = file
+	for key, v := range values {
+		server.storeKey(key, v.typ, v.value)
+	}
+	server.stats.called(true)
+	if n, err := server.saveSnapshot(); err != nil || n != len(values) {
+		t.Fatalf("save: got %d, %v", n, err)
+	}
+	if st := server.stats.snapshot(); st.dirty != 0 || st.saveFailed {
+		t.Errorf("after a save: dirty %d, failed %v", st.dirty, st.saveFailed)
+	}
+
+	loaded := newTestServer()
+	loaded.conf.Server.DBFilename = file
+	if n, err := loaded.loadSnapshot(); err != nil || n != len(values) {
+		t.Fatalf("load: got %d, %v", n, err)
+	}
+	for key, v := range values {
+		value, typ := loaded.lookupKey(key)
+		if typ != v.typ || valueLen(typ, value) != valueLen(v.typ, v.value) {
+			t.Errorf("%s: got %s of %d elements", key, typ, valueLen(typ, value))
+		}
+	}
+	if n := loaded.memory.keyCount(); n != int64(len(values)) {
+		t.Errorf("memory accounts %d keys, want %d", n, len(values))
+	}
+
+	// a missing file loads nothing, a corrupted one fails
+	loaded.conf.Server.DBFilename = file + ".missing"
+	if n, err := loaded.loadSnapshot(); err != nil || n != 0 {
+		t.Errorf("missing file: got %d, %v", n, err)
+	}
+	data, _ := ioutil.ReadFile(file)
+	ioutil.WriteFile(file, data[:len(data)-1], 0600)
+	loaded.conf.Server.DBFilename = file
+	if _, err := loaded.loadSnapshot(); err == nil {
+		t.Errorf("truncated file loaded")
+	}
+
+	server.conf.Server.DBFilename = filepath.Join(file, "nodir", "dump.sdb")
+	if _, err := server.saveSnapshot(); err == nil {
+		t.Errorf("saved to a missing directory")
+	}
+	if st := server.stats.snapshot(); !st.saveFailed {
+		t.Errorf("the failed save is not recorded")
+	}
+}
+
+func TestServer_SnapshotLatency(t *testing.T) {
+
+	server := newTestServer()
+	server.latency = newLatency(1)
+	server.conf.Server.DBFilename = filepath.Join(t.TempDir(), "dump.sdb")
+	// serializing a big value holds the commands for more than 1ms
+	server.storeKey("big", typeString, strings.Repeat("v", 8<<20))
+	if _, err := server.saveSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if history := server.latency.history(eventFork); len(history) != 1 {
+		t.Errorf("%s: got %d samples, want 1", eventFork, len(history))
+	}
+}
+
+func TestServer_SaveRules(t *testing.T) {
+
+	file := filepath.Join(t.TempDir(), "dump.sdb")
+	server := newTestServer()
+	server.conf.Server.Save = "60 1 1 2"
+	server.conf.Server.DBFilename = file
+	wb, rb := pipeConn(server)
+	call(wb, rb, "SET", "a", "1")
+
+	now := time.Now()
+	var tests = []struct {
+		after time.Duration
+		due   bool
+	}{
+		{0, false},
+		// one write is not enough after a second
+		{2 * time.Second, false},
+		{61 * time.Second, true},
+	}
+	for _, test := range tests {
+		if due := server.saveDue(now.Add(test.after)); due != test.due {
+			t.Errorf("after %v: got due %v, want %v", test.after, due, test.due)
+		}
+	}
+	call(wb, rb, "SET", "b", "2")
+	if !server.saveDue(now.Add(2 * time.Second)) {
+		t.Errorf("two writes after 2s: the save is not due")
+	}
+
+	if n, err := server.backgroundSave(); err != nil || n != 2 {
+		t.Fatalf("background save: got %d, %v", n, err)
+	}
+	if st := server.stats.snapshot(); st.dirty != 0 {
+		t.Errorf("dirty after the save: got %d", st.dirty)
+	}
+	if server.saveDue(time.Now().Add(61 * time.Second)) {
+		t.Errorf("a save is due without writes")
+	}
+
+	// a failed save waits saveRetryDelay before the next try
+	server.conf.Server.DBFilename = filepath.Join(file, "nodir", "dump.sdb")
+	call(wb, rb, "SET", "c", "3")
+	call(wb, rb, "SET", "d", "4")
+	if _, err := server.backgroundSave(); err == nil {
+		t.Fatal("saved to a missing directory")
+	}
+	failed := server.stats.snapshot().lastSaveTry
+	if server.saveDue(failed.Add(3 * time.Second)) {
+		t.Errorf("the failed save is tried again before %v", saveRetryDelay)
+	}
+	if !server.saveDue(failed.Add(6 * time.Second)) {
+		t.Errorf("the failed save is not tried again after %v", saveRetryDelay)
+	}
+}
+
+func TestServer_SaveCron(t *testing.T) {
+
+	file := filepath.Join(t.TempDir(), "dump.sdb")
+	server := newTestServer()
+	server.conf.Server.Save = "1 1"
+	server.conf.Server.DBFilename = file
+	client, ran := runUnix(t, server)
+	defer func() {
+		server.Close()
+		stopped(t, server, ran)
+	}()
+	client.Set("k", "v")
+
+	for i := 0; ; i++ {
+		if _, err := os.Stat(file); err == nil {
+			break
+		}
+		if i == 300 {
+			t.Fatal("no background save after 3s")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
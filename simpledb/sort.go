@@ -0,0 +1,241 @@
+package simpledb
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sort commands:
+// sort, sort_ro
+//
+// SORT key [BY pattern] [LIMIT offset count] [GET pattern ...] [ASC|DESC]
+// [ALPHA] [STORE destination]
+//
+// a pattern is a key with a * replaced by the element, "key*->field" reads
+// a field of a hash, GET # is the element itself. A BY pattern without *
+// keeps the elements unsorted.
+
+var (
+	errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errSortScore = errors.New("ERR One or more scores can't be converted into double")
+)
+
+type sortOptions struct {
+	by       string
+	noSort   bool
+	gets     []string
+	offset   int
+	count    int
+	desc     bool
+	alpha    bool
+	store    string
+	hasStore bool
+	limited  bool
+}
+
+func parseSort(args []*Resp, readOnly bool) (*sortOptions, error) {
+	opts := &sortOptions{}
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i].Value))
+		left := len(args) - i - 1
+		switch {
+		case option == "ASC":
+			opts.desc = false
+		case option == "DESC":
+			opts.desc = true
+		case option == "ALPHA":
+			opts.alpha = true
+		case option == "BY" && left >= 1:
+			i++
+			opts.by = string(args[i].Value)
+			opts.noSort = !strings.Contains(opts.by, "*")
+		case option == "GET" && left >= 1:
+			i++
+			opts.gets = append(opts.gets, string(args[i].Value))
+		case option == "LIMIT" && left >= 2:
+			offset, err := strconv.Atoi(string(args[i+1].Value))
+			if err != nil {
+				return nil, errInteger
+			}
+			count, err := strconv.Atoi(string(args[i+2].Value))
+			if err != nil {
+				return nil, errInteger
+			}
+			opts.offset, opts.count, opts.limited = offset, count, true
+			i += 2
+		case option == "STORE" && left >= 1 && !readOnly:
+			i++
+			opts.store, opts.hasStore = string(args[i].Value), true
+		default:
+			return nil, errSyntax
+		}
+	}
+	return opts, nil
+}
+
+// lookupPattern reads the string key or the hash field a pattern names for
+// element, it reports false when there is nothing
+func (d *db) lookupPattern(pattern, element string) (string, bool) {
+	if pattern == "#" {
+		return element, true
+	}
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return "", false
+	}
+	key, field := pattern, ""
+	if arrow := strings.Index(pattern, "->"); arrow > star && arrow+2 < len(pattern) {
+		key, field = pattern[:arrow], pattern[arrow+2:]
+	}
+	key = key[:star] + element + key[star+1:]
+
+	value, typ := d.lookupKey(key)
+	if field == "" {
+		if typ != typeString {
+			return "", false
+		}
+		return value.(string), true
+	}
+	if typ != typeHash {
+		return "", false
+	}
+	v, ok := value.(map[string]string)[field]
+	return v, ok
+}
+
+// sortElements returns the elements of a list, set or sorted set
+func sortElements(typ string, value interface{}) ([]string, error) {
+	var elements []string
+	switch typ {
+	case typeNone:
+	case typeList:
+		for e := value.(*list.List).Front(); e != nil; e = e.Next() {
+			elements = append(elements, fmt.Sprint(e.Value))
+		}
+	case typeSet:
+		for member := range value.(*sMember).val {
+			elements = append(elements, member)
+		}
+	case typeZSet:
+		for _, m := range value.(memberSlice) {
+			elements = append(elements, m.member)
+		}
+	default:
+		return nil, errWrongType
+	}
+	return elements, nil
+}
+
+type sortItem struct {
+	element string
+	weight  string
+	score   float64
+}
+
+func (s *Server) sortCommand(resp *Resp, readOnly bool) error {
+	opts, err := parseSort(resp.Array[2:], readOnly)
+	if err != nil {
+		return s.replyErr(err)
+	}
+	value, typ := s.lookupKey(string(resp.Array[1].Value))
+	elements, err := sortElements(typ, value)
+	if err != nil {
+		return s.replyErr(err)
+	}
+
+	items := make([]sortItem, len(elements))
+	for i, element := range elements {
+		items[i] = sortItem{element: element, weight: element}
+		if opts.by != "" && !opts.noSort {
+			items[i].weight, _ = s.lookupPattern(opts.by, element)
+		}
+		if !opts.alpha && !opts.noSort && items[i].weight != "" {
+			items[i].score, err = strconv.ParseFloat(items[i].weight, 64)
+			if err != nil {
+				return s.replyErr(errSortScore)
+			}
+		}
+	}
+	// sets have no order of their own, they are sorted even with
+	// BY nosort so that LIMIT is stable
+	if !opts.noSort || typ == typeSet {
+		sort.SliceStable(items, func(i, j int) bool {
+			a, b := items[i], items[j]
+			if opts.desc {
+				a, b = b, a
+			}
+			if opts.noSort {
+				return a.element < b.element
+			}
+			if !opts.alpha && a.score != b.score {
+				return a.score < b.score
+			}
+			if opts.alpha && a.weight != b.weight {
+				return a.weight < b.weight
+			}
+			return a.element < b.element
+		})
+	}
+
+	if opts.limited {
+		start := opts.offset
+		if start < 0 {
+			start = 0
+		}
+		if start > len(items) {
+			start = len(items)
+		}
+		end := len(items)
+		if opts.count >= 0 && start+opts.count < end {
+			end = start + opts.count
+		}
+		items = items[start:end]
+	}
+
+	var result []*Resp
+	for _, item := range items {
+		if len(opts.gets) == 0 {
+			result = append(result, NewBulkBytes([]byte(item.element)))
+			continue
+		}
+		for _, pattern := range opts.gets {
+			if v, ok := s.lookupPattern(pattern, item.element); ok {
+				result = append(result, NewBulkBytes([]byte(v)))
+			} else {
+				result = append(result, NewString([]byte("nil")))
+			}
+		}
+	}
+
+	if !opts.hasStore {
+		return s.writeResp(NewArray(result))
+	}
+	stored := list.New()
+	for _, r := range result {
+		if r.IsString() {
+			// nil is stored as an empty string
+			stored.PushBack("")
+		} else {
+			stored.PushBack(string(r.Value))
+		}
+	}
+	if stored.Len() == 0 {
+		s.removeKey(opts.store)
+	} else {
+		s.storeKey(opts.store, typeList, stored)
+	}
+	s.notify(notifyList, "sortstore", opts.store)
+	return s.writeResp(NewInt([]byte(strconv.Itoa(stored.Len()))))
+}
+
+func sortKey(s *Server, resp *Resp) error {
+	return s.sortCommand(resp, false)
+}
+
+func sortReadOnly(s *Server, resp *Resp) error {
+	return s.sortCommand(resp, true)
+}
@@ -0,0 +1,66 @@
+package simpledb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestServer_Sort(t *testing.T) {
+
+	server := newTestServer()
+	server.queue = newQueue()
+	for _, id := range []string{"3", "1", "2"} {
+		server.queue.pushBack("ids", id)
+	}
+	server.set = newSet()
+	server.set.add("names", "bob", "alice", "carol")
+	server.dict = newDict()
+	server.dict.add("weight_1", "30")
+	server.dict.add("weight_2", "10")
+	server.dict.add("weight_3", "20")
+	server.dict.add("name", "simpledb")
+	server.hash = newHash()
+	server.hash = append(server.hash,
+		&Hash{key: "user:1", filed: map[string]string{"name": "ann"}},
+		&Hash{key: "user:2", filed: map[string]string{"name": "ben"}},
+	)
+	wb, rb := pipeConn(server)
+
+	var tests = []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"SORT", "ids"}, "1 2 3"},
+		{[]interface{}{"SORT", "ids", "DESC"}, "3 2 1"},
+		{[]interface{}{"SORT", "ids", "LIMIT", "1", "1"}, "2"},
+		{[]interface{}{"SORT", "names", "ALPHA"}, "alice bob carol"},
+		{[]interface{}{"SORT", "names"}, errSortScore.Error()},
+		{[]interface{}{"SORT", "ids", "BY", "weight_*"}, "2 3 1"},
+		{[]interface{}{"SORT", "ids", "BY", "nosort"}, "3 1 2"},
+		{[]interface{}{"SORT", "ids", "GET", "#", "GET", "user:*->name"}, "1 ann 2 ben 3 nil"},
+		{[]interface{}{"SORT", "ids", "BY", "weight_*", "GET", "weight_*"}, "10 20 30"},
+		{[]interface{}{"SORT", "name"}, errWrongType.Error()},
+		{[]interface{}{"SORT", "missing"}, ""},
+		{[]interface{}{"SORT", "ids", "DESC", "STORE", "sorted"}, "3"},
+		{[]interface{}{"LRANGE", "sorted", "0", "3"}, "3 2 1"},
+		{[]interface{}{"SORT_RO", "ids", "STORE", "sorted"}, errSyntax.Error()},
+		{[]interface{}{"SORT_RO", "ids", "DESC"}, "3 2 1"},
+	}
+	for _, test := range tests {
+		resp, err := call(wb, rb, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := string(resp.Value)
+		if resp.IsArray() {
+			var values []string
+			for _, r := range resp.Array {
+				values = append(values, string(r.Value))
+			}
+			got = strings.Join(values, " ")
+		}
+		if got != test.want {
+			t.Errorf("%v: got %s, want %s", test.args, got, test.want)
+		}
+	}
+}
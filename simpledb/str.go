@@ -74,6 +74,7 @@ func set(s *Server, resp *Resp) error {
 	key := string(resp.Array[1].Value)
 	value := string(resp.Array[2].Value)
 	s.dict.add(key, value)
+	s.notify(notifyString, "set", key)
 
 	return s.replyOk()
 }
@@ -111,6 +112,7 @@ func decrease(s *Server, resp *Resp) error {
 	}
 	v = v - 1
 	s.dict.add(key, strconv.FormatInt(v, 10))
+	s.notify(notifyString, "decrby", key)
 	return s.writeArgs(v)
 }
 
@@ -133,6 +135,7 @@ func decreaseBy(s *Server, resp *Resp) error {
 	}
 	v = v - val
 	s.dict.add(key, strconv.FormatInt(v, 10))
+	s.notify(notifyString, "decrby", key)
 	return s.writeArgs(v)
 }
 
@@ -151,6 +154,7 @@ func increase(s *Server, resp *Resp) error {
 	}
 	v = v + 1
 	s.dict.add(key, strconv.FormatInt(v, 10))
+	s.notify(notifyString, "incrby", key)
 	return s.writeArgs(v)
 }
 
@@ -173,6 +177,7 @@ func increaseBy(s *Server, resp *Resp) error {
 	}
 	v = v + val
 	s.dict.add(key, strconv.FormatInt(v, 10))
+	s.notify(notifyString, "incrby", key)
 	return s.writeArgs(v)
 
 }
@@ -188,11 +193,13 @@ func appends(s *Server, resp *Resp) error {
 	val, err := s.dict.get(key)
 	if err != nil {
 		s.dict.add(key, value)
+		s.notify(notifyString, "append", key)
 		return s.writeArgs(len(value))
 	}
 	if v, ok := val.(string); ok {
 		newValue := v + value
 		s.dict.add(key, newValue)
+		s.notify(notifyString, "append", key)
 		return s.writeArgs(len(newValue))
 	}
 	return s.replyErr(errStr)
@@ -203,10 +210,15 @@ func deletes(s *Server, resp *Resp) error {
 		s.dict = newDict()
 	}
 	for _, args := range resp.Array[1:] {
-		err := s.dict.delete(string(args.Value))
+		key := string(args.Value)
+		if _, err := s.dict.get(key); err != nil {
+			continue
+		}
+		err := s.dict.delete(key)
 		if err != nil {
 			return s.replyErr(err)
 		}
+		s.notify(notifyGeneric, "del", key)
 	}
 	return s.replyOk()
 }
@@ -233,6 +245,7 @@ func multipleSet(s *Server, resp *Resp) error {
 		key := string(resp.Array[i].Value)
 		value := string(resp.Array[i+1].Value)
 		s.dict.add(key, value)
+		s.notify(notifyString, "set", key)
 	}
 	return s.replyOk()
 }
@@ -0,0 +1,126 @@
+package simpledb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// tls
+//
+// with tls_port set the server also listens for TLS connections, with the
+// certificate and key of tls_cert_file and tls_key_file. The clients are
+// verified against tls_ca_cert_file as tls_auth_clients says: "yes" (the
+// default) requires a certificate, "optional" verifies one when given and
+// "no" asks for none. With tls_auth_clients_user "CN" a verified client is
+// authenticated as the ACL user named by the common name of its
+// certificate, when that user exists and is on.
+
+const (
+	tlsAuthClientsYes      = "yes"
+	tlsAuthClientsOptional = "optional"
+	tlsAuthClientsNo       = "no"
+)
+
+var errTLSAuthClients = errors.New("tls_auth_clients must be yes, optional or no")
+
+// loadCertPool reads the PEM certificates of file
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate in %s", file)
+	}
+	return pool, nil
+}
+
+// newServerTLSConfig returns the TLS config of the listener
+func newServerTLSConfig(certFile, keyFile, caCertFile, authClients string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	switch strings.ToLower(authClients) {
+	case "", tlsAuthClientsYes:
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	case tlsAuthClientsOptional:
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case tlsAuthClientsNo:
+		config.ClientAuth = tls.NoClientCert
+		return config, nil
+	default:
+		return nil, errTLSAuthClients
+	}
+	if caCertFile == "" {
+		return nil, fmt.Errorf("tls_ca_cert_file is required to verify the clients, or set tls_auth_clients no")
+	}
+	if config.ClientCAs, err = loadCertPool(caCertFile); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// NewTLSConfig returns the TLS config of a Client. The server is verified
+// against caCertFile, the system roots without it, and certFile and keyFile
+// are the client certificate when the server asks for one.
+func NewTLSConfig(certFile, keyFile, caCertFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if caCertFile != "" {
+		pool, err := loadCertPool(caCertFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// listenTLS serves the TLS connections on the tls port
+func (s *Server) listenTLS() error {
+	addr := fmt.Sprintf("%s:%d", s.host, s.tlsPort)
+	listener, err := tls.Listen("tcp", addr, s.tlsConfig)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %v, %v\n", addr, err.Error())
+	}
+	log.Println("tls listen on: ", addr)
+	s.life.add(listener)
+	return s.serve(listener)
+}
+
+// handshake completes the TLS handshake of a connection and authenticates
+// it by its certificate, see tls_auth_clients_user
+func (s *Server) handshake(conn *tls.Conn) error {
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	if !s.tlsUserFromCN {
+		return nil
+	}
+	state := conn.ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		return nil
+	}
+	name := state.PeerCertificates[0].Subject.CommonName
+	if s.acl.userEnabled(name) {
+		s.setUser(name)
+	}
+	return nil
+}
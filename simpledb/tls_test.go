@@ -0,0 +1,190 @@
+package simpledb
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert signs a certificate for cn with parent, a self-signed CA
+// without parent, and writes it and its key to dir/name.crt and name.key
+func testCert(t *testing.T, dir, name, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// serveTLS serves s over TLS on a loopback listener until the test ends,
+// it returns the port
+func serveTLS(t *testing.T, s *Server) int {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", s.tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleProcess(s.newConn(conn))
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestServer_TLS(t *testing.T) {
+
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	ca, caKey := testCert(t, dir, "ca", "test ca", nil, nil)
+	testCert(t, dir, "server", "simpledb", ca, caKey)
+	testCert(t, dir, "alice", "alice", ca, caKey)
+	testCert(t, dir, "bob", "bob", ca, caKey)
+	testCert(t, dir, "rogue", "alice", nil, nil)
+
+	if _, err := newServerTLSConfig(path("server.crt"), path("server.key"), "", ""); err == nil {
+		t.Errorf("client verification without a CA: no error")
+	}
+	if _, err := newServerTLSConfig(path("server.crt"), path("server.key"), path("ca.crt"), "maybe"); err != errTLSAuthClients {
+		t.Errorf("tls_auth_clients maybe: got %v", err)
+	}
+
+	config, err := newServerTLSConfig(path("server.crt"), path("server.key"), path("ca.crt"), "optional")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer()
+	server.acl = newACL("secret", "")
+	server.acl.users["alice"] = newUser("alice")
+	server.acl.users["alice"].setRule("on")
+	server.acl.users["alice"].setRule("+@all")
+	server.tlsConfig = config
+	server.tlsUserFromCN = true
+	port := serveTLS(t, server)
+
+	var tests = []struct {
+		cert string // client certificate, none when empty
+		want string // ACL WHOAMI reply
+	}{
+		{"alice", "alice"},
+		// bob is no ACL user, the connection needs AUTH
+		{"bob", errNoAuth.Error()},
+		{"", errNoAuth.Error()},
+		// a certificate not signed by the CA is not even sent
+		{"rogue", errNoAuth.Error()},
+	}
+	for _, test := range tests {
+		var certFile, keyFile string
+		if test.cert != "" {
+			certFile, keyFile = path(test.cert+".crt"), path(test.cert+".key")
+		}
+		tlsConfig, err := NewTLSConfig(certFile, keyFile, path("ca.crt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &Client{
+			Host:           "127.0.0.1",
+			Port:           port,
+			TLSConfig:      tlsConfig,
+			ConnectTimeout: defaultTimeout,
+			readTimeout:    defaultTimeout,
+			writeTimeout:   defaultTimeout,
+		}
+		resp, err := client.ACLWhoAmI()
+		client.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", test.cert, err)
+		}
+		if string(resp.Value) != test.want {
+			t.Errorf("%s: got %s, want %s", test.cert, resp.Value, test.want)
+		}
+	}
+
+	// AUTH over TLS without a certificate
+	tlsConfig, err := NewTLSConfig("", "", path("ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &Client{
+		Host:           "127.0.0.1",
+		Port:           port,
+		Password:       "secret",
+		TLSConfig:      tlsConfig,
+		ConnectTimeout: defaultTimeout,
+		readTimeout:    defaultTimeout,
+		writeTimeout:   defaultTimeout,
+	}
+	defer client.Close()
+	resp, err := client.ACLWhoAmI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Value) != defaultUser {
+		t.Errorf("AUTH over TLS: got %s, want %s", resp.Value, defaultUser)
+	}
+
+	// tls_auth_clients yes refuses a client without a certificate
+	required := newTestServer()
+	if required.tlsConfig, err = newServerTLSConfig(path("server.crt"), path("server.key"), path("ca.crt"), "yes"); err != nil {
+		t.Fatal(err)
+	}
+	anonymous := &Client{
+		Host:           "127.0.0.1",
+		Port:           serveTLS(t, required),
+		TLSConfig:      tlsConfig,
+		ConnectTimeout: defaultTimeout,
+		readTimeout:    defaultTimeout,
+		writeTimeout:   defaultTimeout,
+	}
+	defer anonymous.Close()
+	if _, err := anonymous.ACLWhoAmI(); err == nil {
+		t.Errorf("tls_auth_clients yes: a client without a certificate connected")
+	}
+}
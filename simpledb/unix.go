@@ -0,0 +1,68 @@
+package simpledb
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// unix socket
+//
+// with unixsocket set the server also listens on that path, and only there
+// with port 0. unixsocketperm is the octal mode of the socket file, e.g.
+// "770". A Client dials the socket with a Host of "unix:///path/to/socket".
+
+const unixScheme = "unix://"
+
+// parseSocketPerm parses the octal unixsocketperm, 0 keeps the umask
+func parseSocketPerm(perm string) (os.FileMode, error) {
+	if perm == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(perm, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid unixsocketperm %q, want an octal mode such as 770", perm)
+	}
+	return os.FileMode(mode), nil
+}
+
+// listenUnix listens on the unix socket, a socket left by a previous run
+// is replaced
+func (s *Server) listenUnix() (net.Listener, error) {
+	if info, err := os.Lstat(s.unixSocket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(s.unixSocket)
+	}
+	listener, err := net.Listen("unix", s.unixSocket)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %v, %v\n", s.unixSocket, err.Error())
+	}
+	if s.unixSocketPerm != 0 {
+		if err := os.Chmod(s.unixSocket, s.unixSocketPerm); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	log.Println("listen on: ", s.unixSocket)
+	return listener, nil
+}
+
+// connAddrs returns the addresses of a connection as CLIENT LIST shows
+// them, a unix socket connection is the socket path and port 0 as in redis
+func connAddrs(conn net.Conn) (addr, laddr string) {
+	if conn.LocalAddr().Network() == "unix" {
+		path := conn.LocalAddr().String() + ":0"
+		return path, path
+	}
+	return conn.RemoteAddr().String(), conn.LocalAddr().String()
+}
+
+// address returns the network and address the client dials
+func (c *Client) address() (network, addr string) {
+	if strings.HasPrefix(c.Host, unixScheme) {
+		return "unix", strings.TrimPrefix(c.Host, unixScheme)
+	}
+	return "tcp", c.Host + ":" + strconv.Itoa(c.Port)
+}
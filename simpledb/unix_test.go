@@ -0,0 +1,86 @@
+package simpledb
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSocketPerm(t *testing.T) {
+
+	var tests = []struct {
+		perm string
+		want os.FileMode
+		err  bool
+	}{
+		{"", 0, false},
+		{"700", 0700, false},
+		{"0770", 0770, false},
+		{"778", 0, true},
+		{"1777", 0, true},
+		{"rw", 0, true},
+	}
+	for _, test := range tests {
+		mode, err := parseSocketPerm(test.perm)
+		if (err != nil) != test.err || mode != test.want {
+			t.Errorf("%q: got %o, %v, want %o", test.perm, mode, err, test.want)
+		}
+	}
+}
+
+func TestServer_UnixSocket(t *testing.T) {
+
+	server := newTestServer()
+	server.unixSocket = filepath.Join(t.TempDir(), "simpledb.sock")
+	server.unixSocketPerm = 0770
+	// a socket left by a previous run is replaced
+	stale, err := server.listenUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := server.listenUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleProcess(server.newConn(conn))
+		}
+	}()
+
+	info, err := os.Stat(server.unixSocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0770 {
+		t.Errorf("socket mode: got %o, want 770", perm)
+	}
+
+	client := &Client{
+		Host:           "unix://" + server.unixSocket,
+		ConnectTimeout: defaultTimeout,
+		readTimeout:    defaultTimeout,
+		writeTimeout:   defaultTimeout,
+	}
+	defer client.Close()
+	if resp, err := client.Set("k", "v"); err != nil || string(resp.Value) != "OK" {
+		t.Fatalf("SET over the unix socket: got %v, %v", resp, err)
+	}
+	resp, err := client.ClientInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "addr=" + server.unixSocket + ":0 "; !strings.Contains(string(resp.Value), want) {
+		t.Errorf("CLIENT INFO: no %q in %s", want, resp.Value)
+	}
+}
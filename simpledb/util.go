@@ -0,0 +1,126 @@
+package simpledb
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+)
+
+// payloadVersion is written at the end of DUMP payloads, a payload of
+// another version is refused
+const payloadVersion = 1
+
+var (
+	errPayload = errors.New("ERR DUMP payload version or checksum are wrong")
+	crcTable   = crc64.MakeTable(crc64.ECMA)
+)
+
+// sealPayload appends the payload version and a crc64 of everything before
+// the checksum
+func sealPayload(body []byte) []byte {
+	payload := make([]byte, len(body), len(body)+10)
+	copy(payload, body)
+	payload = append(payload, 0, 0)
+	binary.LittleEndian.PutUint16(payload[len(body):], payloadVersion)
+	sum := make([]byte, 8)
+	binary.LittleEndian.PutUint64(sum, crc64.Checksum(payload, crcTable))
+	return append(payload, sum...)
+}
+
+// openPayload checks the version and checksum of a sealed payload and
+// returns its body
+func openPayload(payload []byte) ([]byte, error) {
+	if len(payload) < 10 {
+		return nil, errPayload
+	}
+	n := len(payload) - 8
+	if binary.LittleEndian.Uint64(payload[n:]) != crc64.Checksum(payload[:n], crcTable) {
+		return nil, errPayload
+	}
+	if binary.LittleEndian.Uint16(payload[n-2:n]) != payloadVersion {
+		return nil, errPayload
+	}
+	return payload[:n-2], nil
+}
+
+// matchPattern reports whether str matches the glob-style pattern, following
+// redis: * any sequence, ? any single byte, [abc] [^abc] [a-z] byte classes
+// and \ to escape the next byte
+func matchPattern(pattern, str string) bool {
+
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if matchPattern(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				if pattern[0] == '\\' && len(pattern) >= 2 {
+					pattern = pattern[1:]
+					if pattern[0] == str[0] {
+						match = true
+					}
+				} else if len(pattern) >= 3 && pattern[1] == '-' {
+					start, end := pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+					if start <= str[0] && str[0] <= end {
+						match = true
+					}
+					pattern = pattern[2:]
+				} else if pattern[0] == str[0] {
+					match = true
+				}
+				pattern = pattern[1:]
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			str = str[1:]
+			if len(pattern) == 0 {
+				// unterminated class, treat the end of pattern as ']'
+				return len(str) == 0
+			}
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(str) == 0
+}
@@ -0,0 +1,62 @@
+package simpledb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+
+	var tests = []struct {
+		pattern string
+		str     string
+		want    bool
+	}{
+		{"*", "", true},
+		{"*", "foo", true},
+		{"foo", "foo", true},
+		{"foo", "bar", false},
+		{"f*", "foo", true},
+		{"*o", "foo", true},
+		{"f*r", "foo", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"__keyspace@0__:*", "__keyspace@0__:foo", true},
+	}
+
+	for _, test := range tests {
+		if got := matchPattern(test.pattern, test.str); got != test.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", test.pattern, test.str, got, test.want)
+		}
+	}
+}
+
+func TestPayload(t *testing.T) {
+
+	body := []byte("simpledb")
+	payload := sealPayload(body)
+
+	got, err := openPayload(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("openPayload: got %q, want %q", got, body)
+	}
+
+	payload[0] ^= 0xff
+	if _, err := openPayload(payload); err != errPayload {
+		t.Errorf("openPayload of a corrupted payload: got %v, want %v", err, errPayload)
+	}
+	if _, err := openPayload([]byte("short")); err != errPayload {
+		t.Errorf("openPayload of a short payload: got %v, want %v", err, errPayload)
+	}
+}
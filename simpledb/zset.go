@@ -196,6 +196,7 @@ func zAdd(s *Server, resp *Resp) error {
 	}
 	member := string(resp.Array[3].Value)
 	size := s.zSet.zAdd(key, score, member)
+	s.notify(notifyZSet, "zadd", key)
 	return s.writeArgs(size)
 }
 
@@ -238,6 +239,7 @@ func zIncrementBy(s *Server, resp *Resp) error {
 	member := string(resp.Array[3].Value)
 
 	curScore := s.zSet.zIncrementBy(key, increment, member)
+	s.notify(notifyZSet, "zincr", key)
 	return s.writeArgs(curScore)
 
 }
@@ -298,6 +300,46 @@ func zRem(s *Server, resp *Resp) error {
 		members = append(members, string(m.Value))
 	}
 	result := s.zSet.zRem(key, members...)
+	if result {
+		s.notify(notifyZSet, "zrem", key)
+	}
 	return s.writeArgs(result)
 
 }
+
+func zScan(s *Server, resp *Resp) error {
+	opts, err := parseScan(resp.Array[2:])
+	if err != nil {
+		return s.replyErr(err)
+	}
+	if s.zSet == nil {
+		return s.replyScan(0, nil)
+	}
+	key := string(resp.Array[1].Value)
+	s.zSet.mu.RLock()
+	members := s.zSet.data[key]
+	page, cursor, err := s.scans.page(typeZSet+":"+key, opts.cursor, opts.count, func(fn func(string)) {
+		for _, m := range members {
+			fn(m.member)
+		}
+	})
+	// the members are sorted by score, the scores of the page take one
+	// pass over them
+	scores := make(map[string]float64, len(page))
+	for _, member := range page {
+		if matchPattern(opts.pattern, member) {
+			scores[member] = 0
+		}
+	}
+	var result []string
+	for _, m := range members {
+		if _, ok := scores[m.member]; ok {
+			result = append(result, m.member, strconv.FormatFloat(m.score, 'f', -1, 64))
+		}
+	}
+	s.zSet.mu.RUnlock()
+	if err != nil {
+		return s.replyErr(err)
+	}
+	return s.replyScan(cursor, result)
+}